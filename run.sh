@@ -4,4 +4,4 @@
 # This script takes three parameters and outputs the reimbursement amount
 # Usage: ./run.sh <trip_duration_days> <miles_traveled> <total_receipts_amount>
 
-cd top-coder-solution && go run main.go "$1" "$2" "$3"
\ No newline at end of file
+cd top-coder-solution && go run . "$1" "$2" "$3"
\ No newline at end of file
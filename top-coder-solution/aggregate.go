@@ -0,0 +1,80 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Strategies for combining the outputs of a query's nearest neighbors. Each
+// weights neighbors by inverse distance; the median and trimmed mean keep a
+// single mislabeled close neighbor from dragging the prediction with it.
+const (
+	aggregateMean        = "mean"         // the weighted mean
+	aggregateMedian      = "median"       // the weighted median
+	aggregateTrimmedMean = "trimmed-mean" // the weighted mean without the outer aggregateTrim of weight at each end
+)
+
+// aggregateTrim is the share of the total weight the trimmed mean drops from
+// each end of the neighbors' outputs.
+const aggregateTrim = 0.2
+
+func validateAggregate(how string) error {
+	switch how {
+	case "", aggregateMean, aggregateMedian, aggregateTrimmedMean:
+		return nil
+	}
+	return fmt.Errorf("unknown aggregation %q (want %s, %s or %s)", how, aggregateMean, aggregateMedian, aggregateTrimmedMean)
+}
+
+// inverseDistance is a neighbor's weight by distance alone, with a small
+// epsilon to avoid division by zero.
+func inverseDistance(n Neighbor) float64 {
+	return 1.0 / (n.Distance + 1e-8)
+}
+
+// aggregate combines the outputs of neighbors, which must be non-empty and
+// sorted by distance, as how says, weighting each by weight. The nearest
+// neighbor answers when the weights sum to zero. The median and trimmed mean
+// reorder neighbors by output. Products are converted explicitly so that no
+// platform fuses them into the sums, for reproducible mode.
+func aggregate(neighbors []Neighbor, how string, weight func(Neighbor) float64) float64 {
+	totalWeight := 0.0
+	for _, n := range neighbors {
+		totalWeight += weight(n)
+	}
+	if totalWeight == 0 {
+		return neighbors[0].Output
+	}
+	switch how {
+	case aggregateMedian:
+		slices.SortFunc(neighbors, func(a, b Neighbor) int { return cmp.Compare(a.Output, b.Output) })
+		cum := 0.0
+		for _, n := range neighbors {
+			if cum += weight(n); cum >= totalWeight/2 {
+				return n.Output
+			}
+		}
+		return neighbors[len(neighbors)-1].Output
+	case aggregateTrimmedMean:
+		// Keep the part of each neighbor's weight lying between the trimmed
+		// ends of the cumulative weight.
+		slices.SortFunc(neighbors, func(a, b Neighbor) int { return cmp.Compare(a.Output, b.Output) })
+		lo, hi := aggregateTrim*totalWeight, (1-aggregateTrim)*totalWeight
+		cum, weightedSum, kept := 0.0, 0.0, 0.0
+		for _, n := range neighbors {
+			w := weight(n)
+			if part := min(cum+w, hi) - max(cum, lo); part > 0 {
+				weightedSum += float64(part * n.Output)
+				kept += part
+			}
+			cum += w
+		}
+		return weightedSum / kept
+	}
+	weightedSum := 0.0
+	for _, n := range neighbors {
+		weightedSum += float64(weight(n) * n.Output)
+	}
+	return weightedSum / totalWeight
+}
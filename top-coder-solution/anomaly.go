@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// anomalyMaxPoints bounds the training cases the density estimate is built
+// from. The leave-one-out densities cost the square of the points and each
+// check costs one pass over them, so larger data is estimated from a fixed
+// random sample of this many cases; smaller data uses every case.
+const anomalyMaxPoints = 2000
+
+// anomalySampleSeed seeds the sample of larger training data, so the same
+// data always gives the same detector.
+const anomalySampleSeed = 1
+
+// validateAnomalyQuantile checks an -anomaly-quantile value, a fraction of
+// training cases in [0, 1); 0 disables the check.
+func validateAnomalyQuantile(q float64) error {
+	if !(q >= 0 && q < 1) {
+		return fmt.Errorf("-anomaly-quantile must be at least 0 and below 1, got %g", q)
+	}
+	return nil
+}
+
+// AnomalyDetector flags queries that are implausible relative to the
+// training data, using a Gaussian kernel density estimate over standardized
+// inputs.
+type AnomalyDetector struct {
+	norm      standardizer
+	points    []featureVector
+	bandwidth float64
+	min, max  featureVector
+	// looDensities are the sorted leave-one-out log densities of the
+	// training points, used to express a query's density as a percentile.
+	looDensities []float64
+	threshold    float64
+}
+
+// AnomalyWarning explains why a query was flagged.
+type AnomalyWarning struct {
+	Message    string  `json:"message"`
+	LogDensity float64 `json:"log_density"`
+	Percentile float64 `json:"density_percentile"`
+}
+
+// newAnomalyDetector builds a detector that flags queries whose density is
+// below the given quantile of training-point densities. The training range
+// covers every case; the density, past anomalyMaxPoints cases, a sample.
+func newAnomalyDetector(training TrainingData, quantile float64) *AnomalyDetector {
+	a := &AnomalyDetector{}
+	for i, c := range training {
+		v := caseFeatures(c)
+		for j := range featureNames {
+			if i == 0 || v[j] < a.min[j] {
+				a.min[j] = v[j]
+			}
+			if i == 0 || v[j] > a.max[j] {
+				a.max[j] = v[j]
+			}
+		}
+	}
+	if len(training) > anomalyMaxPoints {
+		s := newCaseSampler(SampleConfig{MaxCases: anomalyMaxPoints, Method: sampleReservoir, Seed: anomalySampleSeed})
+		for _, c := range training {
+			s.add(c)
+		}
+		training = s.sample()
+	}
+	raw := make([]featureVector, len(training))
+	for i, c := range training {
+		raw[i] = caseFeatures(c)
+	}
+	a.norm = newStandardizer(raw)
+	a.points = make([]featureVector, len(raw))
+	for i, v := range raw {
+		a.points[i] = a.norm.apply(v)
+	}
+
+	// Scott's rule for a d-dimensional standardized sample.
+	n := float64(max(len(a.points), 1))
+	a.bandwidth = math.Pow(n, -1.0/(float64(len(featureNames))+4))
+
+	a.looDensities = make([]float64, len(a.points))
+	for i, p := range a.points {
+		a.looDensities[i] = a.logDensity(p, i)
+	}
+	sort.Float64s(a.looDensities)
+	if len(a.looDensities) > 0 {
+		i := int(quantile * float64(len(a.looDensities)-1))
+		a.threshold = a.looDensities[min(max(i, 0), len(a.looDensities)-1)]
+	}
+	return a
+}
+
+// logDensity returns the log kernel density at standardized point v,
+// skipping the training point at index skip (use -1 to include all).
+func (a *AnomalyDetector) logDensity(v featureVector, skip int) float64 {
+	h2 := 2 * a.bandwidth * a.bandwidth
+	d := float64(len(featureNames))
+	norm := math.Pow(math.Sqrt(2*math.Pi)*a.bandwidth, d)
+	sum := 0.0
+	count := 0
+	for i, p := range a.points {
+		if i == skip {
+			continue
+		}
+		sum += math.Exp(-squaredDistance(v, p) / h2)
+		count++
+	}
+	if count == 0 || sum == 0 {
+		return math.Inf(-1)
+	}
+	return math.Log(sum / (float64(count) * norm))
+}
+
+// Check returns a warning when q is unlike the training data, or nil.
+func (a *AnomalyDetector) Check(q Query) *AnomalyWarning {
+	v := q.features()
+	density := a.logDensity(a.norm.apply(v), -1)
+	below := sort.SearchFloat64s(a.looDensities, density)
+	percentile := 100 * float64(below) / float64(max(len(a.looDensities), 1))
+
+	for j, name := range featureNames {
+		if v[j] < a.min[j] || v[j] > a.max[j] {
+			return &AnomalyWarning{
+				Message:    fmt.Sprintf("%s %g is outside the training range [%g, %g]", name, v[j], a.min[j], a.max[j]),
+				LogDensity: density,
+				Percentile: percentile,
+			}
+		}
+	}
+	if density < a.threshold {
+		return &AnomalyWarning{
+			Message:    fmt.Sprintf("input combination is implausible: density is lower than %.1f%% of training cases", 100-percentile),
+			LogDensity: density,
+			Percentile: percentile,
+		}
+	}
+	return nil
+}
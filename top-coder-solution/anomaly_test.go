@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// clusteredCases returns a dense cluster of short, low-mileage trips with
+// two outlying cases stretching the training range to 1000 miles and $2000.
+func clusteredCases() TrainingData {
+	var data TrainingData
+	for days := 1; days <= 5; days++ {
+		for miles := 100.0; miles <= 200; miles += 10 {
+			for receipts := 100.0; receipts <= 200; receipts += 20 {
+				data = append(data, testCase(days, miles, receipts, 500))
+			}
+		}
+	}
+	return append(data, testCase(1, 1000, 100, 800), testCase(5, 100, 2000, 900))
+}
+
+func testCase(days int, miles, receipts, output float64) TestCase {
+	var c TestCase
+	c.Input.TripDurationDays = days
+	c.Input.MilesTraveled = miles
+	c.Input.TotalReceiptsAmount = receipts
+	c.ExpectedOutput = output
+	return c
+}
+
+func TestAnomalyDetectorCheck(t *testing.T) {
+	a := newAnomalyDetector(clusteredCases(), 0.01)
+	tests := []struct {
+		name string
+		q    Query
+		want string // substring of the warning; empty for none
+	}{
+		{"inside the cluster", Query{TripDurationDays: 3, MilesTraveled: 150, TotalReceiptsAmount: 150}, ""},
+		{"on a training case", Query{TripDurationDays: 1, MilesTraveled: 100, TotalReceiptsAmount: 100}, ""},
+		{"days above the range", Query{TripDurationDays: 9, MilesTraveled: 150, TotalReceiptsAmount: 150}, "days 9 is outside the training range [1, 5]"},
+		{"days below the range", Query{TripDurationDays: 0, MilesTraveled: 150, TotalReceiptsAmount: 150}, "days 0 is outside the training range [1, 5]"},
+		{"miles above the range", Query{TripDurationDays: 3, MilesTraveled: 1500, TotalReceiptsAmount: 150}, "miles 1500 is outside the training range [100, 1000]"},
+		{"receipts above the range", Query{TripDurationDays: 3, MilesTraveled: 150, TotalReceiptsAmount: 2500}, "receipts 2500 is outside the training range [100, 2000]"},
+		{"empty corner of the range", Query{TripDurationDays: 5, MilesTraveled: 900, TotalReceiptsAmount: 1800}, "input combination is implausible"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := a.Check(tt.q)
+			switch {
+			case tt.want == "" && w != nil:
+				t.Errorf("got warning %q, want none", w.Message)
+			case tt.want != "" && w == nil:
+				t.Errorf("got no warning, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(w.Message, tt.want):
+				t.Errorf("got warning %q, want %q", w.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnomalyDetectorQuantiles(t *testing.T) {
+	data := clusteredCases()
+	for _, tt := range []struct {
+		name     string
+		data     TrainingData
+		quantile float64
+	}{
+		{"no training data", nil, 0.5},
+		{"one case", data[:1], 0.5},
+		{"lowest density", data, 0},
+		{"highest quantile", data, 0.999999},
+		{"quantile past the range", data, 2},
+		{"negative quantile", data, -1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Must not panic; validateAnomalyQuantile keeps the last two
+			// from the command line, but the detector clamps them anyway.
+			newAnomalyDetector(tt.data, tt.quantile).Check(Query{TripDurationDays: 3, MilesTraveled: 150, TotalReceiptsAmount: 150})
+		})
+	}
+}
+
+func TestValidateAnomalyQuantile(t *testing.T) {
+	for _, tt := range []struct {
+		q  float64
+		ok bool
+	}{
+		{0, true}, {0.01, true}, {0.999, true}, {1, false}, {2, false}, {-0.1, false},
+	} {
+		if err := validateAnomalyQuantile(tt.q); (err == nil) != tt.ok {
+			t.Errorf("validateAnomalyQuantile(%g) = %v, want ok %v", tt.q, err, tt.ok)
+		}
+	}
+}
+
+// TestAnomalyDetectorSamplesLargeData checks that data past anomalyMaxPoints
+// is estimated from a fixed sample, while the range covers every case.
+func TestAnomalyDetectorSamplesLargeData(t *testing.T) {
+	data := randomCases(3*anomalyMaxPoints, 7)
+	data = append(data, testCase(30, 5000, 9000, 2000))
+	a := newAnomalyDetector(data, 0.01)
+	if len(a.points) != anomalyMaxPoints {
+		t.Errorf("density estimated from %d points, want %d", len(a.points), anomalyMaxPoints)
+	}
+	if a.max != (featureVector{30, 5000, 9000}) {
+		t.Errorf("training range ends at %v, want the last case", a.max)
+	}
+	b := newAnomalyDetector(data, 0.01)
+	if a.threshold != b.threshold {
+		t.Errorf("the same data gave thresholds %g and %g", a.threshold, b.threshold)
+	}
+}
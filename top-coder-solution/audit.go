@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of the audit log. Records form a hash chain: each
+// record's Hash covers its contents and the previous record's hash, so any
+// edit, deletion or reordering breaks verification from that point on.
+type AuditRecord struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	Query         Query              `json:"query"`
+	Reimbursement float64            `json:"reimbursement"`
+	Abstained     bool               `json:"abstained,omitempty"`
+	ModelVersion  string             `json:"model_version"`
+	DataSHA256    string             `json:"data_sha256"`
+	Explanation   ExplanationSummary `json:"explanation"`
+	Warning       string             `json:"warning,omitempty"`
+	PrevHash      string             `json:"prev_hash"`
+	Hash          string             `json:"hash"`
+}
+
+// computeHash returns the chain hash of r, ignoring any existing Hash.
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// newAuditRecord builds the audit record for a prediction response made by
+// the model described by prov.
+func newAuditRecord(resp PredictionResponse, prov Provenance, summary ExplanationSummary) AuditRecord {
+	r := AuditRecord{
+		Timestamp:     prov.Timestamp,
+		Query:         resp.Input,
+		Reimbursement: resp.Reimbursement,
+		Abstained:     resp.Abstained,
+		ModelVersion:  prov.ModelVersion,
+		DataSHA256:    prov.DataSHA256,
+		Explanation:   summary,
+	}
+	if resp.Warning != nil {
+		r.Warning = resp.Warning.Message
+	}
+	return r
+}
+
+// auditFlags are the flags configuring the audit log.
+type auditFlags struct {
+	path     string
+	maxBytes int64
+	keep     int
+}
+
+func (a *auditFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&a.path, "audit-log", "", "append a hash-chained JSONL record of each prediction to this file")
+	fs.Int64Var(&a.maxBytes, "audit-max-bytes", 64<<20, "rotate the audit log once it exceeds this size (0 disables)")
+	fs.IntVar(&a.keep, "audit-keep", 10, "number of rotated audit files to retain")
+}
+
+// open returns the configured audit log, or nil when none is configured.
+func (a *auditFlags) open() (*AuditLog, error) {
+	if a.path == "" {
+		return nil, nil
+	}
+	l, err := OpenAuditLog(a.path, a.maxBytes, a.keep)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %v", err)
+	}
+	return l, nil
+}
+
+// AuditLog appends hash-chained prediction records to a JSONL file, rotating
+// it to path.1, path.2, ... once it exceeds maxBytes. Processes sharing a
+// log take turns through an exclusive lock on path.lock, each continuing
+// the chain from the file's last record, so concurrent CLI runs and server
+// replicas writing one log keep a single unbroken chain.
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	keep     int
+	lock     *os.File
+	file     *os.File
+}
+
+// OpenAuditLog opens path for appending, continuing the hash chain from the
+// last record of the current or most recently rotated file. A maxBytes of 0
+// disables rotation.
+func OpenAuditLog(path string, maxBytes int64, keep int) (*AuditLog, error) {
+	l := &AuditLog{path: path, maxBytes: maxBytes, keep: keep}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	l.lock = lock
+	if err := l.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	// Read the chain's tail now, so a damaged log fails here rather than on
+	// the first prediction.
+	if _, err := l.tailHash(); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+func (l *AuditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	return nil
+}
+
+// tailHash returns the hash of the chain's last record: the last of the
+// current file or, when it is empty, of the most recently rotated one.
+func (l *AuditLog) tailHash() (string, error) {
+	prev, err := lastAuditHash(l.path)
+	if err != nil || prev != "" {
+		return prev, err
+	}
+	return lastAuditHash(rotatedPath(l.path, 1))
+}
+
+// auditTailChunk is how much of the end of an audit file lastAuditHash
+// reads at a time looking for the last record.
+const auditTailChunk = 4096
+
+// lastAuditHash returns the hash of the last record in path, or "" if the
+// file is missing or empty. It reads only the end of the file.
+func lastAuditHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	var tail []byte
+	for off := info.Size(); off > 0; {
+		n := min(off, auditTailChunk)
+		off -= n
+		chunk := make([]byte, n)
+		if _, err := file.ReadAt(chunk, off); err != nil {
+			return "", err
+		}
+		tail = append(chunk, tail...)
+		if trimmed := bytes.TrimRight(tail, "\n"); off == 0 || bytes.IndexByte(trimmed, '\n') >= 0 {
+			tail = trimmed
+			break
+		}
+	}
+	if len(tail) == 0 {
+		return "", nil
+	}
+	line := tail[bytes.LastIndexByte(tail, '\n')+1:]
+	var r AuditRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		return "", fmt.Errorf("reading last audit record of %s: %v", path, err)
+	}
+	return r.Hash, nil
+}
+
+// Record appends r to the log, filling in the chain hashes. Holding the
+// log's lock, it reopens the file if another process has rotated it, and
+// chains from whatever record was written last.
+func (l *AuditLog) Record(r AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := lockFile(l.lock); err != nil {
+		return fmt.Errorf("locking audit log: %v", err)
+	}
+	defer unlockFile(l.lock)
+
+	size, err := l.reopenIfRotated()
+	if err != nil {
+		return err
+	}
+	if r.PrevHash, err = l.tailHash(); err != nil {
+		return err
+	}
+	hash, err := r.computeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.maxBytes > 0 && size > 0 && size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating audit log: %v", err)
+		}
+	}
+
+	if _, err := l.file.Write(line); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// reopenIfRotated reopens the log when the file open is no longer the one
+// at path, because another process rotated it, and returns the size of the
+// file at path.
+func (l *AuditLog) reopenIfRotated() (int64, error) {
+	open, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	current, err := os.Stat(l.path)
+	if err == nil && os.SameFile(open, current) {
+		return open.Size(), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	l.file.Close()
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// rotate shifts path.N to path.N+1, dropping files beyond keep, and moves the
+// current file to path.1.
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if l.keep > 0 {
+		os.Remove(rotatedPath(l.path, l.keep))
+	}
+	for n := l.keep - 1; n >= 1; n-- {
+		if _, err := os.Stat(rotatedPath(l.path, n)); err == nil {
+			if err := os.Rename(rotatedPath(l.path, n), rotatedPath(l.path, n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the underlying files.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lock.Close()
+	return l.file.Close()
+}
+
+// verifyAuditFile checks the hash chain of one audit file. With strictStart
+// set, the first record must chain from prev; otherwise its prev_hash is
+// accepted as is (e.g. the oldest retained file after rotation). It returns
+// the hash of the last record and the number of records.
+func verifyAuditFile(path, prev string, strictStart bool) (string, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	count := 0
+	for scanner.Scan() {
+		count++
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return "", count, fmt.Errorf("%s line %d: %v", path, count, err)
+		}
+		if (count > 1 || strictStart) && r.PrevHash != prev {
+			return "", count, fmt.Errorf("%s line %d: chain broken (prev_hash %.12s, expected %.12s)", path, count, r.PrevHash, prev)
+		}
+		want, err := r.computeHash()
+		if err != nil {
+			return "", count, err
+		}
+		if want != r.Hash {
+			return "", count, fmt.Errorf("%s line %d: record hash mismatch, contents were modified", path, count)
+		}
+		prev = r.Hash
+	}
+	return prev, count, scanner.Err()
+}
+
+func runVerifyAudit(args []string) error {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: verify-audit <oldest.jsonl> [... <newest.jsonl>]")
+	}
+
+	prev := ""
+	total := 0
+	for i, path := range fs.Args() {
+		last, n, err := verifyAuditFile(path, prev, i > 0)
+		if err != nil {
+			return err
+		}
+		prev = last
+		total += n
+	}
+	fmt.Printf("Audit chain intact: %d records in %d files\n", total, fs.NArg())
+	return nil
+}
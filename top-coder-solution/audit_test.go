@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAudit records n predictions to the audit log at path.
+func writeAudit(t *testing.T, path string, maxBytes int64, keep, n int) {
+	t.Helper()
+	l, err := OpenAuditLog(path, maxBytes, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := range n {
+		r := AuditRecord{Query: Query{TripDurationDays: i + 1, MilesTraveled: 100, TotalReceiptsAmount: 50}, Reimbursement: float64(200 + i), ModelVersion: "test"}
+		if err := l.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// verifyAudit verifies the chain across files, oldest first, and returns
+// the number of records.
+func verifyAudit(files ...string) (int, error) {
+	prev, total := "", 0
+	for i, f := range files {
+		last, n, err := verifyAuditFile(f, prev, i > 0)
+		if err != nil {
+			return total, err
+		}
+		prev, total = last, total+n
+	}
+	return total, nil
+}
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeAudit(t, path, 0, 0, 3)
+	// Reopening continues the chain from the last record.
+	writeAudit(t, path, 0, 0, 2)
+	n, err := verifyAudit(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("%d records, want 5", n)
+	}
+	last, err := lastAuditHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev, _, _ := verifyAuditFile(path, "", false); last != prev {
+		t.Errorf("lastAuditHash %s, want the last record's %s", last, prev)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	// Each record is a few hundred bytes, so every file holds one or two.
+	writeAudit(t, path, 700, 3, 12)
+
+	if _, err := os.Stat(rotatedPath(path, 4)); !os.IsNotExist(err) {
+		t.Errorf("%s kept beyond -audit-keep 3 (stat error %v)", rotatedPath(path, 4), err)
+	}
+	files := []string{rotatedPath(path, 3), rotatedPath(path, 2), rotatedPath(path, 1), path}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 700 {
+			t.Errorf("%s is %d bytes, over the 700 limit", filepath.Base(f), info.Size())
+		}
+	}
+	// The oldest retained file chains from a dropped one, so only the
+	// files after it must link up.
+	if _, err := verifyAudit(files...); err != nil {
+		t.Error(err)
+	}
+
+	// A log emptied by rotation continues from the rotated file.
+	if err := os.WriteFile(path, nil, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	writeAudit(t, path, 0, 3, 1)
+	if _, err := verifyAudit(rotatedPath(path, 1), path); err != nil {
+		t.Errorf("after rotation: %v", err)
+	}
+}
+
+func TestVerifyAuditFileTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeAudit(t, path, 0, 0, 4)
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(bytes.TrimRight(orig, "\n"), []byte("\n"))
+
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		want   string
+	}{
+		{"intact", func(l [][]byte) [][]byte { return l }, ""},
+		{"edited", func(l [][]byte) [][]byte {
+			l[1] = bytes.Replace(l[1], []byte(`"reimbursement":201`), []byte(`"reimbursement":999`), 1)
+			return l
+		}, "line 2: record hash mismatch"},
+		{"deleted", func(l [][]byte) [][]byte { return append(l[:1:1], l[2:]...) }, "line 2: chain broken"},
+		{"reordered", func(l [][]byte) [][]byte {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, "line 2: chain broken"},
+		{"truncated", func(l [][]byte) [][]byte {
+			l[3] = l[3][:len(l[3])/2]
+			return l
+		}, "line 4:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.tamper(append([][]byte(nil), lines...))
+			tampered := filepath.Join(t.TempDir(), "audit.jsonl")
+			if err := os.WriteFile(tampered, bytes.Join(lines, nil), 0o640); err != nil {
+				t.Fatal(err)
+			}
+			_, _, err := verifyAuditFile(tampered, "", false)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	// A later file must chain from the last record of the one before.
+	if _, _, err := verifyAuditFile(path, "0123456789ab", true); err == nil || !strings.Contains(err.Error(), "line 1: chain broken") {
+		t.Errorf("strict start: error %v, want a broken chain at line 1", err)
+	}
+}
+
+func TestOpenAuditLogDamagedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte("{\"hash\":\"abc\"}\nnot json\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenAuditLog(path, 0, 0); err == nil || !strings.Contains(err.Error(), "reading last audit record") {
+		t.Errorf("error %v, want one about the last record", err)
+	}
+}
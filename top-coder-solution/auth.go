@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scopes grant access to groups of endpoints.
+const (
+	scopePredict = "predict"
+	scopeAdmin   = "admin"
+)
+
+// authConfig is the JSON file configuring API credentials. Tokens may be
+// given in plain text or, preferably, as their hex SHA-256.
+type authConfig struct {
+	Tokens []struct {
+		Name        string   `json:"name"`
+		Token       string   `json:"token,omitempty"`
+		TokenSHA256 string   `json:"token_sha256,omitempty"`
+		Scopes      []string `json:"scopes"`
+	} `json:"tokens"`
+	Clients []struct {
+		CommonName string   `json:"common_name"`
+		Scopes     []string `json:"scopes"`
+	} `json:"clients"`
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name   string
+	Scopes map[string]bool
+}
+
+func newPrincipal(name string, scopes []string) (*Principal, error) {
+	p := &Principal{Name: name, Scopes: map[string]bool{}}
+	for _, s := range scopes {
+		if s != scopePredict && s != scopeAdmin {
+			return nil, fmt.Errorf("%s: unknown scope %q", name, s)
+		}
+		p.Scopes[s] = true
+	}
+	return p, nil
+}
+
+// authenticator resolves bearer tokens and verified client certificates to
+// principals.
+type authenticator struct {
+	tokens  map[[sha256.Size]byte]*Principal
+	clients map[string]*Principal // by certificate common name
+}
+
+func loadAuthenticator(path string) (*authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	a := &authenticator{tokens: map[[sha256.Size]byte]*Principal{}, clients: map[string]*Principal{}}
+	for i, t := range cfg.Tokens {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("token %d", i)
+		}
+		p, err := newPrincipal(name, t.Scopes)
+		if err != nil {
+			return nil, err
+		}
+
+		var sum [sha256.Size]byte
+		switch {
+		case t.TokenSHA256 != "":
+			raw, err := hex.DecodeString(t.TokenSHA256)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("%s: token_sha256 must be 64 hex characters", name)
+			}
+			copy(sum[:], raw)
+		case t.Token != "":
+			sum = sha256.Sum256([]byte(t.Token))
+		default:
+			return nil, fmt.Errorf("%s: one of token or token_sha256 is required", name)
+		}
+		a.tokens[sum] = p
+	}
+	for _, c := range cfg.Clients {
+		if c.CommonName == "" {
+			return nil, fmt.Errorf("client entries require common_name")
+		}
+		p, err := newPrincipal("cert:"+c.CommonName, c.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		a.clients[c.CommonName] = p
+	}
+	return a, nil
+}
+
+// authenticate returns the caller's principal, or nil if the request carries
+// no valid credentials. A verified client certificate takes precedence over
+// a bearer token.
+func (a *authenticator) authenticate(r *http.Request) *Principal {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if p, ok := a.clients[r.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return p
+		}
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil
+	}
+	// Looking up the token's hash rather than the token keeps comparison
+	// timing independent of how much of a guessed token is correct.
+	return a.tokens[sha256.Sum256([]byte(token))]
+}
+
+type principalKey struct{}
+
+// principalFrom returns the authenticated principal of a request, if any.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// require wraps h so that it only runs for callers holding scope. Without an
+// authenticator every request is allowed.
+func (s *Server) require(scope string, h http.HandlerFunc) http.Handler {
+	if s.auth == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := s.auth.authenticate(r)
+		if p == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="reimbursement"`)
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !p.Scopes[scope] {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s lacks the %s scope", p.Name, scope))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// serverTLSConfig builds the TLS configuration. With a client CA, client
+// certificates are verified when presented so that mTLS and bearer tokens
+// can be used side by side.
+func serverTLSConfig(clientCA string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", clientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
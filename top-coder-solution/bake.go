@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Baking embeds a registered model version in the binary, so it predicts
+// with no training data or registry at run time, as for an air-gapped
+// deployment. bake writes the version's manifest and its training data,
+// packed, to the baked directory, which binaries built with the baked tag
+// embed:
+//
+//	MODEL_TAG=v3 go generate
+//	CGO_ENABLED=0 go build -tags baked
+//
+// gives a single static binary whose default -data is the baked model,
+// which the positional interface predicts with too.
+//
+//go:generate go run . bake -model-tag $MODEL_TAG
+const (
+	bakedDir      = "baked"
+	bakedManifest = "manifest.json"
+	bakedCases    = "cases.pack"
+)
+
+// bakedData is the -data of the model baked into the binary.
+const bakedData = "baked:"
+
+// isBaked reports whether a model is baked into this binary.
+func isBaked() bool {
+	return bakedManifestJSON != nil
+}
+
+// loadBakedModel builds the predictor of the model baked into the binary,
+// sampling its training data as the registered model does.
+func loadBakedModel() (*Predictor, error) {
+	if !isBaked() {
+		return nil, fmt.Errorf("no model is baked into this binary; build it with -tags %s", bakedDir)
+	}
+	timer.beginLoad()
+	var m ModelManifest
+	if err := json.Unmarshal(bakedManifestJSON, &m); err != nil {
+		return nil, fmt.Errorf("parsing baked manifest: %v", err)
+	}
+	n, recordSize, err := packedCount(bakedCasesPacked, int64(len(bakedCasesPacked)))
+	if err != nil {
+		return nil, fmt.Errorf("baked training data: %v", err)
+	}
+	data := make(TrainingData, n)
+	decodeRecords(data, bakedCasesPacked[packedHeaderSize:], recordSize)
+	if err := checkCases(data); err != nil {
+		return nil, fmt.Errorf("baked training data: %v", err)
+	}
+	if err := checkNotEmpty(bakedData, data); err != nil {
+		return nil, err
+	}
+	if cfg := m.Hyperparameters.Sample; cfg != nil {
+		s := newCaseSampler(*cfg)
+		for _, c := range data {
+			s.add(c)
+		}
+		data = s.sample()
+	}
+	timer.end(phaseLoad)
+	p := NewPredictor(data, m.Hyperparameters)
+	timer.end(phaseIndex)
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("baked model %q: %v", m.Tag, err)
+	}
+	p.Version = m.Tag
+	p.DataSHA256 = m.DataSHA256
+	return p, nil
+}
+
+// predictBaked answers the positional interface with the baked model.
+func predictBaked(q Query, args []string, dryRun bool, diag *diagnostics) error {
+	p, err := loadBakedModel()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	diag.printf(verbosityInfo, "loaded baked model %s (%d cases) in %v\n", p.Version, len(p.Training), diag.elapsed())
+	if dryRun {
+		diag.printf(verbosityNormal, "dry run: %s\n",
+			dryRunSummary(args, fmt.Sprintf("baked model %s builds from %d cases", p.Version, len(p.Training))))
+		return nil
+	}
+	y := p.PredictQuery(q)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	if diag.enabled(verbosityDebug) {
+		printNeighbors(os.Stderr, q, p.Training, p.K)
+	}
+	diag.printf(verbosityInfo, "predicted with baked model %s in %v total\n", p.Version, diag.elapsed())
+	fmt.Println(centsOf(y))
+	return nil
+}
+
+func runBake(args []string) error {
+	fs := flag.NewFlagSet("bake", flag.ContinueOnError)
+	tag := fs.String("model-tag", "", "registered model version to bake (required)")
+	registry := fs.String("registry", defaultRegistry, "model registry directory")
+	out := fs.String("out", bakedDir, "directory to write the baked model to, which -tags baked builds embed")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tag == "" {
+		return fmt.Errorf("-model-tag is required")
+	}
+	// Loading the version verifies its data and that it builds.
+	p, m, err := loadRegisteredModel(*registry, *tag)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if strings.HasPrefix(m.Hyperparameters.Model, execPrefix) {
+		return fmt.Errorf("model %q predicts with an external program, which cannot be baked", *tag)
+	}
+	data, err := loadTrainingFile(filepath.Join(*registry, *tag, casesFile), false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	if err := writePacked(filepath.Join(*out, bakedCases), data); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(*out, bakedManifest), m); err != nil {
+		return err
+	}
+	fmt.Printf("Baked model %s (%d cases) into %s; build with -tags %s to embed it\n", m.Tag, len(data), *out, bakedDir)
+	return nil
+}
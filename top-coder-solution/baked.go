@@ -0,0 +1,14 @@
+//go:build baked
+
+package main
+
+import _ "embed"
+
+// The model bake wrote to the baked directory, embedded by builds with the
+// baked tag.
+var (
+	//go:embed baked/manifest.json
+	bakedManifestJSON []byte
+	//go:embed baked/cases.pack
+	bakedCasesPacked []byte
+)
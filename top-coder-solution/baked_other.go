@@ -0,0 +1,6 @@
+//go:build !baked
+
+package main
+
+// Builds without the baked tag embed no model.
+var bakedManifestJSON, bakedCasesPacked []byte
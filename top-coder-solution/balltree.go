@@ -0,0 +1,138 @@
+package main
+
+import "math"
+
+// ballTree is an exact neighbor index for any metric satisfying the
+// triangle inequality. Each node bounds its cases by a ball around their
+// centroid; a query skips every node whose ball lies farther away than the
+// current kth nearest neighbor. Unlike a KD-tree, whose axis-aligned splits
+// assume a coordinate-wise metric, the bound holds for Manhattan and
+// Mahalanobis distance alike.
+type ballTree struct {
+	points  []featureVector
+	outputs []float64
+	metric  distanceMetric
+	order   []int32 // case indexes, grouped by leaf
+	nodes   []ballNode
+}
+
+type ballNode struct {
+	center      featureVector
+	radius      float64
+	start, end  int   // range of order
+	left, right int32 // child nodes, -1 at leaves
+}
+
+// ballLeafSize is the most cases a leaf holds; below it scanning is cheaper
+// than descending further.
+const ballLeafSize = 16
+
+func newBallTree(data TrainingData, metric distanceMetric) *ballTree {
+	t := &ballTree{
+		points:  make([]featureVector, len(data)),
+		outputs: make([]float64, len(data)),
+		metric:  metric,
+		order:   make([]int32, len(data)),
+	}
+	for i, c := range data {
+		t.points[i] = caseFeatures(c)
+		t.outputs[i] = c.ExpectedOutput
+		t.order[i] = int32(i)
+	}
+	if len(data) > 0 {
+		t.build(0, len(data))
+	}
+	return t
+}
+
+// build adds the node over order[start:end] and returns its index.
+func (t *ballTree) build(start, end int) int32 {
+	ids := t.order[start:end]
+	var center featureVector
+	for _, id := range ids {
+		for j := range center {
+			center[j] += t.points[id][j] / float64(len(ids))
+		}
+	}
+	n := ballNode{center: center, start: start, end: end, left: -1, right: -1}
+	for _, id := range ids {
+		n.radius = math.Max(n.radius, t.metric.distance(center, t.points[id]))
+	}
+	idx := int32(len(t.nodes))
+	t.nodes = append(t.nodes, n)
+	if len(ids) <= ballLeafSize || n.radius == 0 {
+		return idx
+	}
+
+	// Split around two far-apart pivots: the case farthest from the centroid
+	// and the case farthest from that one.
+	a := t.farthest(ids, center)
+	b := t.farthest(ids, t.points[a])
+	pa, pb := t.points[a], t.points[b]
+	mid := 0
+	for i, id := range ids {
+		if t.metric.distance(t.points[id], pa) <= t.metric.distance(t.points[id], pb) {
+			ids[i], ids[mid] = ids[mid], ids[i]
+			mid++
+		}
+	}
+	if mid == 0 || mid == len(ids) {
+		mid = len(ids) / 2
+	}
+
+	left := t.build(start, start+mid)
+	right := t.build(start+mid, end)
+	t.nodes[idx].left, t.nodes[idx].right = left, right
+	return idx
+}
+
+func (t *ballTree) farthest(ids []int32, from featureVector) int32 {
+	best, bestDist := ids[0], -1.0
+	for _, id := range ids {
+		if d := t.metric.distance(from, t.points[id]); d > bestDist {
+			best, bestDist = id, d
+		}
+	}
+	return best
+}
+
+func (t *ballTree) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	k = min(k, len(t.points))
+	if k == 0 {
+		return dst
+	}
+	nearest := t.visit(dst[len(dst):], 0, q, k)
+	return append(dst, nearest...)
+}
+
+func (t *ballTree) visit(nearest []Neighbor, node int32, q featureVector, k int) []Neighbor {
+	n := &t.nodes[node]
+	if n.left < 0 {
+		for _, id := range t.order[n.start:n.end] {
+			nearest = insertNeighbor(nearest, k, Neighbor{Distance: t.metric.distance(q, t.points[id]), Output: t.outputs[id], Case: int(id)})
+		}
+		return nearest
+	}
+
+	// Descend into the nearer child first so the farther one is more likely
+	// to be pruned.
+	near, far := n.left, n.right
+	dNear := t.bound(near, q)
+	dFar := t.bound(far, q)
+	if dFar < dNear {
+		near, far, dNear, dFar = far, near, dFar, dNear
+	}
+	if len(nearest) < k || dNear < nearest[len(nearest)-1].Distance {
+		nearest = t.visit(nearest, near, q, k)
+	}
+	if len(nearest) < k || dFar < nearest[len(nearest)-1].Distance {
+		nearest = t.visit(nearest, far, q, k)
+	}
+	return nearest
+}
+
+// bound is a lower bound on the distance from q to any case under node.
+func (t *ballTree) bound(node int32, q featureVector) float64 {
+	n := &t.nodes[node]
+	return math.Max(0, t.metric.distance(q, n.center)-n.radius)
+}
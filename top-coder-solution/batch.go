@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+)
+
+func runBatch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	in := fs.String("in", "", "cases to predict, labelled or not, a path or s3:// or gs:// URI (required)")
+	from := fs.String("from", "", "format of -in: json, csv or xlsx (default from its extension)")
+	out := fs.String("out", "", "write the predictions to this path (default stdout)")
+	asJSON := fs.Bool("json", false, "write the predictions as JSON instead of one amount per line")
+	var format amountFormat
+	format.register(fs)
+	var privacy privacyFlags
+	privacy.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if err := format.validate(); err != nil {
+		return err
+	}
+	if err := privacy.validate(); err != nil {
+		return err
+	}
+	inFormat, err := fileFormat(*from, *in)
+	if err != nil {
+		return err
+	}
+	inPath, err := localPath(*in)
+	if err != nil {
+		return err
+	}
+	m, err := parseColumnMapping(model.table.columns)
+	if err != nil {
+		return err
+	}
+	cases, _, err := readCases(inPath, inFormat, m, model.table)
+	if err != nil {
+		return fmt.Errorf("loading cases: %v", err)
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer predictor.Close()
+	noise := privacy.noise(predictor.Training)
+	var rng *rand.Rand
+	if noise != nil {
+		fmt.Fprintln(os.Stderr, noise)
+		rng = noise.rng(0)
+	}
+	preds := make([]PredictionResponse, len(cases))
+	for i, c := range cases {
+		y := predictor.PredictQuery(c.Input)
+		if noise != nil {
+			y = noise.add(rng, y)
+		}
+		preds[i] = PredictionResponse{Input: c.Input, Reimbursement: format.round(y)}
+		if format.style != formatPlain {
+			preds[i].Formatted = format.format(preds[i].Reimbursement)
+		}
+	}
+	if err := predictor.Err(); err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return writeJSONFile(*out, preds)
+	}
+	file := os.Stdout
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	w := bufio.NewWriter(file)
+	for _, p := range preds {
+		fmt.Fprintln(w, format.format(p.Reimbursement))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *out != "" {
+		return file.Close()
+	}
+	return nil
+}
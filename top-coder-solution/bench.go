@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// IndexBenchmark is the measured cost of one exact index on a dataset.
+type IndexBenchmark struct {
+	Index      string
+	Build      time.Duration
+	Query      time.Duration // mean per query
+	Mismatches int           // queries whose neighbor distances differ from a full scan
+}
+
+// benchmarkIndexes builds each index over data and times it on queries,
+// checking its answers against a full scan.
+func benchmarkIndexes(kinds []string, data TrainingData, metric distanceMetric, queries []featureVector, k int) []IndexBenchmark {
+	brute := bruteIndex{data, metric}
+	want := make([][]Neighbor, len(queries))
+	for i, q := range queries {
+		want[i] = brute.search(nil, q, k)
+	}
+
+	var results []IndexBenchmark
+	for _, kind := range kinds {
+		start := time.Now()
+		idx := buildIndex(&IndexConfig{Kind: kind}, data, metric)
+		b := IndexBenchmark{Index: kind, Build: time.Since(start)}
+
+		got := make([][]Neighbor, len(queries))
+		start = time.Now()
+		for i, q := range queries {
+			got[i] = idx.search(nil, q, k)
+		}
+		if len(queries) > 0 {
+			b.Query = time.Since(start) / time.Duration(len(queries))
+		}
+		for i := range queries {
+			if !sameDistances(got[i], want[i]) {
+				b.Mismatches++
+			}
+		}
+		results = append(results, b)
+	}
+	return results
+}
+
+func sameDistances(a, b []Neighbor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Distance != b[i].Distance {
+			return false
+		}
+	}
+	return true
+}
+
+// randomQueries draws queries uniformly over the bounding box of data.
+func randomQueries(data TrainingData, n int, seed uint64) []featureVector {
+	if len(data) == 0 {
+		return nil
+	}
+	lo, hi := caseFeatures(data[0]), caseFeatures(data[0])
+	for _, c := range data {
+		v := caseFeatures(c)
+		for j := range featureNames {
+			lo[j], hi[j] = min(lo[j], v[j]), max(hi[j], v[j])
+		}
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	queries := make([]featureVector, n)
+	for i := range queries {
+		queries[i] = featureVector{
+			float64(int(lo[0]) + rng.IntN(int(hi[0]-lo[0])+1)),
+			lo[1] + rng.Float64()*(hi[1]-lo[1]),
+			lo[2] + rng.Float64()*(hi[2]-lo[2]),
+		}
+	}
+	return queries
+}
+
+func runBenchIndex(args []string) error {
+	fs := flag.NewFlagSet("bench-index", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path")
+	k := fs.Int("k", defaultK, "number of neighbors")
+	metricName := fs.String("metric", metricEuclidean, "distance metric: euclidean, manhattan or mahalanobis")
+	n := fs.Int("queries", 10000, "number of random queries")
+	seed := fs.Uint64("seed", 1, "random seed for the queries")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := validateMetric(*metricName); err != nil {
+		return err
+	}
+	data, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+
+	kinds := []string{indexBallTree, indexVPTree}
+	if *metricName != metricMahalanobis {
+		kinds = append([]string{indexKDTree}, kinds...)
+	}
+	if isEuclidean(*metricName) {
+		kinds = append([]string{indexExact}, kinds...) // the struct-of-arrays scan
+	}
+	metric := newMetric(*metricName, data, nil)
+	queries := randomQueries(data, *n, *seed)
+
+	// The full scan is the baseline every tree must beat.
+	start := time.Now()
+	brute := bruteIndex{data, metric}
+	for _, q := range queries {
+		brute.search(nil, q, *k)
+	}
+	scan := time.Since(start) / time.Duration(max(len(queries), 1))
+
+	fmt.Printf("%d cases, %d queries, k=%d, %s metric\n\n", len(data), len(queries), *k, *metricName)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tBUILD\tQUERY\tSPEEDUP\tMISMATCHES")
+	fmt.Fprintf(w, "full scan\t-\t%v\t1.00x\t-\n", scan)
+	for _, b := range benchmarkIndexes(kinds, data, metric, queries, *k) {
+		fmt.Fprintf(w, "%s\t%v\t%v\t%.2fx\t%d\n", b.Index, b.Build, b.Query, float64(scan)/float64(max(b.Query, 1)), b.Mismatches)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// BinConfig sets the bucket widths the inputs of training cases and queries
+// are rounded to before neighbors are matched, as a legacy system working on
+// bucketed values would have seen them. A zero width leaves its input raw.
+type BinConfig struct {
+	Days     float64 `json:"days,omitempty"`
+	Miles    float64 `json:"miles,omitempty"`
+	Receipts float64 `json:"receipts,omitempty"`
+}
+
+// parseBins parses comma-separated input=width pairs, as in
+// "receipts=10,miles=5".
+func parseBins(s string) (*BinConfig, error) {
+	b := &BinConfig{}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		width, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid bins %q, want input=width pairs such as receipts=10,miles=5", s)
+		}
+		switch name {
+		case "days":
+			b.Days = width
+		case "miles":
+			b.Miles = width
+		case "receipts":
+			b.Receipts = width
+		default:
+			return nil, fmt.Errorf("bins: unknown input %q (want days, miles or receipts)", name)
+		}
+	}
+	return b, b.validate()
+}
+
+func (b *BinConfig) validate() error {
+	if b == nil {
+		return nil
+	}
+	for _, w := range []float64{b.Days, b.Miles, b.Receipts} {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("bin widths must be finite and not negative")
+		}
+	}
+	if b.Days != 0 && b.Days != math.Trunc(b.Days) {
+		return fmt.Errorf("the days bin width must be a whole number")
+	}
+	return nil
+}
+
+// binValue rounds x to the nearest multiple of width, or returns it as is
+// for a zero width.
+func binValue(x, width float64) float64 {
+	if width == 0 {
+		return x
+	}
+	return math.Round(x/width) * width
+}
+
+// apply returns q with its inputs rounded to their buckets. A nil config
+// returns q.
+func (b *BinConfig) apply(q Query) Query {
+	if b == nil {
+		return q
+	}
+	return Query{
+		TripDurationDays:    int(binValue(float64(q.TripDurationDays), b.Days)),
+		MilesTraveled:       binValue(q.MilesTraveled, b.Miles),
+		TotalReceiptsAmount: binValue(q.TotalReceiptsAmount, b.Receipts),
+		Fields:              q.Fields,
+	}
+}
+
+// binCases returns data with every case's inputs rounded to their buckets,
+// or data itself for a nil config.
+func binCases(data TrainingData, b *BinConfig) TrainingData {
+	if b == nil {
+		return data
+	}
+	out := make(TrainingData, len(data))
+	for i, c := range data {
+		c.Input = b.apply(c.Input)
+		out[i] = c
+	}
+	return out
+}
+
+// printBinComparison prints the errors of binned and raw matching over the
+// same cases.
+func printBinComparison(w io.Writer, b *BinConfig, binned, raw EvalSummary) {
+	fmt.Fprintf(w, "\nBinned (days %g, miles %g, receipts %g) vs raw matching:\n", b.Days, b.Miles, b.Receipts)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Matching\tExact matches\tAverage error\tScore")
+	for _, row := range []struct {
+		name string
+		s    EvalSummary
+	}{{"binned", binned}, {"raw", raw}} {
+		fmt.Fprintf(tw, "  %s\t%d\t$%.2f\t%.2f\n", row.name, row.s.ExactMatches, row.s.MeanError, row.s.Score())
+	}
+	tw.Flush()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// Discontinuity is a jump in the model's output between two adjacent inputs
+// near a threshold, with the other inputs held fixed.
+type Discontinuity struct {
+	Feature   string  `json:"feature"`
+	Threshold float64 `json:"threshold"`
+	Input     Query   `json:"input"` // the lower of the two inputs
+	To        float64 `json:"to"`    // the feature's value at the upper input
+	Before    float64 `json:"before"`
+	After     float64 `json:"after"`
+	Jump      float64 `json:"jump"`   // After minus Before
+	Excess    float64 `json:"excess"` // Jump minus the window's median step
+}
+
+// boundaryParams controls how finely boundaries are probed.
+type boundaryParams struct {
+	Width   featureVector // half-width of the window around each threshold
+	Step    featureVector // spacing of the inputs within a window
+	MinJump float64       // smallest excess over the window's median step reported
+}
+
+// boundaryAnchors returns the quartiles of each input over training, the
+// values the other inputs are held at while one is varied.
+func boundaryAnchors(training TrainingData) [3][]float64 {
+	var anchors [3][]float64
+	for j := range featureNames {
+		values := make([]float64, len(training))
+		for i, c := range training {
+			values[i] = caseFeatures(c)[j]
+		}
+		sort.Float64s(values)
+		for _, q := range []float64{0.25, 0.5, 0.75} {
+			anchors[j] = append(anchors[j], values[int(q*float64(len(values)-1))])
+		}
+	}
+	return anchors
+}
+
+// findDiscontinuities varies each input across a window around each of its
+// thresholds, with the other inputs at every combination of their anchors,
+// and reports adjacent inputs whose predictions differ by at least
+// params.MinJump more than the window's median step, largest first. Judging
+// steps against the median keeps a steady slope from counting as a jump.
+func findDiscontinuities(p *Predictor, thresholds map[string][]float64, anchors [3][]float64, params boundaryParams, workers int) []Discontinuity {
+	type probe struct {
+		feature   int
+		threshold float64
+		fixed     featureVector
+	}
+	var probes []probe
+	for j, name := range featureNames {
+		a, b := (j+1)%3, (j+2)%3
+		for _, t := range thresholds[name] {
+			for _, x := range anchors[a] {
+				for _, y := range anchors[b] {
+					var fixed featureVector
+					fixed[a], fixed[b] = x, y
+					probes = append(probes, probe{j, t, fixed})
+				}
+			}
+		}
+	}
+
+	found := make([][]Discontinuity, len(probes))
+	forEach(len(probes), workers, func(i int) {
+		pr := probes[i]
+		j := pr.feature
+		start := math.Max(0, math.Floor((pr.threshold-params.Width[j])/params.Step[j])*params.Step[j])
+		if j == 0 {
+			start = math.Max(1, start) // trips last at least a day
+		}
+		var xs, ys []float64
+		v := pr.fixed
+		for x := start; x <= pr.threshold+params.Width[j]+1e-9; x += params.Step[j] {
+			v[j] = x
+			xs = append(xs, x)
+			ys = append(ys, p.Predict(int(v[0]), v[1], v[2]))
+		}
+		if len(xs) < 2 {
+			return
+		}
+		steps := make([]float64, len(xs)-1)
+		for k := range steps {
+			steps[k] = ys[k+1] - ys[k]
+		}
+		sorted := append([]float64(nil), steps...)
+		sort.Float64s(sorted)
+		median := sorted[len(sorted)/2]
+		for k, jump := range steps {
+			if excess := jump - median; math.Abs(excess) >= params.MinJump {
+				at := pr.fixed
+				at[j] = xs[k]
+				found[i] = append(found[i], Discontinuity{
+					Feature:   featureNames[j],
+					Threshold: pr.threshold,
+					Input:     Query{TripDurationDays: int(at[0]), MilesTraveled: at[1], TotalReceiptsAmount: at[2]},
+					To:        xs[k+1],
+					Before:    ys[k],
+					After:     ys[k+1],
+					Jump:      jump,
+					Excess:    excess,
+				})
+			}
+		}
+	})
+
+	// Windows of nearby thresholds overlap; report each jump once, against
+	// the threshold nearest to it.
+	type key struct {
+		feature string
+		input   featureVector
+		to      float64
+	}
+	nearest := map[key]int{}
+	var all []Discontinuity
+	for _, ds := range found {
+		for _, d := range ds {
+			k := key{d.Feature, d.Input.features(), d.To}
+			i, ok := nearest[k]
+			if !ok {
+				nearest[k] = len(all)
+				all = append(all, d)
+				continue
+			}
+			mid := (d.To + featureValue(d.Input, d.Feature)) / 2
+			if math.Abs(d.Threshold-mid) < math.Abs(all[i].Threshold-mid) {
+				all[i] = d
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return math.Abs(all[i].Excess) > math.Abs(all[j].Excess) })
+	return all
+}
+
+// featureValue returns the named input of q.
+func featureValue(q Query, name string) float64 {
+	j, _ := featureIndex(name)
+	return q.features()[j]
+}
+
+func runBoundaries(args []string) error {
+	fs := flag.NewFlagSet("boundaries", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	segmentsPath := fs.String("thresholds", "", "segmentation config whose thresholds to probe (default discovered with a regression tree)")
+	depth := fs.Int("depth", 4, "depth of the regression tree discovering thresholds")
+	minLeaf := fs.Int("min-leaf", 20, "minimum cases per leaf of the regression tree")
+	daysWidth := fs.Float64("days-width", 2, "days probed either side of a threshold")
+	milesWidth := fs.Float64("miles-width", 50, "miles probed either side of a threshold")
+	receiptsWidth := fs.Float64("receipts-width", 50, "receipts dollars probed either side of a threshold")
+	milesStep := fs.Float64("miles-step", 1, "spacing of probed miles")
+	receiptsStep := fs.Float64("receipts-step", 1, "spacing of probed receipts, in dollars")
+	minJump := fs.Float64("min-jump", 10, "smallest change in output between adjacent inputs, beyond the local trend, reported in dollars")
+	show := fs.Int("show", 30, "number of discontinuities to list")
+	jobs := fs.Int("jobs", 0, "prediction workers (0 uses every CPU)")
+	asJSON := fs.Bool("json", false, "print the discontinuities as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *milesStep <= 0 || *receiptsStep <= 0 {
+		return fmt.Errorf("-miles-step and -receipts-step must be positive")
+	}
+	if *minJump <= 0 {
+		return fmt.Errorf("-min-jump must be positive")
+	}
+
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if len(p.Training) == 0 {
+		return fmt.Errorf("no training data to discover thresholds from")
+	}
+
+	var seg *Segmentation
+	if *segmentsPath != "" {
+		if seg, err = loadSegmentation(*segmentsPath); err != nil {
+			return err
+		}
+	} else {
+		if *depth < 1 {
+			return fmt.Errorf("-depth must be at least 1")
+		}
+		root := fitRegressionTree(p.Training, treeParams{MaxDepth: *depth, MinLeaf: *minLeaf})
+		seg = segmentationFromTree(root, "")
+	}
+
+	params := boundaryParams{
+		Width:   featureVector{*daysWidth, *milesWidth, *receiptsWidth},
+		Step:    featureVector{1, *milesStep, *receiptsStep},
+		MinJump: *minJump,
+	}
+	found := findDiscontinuities(p, seg.Thresholds, boundaryAnchors(p.Training), params, *jobs)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, found)
+	}
+	printBoundaries(os.Stdout, seg.Thresholds, found, *show)
+	return nil
+}
+
+func printBoundaries(w io.Writer, thresholds map[string][]float64, found []Discontinuity, show int) {
+	fmt.Fprintln(w, "Thresholds probed:")
+	for _, name := range featureNames {
+		if ts := thresholds[name]; len(ts) > 0 {
+			fmt.Fprintf(w, "  %s:", name)
+			for _, t := range ts {
+				fmt.Fprintf(w, " %.6g", t)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if len(found) == 0 {
+		fmt.Fprintln(w, "\nNo discontinuities found")
+		return
+	}
+	show = max(show, 0)
+	fmt.Fprintf(w, "\n%d discontinuities, largest first:\n", len(found))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEATURE\tTHRESHOLD\tDAYS\tMILES\tRECEIPTS\tTO\tBEFORE\tAFTER\tJUMP\tEXCESS")
+	for _, d := range found[:min(show, len(found))] {
+		fmt.Fprintf(tw, "%s\t%.6g\t%d\t%g\t%.2f\t%g\t%.2f\t%.2f\t%+.2f\t%+.2f\n", d.Feature, d.Threshold, d.Input.TripDurationDays,
+			d.Input.MilesTraveled, d.Input.TotalReceiptsAmount, d.To, d.Before, d.After, d.Jump, d.Excess)
+	}
+	tw.Flush()
+	if len(found) > show {
+		fmt.Fprintf(w, "... and %d more\n", len(found)-show)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// Canary verdicts.
+const (
+	verdictImproved  = "improved"
+	verdictRegressed = "regressed"
+	verdictNoChange  = "no significant change"
+)
+
+// CanaryComparison compares the mean absolute errors of two models on the
+// same cases. PValue is from a two-sided paired t-test on the per-case
+// absolute errors.
+type CanaryComparison struct {
+	Count        int     `json:"count"`
+	BaselineMAE  float64 `json:"baseline_mae"`
+	CandidateMAE float64 `json:"candidate_mae"`
+	Delta        float64 `json:"delta"` // candidate minus baseline; negative is better
+	PValue       float64 `json:"p_value"`
+	Verdict      string  `json:"verdict"`
+}
+
+// CanarySegment is a CanaryComparison restricted to one band of an input.
+type CanarySegment struct {
+	Dimension string `json:"dimension"`
+	Band      string `json:"band"`
+	CanaryComparison
+}
+
+// CanaryReport is the verdict of a candidate model against a baseline.
+type CanaryReport struct {
+	Baseline  string           `json:"baseline"`
+	Candidate string           `json:"candidate"`
+	Cases     string           `json:"cases"`
+	Alpha     float64          `json:"alpha"`
+	Overall   CanaryComparison `json:"overall"`
+	Segments  []CanarySegment  `json:"segments"`
+}
+
+// compareCanary pairs baseline and candidate results for the same cases,
+// in the same order.
+func compareCanary(baseline, candidate []EvalResult, alpha float64) CanaryComparison {
+	c := CanaryComparison{Count: len(baseline), PValue: 1, Verdict: verdictNoChange}
+	if c.Count == 0 {
+		return c
+	}
+	diffs := make([]float64, len(baseline))
+	for i := range baseline {
+		diffs[i] = candidate[i].AbsError() - baseline[i].AbsError()
+	}
+	c.BaselineMAE = summarize(baseline).MeanError
+	c.CandidateMAE = summarize(candidate).MeanError
+	c.Delta = c.CandidateMAE - c.BaselineMAE
+	c.PValue = pairedTTest(diffs)
+	if c.PValue < alpha {
+		if c.Delta < 0 {
+			c.Verdict = verdictImproved
+		} else {
+			c.Verdict = verdictRegressed
+		}
+	}
+	return c
+}
+
+// pairedTTest returns the two-sided p-value of the hypothesis that diffs
+// have mean zero.
+func pairedTTest(diffs []float64) float64 {
+	n := len(diffs)
+	if n < 2 {
+		return 1
+	}
+	mean, sd := meanStd(diffs)
+	sd *= math.Sqrt(float64(n) / float64(n-1)) // sample standard deviation
+	if sd == 0 {
+		if mean == 0 {
+			return 1
+		}
+		return 0
+	}
+	t := mean / (sd / math.Sqrt(float64(n)))
+	df := float64(n - 1)
+	return regularizedBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// regularizedBeta is the regularized incomplete beta function I_x(a, b),
+// evaluated by its continued fraction.
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	if x > (a+1)/(a+b+2) {
+		return 1 - regularizedBeta(1-x, b, a)
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab-la-lb+a*math.Log(x)+b*math.Log(1-x)) / a
+
+	// Lentz's method.
+	const tiny = 1e-300
+	f, c, d := 1.0, 1.0, 0.0
+	for i := 0; i <= 200; i++ {
+		m := float64(i / 2)
+		var num float64
+		switch {
+		case i == 0:
+			num = 1
+		case i%2 == 0:
+			num = m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		default:
+			num = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		}
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		d = 1 / d
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		f *= c * d
+		if math.Abs(c*d-1) < 1e-12 {
+			break
+		}
+	}
+	return front * (f - 1)
+}
+
+func runCanary(args []string) error {
+	fs := flag.NewFlagSet("canary", flag.ContinueOnError)
+	baselineTag := fs.String("baseline", "", "registered model version currently in production")
+	candidateTag := fs.String("candidate", "", "registered model version proposed to replace it")
+	registry := fs.String("registry", defaultRegistry, "model registry directory")
+	casesPath := fs.String("cases", "", "labelled holdout cases neither model was trained on")
+	alpha := fs.Float64("alpha", 0.05, "significance level of the paired t-test")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *baselineTag == "" || *candidateTag == "" || *casesPath == "" {
+		return fmt.Errorf("-baseline, -candidate and -cases are required")
+	}
+	if *alpha <= 0 || *alpha >= 1 {
+		return fmt.Errorf("-alpha must be between 0 and 1")
+	}
+
+	baseline, _, err := loadRegisteredModel(*registry, *baselineTag)
+	if err != nil {
+		return err
+	}
+	defer baseline.Close()
+	candidate, _, err := loadRegisteredModel(*registry, *candidateTag)
+	if err != nil {
+		return err
+	}
+	defer candidate.Close()
+	cases, err := loadTrainingData(*casesPath)
+	if err != nil {
+		return fmt.Errorf("loading cases: %v", err)
+	}
+
+	report := canaryReport(evaluate(cases, baseline, false), evaluate(cases, candidate, false), *alpha)
+	report.Baseline, report.Candidate, report.Cases = *baselineTag, *candidateTag, *casesPath
+	if *asJSON {
+		return writeJSON(os.Stdout, report)
+	}
+	printCanary(os.Stdout, report)
+	return nil
+}
+
+// canaryReport compares the results overall and in every band of the eval
+// segmenters.
+func canaryReport(baseline, candidate []EvalResult, alpha float64) CanaryReport {
+	r := CanaryReport{Alpha: alpha, Overall: compareCanary(baseline, candidate, alpha)}
+	for _, s := range segmenters {
+		type band struct {
+			order     int
+			name      string
+			baseline  []EvalResult
+			candidate []EvalResult
+		}
+		var bands []*band
+		byName := map[string]*band{}
+		for i, res := range baseline {
+			order, name := s.Band(res.Case)
+			b, ok := byName[name]
+			if !ok {
+				b = &band{order: order, name: name}
+				byName[name] = b
+				bands = append(bands, b)
+			}
+			b.baseline = append(b.baseline, res)
+			b.candidate = append(b.candidate, candidate[i])
+		}
+		sort.Slice(bands, func(i, j int) bool { return bands[i].order < bands[j].order })
+		for _, b := range bands {
+			r.Segments = append(r.Segments, CanarySegment{s.Title, b.name, compareCanary(b.baseline, b.candidate, alpha)})
+		}
+	}
+	return r
+}
+
+func printCanary(w io.Writer, r CanaryReport) {
+	fmt.Fprintf(w, "Candidate %s vs baseline %s on %s (%d cases, alpha %g)\n", r.Candidate, r.Baseline, r.Cases, r.Overall.Count, r.Alpha)
+	fmt.Fprintf(w, "Overall: %s (mean error $%.2f -> $%.2f, %+.2f, p=%.4f)\n\n",
+		r.Overall.Verdict, r.Overall.BaselineMAE, r.Overall.CandidateMAE, r.Overall.Delta, r.Overall.PValue)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIMENSION\tBAND\tCASES\tBASELINE\tCANDIDATE\tDELTA\tP\tVERDICT")
+	for _, s := range r.Segments {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t$%.2f\t$%.2f\t%+.2f\t%.4f\t%s\n",
+			s.Dimension, s.Band, s.Count, s.BaselineMAE, s.CandidateMAE, s.Delta, s.PValue, s.Verdict)
+	}
+	tw.Flush()
+}
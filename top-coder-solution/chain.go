@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ChainStage is one stage of a fallback chain: a model that answers unless
+// it declines, passing the query to the next stage.
+type ChainStage struct {
+	// Stage is exact, table, or a model name. exact answers with the output
+	// of a training case with the same inputs and declines when there is
+	// none; table answers with Table's amount for the trip's days and
+	// declines for days it lacks.
+	Stage string `json:"stage"`
+	// MinConfidence makes the stage decline queries whose neighbor
+	// confidence (see Confidence) is below it; 0 accepts every query.
+	MinConfidence float64         `json:"min_confidence,omitempty"`
+	Table         map[int]float64 `json:"table,omitempty"`
+}
+
+// Stages of a fallback chain that are not models.
+const (
+	stageExact = "exact"
+	stageTable = "table"
+)
+
+func validateChain(stages []ChainStage) error {
+	for i, s := range stages {
+		switch s.Stage {
+		case stageExact:
+		case stageTable:
+			if len(s.Table) == 0 {
+				return fmt.Errorf("chain stage %d: the table stage needs a table of amounts by days", i+1)
+			}
+		default:
+			if s.Stage == "" {
+				return fmt.Errorf("chain stage %d: no stage (want %s, %s or a model)", i+1, stageExact, stageTable)
+			}
+			if err := validateModel(s.Stage); err != nil {
+				return fmt.Errorf("chain stage %d: %v", i+1, err)
+			}
+		}
+		if s.Stage != stageTable && s.Table != nil {
+			return fmt.Errorf("chain stage %d: only the table stage has a table", i+1)
+		}
+		if s.MinConfidence < 0 || s.MinConfidence > 1 {
+			return fmt.Errorf("chain stage %d: min_confidence must be between 0 and 1", i+1)
+		}
+	}
+	return nil
+}
+
+// loadChain reads a JSON array of chain stages from path.
+func loadChain(path string) ([]ChainStage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stages []ChainStage
+	if err := json.Unmarshal(data, &stages); err != nil {
+		return nil, fmt.Errorf("parsing chain %s: %v", path, err)
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("%s: the chain has no stages", path)
+	}
+	if err := validateChain(stages); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return stages, nil
+}
+
+// exactKey identifies inputs to the cent, for exact matching.
+type exactKey struct {
+	days            int
+	miles, receipts int64
+}
+
+func exactKeyOf(q Query) exactKey {
+	return exactKey{q.TripDurationDays, int64(math.Round(q.MilesTraveled * 100)), int64(math.Round(q.TotalReceiptsAmount * 100))}
+}
+
+// chainModel answers each query with the first stage of its chain that does
+// not decline. A stage also declines when its model fails or predicts a
+// value that is not finite, so a broken external model falls through.
+type chainModel struct {
+	hp      Hyperparameters
+	models  []Model // indexed like the stages; nil for exact and table
+	exact   map[exactKey]float64
+	support *Predictor // scores confidence for stages with MinConfidence
+
+	mu  sync.Mutex
+	err error // the first query every stage declined
+}
+
+func (c *chainModel) Fit(training TrainingData) {
+	c.models = make([]Model, len(c.hp.Chain))
+	c.exact, c.support = nil, nil
+	hp := c.hp
+	hp.Chain, hp.Overrides = nil, nil // overrides belong to the predictor wrapping the model
+	for i, s := range c.hp.Chain {
+		switch s.Stage {
+		case stageExact:
+			if c.exact == nil {
+				c.exact = make(map[exactKey]float64, len(training))
+				for _, tc := range training {
+					if _, dup := c.exact[exactKeyOf(tc.Input)]; !dup {
+						c.exact[exactKeyOf(tc.Input)] = tc.ExpectedOutput
+					}
+				}
+			}
+		case stageTable:
+		default:
+			hp.Model = s.Stage
+			c.models[i] = newModel(hp)
+			c.models[i].Fit(training)
+		}
+		if s.MinConfidence > 0 && c.support == nil {
+			hp.Model = ""
+			c.support = NewPredictor(training, hp)
+		}
+	}
+}
+
+// answer returns the index of the stage answering q and its answer, with why
+// each stage before it declined. The index is len(stages) when all decline.
+func (c *chainModel) answer(q Query) (int, float64, []string) {
+	var declined []string
+	var conf *Confidence
+	for i, s := range c.hp.Chain {
+		if s.MinConfidence > 0 {
+			if conf == nil {
+				cc := c.support.Confidence(q)
+				conf = &cc
+			}
+			if conf.Score < s.MinConfidence {
+				declined = append(declined, fmt.Sprintf("%s declined: confidence %.3f below %g", s.Stage, conf.Score, s.MinConfidence))
+				continue
+			}
+		}
+		var y float64
+		switch s.Stage {
+		case stageExact:
+			var ok bool
+			if y, ok = c.exact[exactKeyOf(q)]; !ok {
+				declined = append(declined, stageExact+" declined: no training case with these inputs")
+				continue
+			}
+		case stageTable:
+			var ok bool
+			if y, ok = s.Table[q.TripDurationDays]; !ok {
+				declined = append(declined, fmt.Sprintf("%s declined: no amount for %d days", stageTable, q.TripDurationDays))
+				continue
+			}
+		default:
+			y = c.models[i].Predict(q)
+			if m, ok := c.models[i].(interface{ Err() error }); ok && m.Err() != nil {
+				declined = append(declined, fmt.Sprintf("%s declined: %v", s.Stage, m.Err()))
+				continue
+			}
+			if math.IsNaN(y) || math.IsInf(y, 0) {
+				declined = append(declined, fmt.Sprintf("%s declined: predicted %g", s.Stage, y))
+				continue
+			}
+		}
+		return i, y, declined
+	}
+	return len(c.hp.Chain), math.NaN(), declined
+}
+
+func (c *chainModel) Predict(q Query) float64 {
+	i, y, declined := c.answer(q)
+	if i == len(c.hp.Chain) {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = fmt.Errorf("every stage of the fallback chain declined %d days, %g miles, $%.2f receipts: %s",
+				q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, strings.Join(declined, "; "))
+		}
+		c.mu.Unlock()
+	}
+	return y
+}
+
+func (c *chainModel) Explain(q Query) ModelExplanation {
+	i, y, steps := c.answer(q)
+	if i == len(c.hp.Chain) {
+		return ModelExplanation{Model: "chain", Prediction: y, Steps: append(steps, "every stage declined")}
+	}
+	s := c.hp.Chain[i]
+	switch s.Stage {
+	case stageExact:
+		steps = append(steps, fmt.Sprintf("exact match: output %.2f", y))
+	case stageTable:
+		steps = append(steps, fmt.Sprintf("table amount for %d days: %.2f", q.TripDurationDays, y))
+	default:
+		e := c.models[i].Explain(q)
+		steps = append(steps, e.Steps...)
+	}
+	return ModelExplanation{Model: s.Stage, Prediction: y, Steps: steps}
+}
+
+// stage returns the name of the stage answering q, or "" when all decline.
+func (c *chainModel) stage(q Query) string {
+	if i, _, _ := c.answer(q); i < len(c.hp.Chain) {
+		return c.hp.Chain[i].Stage
+	}
+	return ""
+}
+
+// Err returns the first failure to answer a query, when every stage
+// declined it. Failures of single stages are declines, not errors.
+func (c *chainModel) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *chainModel) Close() error {
+	var first error
+	for _, m := range c.models {
+		if cl, ok := m.(io.Closer); ok {
+			if err := cl.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
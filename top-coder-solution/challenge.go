@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// challengePenalty is what eval.sh adds to the score for each case that is
+// not an exact match, failed runs included.
+const challengePenalty = 0.1
+
+// ChallengeScore is an evaluation computed exactly as the challenge's
+// eval.sh computes it from the printed output of run.sh, so the numbers
+// agree to the cent:
+//
+//   - predictions are compared as printed, rounded to the cent;
+//   - errors are exact decimal amounts, as bc computes them, so an exact
+//     match (error below $0.01) is an equal cent amount and a close match
+//     is an error below $1.00;
+//   - a prediction that is not a finite number is a failed run, left out
+//     of the average error but still charged the non-exact penalty;
+//   - the average error is truncated to the cent, as bc's scale=2 division
+//     truncates, before the score, average error * 100 plus 0.1 per case
+//     that is not an exact match, is computed.
+type ChallengeScore struct {
+	Cases        int     `json:"cases"`
+	Successful   int     `json:"successful"`
+	ExactMatches int     `json:"exact_matches"`
+	CloseMatches int     `json:"close_matches"`
+	AverageError float64 `json:"average_error"`
+	MaxError     float64 `json:"max_error"`
+	Score        float64 `json:"score"`
+}
+
+func challengeScore(results []EvalResult) ChallengeScore {
+	s := ChallengeScore{Cases: len(results)}
+	var total, maxErr Cents
+	for _, r := range results {
+		if math.IsNaN(r.Predicted) || math.IsInf(r.Predicted, 0) {
+			continue
+		}
+		s.Successful++
+		e := centsOf(r.Predicted) - centsOf(r.Case.ExpectedOutput)
+		if e < 0 {
+			e = -e
+		}
+		if e == 0 {
+			s.ExactMatches++
+		}
+		if e < 100 {
+			s.CloseMatches++
+		}
+		total += e
+		maxErr = max(maxErr, e)
+	}
+	if s.Successful == 0 {
+		s.Score = float64(s.Cases) * challengePenalty
+		return s
+	}
+	average := total / Cents(s.Successful)
+	s.AverageError, s.MaxError = average.Dollars(), maxErr.Dollars()
+	// In cents the average error * 100 is the cent count itself. Rounding
+	// to the cent drops the binary error of the penalty's sum, which bc
+	// computes in decimal.
+	s.Score = roundDecimal(float64(average)+float64(s.Cases-s.ExactMatches)*challengePenalty, 2)
+	return s
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChallengeScore(t *testing.T) {
+	result := func(expected, predicted float64) EvalResult {
+		return EvalResult{Case: testCase(1, 1, 1, expected), Predicted: predicted}
+	}
+	tests := []struct {
+		name    string
+		results []EvalResult
+		want    ChallengeScore
+	}{
+		{"no cases", nil, ChallengeScore{}},
+		{"all exact", []EvalResult{result(100, 100), result(250.5, 250.504)},
+			ChallengeScore{Cases: 2, Successful: 2, ExactMatches: 2, CloseMatches: 2}},
+		{"compared as printed", []EvalResult{result(1.01, 1.005)},
+			ChallengeScore{Cases: 1, Successful: 1, ExactMatches: 1, CloseMatches: 1}},
+		{"close and far", []EvalResult{result(100, 100.99), result(100, 101), result(100, 90)},
+			ChallengeScore{Cases: 3, Successful: 3, CloseMatches: 1, AverageError: 3.99, MaxError: 10, Score: 399.3}},
+		{"average truncated to the cent", []EvalResult{result(0, 0.01), result(0, 0.01), result(0, 0)},
+			ChallengeScore{Cases: 3, Successful: 3, ExactMatches: 1, CloseMatches: 3, MaxError: 0.01, Score: 0.2}},
+		{"failed runs are charged but not averaged",
+			[]EvalResult{result(100, 105), result(100, math.NaN()), result(100, math.Inf(1))},
+			ChallengeScore{Cases: 3, Successful: 1, CloseMatches: 0, AverageError: 5, MaxError: 5, Score: 500.3}},
+		{"every run failed", []EvalResult{result(100, math.NaN()), result(100, math.NaN())},
+			ChallengeScore{Cases: 2, Score: 0.2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := challengeScore(tt.results); got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
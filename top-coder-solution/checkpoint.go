@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// TuneCheckpointHeader identifies the run a tune checkpoint belongs to:
+// results only carry over to a run over the same data, folds and cost.
+type TuneCheckpointHeader struct {
+	DataSHA256 string `json:"data_sha256"`
+	Cases      int    `json:"cases"`
+	Folds      int    `json:"folds"` // 0 for leave-one-out
+	Seed       uint64 `json:"seed"`
+	Cost       string `json:"cost,omitempty"`
+}
+
+// checkpoint records the units of work a long run finishes, so an
+// interrupted run can resume without repeating them. The file is JSON
+// lines: a header identifying the run, then one entry per finished unit,
+// written as it finishes. A run killed mid-write leaves at most a partial
+// last line, which resuming ignores.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openCheckpoint starts a checkpoint at path for the run header describes.
+// With resume set, an existing checkpoint of the same run is continued and
+// each of its entries passed to entry; otherwise any file at path is
+// replaced. differ names what sets runs apart, for the error when the
+// headers do not match.
+func openCheckpoint[H comparable](path string, header H, differ string, resume bool, entry func([]byte) error) (*checkpoint, error) {
+	if resume {
+		file, err := os.Open(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			resume = false
+		case err != nil:
+			return nil, err
+		default:
+			err := readCheckpoint(file, header, differ, entry)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("resuming from %s: %v", path, err)
+			}
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{file: file}
+	if resume {
+		// Start on a fresh line after a partial one.
+		_, err = file.WriteString("\n")
+	} else {
+		err = json.NewEncoder(file).Encode(header)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func readCheckpoint[H comparable](file *os.File, header H, differ string, entry func([]byte) error) error {
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 1<<24)
+	if !sc.Scan() {
+		return fmt.Errorf("checkpoint has no header")
+	}
+	var got H
+	if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	if got != header {
+		return fmt.Errorf("checkpoint is of another run (%s differ); remove it or drop -resume", differ)
+	}
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 || entry(sc.Bytes()) != nil {
+			continue // blank, or cut short by an interruption
+		}
+	}
+	return sc.Err()
+}
+
+// record appends a finished unit of work.
+func (c *checkpoint) record(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+func (c *checkpoint) Close() error {
+	return c.file.Close()
+}
+
+// openTuneCheckpoint starts a checkpoint of tune's finished configurations;
+// see openCheckpoint. The results it resumes are keyed by tuneKey.
+func openTuneCheckpoint(path string, header TuneCheckpointHeader, resume bool) (*checkpoint, map[string]TuneResult, error) {
+	done := map[string]TuneResult{}
+	c, err := openCheckpoint(path, header, "data, folds, seed or cost", resume, func(line []byte) error {
+		var r TuneResult
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		done[tuneKey(r.Hyperparameters)] = r
+		return nil
+	})
+	return c, done, err
+}
+
+// tuneKey identifies a configuration in a checkpoint.
+func tuneKey(hp Hyperparameters) string {
+	b, _ := json.Marshal(hp)
+	return string(b)
+}
+
+// EvalCheckpointHeader identifies the cross-validation run an eval
+// checkpoint belongs to: folds only carry over to a run of the same model
+// over the same data and folds.
+type EvalCheckpointHeader struct {
+	DataSHA256 string `json:"data_sha256"`
+	Cases      int    `json:"cases"`
+	Folds      int    `json:"folds"` // 0 for leave-one-out
+	Seed       uint64 `json:"seed"`
+	Model      string `json:"model"` // the hyperparameters, as tuneKey gives them
+}
+
+// EvalCheckpointFold is a fold eval -folds or -loo finished: the training
+// cases it held out and what was predicted for each.
+type EvalCheckpointFold struct {
+	Fold      int       `json:"fold"`
+	Cases     []int     `json:"cases"`
+	Predicted []float64 `json:"predicted"`
+}
+
+// openEvalCheckpoint starts a checkpoint of eval's finished folds; see
+// openCheckpoint. The folds it resumes are keyed by fold number.
+func openEvalCheckpoint(path string, header EvalCheckpointHeader, resume bool) (*checkpoint, map[int]EvalCheckpointFold, error) {
+	done := map[int]EvalCheckpointFold{}
+	c, err := openCheckpoint(path, header, "data, model, folds or seed", resume, func(line []byte) error {
+		var f EvalCheckpointFold
+		if err := json.Unmarshal(line, &f); err != nil {
+			return err
+		}
+		if len(f.Cases) != len(f.Predicted) {
+			return fmt.Errorf("fold %d has %d cases but %d predictions", f.Fold, len(f.Cases), len(f.Predicted))
+		}
+		for _, i := range f.Cases {
+			if i < 0 || i >= header.Cases {
+				return fmt.Errorf("fold %d holds out case %d of %d", f.Fold, i, header.Cases)
+			}
+		}
+		done[f.Fold] = f
+		return nil
+	})
+	return c, done, err
+}
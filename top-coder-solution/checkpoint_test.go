@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOpenEvalCheckpoint(t *testing.T) {
+	header := EvalCheckpointHeader{DataSHA256: "abc", Cases: 4, Folds: 2, Seed: 1, Model: `{"k":3}`}
+	line := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b) + "\n"
+	}
+	fold0 := EvalCheckpointFold{Fold: 0, Cases: []int{0, 2}, Predicted: []float64{100, 200}}
+	fold1 := EvalCheckpointFold{Fold: 1, Cases: []int{1, 3}, Predicted: []float64{300, 400}}
+	other := header
+	other.Seed = 2
+
+	tests := []struct {
+		name    string
+		file    string
+		missing bool // no file at all
+		resume  bool
+		want    []int // folds resumed
+		wantErr string
+	}{
+		{"no checkpoint yet", "", true, true, nil, ""},
+		{"resumed", line(header) + line(fold0) + line(fold1), false, true, []int{0, 1}, ""},
+		{"partial last line ignored", line(header) + line(fold0) + `{"fold":1,"cases":[1,`, false, true, []int{0}, ""},
+		{"blank lines ignored", line(header) + "\n" + line(fold1) + "\n", false, true, []int{1}, ""},
+		{"mismatched predictions ignored", line(header) + line(EvalCheckpointFold{Fold: 0, Cases: []int{0, 2}, Predicted: []float64{1}}),
+			false, true, nil, ""},
+		{"out of range cases ignored", line(header) + line(EvalCheckpointFold{Fold: 0, Cases: []int{0, 4}, Predicted: []float64{1, 2}}),
+			false, true, nil, ""},
+		{"not resuming starts over", line(header) + line(fold0), false, false, nil, ""},
+		{"another run", line(other) + line(fold0), false, true, nil, "checkpoint is of another run"},
+		{"empty file", "", false, true, nil, "checkpoint has no header"},
+		{"bad header", "not json\n", false, true, nil, "reading header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "eval.ckpt")
+			if !tt.missing {
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, done, err := openEvalCheckpoint(path, header, tt.resume)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := slices.Sorted(maps.Keys(done)); !slices.Equal(got, tt.want) {
+				t.Errorf("resumed folds %v, want %v", got, tt.want)
+			}
+			if f, ok := done[0]; ok && !slices.Equal(f.Predicted, fold0.Predicted) {
+				t.Errorf("fold 0 predicted %v, want %v", f.Predicted, fold0.Predicted)
+			}
+
+			// What is recorded now is resumed next time, after what was
+			// resumed this time.
+			if err := c.record(fold1); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			c, done, err = openEvalCheckpoint(path, header, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+			want := slices.Sorted(slices.Values(append(slices.Clone(tt.want), 1)))
+			if got := slices.Sorted(maps.Keys(done)); !slices.Equal(slices.Compact(want), got) {
+				t.Errorf("after recording fold 1, resumed folds %v, want %v", got, slices.Compact(want))
+			}
+		})
+	}
+}
+
+func TestOpenTuneCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tune.ckpt")
+	header := TuneCheckpointHeader{DataSHA256: "abc", Cases: 10, Folds: 5, Seed: 1}
+	c, done, err := openTuneCheckpoint(path, header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("fresh checkpoint resumed %d results", len(done))
+	}
+	results := []TuneResult{
+		{Hyperparameters: Hyperparameters{K: 3}, Cost: 1},
+		{Hyperparameters: Hyperparameters{K: 5, Metric: "manhattan"}, Cost: 2},
+	}
+	for _, r := range results {
+		if err := c.record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	c, done, err = openTuneCheckpoint(path, header, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	for _, r := range results {
+		if got, ok := done[tuneKey(r.Hyperparameters)]; !ok || got.Cost != r.Cost {
+			t.Errorf("resumed %+v for %s, want cost %g", got, tuneKey(r.Hyperparameters), r.Cost)
+		}
+	}
+
+	header.Cost = "cost.json"
+	if _, _, err := openTuneCheckpoint(path, header, true); err == nil || !strings.Contains(err.Error(), "cost differ") {
+		t.Errorf("error %v, want one naming what differs", err)
+	}
+}
+
+func TestCrossValidateResume(t *testing.T) {
+	data := randomCases(200, 1)
+	p := NewPredictor(data, Hyperparameters{K: 5})
+	defer p.Close()
+	folds := randomFolds(len(data), 5, 1)
+	full, err := crossValidateResume(context.Background(), p, folds, 2, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checkpoint a run, keep two of its folds and mark their predictions,
+	// and resume from them.
+	var mu sync.Mutex
+	checkpointed := map[int]EvalCheckpointFold{}
+	if _, err := crossValidateResume(context.Background(), p, folds, 2, nil, nil, func(f EvalCheckpointFold) {
+		mu.Lock()
+		defer mu.Unlock()
+		checkpointed[f.Fold] = f
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpointed) != 5 {
+		t.Fatalf("%d folds checkpointed, want 5", len(checkpointed))
+	}
+	done := map[int]EvalCheckpointFold{}
+	for _, fold := range []int{1, 3} {
+		f := checkpointed[fold]
+		f.Predicted = slices.Clone(f.Predicted)
+		for j := range f.Predicted {
+			f.Predicted[j] = -1
+		}
+		done[fold] = f
+	}
+
+	var finished []int
+	resumed, err := crossValidateResume(context.Background(), p, folds, 2, nil, done, func(f EvalCheckpointFold) {
+		mu.Lock()
+		defer mu.Unlock()
+		finished = append(finished, f.Fold)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(finished)
+	if want := []int{0, 2, 4}; !slices.Equal(finished, want) {
+		t.Errorf("finished folds %v, want only those not resumed, %v", finished, want)
+	}
+	for i, r := range resumed {
+		if !reflect.DeepEqual(r.Case, data[i]) {
+			t.Fatalf("case %d: result for %+v", i, r.Case)
+		}
+		want := full[i].Predicted
+		if _, ok := done[folds[i]]; ok {
+			want = -1
+		}
+		if r.Predicted != want {
+			t.Errorf("case %d in fold %d: predicted %g, want %g", i, folds[i], r.Predicted, want)
+		}
+	}
+
+	// A cancelled run checkpoints no fold it left unfinished.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var partial int
+	if _, err := crossValidateResume(ctx, p, folds, 2, nil, nil, func(EvalCheckpointFold) {
+		mu.Lock()
+		defer mu.Unlock()
+		partial++
+	}); err == nil {
+		t.Error("cancelled run returned no error")
+	}
+	if partial != 0 {
+		t.Errorf("cancelled run checkpointed %d folds", partial)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// featureVector is a case's inputs as (days, miles, receipts), followed by
+// the named input fields feature configs read, in the slots inputFieldSlot
+// gives them. Code that works on the three inputs alone ranges over
+// featureNames rather than the whole vector.
+type featureVector [len(featureNames) + maxInputFields]float64
+
+func caseFeatures(c TestCase) featureVector {
+	return c.Input.features()
+}
+
+// sameInputFields reports whether a and b agree on every named input field.
+func sameInputFields(a, b featureVector) bool {
+	return [maxInputFields]float64(a[len(featureNames):]) == [maxInputFields]float64(b[len(featureNames):])
+}
+
+// standardizer z-score normalizes feature vectors using the mean and
+// standard deviation of a reference set.
+type standardizer struct {
+	Mean, Std featureVector
+}
+
+func newStandardizer(vectors []featureVector) standardizer {
+	var s standardizer
+	if len(vectors) == 0 {
+		s.Std = featureVector{1, 1, 1}
+		return s
+	}
+	n := float64(len(vectors))
+	for _, v := range vectors {
+		for j := range featureNames {
+			s.Mean[j] += v[j] / n
+		}
+	}
+	for _, v := range vectors {
+		for j := range featureNames {
+			d := v[j] - s.Mean[j]
+			s.Std[j] += d * d / n
+		}
+	}
+	for j := range featureNames {
+		s.Std[j] = math.Sqrt(s.Std[j])
+		if s.Std[j] == 0 {
+			s.Std[j] = 1
+		}
+	}
+	return s
+}
+
+func (s standardizer) apply(v featureVector) featureVector {
+	var out featureVector
+	for j := range featureNames {
+		out[j] = (v[j] - s.Mean[j]) / s.Std[j]
+	}
+	return out
+}
+
+func (s standardizer) invert(v featureVector) featureVector {
+	var out featureVector
+	for j := range featureNames {
+		out[j] = v[j]*s.Std[j] + s.Mean[j]
+	}
+	return out
+}
+
+func squaredDistance(a, b featureVector) float64 {
+	sum := 0.0
+	for j := range featureNames {
+		d := a[j] - b[j]
+		sum += d * d
+	}
+	return sum
+}
+
+// kMeans clusters points into k groups using k-means++ seeding followed by
+// Lloyd iterations. It returns the centroids and each point's assignment.
+func kMeans(points []featureVector, k, maxIter int, rng *rand.Rand) ([]featureVector, []int) {
+	if k > len(points) {
+		k = len(points)
+	}
+	if k == 0 {
+		return nil, nil
+	}
+
+	centroids := make([]featureVector, 0, k)
+	centroids = append(centroids, points[rng.Intn(len(points))])
+	dist := make([]float64, len(points))
+	for len(centroids) < k {
+		total := 0.0
+		for i, p := range points {
+			dist[i] = math.Inf(1)
+			for _, c := range centroids {
+				dist[i] = math.Min(dist[i], squaredDistance(p, c))
+			}
+			total += dist[i]
+		}
+		target := rng.Float64() * total
+		chosen := len(points) - 1
+		for i, d := range dist {
+			target -= d
+			if target <= 0 {
+				chosen = i
+				break
+			}
+		}
+		centroids = append(centroids, points[chosen])
+	}
+
+	assign := make([]int, len(points))
+	for iter := 0; iter < maxIter; iter++ {
+		changed := iter == 0
+		for i, p := range points {
+			best, bestDist := 0, math.Inf(1)
+			for c, centroid := range centroids {
+				if d := squaredDistance(p, centroid); d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			if assign[i] != best {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([]featureVector, k)
+		counts := make([]int, k)
+		for i, p := range points {
+			c := assign[i]
+			counts[c]++
+			for j := range p {
+				sums[c][j] += p[j]
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				continue // keep an empty cluster's previous centroid
+			}
+			for j := range sums[c] {
+				centroids[c][j] = sums[c][j] / float64(counts[c])
+			}
+		}
+	}
+
+	return centroids, assign
+}
+
+// ClusterStats describes one cluster in original input units.
+type ClusterStats struct {
+	Cluster    int     `json:"cluster"`
+	Count      int     `json:"count"`
+	Days       float64 `json:"centroid_days"`
+	Miles      float64 `json:"centroid_miles"`
+	Receipts   float64 `json:"centroid_receipts"`
+	OutputMean float64 `json:"output_mean"`
+	OutputStd  float64 `json:"output_std"`
+	OutputMin  float64 `json:"output_min"`
+	OutputMax  float64 `json:"output_max"`
+	PerDayMean float64 `json:"output_per_day_mean"`
+}
+
+// TaggedCase is a training case annotated with its cluster.
+type TaggedCase struct {
+	TestCase
+	Cluster int `json:"cluster"`
+}
+
+func clusterStats(training TrainingData, centroids []featureVector, assign []int, norm standardizer) []ClusterStats {
+	stats := make([]ClusterStats, len(centroids))
+	outputs := make([][]float64, len(centroids))
+	perDay := make([]float64, len(centroids))
+	for i, c := range training {
+		outputs[assign[i]] = append(outputs[assign[i]], c.ExpectedOutput)
+		perDay[assign[i]] += c.ExpectedOutput / float64(max(c.Input.TripDurationDays, 1))
+	}
+
+	for c, centroid := range centroids {
+		orig := norm.invert(centroid)
+		s := ClusterStats{Cluster: c, Count: len(outputs[c]), Days: orig[0], Miles: orig[1], Receipts: orig[2]}
+		if s.Count > 0 {
+			s.OutputMin, s.OutputMax = outputs[c][0], outputs[c][0]
+			for _, o := range outputs[c] {
+				s.OutputMean += o / float64(s.Count)
+				s.OutputMin = math.Min(s.OutputMin, o)
+				s.OutputMax = math.Max(s.OutputMax, o)
+			}
+			for _, o := range outputs[c] {
+				s.OutputStd += (o - s.OutputMean) * (o - s.OutputMean) / float64(s.Count)
+			}
+			s.OutputStd = math.Sqrt(s.OutputStd)
+			s.PerDayMean = perDay[c] / float64(s.Count)
+		}
+		stats[c] = s
+	}
+	return stats
+}
+
+func runCluster(args []string) error {
+	fs := flag.NewFlagSet("cluster", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path")
+	k := fs.Int("clusters", 6, "number of clusters")
+	iterations := fs.Int("iterations", 100, "maximum k-means iterations")
+	seed := fs.Int64("seed", 1, "random seed for centroid initialization")
+	tagOut := fs.String("tag-out", "", "write cases tagged with their cluster to this JSON file")
+	asJSON := fs.Bool("json", false, "print cluster statistics as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *k < 1 {
+		return fmt.Errorf("-clusters must be at least 1")
+	}
+
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+
+	raw := make([]featureVector, len(trainingData))
+	for i, c := range trainingData {
+		raw[i] = caseFeatures(c)
+	}
+	norm := newStandardizer(raw)
+	points := make([]featureVector, len(raw))
+	for i, v := range raw {
+		points[i] = norm.apply(v)
+	}
+
+	centroids, assign := kMeans(points, *k, *iterations, rand.New(rand.NewSource(*seed)))
+	stats := clusterStats(trainingData, centroids, assign, norm)
+	// Number clusters by centroid trip length so output is stable to read.
+	order := make([]int, len(stats))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return stats[order[i]].Days < stats[order[j]].Days
+	})
+	renumber := make([]int, len(stats))
+	sorted := make([]ClusterStats, len(stats))
+	for newID, oldID := range order {
+		renumber[oldID] = newID
+		sorted[newID] = stats[oldID]
+		sorted[newID].Cluster = newID
+	}
+	for i := range assign {
+		assign[i] = renumber[assign[i]]
+	}
+
+	if *asJSON {
+		if err := writeJSON(os.Stdout, sorted); err != nil {
+			return err
+		}
+	} else {
+		printClusterStats(os.Stdout, sorted)
+	}
+
+	if *tagOut != "" {
+		tagged := make([]TaggedCase, len(trainingData))
+		for i, c := range trainingData {
+			tagged[i] = TaggedCase{TestCase: c, Cluster: assign[i]}
+		}
+		if err := writeJSONFile(*tagOut, tagged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printClusterStats(w io.Writer, stats []ClusterStats) {
+	fmt.Fprintf(w, "%-7s %6s %8s %9s %10s %10s %9s %9s %9s %9s\n",
+		"cluster", "cases", "days", "miles", "receipts", "out_mean", "out_std", "out_min", "out_max", "per_day")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%-7d %6d %8.1f %9.1f %10.2f %10.2f %9.2f %9.2f %9.2f %9.2f\n",
+			s.Cluster, s.Count, s.Days, s.Miles, s.Receipts, s.OutputMean, s.OutputStd, s.OutputMin, s.OutputMax, s.PerDayMean)
+	}
+}
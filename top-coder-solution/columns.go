@@ -0,0 +1,69 @@
+package main
+
+import "math"
+
+// featureColumns holds training cases as struct-of-arrays: one contiguous
+// slice per feature. Distance computation then runs as a tight loop over
+// parallel float64 slices that the compiler can keep in registers and
+// bounds-check once, instead of striding over TestCase structs.
+type featureColumns struct {
+	days     []float64
+	miles    []float64
+	receipts []float64
+	outputs  []float64
+}
+
+func newFeatureColumns(data TrainingData) *featureColumns {
+	c := &featureColumns{
+		days:     make([]float64, len(data)),
+		miles:    make([]float64, len(data)),
+		receipts: make([]float64, len(data)),
+		outputs:  make([]float64, len(data)),
+	}
+	forShards(len(data), packedShardCases, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			tc := &data[i]
+			c.days[i] = float64(tc.Input.TripDurationDays)
+			c.miles[i] = tc.Input.MilesTraveled
+			c.receipts[i] = tc.Input.TotalReceiptsAmount
+			c.outputs[i] = tc.ExpectedOutput
+		}
+	})
+	return c
+}
+
+// predict is predictWeightedKNN over the columns, with the exact-match scan
+// fused into the distance loop. Neighbors are selected by squared distance
+// and the square root taken only for the k kept, which picks the same
+// neighbors because the square root is monotonic.
+func (c *featureColumns) predict(tripDays int, miles, receipts float64, k int, how string) float64 {
+	qd := float64(tripDays)
+	days := c.days
+	ms := c.miles[:len(days)]
+	rs := c.receipts[:len(days)]
+	outs := c.outputs[:len(days)]
+
+	k = min(max(k, 1), len(days))
+	buf := neighborPool.Get().(*[]Neighbor)
+	defer neighborPool.Put(buf)
+	neighbors := (*buf)[:0]
+	for i := range days {
+		if days[i] == qd && math.Abs(ms[i]-miles) < 0.001 && math.Abs(rs[i]-receipts) < 0.001 {
+			*buf = neighbors
+			return outs[i]
+		}
+		dd := (qd - days[i]) / dayScale
+		md := (miles - ms[i]) / mileScale
+		rd := (receipts - rs[i]) / receiptScale
+		sq := dd*dd + md*md + rd*rd
+		if len(neighbors) == k && sq >= neighbors[k-1].Distance {
+			continue
+		}
+		neighbors = insertNeighbor(neighbors, k, Neighbor{Distance: sq, Output: outs[i]})
+	}
+	for i := range neighbors {
+		neighbors[i].Distance = math.Sqrt(neighbors[i].Distance)
+	}
+	*buf = neighbors
+	return aggregate(neighbors, how, inverseDistance)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ModelComparison is one query answered by several models, each with its
+// mean absolute error over an evaluation set.
+type ModelComparison struct {
+	Input  Query                `json:"input"`
+	Method string               `json:"method"` // how MeanError was measured
+	Models []ModelComparisonRow `json:"models"`
+}
+
+// ModelComparisonRow is one model's answer and error.
+type ModelComparisonRow struct {
+	Model      string  `json:"model"`
+	Prediction float64 `json:"prediction"`
+	MeanError  float64 `json:"mean_error"`
+}
+
+func runCompareModels(args []string) error {
+	fs := flag.NewFlagSet("compare-models", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	names := fs.String("models", strings.Join([]string{modelKNN, modelLinear, modelTree, modelForest, modelRule}, ","),
+		"comma-separated models to compare (overrides -model)")
+	casesPath := fs.String("cases", "", "labelled cases to measure each model's error on (default cross-validation of the training data)")
+	folds := fs.Int("folds", 10, "k-fold cross-validation of the training data when -cases is not set")
+	seed := fs.Uint64("seed", 1, "random seed for assigning -folds")
+	jobs := fs.Int("jobs", 0, "cross-validation workers (0 uses every CPU)")
+	asJSON := fs.Bool("json", false, "print the comparison as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	q, err := parseQuery(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	base, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+	var cases TrainingData
+	var assigned []int
+	cmp := ModelComparison{Input: q}
+	if *casesPath != "" {
+		if cases, err = loadTrainingData(*casesPath); err != nil {
+			return fmt.Errorf("loading cases: %v", err)
+		}
+		cmp.Method = "cases " + *casesPath
+	} else {
+		if *folds < 2 || *folds > len(base.Training) {
+			return fmt.Errorf("-folds must be between 2 and the number of cases")
+		}
+		assigned = randomFolds(len(base.Training), *folds, *seed)
+		cmp.Method = fmt.Sprintf("%d-fold cross-validation", *folds)
+	}
+
+	for _, name := range strings.Split(*names, ",") {
+		name = strings.TrimSpace(name)
+		hp := base.Hyperparameters()
+		hp.Model = name
+		if isKNN(name) {
+			hp.Model = ""
+		}
+		if err := hp.validate(); err != nil {
+			return fmt.Errorf("model %s: %v", name, err)
+		}
+		row, err := compareModel(base.Training, hp, q, cases, assigned, *jobs)
+		if err != nil {
+			return fmt.Errorf("model %s: %v", name, err)
+		}
+		row.Model = name
+		cmp.Models = append(cmp.Models, row)
+	}
+
+	if *asJSON {
+		return writeJSON(os.Stdout, cmp)
+	}
+	printModelComparison(os.Stdout, cmp)
+	return nil
+}
+
+// compareModel predicts q with a model built from training and hp, and
+// measures its error on cases, or by cross-validation over the folds
+// assigned when cases is nil.
+func compareModel(training TrainingData, hp Hyperparameters, q Query, cases TrainingData, assigned []int, jobs int) (ModelComparisonRow, error) {
+	p := NewPredictor(training, hp)
+	defer p.Close()
+	var row ModelComparisonRow
+	row.Prediction = roundCents(p.PredictQuery(q))
+	var results []EvalResult
+	if cases != nil {
+		results = evaluate(cases, p, false)
+	} else {
+		results = crossValidate(p, assigned, jobs, nil)
+	}
+	if err := p.Err(); err != nil {
+		return row, err
+	}
+	row.MeanError = summarize(results).MeanError
+	return row, nil
+}
+
+func printModelComparison(w io.Writer, cmp ModelComparison) {
+	fmt.Fprintf(w, "Query: %d days, %g miles, $%.2f receipts\n", cmp.Input.TripDurationDays, cmp.Input.MilesTraveled, cmp.Input.TotalReceiptsAmount)
+	fmt.Fprintf(w, "Error measured by %s\n\n", cmp.Method)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tPREDICTION\tMEAN ERROR\t")
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, r := range cmp.Models {
+		fmt.Fprintf(tw, "%s\t$%.2f\t$%.2f\t\n", r.Model, r.Prediction, r.MeanError)
+		lo, hi = math.Min(lo, r.Prediction), math.Max(hi, r.Prediction)
+	}
+	tw.Flush()
+	if len(cmp.Models) > 1 {
+		fmt.Fprintf(w, "\nSpread: $%.2f\n", hi-lo)
+	}
+}
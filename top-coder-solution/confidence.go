@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Confidence describes how well a prediction's neighbors support it.
+type Confidence struct {
+	// Score is the lesser of the neighbors' agreement and proximity, each in
+	// (0, 1]. Exact matches score 1.
+	Score float64 `json:"score"`
+	// Spread is the weighted standard deviation of the neighbors' outputs.
+	Spread float64 `json:"spread"`
+	// MeanDistance is the mean distance to the neighbors, and
+	// TypicalDistance the median of that mean over training cases.
+	MeanDistance    float64 `json:"mean_distance"`
+	TypicalDistance float64 `json:"typical_distance"`
+}
+
+// confidenceSamples is the number of training cases the typical neighbor
+// distance is measured on.
+const confidenceSamples = 1000
+
+// Confidence scores the prediction for q. Agreement is 1/(1 + spread /
+// prediction), so it falls as the neighbors disagree; proximity is 1/(1 +
+// mean distance / typical distance), so it is 0.5 for a query as close to
+// its neighbors as a typical training case.
+func (p *Predictor) Confidence(q Query) Confidence {
+	q = p.Bins.apply(q)
+	v := q.features()
+	pool := p.pool(v)
+	neighbors := p.searcher(v).search(nil, v, max(p.K, 1))
+	c := Confidence{Score: 1, TypicalDistance: p.typicalDistance()}
+	if len(neighbors) == 0 {
+		return Confidence{TypicalDistance: c.TypicalDistance}
+	}
+	for _, nb := range neighbors {
+		in := pool[nb.Case].Input
+		if in.TripDurationDays == q.TripDurationDays && math.Abs(in.MilesTraveled-q.MilesTraveled) < 0.001 &&
+			math.Abs(in.TotalReceiptsAmount-q.TotalReceiptsAmount) < 0.001 && sameInputFields(in.features(), v) {
+			return c
+		}
+	}
+
+	mean := weightedAverage(neighbors)
+	var variance, totalWeight float64
+	for _, nb := range neighbors {
+		w := 1.0 / (nb.Distance + 1e-8)
+		variance += w * (nb.Output - mean) * (nb.Output - mean)
+		totalWeight += w
+		c.MeanDistance += nb.Distance
+	}
+	c.Spread = math.Sqrt(variance / totalWeight)
+	c.MeanDistance /= float64(len(neighbors))
+
+	agreement := 1 / (1 + c.Spread/math.Max(math.Abs(mean), 1e-9))
+	proximity := 1 / (1 + c.MeanDistance/math.Max(c.TypicalDistance, 1e-9))
+	c.Score = math.Min(agreement, proximity)
+	return c
+}
+
+// typicalDistance returns the median over training cases of the mean
+// distance to their k nearest other cases, measured on an evenly spaced
+// subset of at most confidenceSamples cases once and then cached.
+func (p *Predictor) typicalDistance() float64 {
+	p.typicalOnce.Do(func() {
+		n := len(p.Training)
+		step := max(n/confidenceSamples, 1)
+		var means []float64
+		for i := 0; i < n; i += step {
+			v := caseFeatures(p.Training[i])
+			found := p.searcher(v).search(nil, v, max(p.K, 1)+1)
+			sum, count, self := 0.0, 0, false
+			for _, nb := range found {
+				if !self && nb.Distance == 0 {
+					self = true // the case itself
+					continue
+				}
+				if count < max(p.K, 1) {
+					sum += nb.Distance
+					count++
+				}
+			}
+			if count > 0 {
+				means = append(means, sum/float64(count))
+			}
+		}
+		if len(means) > 0 {
+			slices.Sort(means)
+			p.typical = means[len(means)/2]
+		}
+	})
+	return p.typical
+}
+
+// abstainMessage explains a prediction withheld for scoring below
+// minConfidence.
+func abstainMessage(c Confidence, minConfidence float64) string {
+	return fmt.Sprintf("cannot estimate: confidence %.2f is below %.2f (neighbor spread $%.2f, distance %.3f vs typical %.3f)",
+		c.Score, minConfidence, c.Spread, c.MeanDistance, c.TypicalDistance)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Case file formats convert reads and writes. Workbooks are only read.
+const (
+	fileJSON = "json"
+	fileCSV  = "csv"
+	fileXLSX = "xlsx"
+)
+
+// Case fields a tabular file's columns map to.
+const (
+	columnDays      = "days"
+	columnMiles     = "miles"
+	columnReceipts  = "receipts"
+	columnOutput    = "output"
+	columnTimestamp = "timestamp"
+)
+
+var caseColumns = []string{columnDays, columnMiles, columnReceipts, columnOutput, columnTimestamp}
+
+// columnMapping names the column holding each case field in a tabular file.
+// Fields other than caseColumns are named input fields.
+type columnMapping map[string]string
+
+// inputFields returns the named input fields m maps, sorted.
+func (m columnMapping) inputFields() []string {
+	var names []string
+	for field := range m {
+		if !slices.Contains(caseColumns, field) {
+			names = append(names, field)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// defaultColumns names columns after the JSON fields of a case.
+func defaultColumns() columnMapping {
+	return columnMapping{
+		columnDays:      "trip_duration_days",
+		columnMiles:     "miles_traveled",
+		columnReceipts:  "total_receipts_amount",
+		columnOutput:    "expected_output",
+		columnTimestamp: "timestamp",
+	}
+}
+
+// parseColumnMapping overrides the default columns with comma-separated
+// field=column pairs, such as "days=Trip Days,output=Amount Paid". A field
+// other than caseColumns reads a named input field from its column, such
+// as "department=Dept".
+func parseColumnMapping(s string) (columnMapping, error) {
+	m := defaultColumns()
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, want field=column", pair)
+		}
+		if _, known := m[field]; !known && !validInputField(field) {
+			return nil, fmt.Errorf("invalid field %q in column mapping (want %s or an input field name)", field, strings.Join(caseColumns, ", "))
+		}
+		m[field] = column
+	}
+	return m, nil
+}
+
+// parseTable builds cases from a header row and data rows, finding each
+// field's column by name, ignoring case and surrounding space. The cases
+// are labelled when the table has an output column. A blank cell leaves a
+// named input field unset.
+func parseTable(header []string, rows [][]string, m columnMapping) (cases TrainingData, labelled bool, err error) {
+	index := map[string]int{}
+	for field, column := range m {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), column) {
+				index[field] = i
+				break
+			}
+		}
+	}
+	for _, field := range append([]string{columnDays, columnMiles, columnReceipts}, m.inputFields()...) {
+		if _, ok := index[field]; !ok {
+			return nil, false, fmt.Errorf("no %q column for %s (columns are %s)", m[field], field, strings.Join(header, ", "))
+		}
+	}
+	_, labelled = index[columnOutput]
+
+	cases = make(TrainingData, 0, len(rows))
+	for n, row := range rows {
+		cell := func(field string) (string, bool) {
+			i, ok := index[field]
+			if !ok || i >= len(row) {
+				return "", false
+			}
+			return strings.TrimSpace(row[i]), true
+		}
+		number := func(field string) (float64, error) {
+			s, _ := cell(field)
+			v, err := parseAmount(s)
+			if err != nil {
+				return 0, fmt.Errorf("row %d: %s: %v", n+1, m[field], err)
+			}
+			return v, nil
+		}
+		if isBlankRow(row) {
+			continue
+		}
+
+		var c TestCase
+		days, err := number(columnDays)
+		if err != nil {
+			return nil, false, err
+		}
+		if days != math.Trunc(days) {
+			return nil, false, fmt.Errorf("row %d: %s: %g is not a whole number of days", n+1, m[columnDays], days)
+		}
+		c.Input.TripDurationDays = int(days)
+		if c.Input.MilesTraveled, err = number(columnMiles); err != nil {
+			return nil, false, err
+		}
+		if c.Input.TotalReceiptsAmount, err = number(columnReceipts); err != nil {
+			return nil, false, err
+		}
+		if labelled {
+			if c.ExpectedOutput, err = number(columnOutput); err != nil {
+				return nil, false, err
+			}
+		}
+		for _, field := range m.inputFields() {
+			if s, _ := cell(field); s == "" {
+				continue
+			}
+			v, err := number(field)
+			if err != nil {
+				return nil, false, err
+			}
+			if c.Input.Fields == nil {
+				c.Input.Fields = map[string]float64{}
+			}
+			c.Input.Fields[field] = v
+		}
+		if s, ok := cell(columnTimestamp); ok && s != "" {
+			if serial, err := strconv.ParseFloat(s, 64); err == nil {
+				// Spreadsheets store dates as days since 1899-12-30.
+				c.Timestamp = caseTime(excelEpoch.Unix() + int64(math.Round(serial*86400)))
+			} else if err := c.Timestamp.UnmarshalJSON(strconv.AppendQuote(nil, s)); err != nil {
+				return nil, false, fmt.Errorf("row %d: %s: %v", n+1, m[columnTimestamp], err)
+			}
+		}
+		cases = append(cases, c)
+	}
+	return cases, labelled, nil
+}
+
+// excelEpoch is day zero of spreadsheet date serial numbers.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// parseAmount parses a number as finance keeps it, allowing a dollar sign
+// and thousands separators.
+func parseAmount(s string) (float64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+	v, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimPrefix(s, "$"), ",", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readCSVCases reads cases from a CSV file with a header row.
+func readCSVCases(path string, m columnMapping) (TrainingData, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(records) == 0 {
+		return nil, false, fmt.Errorf("%s has no header row", path)
+	}
+	return parseTable(records[0], records[1:], m)
+}
+
+// readJSONCases reads labelled cases, like the training data, or unlabelled
+// inputs, like the private cases.
+func readJSONCases(path string) (TrainingData, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var elems []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return nil, false, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if len(elems) > 0 {
+		if _, ok := elems[0]["input"]; ok {
+			cases, err := loadTrainingFile(path, false)
+			return cases, true, err
+		}
+	}
+	var queries []Query
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, false, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	cases := make(TrainingData, len(queries))
+	for i, q := range queries {
+		cases[i].Input = q
+	}
+	return cases, false, nil
+}
+
+// readCases reads labelled or unlabelled cases from a local file in format.
+func readCases(path, format string, m columnMapping, table tableFlags) (TrainingData, bool, error) {
+	switch format {
+	case fileCSV:
+		return readCSVCases(path, m)
+	case fileXLSX:
+		return table.readWorkbookCases(path)
+	}
+	return readJSONCases(path)
+}
+
+// writeJSONCases writes labelled cases as training data and unlabelled ones
+// as a list of inputs.
+func writeJSONCases(path string, cases TrainingData, labelled bool) error {
+	if labelled {
+		return writeJSONFile(path, cases)
+	}
+	queries := make([]Query, len(cases))
+	for i, c := range cases {
+		queries[i] = c.Input
+	}
+	return writeJSONFile(path, queries)
+}
+
+// writeCSVCases writes cases with a header row of the mapped column names.
+// The timestamp column is written only when some case has a timestamp, and
+// a column for each named input field some case has, headed by its mapped
+// column or else its name, after the receipts.
+func writeCSVCases(w io.Writer, cases TrainingData, labelled bool, m columnMapping) error {
+	named := map[string]bool{}
+	for _, c := range cases {
+		for name := range c.Input.Fields {
+			named[name] = true
+		}
+	}
+	inputs := slices.Sorted(maps.Keys(named))
+	fields := append([]string{columnDays, columnMiles, columnReceipts}, inputs...)
+	if labelled {
+		fields = append(fields, columnOutput)
+	}
+	for _, c := range cases {
+		if c.Timestamp != 0 {
+			fields = append(fields, columnTimestamp)
+			break
+		}
+	}
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f
+		if column, ok := m[f]; ok {
+			header[i] = column
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	row := make([]string, len(fields))
+	for _, c := range cases {
+		for i, f := range fields {
+			switch f {
+			case columnDays:
+				row[i] = strconv.Itoa(c.Input.TripDurationDays)
+			case columnMiles:
+				row[i] = strconv.FormatFloat(c.Input.MilesTraveled, 'f', -1, 64)
+			case columnReceipts:
+				row[i] = strconv.FormatFloat(c.Input.TotalReceiptsAmount, 'f', -1, 64)
+			case columnOutput:
+				row[i] = strconv.FormatFloat(c.ExpectedOutput, 'f', -1, 64)
+			case columnTimestamp:
+				row[i] = ""
+				if c.Timestamp != 0 {
+					row[i] = time.Unix(int64(c.Timestamp), 0).UTC().Format(time.RFC3339)
+				}
+			default:
+				row[i] = ""
+				if v, ok := c.Input.Fields[f]; ok {
+					row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+				}
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// fileFormat returns the format named by flag, or else the one implied by
+// path's extension.
+func fileFormat(flag, path string) (string, error) {
+	if flag == "" {
+		flag = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	switch flag {
+	case fileJSON, fileCSV, fileXLSX:
+		return flag, nil
+	case "":
+		return "", fmt.Errorf("cannot tell the format of %q; set it with -from or -to", path)
+	}
+	return "", fmt.Errorf("unknown format %q (want %s, %s or %s)", flag, fileJSON, fileCSV, fileXLSX)
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	in := fs.String("in", "", "case file to convert, a path or s3:// or gs:// URI")
+	out := fs.String("out", "", "write the converted cases to this path (default stdout)")
+	from := fs.String("from", "", "format of -in: json, csv or xlsx (default from its extension)")
+	to := fs.String("to", "", "format of -out: json or csv (default from its extension)")
+	var table tableFlags
+	table.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	inFormat, err := fileFormat(*from, *in)
+	if err != nil {
+		return err
+	}
+	inPath, err := localPath(*in)
+	if err != nil {
+		return err
+	}
+	outFormat, err := fileFormat(*to, *out)
+	if err != nil {
+		return err
+	}
+	if outFormat == fileXLSX {
+		return fmt.Errorf("cannot write %s; convert to %s or %s", fileXLSX, fileJSON, fileCSV)
+	}
+	m, err := parseColumnMapping(table.columns)
+	if err != nil {
+		return err
+	}
+
+	cases, labelled, err := readCases(inPath, inFormat, m, table)
+	if err != nil {
+		return err
+	}
+
+	return writeCases(*out, outFormat, cases, labelled, m)
+}
+
+// writeCases writes cases to path, or stdout when path is empty, as JSON or
+// CSV with the mapped column names.
+func writeCases(path, format string, cases TrainingData, labelled bool, m columnMapping) error {
+	if format == fileJSON {
+		return writeJSONCases(path, cases, labelled)
+	}
+	if path == "" {
+		return writeCSVCases(os.Stdout, cases, labelled, m)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeCSVCases(file, cases, labelled, m); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
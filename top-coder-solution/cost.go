@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CostFunction prices a prediction error as the business sees it, rather
+// than as the symmetric absolute error: each dollar overpaid or underpaid
+// has its own weight, and errors beyond QuadraticAbove dollars grow with
+// their square, so one $500 miss costs more than five $100 ones.
+type CostFunction struct {
+	Overpayment    float64 `json:"overpayment"`               // weight of a dollar predicted above the expected output
+	Underpayment   float64 `json:"underpayment"`              // weight of a dollar predicted below it
+	QuadraticAbove float64 `json:"quadratic_above,omitempty"` // 0 keeps every error linear
+}
+
+// parseCostFunction parses comma-separated name=value pairs, as in
+// "over=3,under=1,quadratic-above=100". Weights left out are 1.
+func parseCostFunction(s string) (*CostFunction, error) {
+	c := &CostFunction{Overpayment: 1, Underpayment: 1}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		v, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid cost %q, want name=value pairs such as over=3,under=1,quadratic-above=100", s)
+		}
+		switch name {
+		case "over":
+			c.Overpayment = v
+		case "under":
+			c.Underpayment = v
+		case "quadratic-above":
+			c.QuadraticAbove = v
+		default:
+			return nil, fmt.Errorf("cost: unknown term %q (want over, under or quadratic-above)", name)
+		}
+	}
+	for _, v := range []float64{c.Overpayment, c.Underpayment, c.QuadraticAbove} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("cost weights and thresholds must be finite and not negative")
+		}
+	}
+	return c, nil
+}
+
+// cost returns the cost of a prediction with the residual predicted minus
+// expected. Beyond the quadratic threshold t an error e costs e²/t, which
+// meets the linear cost at t.
+func (c *CostFunction) cost(residual float64) float64 {
+	e := math.Abs(residual)
+	if c.QuadraticAbove > 0 && e > c.QuadraticAbove {
+		e = e * e / c.QuadraticAbove
+	}
+	if residual > 0 {
+		return e * c.Overpayment
+	}
+	return e * c.Underpayment
+}
+
+// meanCost returns the mean cost of the results, 0 for none.
+func (c *CostFunction) meanCost(results []EvalResult) float64 {
+	if len(results) == 0 {
+		return 0
+	}
+	var total float64
+	for _, r := range results {
+		total += c.cost(r.Residual())
+	}
+	return total / float64(len(results))
+}
+
+func (c *CostFunction) String() string {
+	s := fmt.Sprintf("overpayments x%g, underpayments x%g", c.Overpayment, c.Underpayment)
+	if c.QuadraticAbove > 0 {
+		s += fmt.Sprintf(", quadratic above $%g", c.QuadraticAbove)
+	}
+	return s
+}
+
+// costFlag is the -cost flag of the commands that measure error.
+type costFlag struct {
+	spec string
+}
+
+func (f *costFlag) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.spec, "cost", "",
+		"also measure the business cost of errors, as over=3,under=1,quadratic-above=100: overpaid and underpaid dollars weighted, errors above the threshold squared")
+}
+
+// function returns the cost function, or nil when -cost is not set.
+func (f costFlag) function() (*CostFunction, error) {
+	if f.spec == "" {
+		return nil, nil
+	}
+	return parseCostFunction(f.spec)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// ReceiptCurve is a fitted reimbursement formula for trips of MinDays to
+// MaxDays days whose receipts saturate: receipts up to Cap are reimbursed at
+// Rate per dollar and the rest at RateAbove, with days and miles linear.
+type ReceiptCurve struct {
+	Segment   string  `json:"segment"`
+	MinDays   int     `json:"min_days"`
+	MaxDays   int     `json:"max_days,omitempty"` // 0 for no upper bound
+	Cases     int     `json:"cases"`
+	Intercept float64 `json:"intercept"`
+	PerDay    float64 `json:"per_day"`
+	PerMile   float64 `json:"per_mile"`
+	Cap       float64 `json:"cap"`
+	Rate      float64 `json:"rate"`
+	RateAbove float64 `json:"rate_above"`
+	RMSE      float64 `json:"rmse"`
+}
+
+// defaultCurveBands start the trip-length segments receipt curves are
+// fitted for: short, medium and long trips, as the interviews describe them.
+var defaultCurveBands = []int{1, 4, 7}
+
+// curveCapQuantiles are the receipt quantiles of a segment tried as its cap.
+const curveCapQuantiles = 40
+
+func (c ReceiptCurve) contains(days int) bool {
+	return days >= c.MinDays && (c.MaxDays == 0 || days <= c.MaxDays)
+}
+
+func (c ReceiptCurve) predict(q Query) float64 {
+	r := q.TotalReceiptsAmount
+	return c.Intercept + c.PerDay*float64(q.TripDurationDays) + c.PerMile*q.MilesTraveled +
+		c.Rate*math.Min(r, c.Cap) + c.RateAbove*math.Max(r-c.Cap, 0)
+}
+
+// fitReceiptCurve fits the curve by least squares at each candidate cap, the
+// quantiles of the cases' receipts, and keeps the cap fitting best.
+func fitReceiptCurve(cases TrainingData) ReceiptCurve {
+	receipts := make([]float64, len(cases))
+	for i, c := range cases {
+		receipts[i] = c.Input.TotalReceiptsAmount
+	}
+	sort.Float64s(receipts)
+
+	best := ReceiptCurve{Cases: len(cases), RMSE: math.Inf(1)}
+	for i := 1; i < curveCapQuantiles; i++ {
+		capAt := receipts[i*(len(receipts)-1)/curveCapQuantiles]
+		eq := newNormalEquations(5)
+		for _, c := range cases {
+			r := c.Input.TotalReceiptsAmount
+			eq.add([]float64{1, float64(c.Input.TripDurationDays), c.Input.MilesTraveled, math.Min(r, capAt), math.Max(r-capAt, 0)}, c.ExpectedOutput, 1)
+		}
+		beta := eq.solve()
+		curve := ReceiptCurve{Cases: len(cases), Intercept: beta[0], PerDay: beta[1], PerMile: beta[2], Cap: capAt, Rate: beta[3], RateAbove: beta[4]}
+		var sse float64
+		for _, c := range cases {
+			d := curve.predict(c.Input) - c.ExpectedOutput
+			sse += d * d
+		}
+		if curve.RMSE = math.Sqrt(sse / float64(len(cases))); curve.RMSE < best.RMSE {
+			best = curve
+		}
+	}
+	return best
+}
+
+// fitReceiptCurves fits a curve for each trip-length band starting at the
+// ascending days of bands, skipping bands without cases.
+func fitReceiptCurves(training TrainingData, bands []int) []ReceiptCurve {
+	var curves []ReceiptCurve
+	for i, from := range bands {
+		to := 0
+		if i+1 < len(bands) {
+			to = bands[i+1] - 1
+		}
+		var cases TrainingData
+		for _, c := range training {
+			if c.Input.TripDurationDays >= from && (to == 0 || c.Input.TripDurationDays <= to) {
+				cases = append(cases, c)
+			}
+		}
+		if len(cases) == 0 {
+			continue
+		}
+		curve := fitReceiptCurve(cases)
+		curve.MinDays, curve.MaxDays = from, to
+		if to == 0 {
+			curve.Segment = fmt.Sprintf("%d+ days", from)
+		} else {
+			curve.Segment = fmt.Sprintf("%d-%d days", from, to)
+		}
+		curves = append(curves, curve)
+	}
+	return curves
+}
+
+// parseCurveBands parses comma-separated ascending day counts.
+func parseCurveBands(s string) ([]int, error) {
+	var bands []int
+	for _, f := range strings.Split(s, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || d < 0 || (len(bands) > 0 && d <= bands[len(bands)-1]) {
+			return nil, fmt.Errorf("invalid bands %q, want ascending day counts such as 1,4,7", s)
+		}
+		bands = append(bands, d)
+	}
+	return bands, nil
+}
+
+// curveModel predicts with the receipt curve of the query's trip length.
+type curveModel struct {
+	curves []ReceiptCurve
+}
+
+func (m *curveModel) Fit(training TrainingData) {
+	m.curves = fitReceiptCurves(training, defaultCurveBands)
+}
+
+// curve returns the curve for days: its band's, or the nearest band's when
+// no band holds it.
+func (m *curveModel) curve(days int) *ReceiptCurve {
+	for i := range m.curves {
+		if m.curves[i].contains(days) {
+			return &m.curves[i]
+		}
+	}
+	if len(m.curves) == 0 {
+		return &ReceiptCurve{}
+	}
+	if days < m.curves[0].MinDays {
+		return &m.curves[0]
+	}
+	return &m.curves[len(m.curves)-1]
+}
+
+func (m *curveModel) Predict(q Query) float64 {
+	return m.curve(q.TripDurationDays).predict(q)
+}
+
+func (m *curveModel) Explain(q Query) ModelExplanation {
+	c := m.curve(q.TripDurationDays)
+	r := q.TotalReceiptsAmount
+	below, above := math.Min(r, c.Cap), math.Max(r-c.Cap, 0)
+	steps := []string{
+		"segment " + c.Segment,
+		fmt.Sprintf("intercept: %+.2f", c.Intercept),
+		fmt.Sprintf("days: %d × %.2f = %+.2f", q.TripDurationDays, c.PerDay, c.PerDay*float64(q.TripDurationDays)),
+		fmt.Sprintf("miles: %g × %.4f = %+.2f", q.MilesTraveled, c.PerMile, c.PerMile*q.MilesTraveled),
+		fmt.Sprintf("receipts up to cap %.2f: %.2f × %.4f = %+.2f", c.Cap, below, c.Rate, c.Rate*below),
+	}
+	if above > 0 {
+		steps = append(steps, fmt.Sprintf("receipts above cap: %.2f × %.4f = %+.2f", above, c.RateAbove, c.RateAbove*above))
+	}
+	return ModelExplanation{Model: modelCurve, Prediction: c.predict(q), Steps: steps}
+}
+
+func printCurves(w io.Writer, curves []ReceiptCurve) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Segment\tCases\tCap\tRate to cap\tRate above\tPer day\tPer mile\tRMSE")
+	for _, c := range curves {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.4f\t%.4f\t%.2f\t%.4f\t%.2f\n",
+			c.Segment, c.Cases, c.Cap, c.Rate, c.RateAbove, c.PerDay, c.PerMile, c.RMSE)
+	}
+	tw.Flush()
+}
+
+func runCurves(args []string) error {
+	fs := flag.NewFlagSet("curves", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	bands := fs.String("bands", "1,4,7", "first days of the trip-length segments, ascending")
+	asJSON := fs.Bool("json", false, "print the curves as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	starts, err := parseCurveBands(*bands)
+	if err != nil {
+		return err
+	}
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	curves := fitReceiptCurves(trainingData, starts)
+	if len(curves) == 0 {
+		return fmt.Errorf("no training cases in any band")
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, curves)
+	}
+	printCurves(os.Stdout, curves)
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+)
+
+// neighborGraph caches each training case's nearest neighbors among the
+// other cases, and the other cases it exactly matches. Cross-validation
+// answers a held-out case from these lists by skipping the cases in its
+// fold, instead of rebuilding the model and searching again for every case.
+type neighborGraph struct {
+	lists    [][]Neighbor // Case indexes the training data; nearest first
+	complete []bool       // whether lists[i] holds every other case
+	exact    [][]int      // exact matches of each case, in training order
+}
+
+// buildNeighborGraph finds the depth nearest other cases of every training
+// case of p, searching on up to workers goroutines.
+func buildNeighborGraph(p *Predictor, depth, workers int) *neighborGraph {
+	n := len(p.Training)
+	g := &neighborGraph{lists: make([][]Neighbor, n), complete: make([]bool, n), exact: exactMatches(p.Training)}
+	forEach(n, workers, func(i int) {
+		v := caseFeatures(p.Training[i])
+		found := p.searcher(v).search(nil, v, depth+1)
+		list := found[:0]
+		for _, nb := range found {
+			if nb.Case != i {
+				list = append(list, nb)
+			}
+		}
+		g.lists[i] = list[:min(len(list), depth)]
+		g.complete[i] = len(g.lists[i]) == n-1
+	})
+	return g
+}
+
+// exactMatches lists, for every case, the other cases a prediction would
+// treat as an exact match of it: the same days and named input fields, and
+// miles and receipts within 0.001. Cases are bucketed on a 0.001 grid so
+// only neighboring buckets need comparing.
+func exactMatches(training TrainingData) [][]int {
+	type cell struct{ days, miles, receipts int64 }
+	cellOf := func(c TestCase) cell {
+		return cell{int64(c.Input.TripDurationDays), int64(math.Floor(c.Input.MilesTraveled * 1000)),
+			int64(math.Floor(c.Input.TotalReceiptsAmount * 1000))}
+	}
+	cells := map[cell][]int{}
+	for i, c := range training {
+		cells[cellOf(c)] = append(cells[cellOf(c)], i)
+	}
+
+	matches := make([][]int, len(training))
+	for i, c := range training {
+		home := cellOf(c)
+		for dm := int64(-1); dm <= 1; dm++ {
+			for dr := int64(-1); dr <= 1; dr++ {
+				for _, j := range cells[cell{home.days, home.miles + dm, home.receipts + dr}] {
+					o := training[j].Input
+					if j != i && math.Abs(o.MilesTraveled-c.Input.MilesTraveled) < 0.001 &&
+						math.Abs(o.TotalReceiptsAmount-c.Input.TotalReceiptsAmount) < 0.001 && sameInputFields(o.features(), c.Input.features()) {
+						matches[i] = append(matches[i], j)
+					}
+				}
+			}
+		}
+		slices.Sort(matches[i])
+	}
+	return matches
+}
+
+// predict predicts training case i from the cases outside its fold, exactly
+// as predictWeightedKNN would over them. ok is false when too much of a
+// truncated list lies in the fold to find k neighbors.
+func (g *neighborGraph) predict(training TrainingData, i int, folds []int, k int, how string, buf []Neighbor) (prediction float64, ok bool) {
+	for _, j := range g.exact[i] {
+		if folds[j] != folds[i] {
+			return training[j].ExpectedOutput, true
+		}
+	}
+	nearest := buf[:0]
+	for _, nb := range g.lists[i] {
+		if folds[nb.Case] != folds[i] {
+			nearest = append(nearest, nb)
+			if len(nearest) == k {
+				break
+			}
+		}
+	}
+	if len(nearest) == 0 || (len(nearest) < k && !g.complete[i]) {
+		return 0, false
+	}
+	return aggregate(nearest, how, inverseDistance), true
+}
+
+// leaveOneOutFolds puts every case in a fold of its own.
+func leaveOneOutFolds(n int) []int {
+	folds := make([]int, n)
+	for i := range folds {
+		folds[i] = i
+	}
+	return folds
+}
+
+// randomFolds assigns n cases to k folds of near-equal size.
+func randomFolds(n, k int, seed uint64) []int {
+	folds := make([]int, n)
+	for i, j := range rand.New(rand.NewPCG(seed, seed)).Perm(n) {
+		folds[j] = i % k
+	}
+	return folds
+}
+
+// crossValidate predicts every training case of p with a model trained on
+// the cases outside its fold, working through folds on up to workers
+// goroutines and counting finished cases in prog. Exact searches are
+// answered from a neighbor graph built once. Cases the graph cannot settle,
+// and models whose neighbors depend on more than the fixed distances between
+// cases (segmentation, approximate indexes, a metric, fallback model or
+// recency weights fitted to the training data) and models other than KNN,
+// are predicted by a model rebuilt without the fold.
+func crossValidate(p *Predictor, folds []int, workers int, prog *progress) []EvalResult {
+	results, _ := crossValidateContext(context.Background(), p, folds, workers, prog)
+	return results
+}
+
+// crossValidateContext is crossValidate that stops once ctx is done,
+// returning ctx's error with the results incomplete.
+func crossValidateContext(ctx context.Context, p *Predictor, folds []int, workers int, prog *progress) ([]EvalResult, error) {
+	return crossValidateResume(ctx, p, folds, workers, prog, nil, nil)
+}
+
+// crossValidateResume is crossValidateContext that takes the predictions of
+// the folds in done, as an interrupted run checkpointed them, instead of
+// predicting them again, and passes each fold it finishes to finished
+// unless that is nil.
+func crossValidateResume(ctx context.Context, p *Predictor, folds []int, workers int, prog *progress,
+	done map[int]EvalCheckpointFold, finished func(EvalCheckpointFold)) ([]EvalResult, error) {
+	training := p.Training
+	results := make([]EvalResult, len(training))
+
+	// Cases ordered by fold, so each fold is a contiguous run.
+	order := make([]int, len(training))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return folds[a] - folds[b] })
+	var runs []int // start of each fold's run in order
+	for r, i := range order {
+		if r == 0 || folds[i] != folds[order[r-1]] {
+			runs = append(runs, r)
+		}
+	}
+
+	var graph *neighborGraph
+	if p.model == nil && p.Segmentation == nil && !p.Index.approximate() && p.Metric != metricMahalanobis && p.FallbackDistance == 0 &&
+		p.recency == nil && !p.Reproducible {
+		depth := p.K
+		if len(runs) < len(training) {
+			// Expect a 1/len(runs) share of each list to be in the fold.
+			depth = 2*int(math.Ceil(float64(p.K*len(runs))/float64(len(runs)-1))) + 4
+		}
+		graph = buildNeighborGraph(p, depth, workers)
+	}
+
+	var stopped atomic.Bool // a fold was left unfinished
+	err := forEachContext(ctx, len(runs), workers, func(f int) {
+		end := len(order)
+		if f+1 < len(runs) {
+			end = runs[f+1]
+		}
+		run := order[runs[f]:end]
+		if d, ok := done[folds[run[0]]]; ok && len(d.Cases) == len(run) {
+			for j, i := range d.Cases {
+				results[i] = EvalResult{Case: training[i], Predicted: d.Predicted[j]}
+			}
+			prog.add(len(run))
+			return
+		}
+		var without *Predictor // built only if the graph falls short
+		var buf []Neighbor
+		complete := true
+		for _, i := range run {
+			if ctx.Err() != nil {
+				stopped.Store(true)
+				complete = false
+				break
+			}
+			c := training[i]
+			results[i].Case = c
+			if graph != nil {
+				if predicted, ok := graph.predict(training, i, folds, max(p.K, 1), p.Aggregate, buf); ok {
+					if p.overrides != nil {
+						predicted, _ = applyOverrides(p.overrides, caseFeatures(c), predicted)
+					}
+					results[i].Predicted = predicted
+					continue
+				}
+			}
+			if without == nil {
+				held := make(TrainingData, 0, len(training))
+				for j, other := range training {
+					if folds[j] != folds[i] {
+						held = append(held, other)
+					}
+				}
+				without = NewPredictor(held, p.Hyperparameters())
+			}
+			results[i].Predicted = without.PredictQuery(c.Input)
+		}
+		if without != nil {
+			without.Close()
+		}
+		prog.add(len(run))
+		if finished != nil && complete {
+			d := EvalCheckpointFold{Fold: folds[run[0]], Cases: run, Predicted: make([]float64, len(run))}
+			for j, i := range run {
+				d.Predicted[j] = results[i].Predicted
+			}
+			finished(d)
+		}
+	})
+	if err == nil && stopped.Load() {
+		err = ctx.Err()
+	}
+	return results, err
+}
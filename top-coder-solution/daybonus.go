@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// DayBonus estimates the bonus trips of one length get beyond what a smooth
+// formula gives them.
+type DayBonus struct {
+	Days  int `json:"days"`
+	Cases int `json:"cases"`
+	// Bonus is the mean residual of the trips against the rule model fitted
+	// without day bonuses, and StdErr its standard error.
+	Bonus  float64 `json:"bonus"`
+	StdErr float64 `json:"std_err"`
+	// Spike is Bonus less the mean Bonus of the neighboring lengths, so a
+	// bonus particular to this length stands out from a curved per diem.
+	Spike float64 `json:"spike"`
+	// Significant is set when Spike exceeds dayBonusSignificance times its
+	// standard error.
+	Significant bool `json:"significant"`
+}
+
+// dayBonusSignificance is the number of standard errors a spike must exceed
+// to be reported as a bonus.
+const dayBonusSignificance = 3.0
+
+// estimateDayBonuses fits the rule model without day bonuses and measures
+// the residuals of each trip length.
+func estimateDayBonuses(training TrainingData) []DayBonus {
+	baseline := &ruleModel{Config: RuleConfig{DayBonus: map[int]float64{}}}
+	baseline.Fit(training)
+
+	byDays := map[int][]float64{}
+	maxDays := 0
+	for _, c := range training {
+		d := c.Input.TripDurationDays
+		byDays[d] = append(byDays[d], c.ExpectedOutput-baseline.Predict(c.Input))
+		maxDays = max(maxDays, d)
+	}
+	var out []DayBonus
+	index := map[int]int{}
+	for d := 0; d <= maxDays; d++ {
+		residuals := byDays[d]
+		if len(residuals) == 0 {
+			continue
+		}
+		mean, std := meanStd(residuals)
+		index[d] = len(out)
+		out = append(out, DayBonus{Days: d, Cases: len(residuals), Bonus: mean, StdErr: std / math.Sqrt(float64(len(residuals)))})
+	}
+	for i := range out {
+		b := &out[i]
+		var sum, variance float64
+		var n int
+		for _, d := range []int{b.Days - 1, b.Days + 1} {
+			if j, ok := index[d]; ok {
+				sum += out[j].Bonus
+				variance += out[j].StdErr * out[j].StdErr
+				n++
+			}
+		}
+		if n > 0 {
+			b.Spike = b.Bonus - sum/float64(n)
+			variance /= float64(n * n)
+		}
+		spikeErr := math.Sqrt(b.StdErr*b.StdErr + variance)
+		b.Significant = spikeErr > 0 && math.Abs(b.Spike) > dayBonusSignificance*spikeErr
+	}
+	return out
+}
+
+func printDayBonuses(w io.Writer, bonuses []DayBonus) {
+	fmt.Fprintln(w, "Residuals by trip length against the rule model without day bonuses")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Days\tCases\tBonus\tStd err\tSpike\t")
+	for _, b := range bonuses {
+		mark := ""
+		if b.Significant {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%+.2f\t%.2f\t%+.2f\t%s\n", b.Days, b.Cases, b.Bonus, b.StdErr, b.Spike, mark)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n* spike beyond %g standard errors: a bonus for exactly that many days\n", dayBonusSignificance)
+}
+
+func runDayBonuses(args []string) error {
+	fs := flag.NewFlagSet("day-bonuses", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	asJSON := fs.Bool("json", false, "print the estimates as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if len(trainingData) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+	bonuses := estimateDayBonuses(trainingData)
+	if *asJSON {
+		return writeJSON(os.Stdout, bonuses)
+	}
+	printDayBonuses(os.Stdout, bonuses)
+	return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Statuses of a doctor check.
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "FAIL"
+)
+
+// DoctorCheck is the outcome of one doctor check and, unless it passed,
+// how to fix what it found.
+type DoctorCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// doctor runs the environment checks behind the doctor command.
+type doctor struct {
+	checks []DoctorCheck
+}
+
+func (d *doctor) ok(check, format string, args ...any) {
+	d.checks = append(d.checks, DoctorCheck{Check: check, Status: doctorOK, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) warn(check, detail, fix string) {
+	d.checks = append(d.checks, DoctorCheck{Check: check, Status: doctorWarn, Detail: detail, Fix: fix})
+}
+
+func (d *doctor) fail(check, detail, fix string) {
+	d.checks = append(d.checks, DoctorCheck{Check: check, Status: doctorFail, Detail: detail, Fix: fix})
+}
+
+func (d *doctor) failed() int {
+	n := 0
+	for _, c := range d.checks {
+		if c.Status == doctorFail {
+			n++
+		}
+	}
+	return n
+}
+
+// findData looks for a data file that is not where path says, returning
+// the first existing candidate: path taken relative to the executable's
+// directory instead of the working directory, or a file of the same name
+// in either directory or its parent.
+func findData(path string) string {
+	var dirs []string
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	if exe, err := os.Executable(); err == nil {
+		exeDir := filepath.Dir(exe)
+		if !filepath.IsAbs(path) {
+			if found := filepath.Join(exeDir, path); isFile(found) {
+				return found
+			}
+		}
+		dirs = append(dirs, exeDir)
+	}
+	for _, dir := range dirs {
+		for _, candidate := range []string{filepath.Join(dir, filepath.Base(path)), filepath.Join(dir, "..", filepath.Base(path))} {
+			if isFile(candidate) {
+				return filepath.Clean(candidate)
+			}
+		}
+	}
+	return ""
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// checkData checks the training data at path can be found and read, and
+// that every case in it is valid.
+func (d *doctor) checkData(check, path string) {
+	if path == bakedData {
+		if !isBaked() {
+			d.fail(check, fmt.Sprintf("-data %s names the baked model, but none is baked into this binary", bakedData),
+				fmt.Sprintf("pass -data with a training data file, or build with '%s bake' and -tags %s", progName(), bakedDir))
+			return
+		}
+		p, err := loadBakedModel()
+		if err != nil {
+			d.fail(check, err.Error(), "rebuild the binary from a fresh bake of the model")
+			return
+		}
+		d.ok(check, "baked model %s with %d cases", p.Version, len(p.Training))
+		return
+	}
+	local, err := localPath(path)
+	if err != nil {
+		d.fail(check, err.Error(), "check the URI, your network and your storage credentials, and that the cache directory is writable")
+		return
+	}
+	info, err := os.Stat(local)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		detail := fmt.Sprintf("%s not found", path)
+		if !filepath.IsAbs(local) {
+			if wd, err := os.Getwd(); err == nil {
+				detail += fmt.Sprintf(" (relative to the working directory %s)", wd)
+			}
+		}
+		fix := "pass -data with the path of the training data file, such as public_cases.json"
+		if found := findData(local); found != "" {
+			fix = fmt.Sprintf("found %s: pass -data %s", found, found)
+		}
+		d.fail(check, detail, fix)
+		return
+	case err != nil:
+		d.fail(check, err.Error(), "check the permissions of the file and its directories")
+		return
+	case info.IsDir():
+		d.fail(check, fmt.Sprintf("%s is a directory", path), "pass -data with a training data file in it")
+		return
+	}
+	file, err := os.Open(local)
+	if err != nil {
+		d.fail(check, err.Error(), fmt.Sprintf("make %s readable by this user", path))
+		return
+	}
+	file.Close()
+	d.ok(check, "%s found (%d bytes)", path, info.Size())
+
+	data, err := readTrainingFile(local, false)
+	switch {
+	case err != nil:
+		d.fail(check+" schema", err.Error(),
+			fmt.Sprintf("correct the case named, then run '%s lint-data %s' to find suspicious ones", progName(), path))
+	case len(data) == 0:
+		d.fail(check+" schema", fmt.Sprintf("%s has no cases", path), "pass -data with a file holding the training cases")
+	default:
+		d.ok(check+" schema", "%d valid cases", len(data))
+	}
+}
+
+// checkConfig checks the config file parses, that each profile and policy
+// version applies to the model flags, and that the training data they name
+// exists. A missing config file is fine unless it was asked for.
+func (d *doctor) checkConfig(path string, explicit bool) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) && !explicit {
+		d.ok("config", "no %s (optional)", path)
+		return
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		d.fail("config", err.Error(), "fix the JSON of the config file, or pass -config with the right one")
+		return
+	}
+	type flagSource struct {
+		name  string
+		flags Profile
+	}
+	var sources []flagSource
+	problems := 0
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		sources = append(sources, flagSource{"profile " + name, c.Profiles[name]})
+	}
+	for _, v := range c.PolicyVersions {
+		sources = append(sources, flagSource{"policy version " + v.Name, Profile{Flags: v.Flags}})
+		if _, err := time.Parse(time.DateOnly, v.Effective); err != nil {
+			d.fail("config", fmt.Sprintf("policy version %s: effective date %q is not YYYY-MM-DD", v.Name, v.Effective),
+				"write the effective date as YYYY-MM-DD")
+			problems++
+		}
+	}
+	for _, s := range sources {
+		set := flag.NewFlagSet("doctor", flag.ContinueOnError)
+		var model modelFlags
+		model.register(set)
+		if err := s.flags.apply(set); err != nil {
+			d.fail("config", fmt.Sprintf("%s: %v", s.name, err), "correct the flag's value in the config file")
+			problems++
+			continue
+		}
+		if model.dataPath == bakedData || isRemote(model.dataPath) || model.dataPath == defaultDataPath {
+			continue
+		}
+		if !isFile(model.dataPath) {
+			d.fail("config", fmt.Sprintf("%s: data %s not found", s.name, model.dataPath),
+				"correct the data path in the config file; relative paths are from the working directory")
+			problems++
+		}
+	}
+	if problems == 0 {
+		d.ok("config", "%s: %d profiles and %d policy versions", path, len(c.Profiles), len(c.PolicyVersions))
+	}
+}
+
+// checkRegistry checks each registered model's manifest parses and its
+// training data snapshot matches the recorded hash.
+func (d *doctor) checkRegistry(registry string) {
+	entries, err := os.ReadDir(registry)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.ok("registry", "no %s directory (optional)", registry)
+		return
+	}
+	if err != nil {
+		d.fail("registry", err.Error(), "check the permissions of the registry directory")
+		return
+	}
+	models := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := loadManifest(registry, e.Name())
+		if err != nil {
+			d.warn("registry", err.Error(), fmt.Sprintf("remove %s, or register it again with train -force", filepath.Join(registry, e.Name())))
+			continue
+		}
+		sum, err := hashFile(filepath.Join(registry, m.Tag, casesFile))
+		if err != nil || sum != m.DataSHA256 {
+			d.warn("registry", fmt.Sprintf("model %s: training data snapshot is missing or changed", m.Tag),
+				fmt.Sprintf("register %s again with train -force", m.Tag))
+			continue
+		}
+		models++
+	}
+	d.ok("registry", "%s: %d models verified", registry, models)
+}
+
+// checkWritable checks a directory the program writes to can be created and
+// written.
+func (d *doctor) checkWritable(check, dir, use, fix string) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		d.fail(check, fmt.Sprintf("%s, for %s: %v", dir, use, err), fix)
+		return
+	}
+	file, err := os.CreateTemp(dir, "doctor-*")
+	if err != nil {
+		d.fail(check, fmt.Sprintf("%s, for %s: %v", dir, use, err), fix)
+		return
+	}
+	file.Close()
+	os.Remove(file.Name())
+	d.ok(check, "%s is writable", dir)
+}
+
+func printDoctor(w io.Writer, checks []DoctorCheck) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+	for _, c := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Status, c.Check, c.Detail)
+		if c.Fix != "" {
+			fmt.Fprintf(tw, "\t\tfix: %s\n", c.Fix)
+		}
+	}
+	tw.Flush()
+}
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	dataDefault := defaultDataPath
+	if isBaked() {
+		dataDefault = bakedData
+	}
+	dataPath := fs.String("data", dataDefault, "training data to check, a path or s3:// or gs:// URI")
+	registry := fs.String("registry", defaultRegistry, "model registry directory to check")
+	asJSON := fs.Bool("json", false, "write the checks as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	configExplicit := false
+	fs.Visit(func(f *flag.Flag) { configExplicit = configExplicit || f.Name == "config" })
+
+	var d doctor
+	d.checkData("data", *dataPath)
+	d.checkConfig(fs.Lookup("config").Value.String(), configExplicit)
+	d.checkRegistry(*registry)
+	if dir, err := objectCacheDir(); err != nil {
+		d.fail("cache", err.Error(), "set XDG_CACHE_HOME or HOME to a writable directory")
+	} else {
+		d.checkWritable("cache", dir, "s3:// and gs:// downloads", "set XDG_CACHE_HOME or HOME to a writable directory")
+	}
+	d.checkWritable("temp", os.TempDir(), "-mem-budget's packed copies", "set TMPDIR to a writable directory")
+
+	if *asJSON {
+		if err := writeJSON(os.Stdout, d.checks); err != nil {
+			return err
+		}
+	} else {
+		printDoctor(os.Stdout, d.checks)
+	}
+	if n := d.failed(); n > 0 {
+		if !*asJSON {
+			fmt.Printf("\n%d %s failed; see the fixes above\n", n, plural(n, "check", "checks"))
+		}
+		return &exitError{code: 1}
+	}
+	if !*asJSON {
+		fmt.Println("\nAll checks passed")
+	}
+	return nil
+}
+
+// plural returns one when n is 1, and many otherwise.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// driftColumns are the distributions compared between two datasets.
+var driftColumns = []struct {
+	Name  string
+	Value func(c TestCase) float64
+}{
+	{"days", func(c TestCase) float64 { return float64(c.Input.TripDurationDays) }},
+	{"miles", func(c TestCase) float64 { return c.Input.MilesTraveled }},
+	{"receipts", func(c TestCase) float64 { return c.Input.TotalReceiptsAmount }},
+	{"output", func(c TestCase) float64 { return c.ExpectedOutput }},
+	{"output_per_day", func(c TestCase) float64 {
+		return c.ExpectedOutput / float64(max(c.Input.TripDurationDays, 1))
+	}},
+}
+
+// DriftStat compares one column between an old and a new dataset.
+type DriftStat struct {
+	Column     string  `json:"column"`
+	OldMean    float64 `json:"old_mean"`
+	NewMean    float64 `json:"new_mean"`
+	MeanShift  float64 `json:"mean_shift"`
+	StdShift   float64 `json:"mean_shift_std"` // mean shift in units of the old std
+	KS         float64 `json:"ks"`
+	KSCritical float64 `json:"ks_critical"`
+	Drifted    bool    `json:"drifted"`
+}
+
+func meanStd(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// ksStatistic returns the two-sample Kolmogorov-Smirnov statistic: the
+// largest gap between the empirical CDFs. Inputs are sorted in place.
+func ksStatistic(a, b []float64) float64 {
+	sort.Float64s(a)
+	sort.Float64s(b)
+	i, j := 0, 0
+	d := 0.0
+	for i < len(a) && j < len(b) {
+		x := math.Min(a[i], b[j])
+		for i < len(a) && a[i] <= x {
+			i++
+		}
+		for j < len(b) && b[j] <= x {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+	return d
+}
+
+// ksCritical returns the KS critical value at significance alpha for
+// samples of size n and m.
+func ksCritical(alpha float64, n, m int) float64 {
+	c := math.Sqrt(-math.Log(alpha/2) / 2)
+	return c * math.Sqrt(float64(n+m)/float64(n*m))
+}
+
+// compareDatasets computes drift statistics for every column. A column is
+// marked drifted when its KS statistic exceeds the critical value at alpha
+// or its mean moves by more than maxStdShift old standard deviations.
+func compareDatasets(oldCases, newCases TrainingData, alpha, maxStdShift float64) []DriftStat {
+	stats := make([]DriftStat, 0, len(driftColumns))
+	for _, col := range driftColumns {
+		a := make([]float64, len(oldCases))
+		for i, c := range oldCases {
+			a[i] = col.Value(c)
+		}
+		b := make([]float64, len(newCases))
+		for i, c := range newCases {
+			b[i] = col.Value(c)
+		}
+
+		oldMean, oldStd := meanStd(a)
+		newMean, _ := meanStd(b)
+		s := DriftStat{
+			Column:     col.Name,
+			OldMean:    oldMean,
+			NewMean:    newMean,
+			MeanShift:  newMean - oldMean,
+			KS:         ksStatistic(a, b),
+			KSCritical: ksCritical(alpha, len(a), len(b)),
+		}
+		if oldStd > 0 {
+			s.StdShift = s.MeanShift / oldStd
+		}
+		s.Drifted = s.KS > s.KSCritical || math.Abs(s.StdShift) > maxStdShift
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func runDrift(args []string) error {
+	fs := flag.NewFlagSet("drift", flag.ContinueOnError)
+	alpha := fs.Float64("alpha", 0.05, "KS test significance level")
+	maxStdShift := fs.Float64("max-std-shift", 0.25, "flag mean shifts larger than this many old standard deviations")
+	asJSON := fs.Bool("json", false, "print statistics as JSON")
+	failOnDrift := fs.Bool("fail-on-drift", false, "exit nonzero when any column drifted")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: drift [flags] <old_cases.json> <new_cases.json>")
+	}
+
+	oldCases, err := loadTrainingData(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("loading %s: %v", fs.Arg(0), err)
+	}
+	newCases, err := loadTrainingData(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("loading %s: %v", fs.Arg(1), err)
+	}
+	if len(oldCases) == 0 || len(newCases) == 0 {
+		return fmt.Errorf("both datasets must contain cases")
+	}
+
+	stats := compareDatasets(oldCases, newCases, *alpha, *maxStdShift)
+	if *asJSON {
+		if err := writeJSON(os.Stdout, stats); err != nil {
+			return err
+		}
+	} else {
+		printDrift(os.Stdout, stats, len(oldCases), len(newCases))
+	}
+
+	drifted := 0
+	for _, s := range stats {
+		if s.Drifted {
+			drifted++
+		}
+	}
+	if drifted > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d of %d distributions drifted; retuning is recommended\n", drifted, len(stats))
+		if *failOnDrift {
+			return fmt.Errorf("dataset drift detected")
+		}
+	}
+	return nil
+}
+
+func printDrift(w io.Writer, stats []DriftStat, nOld, nNew int) {
+	fmt.Fprintf(w, "Old cases: %d  New cases: %d\n", nOld, nNew)
+	fmt.Fprintf(w, "%-15s %10s %10s %10s %8s %7s %7s  %s\n",
+		"column", "old_mean", "new_mean", "shift", "shift_sd", "ks", "ks_crit", "status")
+	for _, s := range stats {
+		status := "ok"
+		if s.Drifted {
+			status = "DRIFT"
+		}
+		fmt.Fprintf(w, "%-15s %10.2f %10.2f %10.2f %8.3f %7.3f %7.3f  %s\n",
+			s.Column, s.OldMean, s.NewMean, s.MeanShift, s.StdShift, s.KS, s.KSCritical, status)
+	}
+}
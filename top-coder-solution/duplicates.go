@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Strategies for consolidating training cases with identical inputs.
+// Without consolidation the first such case in file order answers exact-match
+// queries, so results depend on how the file is ordered.
+const (
+	duplicatesKeepAll    = "keep-all"    // keep every case
+	duplicatesMean       = "mean"        // one case with the mean output
+	duplicatesMedian     = "median"      // one case with the median output
+	duplicatesMostRecent = "most-recent" // the latest timestamped case, else the last in file order
+)
+
+func validateDuplicates(strategy string) error {
+	switch strategy {
+	case "", duplicatesKeepAll, duplicatesMean, duplicatesMedian, duplicatesMostRecent:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicates strategy %q (want %s, %s, %s or %s)",
+		strategy, duplicatesKeepAll, duplicatesMean, duplicatesMedian, duplicatesMostRecent)
+}
+
+// mergeDuplicates consolidates cases whose inputs are identical into one
+// case per input, placed where the first of them was. It returns data itself
+// when there is nothing to merge.
+func mergeDuplicates(data TrainingData, strategy string) TrainingData {
+	if strategy == "" || strategy == duplicatesKeepAll {
+		return data
+	}
+	groups := map[featureVector][]int{}
+	var order []featureVector // inputs in order of first appearance
+	for i, c := range data {
+		v := caseFeatures(c)
+		if _, ok := groups[v]; !ok {
+			order = append(order, v)
+		}
+		groups[v] = append(groups[v], i)
+	}
+	if len(order) == len(data) {
+		return data
+	}
+
+	out := make(TrainingData, 0, len(order))
+	for _, v := range order {
+		idx := groups[v]
+		c := data[idx[0]]
+		switch strategy {
+		case duplicatesMean:
+			sum := 0.0
+			for _, i := range idx {
+				sum += data[i].ExpectedOutput
+			}
+			c.ExpectedOutput = sum / float64(len(idx))
+		case duplicatesMedian:
+			outputs := make([]float64, len(idx))
+			for j, i := range idx {
+				outputs[j] = data[i].ExpectedOutput
+			}
+			slices.Sort(outputs)
+			n := len(outputs)
+			c.ExpectedOutput = (outputs[(n-1)/2] + outputs[n/2]) / 2
+		case duplicatesMostRecent:
+			latest := idx[len(idx)-1]
+			for _, i := range idx {
+				if data[i].Timestamp > data[latest].Timestamp {
+					latest = i
+				}
+			}
+			c = data[latest]
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// EfficiencyPoint is the prediction for one miles-per-day ratio with the
+// trip's days and receipts held fixed.
+type EfficiencyPoint struct {
+	MilesPerDay   float64 `json:"miles_per_day"`
+	Miles         float64 `json:"miles"`
+	Reimbursement float64 `json:"reimbursement"`
+	// PerMile is the change in reimbursement per extra mile since the
+	// previous point, which jumps where an efficiency bonus starts or ends.
+	PerMile float64 `json:"per_mile"`
+}
+
+// efficiencyCurve predicts a trip of days and receipts at each miles-per-day
+// ratio.
+func efficiencyCurve(p *Predictor, days int, receipts float64, ratios []float64) []EfficiencyPoint {
+	points := make([]EfficiencyPoint, len(ratios))
+	for i, mpd := range ratios {
+		miles := mpd * float64(days)
+		points[i] = EfficiencyPoint{MilesPerDay: mpd, Miles: miles, Reimbursement: roundCents(p.Predict(days, miles, receipts))}
+		if i > 0 && miles != points[i-1].Miles {
+			points[i].PerMile = (points[i].Reimbursement - points[i-1].Reimbursement) / (miles - points[i-1].Miles)
+		}
+	}
+	return points
+}
+
+// medianInput returns the median of input j over training.
+func medianInput(training TrainingData, j int) float64 {
+	values := make([]float64, len(training))
+	for i, c := range training {
+		values[i] = caseFeatures(c)[j]
+	}
+	sort.Float64s(values)
+	return values[len(values)/2]
+}
+
+func printEfficiency(w io.Writer, days int, receipts float64, points []EfficiencyPoint) {
+	fmt.Fprintf(w, "Reimbursement by miles per day for %d-day trips with $%.2f receipts\n\n", days, receipts)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Miles/day\tMiles\tReimbursement\tPer extra mile")
+	for i, pt := range points {
+		perMile := ""
+		if i > 0 {
+			perMile = fmt.Sprintf("%.4f", pt.PerMile)
+		}
+		fmt.Fprintf(tw, "%g\t%g\t%.2f\t%s\n", pt.MilesPerDay, pt.Miles, pt.Reimbursement, perMile)
+	}
+	tw.Flush()
+}
+
+func runEfficiency(args []string) error {
+	fs := flag.NewFlagSet("efficiency", flag.ContinueOnError)
+	ratios := fs.String("mpd", "0:400:10", "miles-per-day range start:end[:step]")
+	days := fs.Int("days", 0, "trip days held fixed (0 for the training median)")
+	receipts := fs.Float64("receipts", -1, "receipts held fixed (negative for the training median)")
+	asJSON := fs.Bool("json", false, "print the curve as JSON")
+	var model modelFlags
+	model.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	grid, err := parseGridRange(*ratios)
+	if err != nil {
+		return fmt.Errorf("-mpd: %v", err)
+	}
+	if *days < 0 {
+		return fmt.Errorf("-days must not be negative")
+	}
+
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if len(p.Training) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+	if *days == 0 {
+		*days = int(medianInput(p.Training, 0))
+	}
+	if *receipts < 0 {
+		*receipts = medianInput(p.Training, 2)
+	}
+
+	points := efficiencyCurve(p, *days, *receipts, grid.Values())
+	if err := p.Err(); err != nil {
+		return err
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, points)
+	}
+	printEfficiency(os.Stdout, *days, *receipts, points)
+	return nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+)
+
+// EvalResult is the outcome of predicting a single labelled case.
+type EvalResult struct {
+	Case      TestCase
+	Predicted float64
+}
+
+// Residual returns predicted minus expected output.
+func (r EvalResult) Residual() float64 {
+	return r.Predicted - r.Case.ExpectedOutput
+}
+
+// AbsError returns the absolute prediction error.
+func (r EvalResult) AbsError() float64 {
+	return math.Abs(r.Residual())
+}
+
+// EvalSummary aggregates error metrics over a set of results.
+type EvalSummary struct {
+	Count        int      `json:"count"`
+	ExactMatches int      `json:"exact_matches"` // predicted to the cent, as printed
+	CloseMatches int      `json:"close_matches"` // within ±$1.00, as printed
+	MeanError    float64  `json:"mean_error"`
+	RMSE         float64  `json:"rmse"`
+	MaxError     float64  `json:"max_error"`
+	MaxErrorCase TestCase `json:"max_error_case"`
+	// Challenge is the evaluation as the challenge's eval.sh computes it.
+	Challenge ChallengeScore `json:"challenge"`
+}
+
+// Score is the challenge score, average error * 100 plus 0.1 per non-exact
+// case, exactly as eval.sh computes it. Lower is better.
+func (s EvalSummary) Score() float64 {
+	return s.Challenge.Score
+}
+
+func summarize(results []EvalResult) EvalSummary {
+	var s EvalSummary
+	s.Count = len(results)
+	if s.Count == 0 {
+		return s
+	}
+	s.Challenge = challengeScore(results)
+
+	totalError := 0.0
+	totalSquared := 0.0
+	for _, r := range results {
+		e := r.AbsError()
+		if centsOf(r.Predicted) == centsOf(r.Case.ExpectedOutput) {
+			s.ExactMatches++
+		}
+		if d := centsOf(r.Predicted) - centsOf(r.Case.ExpectedOutput); d > -100 && d < 100 {
+			s.CloseMatches++
+		}
+		totalError += e
+		totalSquared += e * e
+		if e > s.MaxError {
+			s.MaxError = e
+			s.MaxErrorCase = r.Case
+		}
+	}
+
+	s.MeanError = totalError / float64(s.Count)
+	s.RMSE = math.Sqrt(totalSquared / float64(s.Count))
+	return s
+}
+
+// evaluate predicts every case with p. With leaveOneOut set, each case is
+// predicted by p trained without the case at the same index, so cases must be
+// p's training data itself.
+func evaluate(cases TrainingData, p *Predictor, leaveOneOut bool) []EvalResult {
+	if leaveOneOut {
+		return crossValidate(p, leaveOneOutFolds(len(p.Training)), 0, nil)
+	}
+	results, _ := evaluateContext(context.Background(), cases, p)
+	return results
+}
+
+// evaluateContext predicts every case with p until ctx is done, returning
+// ctx's error with the results found so far.
+func evaluateContext(ctx context.Context, cases TrainingData, p *Predictor) ([]EvalResult, error) {
+	results := make([]EvalResult, 0, len(cases))
+
+	for _, c := range cases {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		predicted := p.PredictQuery(c.Input)
+		results = append(results, EvalResult{Case: c, Predicted: predicted})
+	}
+
+	return results, nil
+}
+
+// Segment groups results that share a value of some input band.
+type Segment struct {
+	Name    string
+	Summary EvalSummary
+}
+
+// segmenter assigns a case to a named band. Bands sort by their order index.
+type segmenter struct {
+	Title string
+	Band  func(c TestCase) (order int, name string)
+}
+
+var durationBands = segmenter{"Trip duration", func(c TestCase) (int, string) {
+	d := c.Input.TripDurationDays
+	switch {
+	case d <= 3:
+		return 0, "1-3 days"
+	case d <= 7:
+		return 1, "4-7 days"
+	case d <= 10:
+		return 2, "8-10 days"
+	default:
+		return 3, "11+ days"
+	}
+}}
+
+var milesBands = segmenter{"Miles traveled", func(c TestCase) (int, string) {
+	m := c.Input.MilesTraveled
+	switch {
+	case m < 100:
+		return 0, "0-99 miles"
+	case m < 500:
+		return 1, "100-499 miles"
+	case m < 1000:
+		return 2, "500-999 miles"
+	default:
+		return 3, "1000+ miles"
+	}
+}}
+
+var receiptBands = segmenter{"Receipts", func(c TestCase) (int, string) {
+	r := c.Input.TotalReceiptsAmount
+	switch {
+	case r < 500:
+		return 0, "$0-499"
+	case r < 1000:
+		return 1, "$500-999"
+	case r < 1500:
+		return 2, "$1000-1499"
+	default:
+		return 3, "$1500+"
+	}
+}}
+
+// segmenters are the input bands eval and its kin break results down by.
+var segmenters = []segmenter{durationBands, milesBands, receiptBands}
+
+// segment splits results into the bands defined by s, in band order.
+func (s segmenter) segment(results []EvalResult) []Segment {
+	type group struct {
+		order   int
+		name    string
+		results []EvalResult
+	}
+	groups := map[string]*group{}
+	for _, r := range results {
+		order, name := s.Band(r.Case)
+		g, ok := groups[name]
+		if !ok {
+			g = &group{order: order, name: name}
+			groups[name] = g
+		}
+		g.results = append(g.results, r)
+	}
+
+	ordered := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].order < ordered[j].order
+	})
+
+	segments := make([]Segment, len(ordered))
+	for i, g := range ordered {
+		segments[i] = Segment{Name: g.name, Summary: summarize(g.results)}
+	}
+	return segments
+}
+
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	casesPath := fs.String("cases", "", "labelled cases to evaluate (default the training data)")
+	loo := fs.Bool("loo", false, "leave-one-out: evaluate each training case against the rest")
+	report := fs.String("report", "", "write a standalone HTML report to this path")
+	folds := fs.Int("folds", 0, "k-fold cross-validation: evaluate each fold of the training data against the rest")
+	seed := fs.Uint64("seed", 1, "random seed for assigning -folds")
+	compareExact := fs.Bool("compare-exact", false,
+		"compare the approximate -index against exact search for accuracy and speed")
+	compareBins := fs.Bool("compare-bins", false,
+		"also evaluate matching on the raw inputs, for comparison with -bins")
+	jobs := fs.Int("jobs", 0, "cross-validation workers (0 uses every CPU)")
+	checkpointPath := fs.String("checkpoint", "", "with -loo or -folds, record each fold as it finishes in this file")
+	resume := fs.Bool("resume", false, "skip the folds -checkpoint records as finished by an interrupted run")
+	showProgress := fs.Bool("progress", false, "report cross-validation progress on stderr")
+	timeout := fs.Duration("timeout", 0, "give up evaluating after this long (0 for no limit); interrupting also stops it")
+	var costs costFlag
+	costs.register(fs)
+	scorersPath := fs.String("scorers", "",
+		"JSON list of custom scorers, expressions over each case or exec programs, to report alongside the metrics")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *resume && *checkpointPath == "" {
+		return fmt.Errorf("-resume requires -checkpoint")
+	}
+	if *checkpointPath != "" && !*loo && *folds == 0 {
+		return fmt.Errorf("-checkpoint requires -loo or -folds")
+	}
+	costFn, err := costs.function()
+	if err != nil {
+		return err
+	}
+	var scorers []scorer
+	if *scorersPath != "" {
+		if scorers, err = loadScorers(*scorersPath); err != nil {
+			return err
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+
+	cases := predictor.Training
+	if *loo || *folds > 0 {
+		if *casesPath != "" {
+			return fmt.Errorf("-loo and -folds evaluate the training data; -cases must not be set")
+		}
+		if *loo && *folds > 0 {
+			return fmt.Errorf("-loo and -folds are mutually exclusive")
+		}
+		if *folds == 1 || *folds > len(cases) {
+			return fmt.Errorf("-folds must be between 2 and the number of cases")
+		}
+	} else if *casesPath != "" {
+		cases, err = loadTrainingData(*casesPath)
+		if err != nil {
+			return fmt.Errorf("loading cases: %v", err)
+		}
+	}
+
+	if *compareExact && !predictor.Index.approximate() {
+		return fmt.Errorf("-compare-exact requires an approximate -index")
+	}
+	if *compareBins && predictor.Bins == nil {
+		return fmt.Errorf("-compare-bins requires -bins")
+	}
+
+	// run evaluates p on the cases, or cross-validates it on its training
+	// data; the folds depend only on the number of cases, so binned and raw
+	// runs hold out the same cases. Only the main run is checkpointed.
+	run := func(p *Predictor, cases TrainingData, showProgress, checkpointed bool) ([]EvalResult, error) {
+		var results []EvalResult
+		var err error
+		if *loo || *folds > 0 {
+			assigned := leaveOneOutFolds(len(cases))
+			if *folds > 0 {
+				assigned = randomFolds(len(cases), *folds, *seed)
+			}
+			var done map[int]EvalCheckpointFold
+			var finished func(EvalCheckpointFold)
+			if checkpointed && *checkpointPath != "" {
+				header := EvalCheckpointHeader{DataSHA256: p.DataSHA256, Cases: len(cases), Folds: *folds, Seed: *seed,
+					Model: tuneKey(p.Hyperparameters())}
+				if *folds == 0 {
+					header.Seed = 0
+				}
+				cp, resumed, err := openEvalCheckpoint(*checkpointPath, header, *resume)
+				if err != nil {
+					return nil, err
+				}
+				defer cp.Close()
+				done = resumed
+				if *resume {
+					fmt.Fprintf(os.Stderr, "resuming: %d folds finished\n", len(done))
+				}
+				finished = func(f EvalCheckpointFold) {
+					if err := cp.record(f); err != nil {
+						fmt.Fprintf(os.Stderr, "warning: checkpoint: %v\n", err)
+					}
+				}
+			}
+			var prog *progress
+			if showProgress {
+				prog = startProgress(os.Stderr, "cross-validation", len(cases), time.Second)
+			}
+			results, err = crossValidateResume(ctx, p, assigned, *jobs, prog, done, finished)
+			prog.stop()
+			if err != nil && finished != nil {
+				return nil, fmt.Errorf("evaluation stopped: %v; rerun with -resume to continue from %s", err, *checkpointPath)
+			}
+		} else {
+			results, err = evaluateContext(ctx, cases, p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("evaluation stopped: %v", err)
+		}
+		return results, p.Err()
+	}
+	results, err := run(predictor, cases, *showProgress, true)
+	if err != nil {
+		return err
+	}
+	summary := summarize(results)
+	printSummary(os.Stdout, summary)
+	if costFn != nil {
+		fmt.Fprintf(os.Stdout, "Business cost: $%.2f per case (%s)\n", costFn.meanCost(results), costFn)
+	}
+	scores, err := runScorers(scorers, results)
+	if err != nil {
+		return err
+	}
+	for _, s := range scores {
+		fmt.Fprintf(os.Stdout, "Score %s: %.4f\n", s.Name, s.Score)
+	}
+	if *compareExact {
+		printIndexComparison(os.Stdout, predictor.Index.Kind, compareIndex(predictor, cases))
+	}
+	if *compareBins {
+		rawFlags := model
+		rawFlags.bins = ""
+		raw, err := rawFlags.build()
+		if err != nil {
+			return err
+		}
+		rawCases := cases
+		if *casesPath == "" {
+			rawCases = raw.Training
+		}
+		rawResults, err := run(raw, rawCases, false, false)
+		if err != nil {
+			return err
+		}
+		printBinComparison(os.Stdout, predictor.Bins, summary, summarize(rawResults))
+	}
+
+	if *report != "" {
+		file, err := os.Create(*report)
+		if err != nil {
+			return err
+		}
+		err = writeHTMLReport(file, results, summary)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("writing report: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func printSummary(w io.Writer, s EvalSummary) {
+	if s.Count == 0 {
+		fmt.Fprintln(w, "No cases evaluated")
+		return
+	}
+	fmt.Fprintf(w, "Total cases: %d\n", s.Count)
+	fmt.Fprintf(w, "Exact matches (to the cent): %d (%.1f%%)\n", s.ExactMatches, pct(s.ExactMatches, s.Count))
+	fmt.Fprintf(w, "Close matches (±$1.00): %d (%.1f%%)\n", s.CloseMatches, pct(s.CloseMatches, s.Count))
+	if failed := s.Count - s.Challenge.Successful; failed > 0 {
+		fmt.Fprintf(w, "Failed predictions: %d\n", failed)
+	}
+	// The average is truncated to the cent as eval.sh truncates it.
+	fmt.Fprintf(w, "Average error: $%.2f\n", s.Challenge.AverageError)
+	fmt.Fprintf(w, "RMSE: $%.2f\n", s.RMSE)
+	fmt.Fprintf(w, "Maximum error: $%.2f (%d days, %g miles, $%.2f receipts)\n", s.MaxError,
+		s.MaxErrorCase.Input.TripDurationDays, s.MaxErrorCase.Input.MilesTraveled, s.MaxErrorCase.Input.TotalReceiptsAmount)
+	fmt.Fprintf(w, "Score: %.2f (lower is better)\n", s.Score())
+}
+
+func pct(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
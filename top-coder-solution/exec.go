@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// execPrefix selects a model implemented by an external program, as in
+// -model exec:./my_model.
+const execPrefix = "exec:"
+
+// execModel is a model implemented by an external program. The program reads
+// requests on stdin and writes one response per request on stdout, each a
+// single line of JSON:
+//
+//	{"op":"fit","cases":[...]}    -> {}
+//	{"op":"predict","input":{…}}  -> {"prediction":1234.56}
+//	{"op":"explain","input":{…}}  -> {"prediction":1234.56,"steps":["…"]}
+//
+// Cases and inputs have the JSON form of the case files. Any response may be
+// {"error":"message"} instead; a program that does not explain answers
+// explain requests that way. Fit starts the program, which then runs until
+// the model is closed or garbage collected. Requests are sent one at a time.
+type execModel struct {
+	command string
+
+	mu   sync.Mutex
+	proc *execProcess
+	err  error // the first failure; predictions after it are NaN
+}
+
+type execRequest struct {
+	Op    string       `json:"op"`
+	Cases TrainingData `json:"cases,omitempty"`
+	Input *Query       `json:"input,omitempty"`
+}
+
+type execResponse struct {
+	Prediction float64  `json:"prediction"`
+	Steps      []string `json:"steps,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// execProcess is a running model program.
+type execProcess struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	enc      *json.Encoder
+	dec      *json.Decoder
+	stopOnce sync.Once
+}
+
+func startExecProcess(command string) (*execProcess, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no program given")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execProcess{cmd: cmd, stdin: stdin, enc: json.NewEncoder(stdin), dec: json.NewDecoder(stdout)}, nil
+}
+
+// stop closes the program's stdin and waits for it to exit.
+func (p *execProcess) stop() {
+	p.stopOnce.Do(func() {
+		p.stdin.Close()
+		p.cmd.Wait()
+	})
+}
+
+// call sends req and returns the response. m.mu must be held.
+func (m *execModel) call(req execRequest) (execResponse, error) {
+	var resp execResponse
+	if m.proc == nil {
+		return resp, fmt.Errorf("model is not fitted")
+	}
+	if err := m.proc.enc.Encode(req); err != nil {
+		return resp, fmt.Errorf("sending %s request: %v", req.Op, err)
+	}
+	if err := m.proc.dec.Decode(&resp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return resp, fmt.Errorf("reading %s response: %v", req.Op, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s: %s", req.Op, resp.Error)
+	}
+	return resp, nil
+}
+
+// fail records err as the model's failure unless one is recorded already.
+// m.mu must be held.
+func (m *execModel) fail(err error) {
+	if m.err == nil {
+		m.err = fmt.Errorf("model %s%s: %v", execPrefix, m.command, err)
+	}
+}
+
+// Fit starts a fresh program and sends it training.
+func (m *execModel) Fit(training TrainingData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.proc != nil {
+		m.proc.stop()
+	}
+	proc, err := startExecProcess(m.command)
+	if err != nil {
+		m.proc = nil
+		m.fail(err)
+		return
+	}
+	m.proc = proc
+	runtime.AddCleanup(m, (*execProcess).stop, proc)
+	if _, err := m.call(execRequest{Op: "fit", Cases: training}); err != nil {
+		m.fail(err)
+	}
+}
+
+func (m *execModel) Predict(q Query) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return math.NaN()
+	}
+	resp, err := m.call(execRequest{Op: "predict", Input: &q})
+	if err != nil {
+		m.fail(err)
+		return math.NaN()
+	}
+	return resp.Prediction
+}
+
+func (m *execModel) Explain(q Query) ModelExplanation {
+	e := ModelExplanation{Model: execPrefix + m.command}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		e.Prediction, e.Steps = math.NaN(), []string{m.err.Error()}
+		return e
+	}
+	resp, err := m.call(execRequest{Op: "explain", Input: &q})
+	if err == nil {
+		e.Prediction, e.Steps = resp.Prediction, resp.Steps
+		return e
+	}
+	// Explaining is optional; fall back to the bare prediction.
+	e.Steps = []string{fmt.Sprintf("no explanation (%v)", err)}
+	if resp, err = m.call(execRequest{Op: "predict", Input: &q}); err != nil {
+		m.fail(err)
+		e.Prediction = math.NaN()
+		return e
+	}
+	e.Prediction = resp.Prediction
+	return e
+}
+
+// Err returns the first failure of the program, if any.
+func (m *execModel) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close stops the program.
+func (m *execModel) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.proc != nil {
+		m.proc.stop()
+		m.proc = nil
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// expr is a compiled arithmetic expression over named variables, used to
+// define derived features in config. It has numbers, variables, the
+// operators + - * / % ^, comparisons == != < <= > >= (1 for true, 0 for
+// false), && || !, cond ? a : b, parentheses and the functions abs, sqrt,
+// log, exp, floor, ceil, round, min and max. Division by zero gives 0, so
+// ratios stay finite when an input is zero.
+type expr struct {
+	src  string
+	root *exprNode
+}
+
+type exprOp int
+
+const (
+	opConst exprOp = iota
+	opVar
+	opNeg
+	opNot
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opMod
+	opPow
+	opEq
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opAnd
+	opOr
+	opCond
+	opAbs
+	opSqrt
+	opLog
+	opExp
+	opFloor
+	opCeil
+	opRound
+	opMin
+	opMax
+)
+
+// exprFuncs maps function names to their op and number of arguments.
+var exprFuncs = map[string]struct {
+	op    exprOp
+	arity int
+}{
+	"abs": {opAbs, 1}, "sqrt": {opSqrt, 1}, "log": {opLog, 1}, "exp": {opExp, 1},
+	"floor": {opFloor, 1}, "ceil": {opCeil, 1}, "round": {opRound, 1},
+	"min": {opMin, 2}, "max": {opMax, 2},
+}
+
+var exprBinary = map[string]exprOp{
+	"+": opAdd, "-": opSub, "*": opMul, "/": opDiv, "%": opMod, "^": opPow,
+	"==": opEq, "!=": opNe, "<": opLt, "<=": opLe, ">": opGt, ">=": opGe,
+	"&&": opAnd, "||": opOr,
+}
+
+type exprNode struct {
+	op    exprOp
+	value float64 // opConst
+	index int     // opVar: index into the variables
+	args  []*exprNode
+}
+
+// parseExpr compiles src, whose variables are vars; eval takes their values
+// in the same order.
+func parseExpr(src string, vars []string) (*expr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %v", src, err)
+	}
+	p := &exprParser{tokens: tokens, vars: vars}
+	root, err := p.parseCond()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %v", src, err)
+	}
+	return &expr{src: src, root: root}, nil
+}
+
+func (e *expr) String() string {
+	return e.src
+}
+
+// eval returns the value of the expression for the variable values vars.
+func (e *expr) eval(vars []float64) float64 {
+	return e.root.eval(vars)
+}
+
+func tokenizeExpr(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' ||
+				src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			if i+1 < len(src) {
+				if two := src[i : i+2]; two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "&&" || two == "||" {
+					tokens = append(tokens, two)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%^()<>!?:,", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// exprParser is a recursive-descent parser. From loosest to tightest:
+// ?:, ||, &&, comparisons, + -, * / %, unary - and !, ^.
+type exprParser struct {
+	tokens []string
+	pos    int
+	vars   []string
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		if p.pos >= len(p.tokens) {
+			return fmt.Errorf("expected %q at end", tok)
+		}
+		return fmt.Errorf("expected %q, got %q", tok, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) parseCond() (*exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.pos++
+	then, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{op: opCond, args: []*exprNode{cond, then, otherwise}}, nil
+}
+
+// binaryLevels are the binary operators by precedence, loosest first.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseBinary(level int) (*exprNode, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for slices.Contains(binaryLevels[level], p.peek()) {
+		op := exprBinary[p.tokens[p.pos]]
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{op: op, args: []*exprNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	switch p.peek() {
+	case "-", "!":
+		op := opNeg
+		if p.tokens[p.pos] == "!" {
+			op = opNot
+		}
+		p.pos++
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{op: op, args: []*exprNode{arg}}, nil
+	}
+	base, err := p.parsePrimary()
+	if err != nil || p.peek() != "^" {
+		return base, err
+	}
+	p.pos++
+	exp, err := p.parseUnary() // right-associative
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{op: opPow, args: []*exprNode{base, exp}}, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end")
+	case tok == "(":
+		p.pos++
+		inner, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case tok[0] >= '0' && tok[0] <= '9' || tok[0] == '.':
+		v, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok)
+		}
+		p.pos++
+		return &exprNode{op: opConst, value: v}, nil
+	case tok[0] == '_' || tok[0] >= 'a' && tok[0] <= 'z' || tok[0] >= 'A' && tok[0] <= 'Z':
+		p.pos++
+		if p.peek() == "(" {
+			return p.parseCall(tok)
+		}
+		for i, name := range p.vars {
+			if name == tok {
+				return &exprNode{op: opVar, index: i}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown variable %q (want one of %s)", tok, strings.Join(p.vars, ", "))
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
+
+func (p *exprParser) parseCall(name string) (*exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++ // (
+	node := &exprNode{op: fn.op}
+	for p.peek() != ")" {
+		if len(node.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, arg)
+	}
+	p.pos++ // )
+	if len(node.args) != fn.arity {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, fn.arity, len(node.args))
+	}
+	return node, nil
+}
+
+func exprBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n *exprNode) eval(vars []float64) float64 {
+	switch n.op {
+	case opConst:
+		return n.value
+	case opVar:
+		return vars[n.index]
+	case opNeg:
+		return -n.args[0].eval(vars)
+	case opNot:
+		return exprBool(n.args[0].eval(vars) == 0)
+	case opAnd:
+		return exprBool(n.args[0].eval(vars) != 0 && n.args[1].eval(vars) != 0)
+	case opOr:
+		return exprBool(n.args[0].eval(vars) != 0 || n.args[1].eval(vars) != 0)
+	case opCond:
+		if n.args[0].eval(vars) != 0 {
+			return n.args[1].eval(vars)
+		}
+		return n.args[2].eval(vars)
+	}
+
+	a := n.args[0].eval(vars)
+	switch n.op {
+	case opAbs:
+		return math.Abs(a)
+	case opSqrt:
+		return math.Sqrt(a)
+	case opLog:
+		return math.Log(a)
+	case opExp:
+		return math.Exp(a)
+	case opFloor:
+		return math.Floor(a)
+	case opCeil:
+		return math.Ceil(a)
+	case opRound:
+		return math.Round(a)
+	}
+
+	b := n.args[1].eval(vars)
+	switch n.op {
+	case opAdd:
+		return a + b
+	case opSub:
+		return a - b
+	case opMul:
+		return a * b
+	case opDiv:
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	case opMod:
+		if b == 0 {
+			return 0
+		}
+		return math.Mod(a, b)
+	case opPow:
+		return math.Pow(a, b)
+	case opEq:
+		return exprBool(a == b)
+	case opNe:
+		return exprBool(a != b)
+	case opLt:
+		return exprBool(a < b)
+	case opLe:
+		return exprBool(a <= b)
+	case opGt:
+		return exprBool(a > b)
+	case opGe:
+		return exprBool(a >= b)
+	case opMin:
+		return math.Min(a, b)
+	case opMax:
+		return math.Max(a, b)
+	}
+	panic(fmt.Sprintf("exprNode.eval: unknown op %d", n.op))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	vars := []string{"days", "miles", "receipts"}
+	values := []float64{5, 200, 1000}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"42", 42},
+		{".5", 0.5},
+		{"days", 5},
+		{"miles / days", 40},
+		{"receipts / (days * 0)", 0}, // division by zero gives 0
+		{"miles % 0", 0},
+		{"miles % 7", 4},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},  // left-associative
+		{"2 ^ 3 ^ 2", 512}, // right-associative
+		{"-2 ^ 2", -4},     // ^ binds tighter than unary minus
+		{"-days + 1", -4},
+		{"--days", 5},
+		{"days == 5", 1},
+		{"days != 5", 0},
+		{"days < 5", 0},
+		{"days <= 5", 1},
+		{"miles > 100 && receipts > 2000", 0},
+		{"miles > 100 || receipts > 2000", 1},
+		{"!(days == 5)", 0},
+		{"!0", 1},
+		{"1 + 1 == 2", 1}, // comparisons are looser than +
+		{"days > 3 ? 1 : 2", 1},
+		{"days > 9 ? 1 : days > 4 ? 2 : 3", 2},
+		{"abs(-3)", 3},
+		{"sqrt(16)", 4},
+		{"log(1)", 0},
+		{"exp(0)", 1},
+		{"floor(2.7)", 2},
+		{"ceil(2.1)", 3},
+		{"round(2.5)", 3},
+		{"min(days, 3)", 3},
+		{"max(days, miles / 10)", 20},
+		{"max(min(receipts, 800), 100) / days", 160},
+		{"  days\t*\n2 ", 10},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.src, vars)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if got := e.eval(values); got != tt.want {
+			t.Errorf("%q = %g, want %g", tt.src, got, tt.want)
+		}
+		if e.String() != tt.src {
+			t.Errorf("String() = %q, want %q", e.String(), tt.src)
+		}
+	}
+}
+
+func TestExprEvalNonFinite(t *testing.T) {
+	for _, src := range []string{"sqrt(-1)", "log(0)", "log(-1)"} {
+		e, err := parseExpr(src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.eval(nil); !math.IsNaN(got) && !math.IsInf(got, 0) {
+			t.Errorf("%q = %g, want a non-finite value", src, got)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	vars := []string{"days", "miles", "receipts"}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"days +", "unexpected end"},
+		{"days $ 2", "unexpected character '$' at offset 5"},
+		{"nights * 2", `unknown variable "nights" (want one of days, miles, receipts)`},
+		{"cube(days)", `unknown function "cube"`},
+		{"min(days)", "min takes 2 arguments, got 1"},
+		{"abs(days, miles)", "abs takes 1 arguments, got 2"},
+		{"(days + 1", `expected ")" at end`},
+		{"days days", `unexpected "days"`},
+		{"days ? 1", `expected ":" at end`},
+		{"1..2", `bad number "1..2"`},
+		{"max(days miles)", `expected ",", got "miles"`},
+		{")", `unexpected ")"`},
+	}
+	for _, tt := range tests {
+		_, err := parseExpr(tt.src, vars)
+		if err == nil {
+			t.Errorf("parseExpr(%q) succeeded, want error %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "expression ") {
+			t.Errorf("parseExpr(%q) error %q, want one containing %q", tt.src, err, tt.want)
+		}
+	}
+}
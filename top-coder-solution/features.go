@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxFeatures bounds the number of features a feature set may hold.
+const maxFeatures = 8
+
+// featureDef is a named feature derived from a case's inputs. Distances
+// divide the feature by Scale, its typical range, so that features measured
+// in days and in dollars weigh comparably.
+type featureDef struct {
+	Name  string
+	Scale float64
+	value func(in featureVector) float64
+}
+
+// builtinFeatures are the features a feature set may name. Features of
+// other inputs are read from named input fields by config (see
+// FeatureConfig); the distance code works with any of them.
+var builtinFeatures = []featureDef{
+	{"days", dayScale, func(in featureVector) float64 { return in[0] }},
+	{"miles", mileScale, func(in featureVector) float64 { return in[1] }},
+	{"receipts", receiptScale, func(in featureVector) float64 { return in[2] }},
+	{"miles_per_day", 400, func(in featureVector) float64 { return in[1] / math.Max(in[0], 1) }},
+	{"receipts_per_day", 500, func(in featureVector) float64 { return in[2] / math.Max(in[0], 1) }},
+}
+
+// FeatureConfig selects a built-in feature by name and optionally overrides
+// its scale. With Input set, the feature is instead the named input field
+// Name of the cases and queries (see Query.Fields), as
+// {"name": "destination_tier", "input": true}; a case without the field
+// reads 0. With Expr set, it is a feature of that name computed from days,
+// miles, receipts and the set's input features, as "receipts / days". Input
+// and derived features without a scale are scaled by their standard
+// deviation over the training data.
+type FeatureConfig struct {
+	Name  string  `json:"name"`
+	Scale float64 `json:"scale,omitempty"`
+	Input bool    `json:"input,omitempty"`
+	Expr  string  `json:"expr,omitempty"`
+}
+
+// maxInputFields bounds the named input fields feature configs may read in
+// one process, each of which takes a slot of featureVector.
+const maxInputFields = 5
+
+// inputFields are the named input fields feature configs read, in the order
+// of their featureVector slots after days, miles and receipts. Fields are
+// added as configs name them and never removed, so a slot means the same
+// field for the life of the process.
+var inputFields struct {
+	mu    sync.Mutex
+	names atomic.Pointer[[]string]
+}
+
+// inputFieldNames returns the named input fields with slots, in slot order.
+func inputFieldNames() []string {
+	if names := inputFields.names.Load(); names != nil {
+		return *names
+	}
+	return nil
+}
+
+// inputFieldSlot returns the featureVector slot of the named input field,
+// giving it the next free one if it has none.
+func inputFieldSlot(name string) (int, error) {
+	inputFields.mu.Lock()
+	defer inputFields.mu.Unlock()
+	names := inputFieldNames()
+	if i := slices.Index(names, name); i >= 0 {
+		return len(featureNames) + i, nil
+	}
+	if len(names) == maxInputFields {
+		return 0, fmt.Errorf("input feature %q: at most %d named input fields are supported (have %s)",
+			name, maxInputFields, strings.Join(names, ", "))
+	}
+	names = append(slices.Clip(names), name)
+	inputFields.names.Store(&names)
+	return len(featureNames) + len(names) - 1, nil
+}
+
+// featureSet is the ordered list of features a predictor measures distance
+// over. A nil set is the default (days, miles, receipts) at their built-in
+// scales, which metrics and indexes compute directly on the inputs.
+type featureSet []featureDef
+
+// newFeatureSet resolves configs against builtinFeatures. It returns nil,
+// the default set, when configs is empty or names exactly the default
+// features at their built-in scales.
+func newFeatureSet(configs []FeatureConfig) (featureSet, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	if len(configs) > maxFeatures {
+		return nil, fmt.Errorf("at most %d features are supported, got %d", maxFeatures, len(configs))
+	}
+	fs := make(featureSet, len(configs))
+	// Expressions may read days, miles, receipts and the set's input
+	// features, as the vector slots in vars.
+	vars, slots := featureNames[:], []int{0, 1, 2}
+	for _, c := range configs {
+		if c.Input {
+			slot, err := inputFieldSlot(c.Name)
+			if err != nil {
+				return nil, err
+			}
+			vars, slots = append(slices.Clip(vars), c.Name), append(slots, slot)
+		}
+	}
+	seen := map[string]bool{}
+	for i, c := range configs {
+		def, ok := lookupFeature(c.Name)
+		switch {
+		case c.Input && c.Expr != "":
+			return nil, fmt.Errorf("feature %q is either an input field or derived by \"expr\", not both", c.Name)
+		case (c.Input || c.Expr != "") && ok:
+			return nil, fmt.Errorf("feature %q is built in and cannot be redefined", c.Name)
+		case c.Input:
+			if !validInputField(c.Name) {
+				return nil, fmt.Errorf("input feature %q must be named by a field other than %s",
+					c.Name, strings.Join(queryJSONNames, ", "))
+			}
+			slot := slots[slices.Index(vars, c.Name)]
+			def = featureDef{Name: c.Name, value: func(in featureVector) float64 { return in[slot] }}
+		case c.Expr != "":
+			if c.Name == "" {
+				return nil, fmt.Errorf("derived feature %q has no name", c.Expr)
+			}
+			e, err := parseExpr(c.Expr, vars)
+			if err != nil {
+				return nil, fmt.Errorf("feature %q: %v", c.Name, err)
+			}
+			def = featureDef{Name: c.Name, value: func(in featureVector) float64 {
+				var values featureVector
+				for j, slot := range slots {
+					values[j] = in[slot]
+				}
+				return e.eval(values[:len(slots)])
+			}}
+		case !ok:
+			return nil, fmt.Errorf("unknown feature %q (want one of %s, an input field with \"input\", or a derived feature with \"expr\")",
+				c.Name, strings.Join(builtinFeatureNames(), ", "))
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("feature %q is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Scale < 0 || math.IsNaN(c.Scale) || math.IsInf(c.Scale, 0) {
+			return nil, fmt.Errorf("feature %q: scale must be a positive number", c.Name)
+		}
+		if c.Scale > 0 {
+			def.Scale = c.Scale
+		}
+		fs[i] = def
+	}
+	if fs.isDefault() {
+		return nil, nil
+	}
+	return fs, nil
+}
+
+func lookupFeature(name string) (featureDef, bool) {
+	for _, f := range builtinFeatures {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return featureDef{}, false
+}
+
+func builtinFeatureNames() []string {
+	names := make([]string, len(builtinFeatures))
+	for i, f := range builtinFeatures {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// isDefault reports whether fs measures the same distances as the default
+// set.
+func (fs featureSet) isDefault() bool {
+	if len(fs) != len(featureNames) {
+		return false
+	}
+	for i, f := range fs {
+		if f.Name != builtinFeatures[i].Name || f.Scale != builtinFeatures[i].Scale {
+			return false
+		}
+	}
+	return true
+}
+
+// validInputField reports whether name may name an input field: it must
+// not be empty or one of the inputs every query has.
+func validInputField(name string) bool {
+	return name != "" && !slices.ContainsFunc(queryJSONNames, func(s string) bool { return strings.EqualFold(s, name) })
+}
+
+// withScales returns fs with each input or derived feature lacking a scale
+// scaled by its standard deviation over training, or 1 when it does not
+// vary.
+func (fs featureSet) withScales(training TrainingData) featureSet {
+	var out featureSet
+	for i, f := range fs {
+		if f.Scale > 0 {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(fs)
+		}
+		sum, sumSq := 0.0, 0.0
+		for _, c := range training {
+			v := f.value(caseFeatures(c))
+			sum += v
+			sumSq += v * v
+		}
+		out[i].Scale = 1
+		if n := float64(len(training)); n > 0 {
+			mean := sum / n
+			if sd := math.Sqrt(math.Max(sumSq/n-mean*mean, 0)); sd > 0 {
+				out[i].Scale = sd
+			}
+		}
+	}
+	if out == nil {
+		return fs
+	}
+	return out
+}
+
+// featurePoint is a case's features in the order of a feature set, scaled
+// by their Scale. Entries past the set's length are zero.
+type featurePoint [maxFeatures]float64
+
+// project returns the scaled features of the inputs in.
+func (fs featureSet) project(in featureVector) featurePoint {
+	var p featurePoint
+	for i, f := range fs {
+		p[i] = f.value(in) / f.Scale
+	}
+	return p
+}
+
+// loadFeatureConfig reads a JSON array of feature configs from path.
+func loadFeatureConfig(path string) ([]FeatureConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []FeatureConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing feature config %s: %v", path, err)
+	}
+	if _, err := newFeatureSet(configs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return configs, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Query
+		wantErr string
+	}{
+		{
+			"inputs only",
+			`{"trip_duration_days":3,"miles_traveled":93,"total_receipts_amount":1.42}`,
+			Query{TripDurationDays: 3, MilesTraveled: 93, TotalReceiptsAmount: 1.42},
+			"",
+		},
+		{
+			"named input fields",
+			`{"trip_duration_days":3,"miles_traveled":93,"total_receipts_amount":1.42,"destination_tier":2,"department":7}`,
+			Query{TripDurationDays: 3, MilesTraveled: 93, TotalReceiptsAmount: 1.42,
+				Fields: map[string]float64{"destination_tier": 2, "department": 7}},
+			"",
+		},
+		{
+			"inputs match ignoring case",
+			`{"Trip_Duration_Days":3,"MILES_TRAVELED":93}`,
+			Query{TripDurationDays: 3, MilesTraveled: 93},
+			"",
+		},
+		{"field not a number", `{"trip_duration_days":3,"department":"sales"}`, Query{}, `input field "department" must be a number`},
+		{"not an object", `[3]`, Query{}, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Query
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			b, err := json.Marshal(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again Query
+			if err := json.Unmarshal(b, &again); err != nil || !reflect.DeepEqual(again, got) {
+				t.Errorf("%s round-trips to %+v, %v", b, again, err)
+			}
+		})
+	}
+}
+
+func TestPredictRequestJSON(t *testing.T) {
+	var req PredictRequest
+	if err := json.Unmarshal([]byte(`{"trip_duration_days":3,"policy":"v2","destination_tier":2}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	want := PredictRequest{Query: Query{TripDurationDays: 3, Fields: map[string]float64{"destination_tier": 2}}, Policy: "v2"}
+	if !reflect.DeepEqual(req, want) {
+		t.Fatalf("got %+v, want %+v", req, want)
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"trip_duration_days":3,"miles_traveled":0,"total_receipts_amount":0,"destination_tier":2,"policy":"v2"}`; got != want {
+		t.Errorf("marshals to %s, want %s", got, want)
+	}
+}
+
+func TestNewFeatureSetInputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []FeatureConfig
+		wantErr string
+	}{
+		{"input feature", []FeatureConfig{{Name: "days"}, {Name: "test_tier", Input: true}}, ""},
+		{
+			"expression over an input feature",
+			[]FeatureConfig{{Name: "days"}, {Name: "test_tier", Input: true}, {Name: "tier_days", Expr: "test_tier * days"}},
+			"",
+		},
+		{"expression over an unlisted field", []FeatureConfig{{Name: "tier_days", Expr: "test_other * days"}}, "test_other"},
+		{"input and expr", []FeatureConfig{{Name: "test_tier", Input: true, Expr: "days"}}, "not both"},
+		{"builtin as input", []FeatureConfig{{Name: "miles", Input: true}}, "cannot be redefined"},
+		{"query input as input", []FeatureConfig{{Name: "Miles_Traveled", Input: true}}, "must be named by a field other than"},
+		{"unnamed input", []FeatureConfig{{Input: true}}, "must be named by a field other than"},
+		{"listed twice", []FeatureConfig{{Name: "test_tier", Input: true}, {Name: "test_tier", Input: true}}, "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newFeatureSet(tt.configs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPredictInputField checks that a named input field takes part in
+// distances and exact matches.
+func TestPredictInputField(t *testing.T) {
+	trip := Query{TripDurationDays: 3, MilesTraveled: 100, TotalReceiptsAmount: 200}
+	withTier := func(tier float64) Query {
+		q := trip
+		q.Fields = map[string]float64{"test_destination_tier": tier}
+		return q
+	}
+	data := TrainingData{
+		{Input: withTier(1), ExpectedOutput: 300},
+		{Input: withTier(3), ExpectedOutput: 900},
+	}
+	hp := Hyperparameters{K: 1, Features: []FeatureConfig{
+		{Name: "days"}, {Name: "miles"}, {Name: "receipts"}, {Name: "test_destination_tier", Input: true, Scale: 1},
+	}}
+	p := NewPredictor(data, hp)
+	defer p.Close()
+
+	tests := []struct {
+		name string
+		q    Query
+		want float64
+	}{
+		{"exact match", withTier(3), 900},
+		{"nearest tier", withTier(2.6), 900},
+		{"missing field reads 0", trip, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.PredictQuery(tt.q); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTableInputFields checks that -columns maps named input fields to and
+// from tabular files.
+func TestTableInputFields(t *testing.T) {
+	m, err := parseColumnMapping("department=Dept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{"trip_duration_days", "miles_traveled", "total_receipts_amount", "expected_output", "Dept"}
+	cases, labelled, err := parseTable(header, [][]string{{"3", "93", "1.42", "364.51", "7"}, {"5", "130", "306.9", "574.1", ""}}, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dept := testCase(3, 93, 1.42, 364.51)
+	dept.Input.Fields = map[string]float64{"department": 7}
+	want := TrainingData{dept, testCase(5, 130, 306.9, 574.1)}
+	if !labelled || !reflect.DeepEqual(cases, want) {
+		t.Errorf("got %+v (labelled %v), want %+v", cases, labelled, want)
+	}
+
+	var b strings.Builder
+	if err := writeCSVCases(&b, cases, true, m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "trip_duration_days,miles_traveled,total_receipts_amount,Dept,expected_output\n"+
+		"3,93,1.42,7,364.51\n5,130,306.9,,574.1\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	if _, _, err := parseTable(header[:4], nil, m); err == nil || !strings.Contains(err.Error(), `no "Dept" column for department`) {
+		t.Errorf("parsing without the field's column: got %v", err)
+	}
+	if _, err := parseColumnMapping("Miles_Traveled=Miles"); err == nil {
+		t.Error("mapping a query input as a named field: got no error")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Output formats for a reimbursement amount.
+const (
+	formatPlain      = "plain"
+	formatCurrency   = "currency"
+	formatScientific = "scientific"
+)
+
+// amountFormat renders reimbursement amounts with a fixed number of decimal
+// places.
+type amountFormat struct {
+	precision int
+	style     string
+}
+
+func (f *amountFormat) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.precision, "precision", 2, "decimal places of the reimbursement (0-10)")
+	fs.StringVar(&f.style, "format", formatPlain, "reimbursement format: plain (1234.56), currency ($1,234.56) or scientific (1.23e+03)")
+}
+
+func (f amountFormat) validate() error {
+	if f.precision < 0 || f.precision > 10 {
+		return fmt.Errorf("-precision must be between 0 and 10")
+	}
+	switch f.style {
+	case formatPlain, formatCurrency, formatScientific:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (want %s, %s or %s)", f.style, formatPlain, formatCurrency, formatScientific)
+}
+
+// round rounds v to the format's decimal places by its decimal value, so
+// the number reported in JSON is the one printed.
+func (f amountFormat) round(v float64) float64 {
+	return roundDecimal(v, f.precision)
+}
+
+// format renders v, which should already be rounded.
+func (f amountFormat) format(v float64) string {
+	switch f.style {
+	case formatScientific:
+		return strconv.FormatFloat(v, 'e', f.precision, 64)
+	case formatCurrency:
+		s := strconv.FormatFloat(math.Abs(v), 'f', f.precision, 64)
+		whole, frac, hasFrac := strings.Cut(s, ".")
+		var b strings.Builder
+		if v < 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte('$')
+		for i, digit := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(digit)
+		}
+		if hasFrac {
+			b.WriteByte('.')
+			b.WriteString(frac)
+		}
+		return b.String()
+	}
+	return strconv.FormatFloat(v, 'f', f.precision, 64)
+}
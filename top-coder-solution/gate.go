@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// exitGateFailed is the exit status of gate when a threshold is not met,
+// and of check-properties when an invariant is violated.
+const exitGateFailed = 4
+
+// GateCheck is one metric threshold of the gate.
+type GateCheck struct {
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Max       bool    `json:"max"` // the threshold is an upper bound rather than a lower one
+	Passed    bool    `json:"passed"`
+}
+
+// gateChecks compares s against the thresholds, skipping disabled ones:
+// upper bounds of zero and lower bounds that are not positive.
+func gateChecks(s EvalSummary, maxMAE, maxRMSE, maxError, maxScore float64, minExact, minClose int) []GateCheck {
+	var checks []GateCheck
+	upper := func(metric string, value, threshold float64) {
+		if threshold > 0 {
+			checks = append(checks, GateCheck{metric, value, threshold, true, value <= threshold})
+		}
+	}
+	lower := func(metric string, value, threshold int) {
+		if threshold > 0 {
+			checks = append(checks, GateCheck{metric, float64(value), float64(threshold), false, value >= threshold})
+		}
+	}
+	upper("mean_error", s.MeanError, maxMAE)
+	upper("rmse", s.RMSE, maxRMSE)
+	upper("max_error", s.MaxError, maxError)
+	upper("score", s.Score(), maxScore)
+	lower("exact_matches", s.ExactMatches, minExact)
+	lower("close_matches", s.CloseMatches, minClose)
+	return checks
+}
+
+func runGate(args []string) error {
+	fs := flag.NewFlagSet("gate", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	casesPath := fs.String("cases", "", "labelled cases to evaluate (default the training data)")
+	maxMAE := fs.Float64("max-mae", 0, "fail if the mean absolute error exceeds this (0 disables)")
+	maxRMSE := fs.Float64("max-rmse", 0, "fail if the RMSE exceeds this (0 disables)")
+	maxError := fs.Float64("max-error", 0, "fail if any case's error exceeds this (0 disables)")
+	maxScore := fs.Float64("max-score", 0, "fail if the challenge score exceeds this (0 disables)")
+	minExact := fs.Int("min-exact", 0, "fail if fewer cases than this are exact matches (to the cent)")
+	minClose := fs.Int("min-close", 0, "fail if fewer cases than this are close matches (±$1.00)")
+	asJSON := fs.Bool("json", false, "print the checks as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *maxMAE <= 0 && *maxRMSE <= 0 && *maxError <= 0 && *maxScore <= 0 && *minExact <= 0 && *minClose <= 0 {
+		return fmt.Errorf("no thresholds given; set at least one of -max-mae, -max-rmse, -max-error, -max-score, -min-exact or -min-close")
+	}
+
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	cases := p.Training
+	if *casesPath != "" {
+		if cases, err = loadTrainingData(*casesPath); err != nil {
+			return fmt.Errorf("loading cases: %v", err)
+		}
+	}
+	results := evaluate(cases, p, false)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	checks := gateChecks(summarize(results), *maxMAE, *maxRMSE, *maxError, *maxScore, *minExact, *minClose)
+
+	if *asJSON {
+		if err := writeJSON(os.Stdout, checks); err != nil {
+			return err
+		}
+	} else {
+		printGate(os.Stdout, checks)
+	}
+	for _, c := range checks {
+		if !c.Passed {
+			return &exitError{code: exitGateFailed}
+		}
+	}
+	return nil
+}
+
+func printGate(w io.Writer, checks []GateCheck) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tVALUE\tTHRESHOLD\tRESULT")
+	failed := 0
+	for _, c := range checks {
+		bound, result := ">=", "pass"
+		if c.Max {
+			bound = "<="
+		}
+		if !c.Passed {
+			result = "FAIL"
+			failed++
+		}
+		value := fmt.Sprintf("%.2f", c.Value)
+		if !c.Max {
+			value = fmt.Sprintf("%.0f", c.Value) // a count
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s %g\t%s\n", c.Metric, value, bound, c.Threshold, result)
+	}
+	tw.Flush()
+	if failed > 0 {
+		fmt.Fprintf(w, "Gate failed: %d of %d checks\n", failed, len(checks))
+	} else {
+		fmt.Fprintln(w, "Gate passed")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultGoldenPath is the golden fixture verify-golden checks by default.
+const defaultGoldenPath = "testdata/golden.json"
+
+// GoldenFile records the predictions of one model configuration for a fixed
+// set of inputs, so that refactors can be checked for unintended drift.
+type GoldenFile struct {
+	CreatedAt       time.Time       `json:"created_at"`
+	DataSHA256      string          `json:"data_sha256"`
+	Hyperparameters Hyperparameters `json:"hyperparameters"`
+	Cases           []GoldenCase    `json:"cases"`
+}
+
+// GoldenCase is one recorded prediction, at full precision.
+type GoldenCase struct {
+	Input         Query   `json:"input"`
+	Reimbursement float64 `json:"reimbursement"`
+}
+
+// goldenInputs samples n representative inputs: half are training inputs,
+// which exercise exact matches and dense regions, and half are drawn
+// uniformly over the range of the training inputs, which exercise
+// interpolation between cases.
+func goldenInputs(training TrainingData, n int, seed uint64) []Query {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	lo := featureVector{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := featureVector{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, c := range training {
+		v := caseFeatures(c)
+		for i := range featureNames {
+			lo[i], hi[i] = math.Min(lo[i], v[i]), math.Max(hi[i], v[i])
+		}
+	}
+	queries := make([]Query, n)
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = training[rng.IntN(len(training))].Input
+			continue
+		}
+		queries[i] = Query{
+			TripDurationDays:    int(lo[0]) + rng.IntN(int(hi[0]-lo[0])+1),
+			MilesTraveled:       roundCents(lo[1] + rng.Float64()*(hi[1]-lo[1])),
+			TotalReceiptsAmount: roundCents(lo[2] + rng.Float64()*(hi[2]-lo[2])),
+		}
+	}
+	return queries
+}
+
+func runGenGolden(args []string) error {
+	fs := flag.NewFlagSet("gen-golden", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	n := fs.Int("n", 200, "number of inputs to record")
+	seed := fs.Uint64("seed", 1, "random seed for sampling inputs")
+	out := fs.String("out", "", "write the golden file to this path (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *n < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if len(p.Training) == 0 {
+		return fmt.Errorf("no training data to sample inputs from")
+	}
+
+	g := GoldenFile{CreatedAt: time.Now().UTC(), DataSHA256: p.DataSHA256, Hyperparameters: p.Hyperparameters()}
+	for _, q := range goldenInputs(p.Training, *n, *seed) {
+		g.Cases = append(g.Cases, GoldenCase{q, p.PredictQuery(q)})
+	}
+	return writeJSONFile(*out, g)
+}
+
+// GoldenDrift is a golden case whose prediction has changed.
+type GoldenDrift struct {
+	GoldenCase
+	Now float64 `json:"now"`
+}
+
+func runVerifyGolden(args []string) error {
+	fs := flag.NewFlagSet("verify-golden", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	goldenPath := fs.String("golden", defaultGoldenPath, "golden file written by gen-golden")
+	tolerance := fs.Float64("tolerance", 0.005, "largest change in a prediction that is not drift, in dollars")
+	show := fs.Int("show", 20, "number of drifted cases to list")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tolerance < 0 {
+		return fmt.Errorf("-tolerance must not be negative")
+	}
+	data, err := os.ReadFile(*goldenPath)
+	if err != nil {
+		return err
+	}
+	var g GoldenFile
+	if err := json.Unmarshal(data, &g); err != nil {
+		return fmt.Errorf("parsing golden file %s: %v", *goldenPath, err)
+	}
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	// Predictions from other data or settings are expected to differ.
+	if p.DataSHA256 != g.DataSHA256 {
+		return fmt.Errorf("training data hash %.12s does not match the golden file's %.12s", p.DataSHA256, g.DataSHA256)
+	}
+	want, _ := json.Marshal(g.Hyperparameters)
+	got, _ := json.Marshal(p.Hyperparameters())
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("hyperparameters %s do not match the golden file's %s", got, want)
+	}
+
+	var drifted []GoldenDrift
+	for _, c := range g.Cases {
+		q := c.Input
+		now := p.PredictQuery(q)
+		if !(math.Abs(now-c.Reimbursement) <= *tolerance) {
+			drifted = append(drifted, GoldenDrift{c, now})
+		}
+	}
+	if len(drifted) == 0 {
+		fmt.Printf("All %d golden predictions match within $%g\n", len(g.Cases), *tolerance)
+		return nil
+	}
+	printGoldenDrift(os.Stdout, drifted, *show)
+	return fmt.Errorf("%d of %d golden predictions drifted by more than $%g", len(drifted), len(g.Cases), *tolerance)
+}
+
+func printGoldenDrift(w io.Writer, drifted []GoldenDrift, show int) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAYS\tMILES\tRECEIPTS\tGOLDEN\tNOW\tCHANGE")
+	for _, d := range drifted[:min(show, len(drifted))] {
+		fmt.Fprintf(tw, "%d\t%g\t%.2f\t%.4f\t%.4f\t%+.4f\n", d.Input.TripDurationDays, d.Input.MilesTraveled,
+			d.Input.TotalReceiptsAmount, d.Reimbursement, d.Now, d.Now-d.Reimbursement)
+	}
+	tw.Flush()
+	if len(drifted) > show {
+		fmt.Fprintf(w, "... and %d more\n", len(drifted)-show)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcPredictStream is the path of the PredictStream RPC of
+// reimbursement.proto. The server speaks just enough gRPC for it: messages
+// framed over HTTP/2 with their status in the trailers, uncompressed, with
+// the protobuf encoding of Case and Prediction written by hand.
+const grpcPredictStream = "/reimbursement.v1.Reimbursement/PredictStream"
+
+// grpcMaxMessage bounds an incoming message, as gRPC's default limit does.
+const grpcMaxMessage = 4 << 20
+
+// gRPC status codes the server answers with.
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+// grpcCase is a decoded Case message.
+type grpcCase struct {
+	ID     string
+	Policy string
+	Query  Query
+}
+
+// grpcPrediction is a Prediction message.
+type grpcPrediction struct {
+	ID            string
+	Reimbursement float64
+	Abstained     bool
+	Warning       string
+	Error         string
+	ModelVersion  string
+}
+
+var errMalformedProto = errors.New("malformed protobuf message")
+
+// decodeCase decodes a Case message, skipping unknown fields.
+func decodeCase(b []byte) (grpcCase, error) {
+	var c grpcCase
+	err := walkProto(b, func(key, v uint64, data []byte) error {
+		switch key {
+		case 1<<3 | 0:
+			c.Query.TripDurationDays = int(int32(v))
+		case 2<<3 | 1:
+			c.Query.MilesTraveled = math.Float64frombits(v)
+		case 3<<3 | 1:
+			c.Query.TotalReceiptsAmount = math.Float64frombits(v)
+		case 4<<3 | 2:
+			c.ID = string(data)
+		case 5<<3 | 2:
+			c.Policy = string(data)
+		case 6<<3 | 2:
+			// A map entry: the key is field 1 and the value field 2.
+			var name string
+			var value float64
+			err := walkProto(data, func(key, v uint64, data []byte) error {
+				switch key {
+				case 1<<3 | 2:
+					name = string(data)
+				case 2<<3 | 1:
+					value = math.Float64frombits(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if c.Query.Fields == nil {
+				c.Query.Fields = map[string]float64{}
+			}
+			c.Query.Fields[name] = value
+		}
+		return nil
+	})
+	return c, err
+}
+
+// walkProto calls visit with the key (field number and wire type) and value
+// of each field of the message b in turn: v holds a varint or the bits of a
+// fixed-width value, and data the bytes of a length-delimited one.
+func walkProto(b []byte, visit func(key, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformedProto
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case 0: // varint
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errMalformedProto
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errMalformedProto
+			}
+			v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errMalformedProto
+			}
+			data = b[n : n+int(l)]
+			b = b[n+int(l):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errMalformedProto
+			}
+			v = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return errMalformedProto
+		}
+		if err := visit(key, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshal encodes p as a Prediction message, omitting zero fields as proto3
+// does.
+func (p grpcPrediction) marshal() []byte {
+	var b []byte
+	b = appendProtoString(b, 1, p.ID)
+	if p.Reimbursement != 0 {
+		b = binary.AppendUvarint(b, 2<<3|1)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Reimbursement))
+	}
+	if p.Abstained {
+		b = binary.AppendUvarint(b, 3<<3|0)
+		b = append(b, 1)
+	}
+	b = appendProtoString(b, 4, p.Warning)
+	b = appendProtoString(b, 5, p.Error)
+	return appendProtoString(b, 6, p.ModelVersion)
+}
+
+func appendProtoString(b []byte, field uint64, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, field<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// handlePredictStream serves the PredictStream RPC. It reads the next case
+// only after sending the previous prediction, so a client that stops
+// reading is held back by HTTP/2 flow control instead of piling up work.
+// The model is loaded afresh for each case, so a long-lived stream follows
+// reloads, and each case may name its own policy.
+func (s *Server) handlePredictStream(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		writeError(w, http.StatusUnsupportedMediaType, "gRPC requires HTTP/2 and content type application/grpc")
+		return
+	}
+	rc := http.NewResponseController(w)
+	// The stream lasts as long as the client wants, not the server's
+	// request timeouts.
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	code, msg := s.predictStream(r.Context(), r.Body, w, rc)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(msg))
+	}
+}
+
+// predictStream answers the framed cases read from body until it ends,
+// returning the RPC's status.
+func (s *Server) predictStream(ctx context.Context, body io.Reader, w io.Writer, rc *http.ResponseController) (int, string) {
+	var header [5]byte
+	for i := 0; ; i++ {
+		if _, err := io.ReadFull(body, header[:]); err == io.EOF {
+			return grpcOK, ""
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return grpcCanceled, "stream canceled"
+			}
+			return grpcInvalidArgument, fmt.Sprintf("reading case %d: %v", i, err)
+		}
+		if header[0] != 0 {
+			return grpcUnimplemented, "compressed messages are not supported"
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > grpcMaxMessage {
+			return grpcResourceExhausted, fmt.Sprintf("case %d of %d bytes exceeds the limit of %d", i, size, grpcMaxMessage)
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(body, buf); err != nil {
+			if ctx.Err() != nil {
+				return grpcCanceled, "stream canceled"
+			}
+			return grpcInvalidArgument, fmt.Sprintf("reading case %d: %v", i, err)
+		}
+		c, err := decodeCase(buf)
+		if err != nil {
+			return grpcInvalidArgument, fmt.Sprintf("case %d: %v", i, err)
+		}
+
+		policy := c.Policy
+		if policy == "" {
+			policy = defaultPolicy
+		}
+		t := s.tenants[policy]
+		if t == nil {
+			out := grpcPrediction{ID: c.ID, Error: fmt.Sprintf("unknown policy %q", policy)}
+			if !writeGRPCMessage(w, rc, out.marshal()) {
+				return grpcCanceled, "stream canceled"
+			}
+			continue
+		}
+		m := t.model.Load()
+		if m == nil {
+			return grpcUnavailable, "model is still loading"
+		}
+		out := grpcPrediction{ID: c.ID, ModelVersion: m.predictor.Version}
+		resp, err := s.predictOne(ctx, m, c.Query, m.provenance(time.Now()))
+		var refused *inputError
+		switch {
+		case ctx.Err() != nil:
+			return grpcCanceled, "stream canceled"
+		case errors.As(err, &refused):
+			out.Error = refused.Error()
+		case err != nil:
+			log.Printf("predict stream: %v", err)
+			return grpcInternal, "prediction failed"
+		default:
+			out.Reimbursement, out.Abstained = resp.Reimbursement, resp.Abstained
+			if resp.Warning != nil {
+				out.Warning = resp.Warning.Message
+			}
+		}
+
+		if !writeGRPCMessage(w, rc, out.marshal()) {
+			return grpcCanceled, "stream canceled"
+		}
+	}
+}
+
+// writeGRPCMessage sends a framed message, reporting whether the client is
+// still there to receive it.
+func writeGRPCMessage(w io.Writer, rc *http.ResponseController, msg []byte) bool {
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return false
+	}
+	return rc.Flush() == nil
+}
+
+// grpcEscape percent-encodes a status message as the grpc-message trailer
+// requires.
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
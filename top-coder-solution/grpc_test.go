@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// unhex decodes hex written with spaces between fields.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeCase(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string // as protoc encodes it
+		want grpcCase
+	}{
+		{"empty", "", grpcCase{}},
+		{
+			"every field",
+			"08 03  11 0000000000405740  19 b81e85eb51b8f63f  22 01 61  2a 02 7632",
+			grpcCase{ID: "a", Policy: "v2", Query: Query{TripDurationDays: 3, MilesTraveled: 93, TotalReceiptsAmount: 1.42}},
+		},
+		{
+			"fields out of order",
+			"2a 02 7632  19 b81e85eb51b8f63f  08 03",
+			grpcCase{Policy: "v2", Query: Query{TripDurationDays: 3, TotalReceiptsAmount: 1.42}},
+		},
+		{
+			"negative int32 is ten bytes",
+			"08 ffffffffffffffffff01",
+			grpcCase{Query: Query{TripDurationDays: -1}},
+		},
+		{
+			"repeated field keeps the last",
+			"08 03  08 05",
+			grpcCase{Query: Query{TripDurationDays: 5}},
+		},
+		{
+			"unknown fields of every wire type are skipped",
+			"48 05  55 01020304  5a 02 ffff  61 0000000000000000  08 07  a2 06 00",
+			grpcCase{Query: Query{TripDurationDays: 7}},
+		},
+		{
+			"named input fields, a missing value reading 0",
+			"08 03  32 0f 0a 04 74696572 11 0000000000000040  32 06 0a 04 64657074",
+			grpcCase{Query: Query{TripDurationDays: 3, Fields: map[string]float64{"tier": 2, "dept": 0}}},
+		},
+		{
+			"multi-byte length",
+			"22 80 01 " + strings.Repeat("78", 128),
+			grpcCase{ID: strings.Repeat("x", 128)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCase(unhex(t, tt.hex))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCaseMalformed(t *testing.T) {
+	for _, tt := range []struct{ name, hex string }{
+		{"truncated key", "80"},
+		{"truncated varint", "08 ff"},
+		{"missing varint", "08"},
+		{"short fixed64", "11 00000000004057"},
+		{"short fixed32", "55 010203"},
+		{"length past the end", "22 05 6162"},
+		{"malformed map entry", "32 02 0a 05"},
+		{"truncated length", "22 80"},
+		{"start group wire type", "0b"},
+		{"end group wire type", "0c"},
+		{"invalid wire type", "0e"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCase(unhex(t, tt.hex)); !errors.Is(err, errMalformedProto) {
+				t.Errorf("got error %v, want %v", err, errMalformedProto)
+			}
+		})
+	}
+}
+
+func TestPredictionMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		p    grpcPrediction
+		want string // as protoc encodes it
+	}{
+		{"zero fields are omitted", grpcPrediction{}, ""},
+		{
+			"prediction",
+			grpcPrediction{ID: "a", Reimbursement: 1234.56, ModelVersion: "v3"},
+			"0a 01 61  11 0ad7a3703d4a9340  32 02 7633",
+		},
+		{
+			"abstained",
+			grpcPrediction{ID: "b", Abstained: true, Warning: "w"},
+			"0a 01 62  18 01  22 01 77",
+		},
+		{"error", grpcPrediction{Error: "bad"}, "2a 03 626164"},
+		{"negative reimbursement", grpcPrediction{Reimbursement: -1.5}, "11 000000000000f8bf"},
+		{
+			"multi-byte length",
+			grpcPrediction{Warning: strings.Repeat("x", 200)},
+			"22 c8 01 " + strings.Repeat("78", 200),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tt.p.marshal()); got != hex.EncodeToString(unhex(t, tt.want)) {
+				t.Errorf("got %s, want %s", got, strings.ReplaceAll(tt.want, " ", ""))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports that the process is up, whether or not the model has
+// loaded yet.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz fails until the training data and neighbor index of every
+// policy are loaded, so the load balancer only routes traffic to instances
+// that can answer.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	for _, t := range s.tenants {
+		if t.model.Load() == nil {
+			writeJSONResponse(w, http.StatusServiceUnavailable, map[string]string{"status": "loading"})
+			return
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// ModelInfo describes the model being served.
+type ModelInfo struct {
+	Policy          string          `json:"policy,omitempty"` // the policy served, unless the default
+	PolicyVersion   string          `json:"policy_version,omitempty"`
+	ModelVersion    string          `json:"model_version"`
+	DataSHA256      string          `json:"data_sha256"`
+	CaseCount       int             `json:"case_count"`
+	Hyperparameters Hyperparameters `json:"hyperparameters"`
+	LoadedAt        time.Time       `json:"loaded_at"`
+	Shadow          *ModelInfo      `json:"shadow,omitempty"` // the shadow model, if any
+}
+
+func (m *serving) info() ModelInfo {
+	info := predictorInfo(m.predictor, m.loadedAt)
+	if m.policy != defaultPolicy {
+		info.Policy = m.policy
+	}
+	if m.shadow != nil {
+		shadow := predictorInfo(m.shadow, m.loadedAt)
+		info.Shadow = &shadow
+	}
+	return info
+}
+
+func predictorInfo(p *Predictor, loadedAt time.Time) ModelInfo {
+	prov := p.Provenance(loadedAt)
+	return ModelInfo{
+		PolicyVersion:   prov.PolicyVersion,
+		ModelVersion:    prov.ModelVersion,
+		DataSHA256:      prov.DataSHA256,
+		CaseCount:       len(p.Training),
+		Hyperparameters: prov.Hyperparameters,
+		LoadedAt:        loadedAt,
+	}
+}
+
+func (s *Server) handleModelInfo(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantFor(w, r, "")
+	if t == nil {
+		return
+	}
+	if m := t.ready(w); m != nil {
+		writeJSONResponse(w, http.StatusOK, m.info())
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+// hnswIndex is a hierarchical navigable small world graph (Malkov and
+// Yashunin, 2016) for approximate nearest neighbor search. Each case is a
+// node on layers 0 through a randomly drawn level; upper layers are sparse
+// and route a greedy search quickly to the right region of layer 0, where a
+// beam search of width efSearch collects the neighbors.
+type hnswIndex struct {
+	points  []featureVector
+	outputs []float64
+	metric  distanceMetric
+	links   [][][]int32 // links[node][layer]
+
+	entry    int32
+	maxLayer int
+
+	m, efConstruction, efSearch int
+
+	scratch sync.Pool // *hnswScratch
+}
+
+// hnswCandidate is a node and its distance to the query.
+type hnswCandidate struct {
+	id   int32
+	dist float64
+}
+
+// hnswScratch is per-search state, pooled so concurrent queries are safe.
+type hnswScratch struct {
+	visited []uint32 // generation at which each node was last visited
+	gen     uint32
+	cands   candidateHeap
+	results candidateHeap
+}
+
+// hnswSeed fixes the level draws, so the same data always builds the same
+// graph and approximate predictions are reproducible.
+const hnswSeed = 0x5eed
+
+func newHNSW(data TrainingData, metric distanceMetric, m, efConstruction, efSearch int) *hnswIndex {
+	h := &hnswIndex{
+		points:         make([]featureVector, len(data)),
+		outputs:        make([]float64, len(data)),
+		metric:         metric,
+		links:          make([][][]int32, len(data)),
+		entry:          -1,
+		m:              m,
+		efConstruction: efConstruction,
+		efSearch:       efSearch,
+	}
+	h.scratch.New = func() any { return &hnswScratch{visited: make([]uint32, len(h.points))} }
+
+	rng := rand.New(rand.NewPCG(hnswSeed, uint64(len(data))))
+	levelMult := 1 / math.Log(float64(m))
+	for i, c := range data {
+		h.points[i] = caseFeatures(c)
+		h.outputs[i] = c.ExpectedOutput
+		h.insert(int32(i), int(-math.Log(1-rng.Float64())*levelMult))
+	}
+	return h
+}
+
+func (h *hnswIndex) dist(q featureVector, id int32) float64 {
+	return h.metric.distance(q, h.points[id])
+}
+
+func (h *hnswIndex) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * h.m
+	}
+	return h.m
+}
+
+func (h *hnswIndex) insert(id int32, level int) {
+	h.links[id] = make([][]int32, level+1)
+	if h.entry < 0 {
+		h.entry, h.maxLayer = id, level
+		return
+	}
+
+	q := h.points[id]
+	s := h.scratch.Get().(*hnswScratch)
+	defer h.scratch.Put(s)
+
+	eps := []hnswCandidate{{h.entry, h.dist(q, h.entry)}}
+	for layer := h.maxLayer; layer > level; layer-- {
+		eps = h.searchLayer(s, q, eps, 1, layer)
+	}
+	for layer := min(level, h.maxLayer); layer >= 0; layer-- {
+		eps = h.searchLayer(s, q, eps, h.efConstruction, layer)
+		h.links[id][layer] = h.selectNeighbors(eps, h.m)
+		for _, n := range h.links[id][layer] {
+			h.links[n][layer] = append(h.links[n][layer], id)
+			if len(h.links[n][layer]) > h.maxLinks(layer) {
+				h.prune(n, layer)
+			}
+		}
+	}
+	if level > h.maxLayer {
+		h.entry, h.maxLayer = id, level
+	}
+}
+
+// selectNeighbors picks up to m links from cands, sorted nearest first,
+// preferring candidates closer to the new node than to any already chosen so
+// that links spread in different directions. Remaining slots are filled with
+// the nearest rejected candidates.
+func (h *hnswIndex) selectNeighbors(cands []hnswCandidate, m int) []int32 {
+	chosen := make([]int32, 0, m)
+	var rejected []int32
+	for _, c := range cands {
+		if len(chosen) == m {
+			break
+		}
+		diverse := true
+		for _, r := range chosen {
+			if h.dist(h.points[c.id], r) < c.dist {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			chosen = append(chosen, c.id)
+		} else {
+			rejected = append(rejected, c.id)
+		}
+	}
+	for _, r := range rejected {
+		if len(chosen) == m {
+			break
+		}
+		chosen = append(chosen, r)
+	}
+	return chosen
+}
+
+// prune cuts the links of node n on layer back to the layer's maximum.
+func (h *hnswIndex) prune(n int32, layer int) {
+	p := h.points[n]
+	cands := make([]hnswCandidate, len(h.links[n][layer]))
+	for i, id := range h.links[n][layer] {
+		cands[i] = hnswCandidate{id, h.dist(p, id)}
+	}
+	slices.SortFunc(cands, func(a, b hnswCandidate) int { return compareDist(a.dist, b.dist) })
+	h.links[n][layer] = h.selectNeighbors(cands, h.maxLinks(layer))
+}
+
+func compareDist(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// searchLayer is a beam search of width ef on one layer starting from eps.
+// It returns the nearest nodes found, nearest first.
+func (h *hnswIndex) searchLayer(s *hnswScratch, q featureVector, eps []hnswCandidate, ef, layer int) []hnswCandidate {
+	if len(s.visited) < len(h.points) {
+		s.visited = make([]uint32, len(h.points))
+	}
+	s.gen++
+	if s.gen == 0 { // wrapped; forget stale marks
+		clear(s.visited)
+		s.gen = 1
+	}
+	s.cands = s.cands[:0]
+	s.results = s.results[:0]
+	for _, e := range eps {
+		s.visited[e.id] = s.gen
+		s.cands.push(hnswCandidate{e.id, -e.dist}) // negated: pops nearest
+		s.results.push(e)
+	}
+	for len(s.results) > ef {
+		s.results.pop()
+	}
+
+	for len(s.cands) > 0 {
+		c := s.cands.pop()
+		if -c.dist > s.results[0].dist && len(s.results) >= ef {
+			break
+		}
+		if layer >= len(h.links[c.id]) {
+			continue
+		}
+		for _, n := range h.links[c.id][layer] {
+			if s.visited[n] == s.gen {
+				continue
+			}
+			s.visited[n] = s.gen
+			d := h.dist(q, n)
+			if len(s.results) < ef || d < s.results[0].dist {
+				s.cands.push(hnswCandidate{n, -d})
+				s.results.push(hnswCandidate{n, d})
+				if len(s.results) > ef {
+					s.results.pop()
+				}
+			}
+		}
+	}
+
+	out := make([]hnswCandidate, len(s.results))
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = s.results.pop()
+	}
+	return out
+}
+
+func (h *hnswIndex) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	if h.entry < 0 {
+		return dst
+	}
+	s := h.scratch.Get().(*hnswScratch)
+	defer h.scratch.Put(s)
+
+	eps := []hnswCandidate{{h.entry, h.dist(q, h.entry)}}
+	for layer := h.maxLayer; layer > 0; layer-- {
+		eps = h.searchLayer(s, q, eps, 1, layer)
+	}
+	found := h.searchLayer(s, q, eps, max(h.efSearch, k), 0)
+	for _, c := range found[:min(k, len(found))] {
+		dst = append(dst, Neighbor{Distance: c.dist, Output: h.outputs[c.id], Case: int(c.id)})
+	}
+	return dst
+}
+
+// candidateHeap is a binary max-heap on dist.
+type candidateHeap []hnswCandidate
+
+func (hp *candidateHeap) push(c hnswCandidate) {
+	*hp = append(*hp, c)
+	a := *hp
+	for i := len(a) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if a[parent].dist >= a[i].dist {
+			break
+		}
+		a[parent], a[i] = a[i], a[parent]
+		i = parent
+	}
+}
+
+func (hp *candidateHeap) pop() hnswCandidate {
+	a := *hp
+	top := a[0]
+	last := len(a) - 1
+	a[0] = a[last]
+	a = a[:last]
+	for i := 0; ; {
+		l, r, largest := 2*i+1, 2*i+2, i
+		if l < len(a) && a[l].dist > a[largest].dist {
+			largest = l
+		}
+		if r < len(a) && a[r].dist > a[largest].dist {
+			largest = r
+		}
+		if largest == i {
+			break
+		}
+		a[i], a[largest] = a[largest], a[i]
+		i = largest
+	}
+	*hp = a
+	return top
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// FeatureImportance is how much a model's cross-validated mean absolute
+// error grows without each input.
+type FeatureImportance struct {
+	Model         string  `json:"model"`
+	BaselineError float64 `json:"baseline_error"`
+	// Permutation is the error increase when an input's values are shuffled
+	// among the held-out cases, breaking its link to the output.
+	Permutation map[string]float64 `json:"permutation"`
+	// Drop is the error increase when a configured distance feature is
+	// removed and the model refitted, showing whether it earns its place.
+	Drop map[string]float64 `json:"drop,omitempty"`
+}
+
+// permutationErrors fits hp on the cases outside each fold and measures the
+// mean absolute error on the fold as it is and with each input shuffled,
+// averaged over repeats shuffles. It returns the baseline error and the
+// error with each input shuffled.
+func permutationErrors(training TrainingData, hp Hyperparameters, assigned []int, folds, repeats int, seed uint64, jobs int) (float64, [3]float64, error) {
+	type foldErrors struct {
+		base     float64
+		permuted [3]float64
+		err      error
+	}
+	results := make([]foldErrors, folds)
+	forEach(folds, jobs, func(f int) {
+		var train, test TrainingData
+		for i, c := range training {
+			if assigned[i] == f {
+				test = append(test, c)
+			} else {
+				train = append(train, c)
+			}
+		}
+		p := NewPredictor(train, hp)
+		defer p.Close()
+		absErr := func(cases TrainingData) float64 {
+			sum := 0.0
+			for _, c := range cases {
+				sum += math.Abs(p.PredictQuery(c.Input) - c.ExpectedOutput)
+			}
+			return sum
+		}
+		r := &results[f]
+		r.base = absErr(test)
+		shuffled := make(TrainingData, len(test))
+		for j := range featureNames {
+			rng := rand.New(rand.NewPCG(seed, uint64(f*len(featureNames)+j)))
+			for range repeats {
+				copy(shuffled, test)
+				perm := rng.Perm(len(test))
+				for i, from := range perm {
+					switch j {
+					case 0:
+						shuffled[i].Input.TripDurationDays = test[from].Input.TripDurationDays
+					case 1:
+						shuffled[i].Input.MilesTraveled = test[from].Input.MilesTraveled
+					case 2:
+						shuffled[i].Input.TotalReceiptsAmount = test[from].Input.TotalReceiptsAmount
+					}
+				}
+				r.permuted[j] += absErr(shuffled) / float64(repeats)
+			}
+		}
+		r.err = p.Err()
+	})
+
+	var base float64
+	var permuted [3]float64
+	for _, r := range results {
+		if r.err != nil {
+			return 0, permuted, r.err
+		}
+		base += r.base
+		for j := range permuted {
+			permuted[j] += r.permuted[j]
+		}
+	}
+	n := float64(len(training))
+	for j := range permuted {
+		permuted[j] /= n
+	}
+	return base / n, permuted, nil
+}
+
+// featureImportance measures the permutation importance of each input for
+// hp, and the drop importance of each of hp's configured features.
+func featureImportance(training TrainingData, hp Hyperparameters, assigned []int, folds, repeats int, seed uint64, jobs int) (FeatureImportance, error) {
+	base, permuted, err := permutationErrors(training, hp, assigned, folds, repeats, seed, jobs)
+	if err != nil {
+		return FeatureImportance{}, err
+	}
+	imp := FeatureImportance{BaselineError: base, Permutation: map[string]float64{}}
+	for j, name := range featureNames {
+		imp.Permutation[name] = permuted[j] - base
+	}
+	if len(hp.Features) < 2 {
+		return imp, nil
+	}
+	imp.Drop = map[string]float64{}
+	for i, f := range hp.Features {
+		reduced := hp
+		reduced.Features = append(append([]FeatureConfig(nil), hp.Features[:i]...), hp.Features[i+1:]...)
+		if reduced.validate() != nil {
+			continue // the index or metric needs the feature
+		}
+		e, _, err := permutationErrors(training, reduced, assigned, folds, 0, seed, jobs)
+		if err != nil {
+			return imp, err
+		}
+		imp.Drop[f.Name] = e - base
+	}
+	return imp, nil
+}
+
+func printImportance(w io.Writer, method string, imps []FeatureImportance, features []FeatureConfig) {
+	fmt.Fprintf(w, "Increase in mean absolute error by %s\n\n", method)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "MODEL\tBASELINE"
+	for _, name := range featureNames {
+		header += "\tSHUFFLE " + strings.ToUpper(name)
+	}
+	for _, f := range features {
+		header += "\tDROP " + strings.ToUpper(f.Name)
+	}
+	fmt.Fprintln(tw, header+"\t")
+	for _, imp := range imps {
+		fmt.Fprintf(tw, "%s\t$%.2f", imp.Model, imp.BaselineError)
+		for _, name := range featureNames {
+			fmt.Fprintf(tw, "\t%+.2f", imp.Permutation[name])
+		}
+		for _, f := range features {
+			if d, ok := imp.Drop[f.Name]; ok {
+				fmt.Fprintf(tw, "\t%+.2f", d)
+			} else {
+				fmt.Fprint(tw, "\t-")
+			}
+		}
+		fmt.Fprintln(tw, "\t")
+	}
+	tw.Flush()
+}
+
+func runImportance(args []string) error {
+	fs := flag.NewFlagSet("importance", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	names := fs.String("models", strings.Join([]string{modelKNN, modelLinear, modelTree, modelForest, modelRule}, ","),
+		"comma-separated models to measure (overrides -model)")
+	folds := fs.Int("folds", 5, "k-fold cross-validation folds")
+	repeats := fs.Int("repeats", 3, "shuffles of each input per fold, averaged")
+	seed := fs.Uint64("seed", 1, "random seed for assigning folds and shuffling")
+	jobs := fs.Int("jobs", 0, "fold workers (0 uses every CPU)")
+	asJSON := fs.Bool("json", false, "print the importances as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *repeats < 1 {
+		return fmt.Errorf("-repeats must be at least 1")
+	}
+
+	base, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+	if *folds < 2 || *folds > len(base.Training) {
+		return fmt.Errorf("-folds must be between 2 and the number of cases")
+	}
+	assigned := randomFolds(len(base.Training), *folds, *seed)
+
+	var imps []FeatureImportance
+	for _, name := range strings.Split(*names, ",") {
+		name = strings.TrimSpace(name)
+		hp := base.Hyperparameters()
+		hp.Model = name
+		if isKNN(name) {
+			hp.Model = ""
+		}
+		if err := hp.validate(); err != nil {
+			return fmt.Errorf("model %s: %v", name, err)
+		}
+		imp, err := featureImportance(base.Training, hp, assigned, *folds, *repeats, *seed, *jobs)
+		if err != nil {
+			return fmt.Errorf("model %s: %v", name, err)
+		}
+		imp.Model = name
+		imps = append(imps, imp)
+	}
+
+	if *asJSON {
+		return writeJSON(os.Stdout, imps)
+	}
+	var features []FeatureConfig
+	if len(base.Features) >= 2 {
+		features = base.Features
+	}
+	printImportance(os.Stdout, fmt.Sprintf("%d-fold cross-validation", *folds), imps, features)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// Neighbor search structures. indexExact picks the fastest exact structure
+// for the metric: a brute-force scan for Euclidean distance and a ball tree
+// for the others.
+const (
+	indexExact    = "exact"
+	indexBallTree = "ball-tree"
+	indexKDTree   = "kd-tree"
+	indexVPTree   = "vp-tree"
+	indexHNSW     = "hnsw"
+	indexLSH      = "lsh"
+)
+
+// IndexConfig selects the structure used to find neighbors and its tuning.
+// A nil config means indexExact.
+type IndexConfig struct {
+	Kind string `json:"kind"`
+
+	// HNSW parameters: M is the number of links per node, EfConstruction and
+	// EfSearch the candidate list sizes while building and querying. Larger
+	// values trade speed for recall.
+	M              int `json:"m,omitempty"`
+	EfConstruction int `json:"ef_construction,omitempty"`
+	EfSearch       int `json:"ef_search,omitempty"`
+
+	// LSH parameters: Tables hash tables of Hashes projections each, with
+	// buckets BucketWidth wide in scaled feature units. More tables or wider
+	// buckets raise recall at the cost of more candidates to re-rank.
+	Tables      int     `json:"tables,omitempty"`
+	Hashes      int     `json:"hashes,omitempty"`
+	BucketWidth float64 `json:"bucket_width,omitempty"`
+}
+
+func (c *IndexConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	switch c.Kind {
+	case indexExact, indexBallTree, indexKDTree, indexVPTree:
+	case indexHNSW:
+		if c.M < 2 || c.EfConstruction < 1 || c.EfSearch < 1 {
+			return fmt.Errorf("hnsw index requires m >= 2 and positive ef values")
+		}
+	case indexLSH:
+		if c.Tables < 1 || c.Hashes < 1 || !(c.BucketWidth > 0) {
+			return fmt.Errorf("lsh index requires positive tables, hashes and bucket width")
+		}
+	default:
+		return fmt.Errorf("unknown index %q (want %s, %s, %s, %s, %s or %s)",
+			c.Kind, indexExact, indexBallTree, indexKDTree, indexVPTree, indexHNSW, indexLSH)
+	}
+	return nil
+}
+
+// approximate reports whether c may miss some of the true nearest neighbors.
+func (c *IndexConfig) approximate() bool {
+	return c != nil && (c.Kind == indexHNSW || c.Kind == indexLSH)
+}
+
+// kind is the index kind, resolving a nil config to indexExact.
+func (c *IndexConfig) kind() string {
+	if c == nil {
+		return indexExact
+	}
+	return c.Kind
+}
+
+// neighborIndex finds the training cases nearest a query.
+type neighborIndex interface {
+	// search appends up to k cases near q to dst, nearest first.
+	search(dst []Neighbor, q featureVector, k int) []Neighbor
+}
+
+// buildIndex builds the index c describes over data under metric. c must be
+// valid for the metric.
+func buildIndex(c *IndexConfig, data TrainingData, metric distanceMetric) neighborIndex {
+	switch c.kind() {
+	case indexHNSW:
+		return newHNSW(data, metric, c.M, c.EfConstruction, c.EfSearch)
+	case indexLSH:
+		return newLSH(data, c.Tables, c.Hashes, c.BucketWidth)
+	case indexKDTree:
+		return newKDTree(data, metric)
+	case indexVPTree:
+		return newVPTree(data, metric)
+	case indexExact:
+		if _, ok := metric.(euclideanMetric); ok {
+			return exactIndex{newFeatureColumns(data)}
+		}
+	}
+	return newBallTree(data, metric)
+}
+
+// bruteIndex scans every case under any metric.
+type bruteIndex struct {
+	data   TrainingData
+	metric distanceMetric
+}
+
+func (x bruteIndex) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	nearest := dst[len(dst):]
+	for i, c := range x.data {
+		nearest = insertNeighbor(nearest, min(k, len(x.data)), Neighbor{Distance: x.metric.distance(q, caseFeatures(c)), Output: c.ExpectedOutput, Case: i})
+	}
+	return append(dst, nearest...)
+}
+
+// exactIndex scans every case under the Euclidean metric, bit-for-bit like
+// calculateDistance.
+type exactIndex struct{ cols *featureColumns }
+
+func (x exactIndex) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	c := x.cols
+	k = min(k, len(c.days))
+	for i := range c.days {
+		dd := (q[0] - c.days[i]) / dayScale
+		md := (q[1] - c.miles[i]) / mileScale
+		rd := (q[2] - c.receipts[i]) / receiptScale
+		dst = insertNeighbor(dst, k, Neighbor{Distance: math.Sqrt(dd*dd + md*md + rd*rd), Output: c.outputs[i], Case: i})
+	}
+	return dst
+}
+
+// indexFlags are the model flags choosing the neighbor index.
+type indexFlags struct {
+	kind           string
+	m              int
+	efConstruction int
+	efSearch       int
+	tables         int
+	hashes         int
+	bucketWidth    float64
+}
+
+func (f *indexFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.kind, "index", indexExact,
+		"neighbor index: exact (chosen for the metric), ball-tree, kd-tree, vp-tree, or hnsw or lsh (approximate)")
+	fs.IntVar(&f.m, "hnsw-m", 16, "links per node of the hnsw index")
+	fs.IntVar(&f.efConstruction, "hnsw-ef-construction", 200, "candidate list size while building the hnsw index")
+	fs.IntVar(&f.efSearch, "hnsw-ef", 64, "candidate list size while querying the hnsw index")
+	fs.IntVar(&f.tables, "lsh-tables", 8, "hash tables of the lsh index")
+	fs.IntVar(&f.hashes, "lsh-hashes", 4, "projections hashed together in each lsh table")
+	fs.Float64Var(&f.bucketWidth, "lsh-width", 0.2, "lsh bucket width in scaled feature units")
+}
+
+// config returns the selected index configuration, nil for exact search.
+func (f *indexFlags) config() (*IndexConfig, error) {
+	if f.kind == indexExact {
+		return nil, nil
+	}
+	c := &IndexConfig{Kind: f.kind}
+	switch f.kind {
+	case indexHNSW:
+		c.M, c.EfConstruction, c.EfSearch = f.m, f.efConstruction, f.efSearch
+	case indexLSH:
+		c.Tables, c.Hashes, c.BucketWidth = f.tables, f.hashes, f.bucketWidth
+	}
+	return c, c.validate()
+}
+
+// predictIndexed is predictWeightedKNN using idx, built over pool, for the
+// neighbor search, with neighbors weighted for recency by r and combined as
+// how says.
+func predictIndexed(idx neighborIndex, pool TrainingData, q featureVector, k int, r *recencyDecay, how string) float64 {
+	buf := neighborPool.Get().(*[]Neighbor)
+	defer neighborPool.Put(buf)
+	neighbors := idx.search((*buf)[:0], q, max(k, 1))
+	*buf = neighbors
+	for _, n := range neighbors {
+		in := pool[n.Case].Input
+		if float64(in.TripDurationDays) == q[0] && math.Abs(in.MilesTraveled-q[1]) < 0.001 &&
+			math.Abs(in.TotalReceiptsAmount-q[2]) < 0.001 && sameInputFields(in.features(), q) {
+			return n.Output
+		}
+	}
+	return r.aggregate(neighbors, pool, how)
+}
+
+// IndexComparison measures an approximate index against exact search.
+type IndexComparison struct {
+	Queries     int
+	K           int
+	Recall      float64 // mean fraction of the exact k nearest found
+	MeanAbsDiff float64 // mean |approximate - exact| prediction
+	MaxAbsDiff  float64
+	IndexTime   time.Duration // mean per query
+	ExactTime   time.Duration
+}
+
+// compareIndex runs queries through p and through an exact-search copy of
+// it, comparing the neighbors found, the predictions and the query time.
+func compareIndex(p *Predictor, queries TrainingData) IndexComparison {
+	hp := p.Hyperparameters()
+	hp.Index = nil
+	exact := NewPredictor(p.Training, hp)
+	cmp := IndexComparison{Queries: len(queries), K: p.K}
+	if len(queries) == 0 {
+		return cmp
+	}
+
+	approx := make([]float64, len(queries))
+	start := time.Now()
+	for i, c := range queries {
+		approx[i] = p.PredictQuery(c.Input)
+	}
+	cmp.IndexTime = time.Since(start) / time.Duration(len(queries))
+
+	start = time.Now()
+	for i, c := range queries {
+		d := math.Abs(approx[i] - exact.PredictQuery(c.Input))
+		cmp.MeanAbsDiff += d / float64(len(queries))
+		cmp.MaxAbsDiff = math.Max(cmp.MaxAbsDiff, d)
+	}
+	cmp.ExactTime = time.Since(start) / time.Duration(len(queries))
+
+	var found, want []Neighbor
+	for _, c := range queries {
+		v := caseFeatures(c)
+		idx, _ := p.poolIndex(v)
+		found = idx.search(found[:0], v, p.K)
+		want = exact.searcher(v).search(want[:0], v, p.K)
+		hits := 0
+		for _, w := range want {
+			if slices.ContainsFunc(found, func(n Neighbor) bool { return n.Case == w.Case }) {
+				hits++
+			}
+		}
+		if len(want) > 0 {
+			cmp.Recall += float64(hits) / float64(len(want)) / float64(len(queries))
+		}
+	}
+	return cmp
+}
+
+func printIndexComparison(w io.Writer, kind string, c IndexComparison) {
+	fmt.Fprintf(w, "\nIndex %s vs exact search over %d queries:\n", kind, c.Queries)
+	fmt.Fprintf(w, "  Recall@%d: %.1f%%\n", c.K, c.Recall*100)
+	fmt.Fprintf(w, "  Prediction difference: mean $%.2f, max $%.2f\n", c.MeanAbsDiff, c.MaxAbsDiff)
+	fmt.Fprintf(w, "  Query time: %v vs %v exact\n", c.IndexTime, c.ExactTime)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomCases returns n cases spread over the ranges of the public data,
+// with every tenth a copy of an earlier one so searches meet ties.
+func randomCases(n int, seed uint64) TrainingData {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	data := make(TrainingData, n)
+	for i := range data {
+		if i%10 == 9 {
+			data[i] = data[rng.IntN(i)]
+			continue
+		}
+		data[i].Input.TripDurationDays = 1 + rng.IntN(14)
+		data[i].Input.MilesTraveled = math.Round(rng.Float64()*1200*100) / 100
+		data[i].Input.TotalReceiptsAmount = math.Round(rng.Float64()*2500*100) / 100
+		data[i].ExpectedOutput = rng.Float64() * 2000
+	}
+	return data
+}
+
+// indexRecall is the mean fraction of the exact k nearest neighbors idx finds.
+func indexRecall(idx, exact neighborIndex, queries []featureVector, k int) float64 {
+	var sum float64
+	for _, q := range queries {
+		want := exact.search(nil, q, k)
+		bound := want[len(want)-1].Distance
+		found := 0
+		for _, nb := range idx.search(nil, q, k) {
+			if nb.Distance <= bound+1e-12 {
+				found++
+			}
+		}
+		sum += float64(min(found, len(want))) / float64(len(want))
+	}
+	return sum / float64(len(queries))
+}
+
+func TestApproximateIndexRecall(t *testing.T) {
+	data := randomCases(5000, 5)
+	queries := randomQueries(data, 300, 6)
+	metric := newMetric(metricEuclidean, data, nil)
+	exact := bruteIndex{data, metric}
+	tests := []struct {
+		config *IndexConfig
+		min    float64
+	}{
+		{&IndexConfig{Kind: indexHNSW, M: 16, EfConstruction: 200, EfSearch: 64}, 0.98},
+		{&IndexConfig{Kind: indexLSH, Tables: 8, Hashes: 4, BucketWidth: 0.2}, 0.9},
+	}
+	for _, tt := range tests {
+		idx := buildIndex(tt.config, data, metric)
+		for _, k := range []int{1, 10} {
+			if r := indexRecall(idx, exact, queries, k); r < tt.min {
+				t.Errorf("%s k=%d: recall %.3f, want at least %.2f", tt.config.Kind, k, r, tt.min)
+			}
+		}
+	}
+}
+
+func TestExactIndexesMatchBruteForce(t *testing.T) {
+	data := randomCases(2000, 1)
+	queries := randomQueries(data, 200, 2)
+	tests := []struct {
+		index  string
+		metric string
+	}{
+		{indexExact, metricEuclidean},
+		{indexExact, metricManhattan},
+		{indexExact, metricMahalanobis},
+		{indexKDTree, metricEuclidean},
+		{indexKDTree, metricManhattan},
+		{indexBallTree, metricEuclidean},
+		{indexBallTree, metricManhattan},
+		{indexBallTree, metricMahalanobis},
+		{indexVPTree, metricEuclidean},
+		{indexVPTree, metricManhattan},
+		{indexVPTree, metricMahalanobis},
+	}
+	for _, tt := range tests {
+		t.Run(tt.index+"/"+tt.metric, func(t *testing.T) {
+			metric := newMetric(tt.metric, data, nil)
+			idx := buildIndex(&IndexConfig{Kind: tt.index}, data, metric)
+			brute := bruteIndex{data, metric}
+			for _, k := range []int{1, 5, 37} {
+				for _, q := range queries {
+					want := brute.search(nil, q, k)
+					got := idx.search(nil, q, k)
+					if len(got) != len(want) {
+						t.Fatalf("k=%d query %v: got %d neighbors, want %d", k, q, len(got), len(want))
+					}
+					for i := range want {
+						// Ties may come back in another order, so compare
+						// distances, and check each case is at its distance.
+						if math.Abs(got[i].Distance-want[i].Distance) > 1e-9 {
+							t.Fatalf("k=%d query %v: neighbor %d at distance %g, want %g",
+								k, q, i, got[i].Distance, want[i].Distance)
+						}
+						if d := metric.distance(q, caseFeatures(data[got[i].Case])); math.Abs(d-got[i].Distance) > 1e-9 {
+							t.Fatalf("k=%d query %v: case %d reported at distance %g, is at %g",
+								k, q, got[i].Case, got[i].Distance, d)
+						}
+						if got[i].Output != data[got[i].Case].ExpectedOutput {
+							t.Fatalf("k=%d query %v: case %d has output %g, want %g",
+								k, q, got[i].Case, got[i].Output, data[got[i].Case].ExpectedOutput)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExactIndexesSmallData(t *testing.T) {
+	q := featureVector{5, 300, 800}
+	for _, kind := range []string{indexExact, indexKDTree, indexBallTree, indexVPTree} {
+		for _, n := range []int{0, 1, 3} {
+			data := randomCases(n, 3)
+			idx := buildIndex(&IndexConfig{Kind: kind}, data, newMetric(metricEuclidean, data, nil))
+			if got := idx.search(nil, q, 5); len(got) != n {
+				t.Errorf("%s over %d cases: got %d neighbors, want %d", kind, n, len(got), n)
+			}
+		}
+	}
+}
+
+func TestSearchAppendsToDst(t *testing.T) {
+	data := randomCases(100, 4)
+	metric := newMetric(metricEuclidean, data, nil)
+	head := Neighbor{Distance: -1, Case: -1}
+	for _, c := range []*IndexConfig{
+		{Kind: indexExact}, {Kind: indexKDTree}, {Kind: indexBallTree}, {Kind: indexVPTree},
+		{Kind: indexHNSW, M: 16, EfConstruction: 200, EfSearch: 64},
+		{Kind: indexLSH, Tables: 8, Hashes: 4, BucketWidth: 0.2},
+	} {
+		got := buildIndex(c, data, metric).search([]Neighbor{head}, featureVector{5, 300, 800}, 3)
+		if len(got) == 0 || got[0] != head {
+			t.Errorf("%s: search did not keep the neighbors already in dst", c.Kind)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of an async batch job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+func (s JobStatus) finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// Job is an async batch prediction.
+type Job struct {
+	mu         sync.Mutex
+	id         string
+	owner      string
+	policy     string
+	status     JobStatus
+	total      int
+	done       int
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	err        string
+
+	cases      []Query
+	expected   []float64
+	absError   float64 // summed over the scored cases: those done and not abstained
+	scored     int
+	results    []PredictionResponse
+	provenance Provenance
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	// updated is closed and replaced whenever the job's view changes, waking
+	// event streams.
+	updated chan struct{}
+}
+
+// JobRequest is the body of POST /jobs. ExpectedOutputs, when set, holds the
+// known reimbursement of each case, so the job reports its running mean
+// error as it goes, as an evaluation.
+type JobRequest struct {
+	Cases           []Query   `json:"cases"`
+	ExpectedOutputs []float64 `json:"expected_outputs,omitempty"`
+	Policy          string    `json:"policy,omitempty"`
+}
+
+// JobView is the JSON representation of a job's progress.
+type JobView struct {
+	ID         string     `json:"id"`
+	Policy     string     `json:"policy,omitempty"` // the policy predicting, unless the default
+	Status     JobStatus  `json:"status"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Progress   float64    `json:"progress"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	MeanError  *float64   `json:"mean_error,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ResultsURL string     `json:"results_url,omitempty"`
+}
+
+func (j *Job) view() JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := JobView{ID: j.id, Status: j.status, Total: j.total, Done: j.done, CreatedAt: j.createdAt, Error: j.err}
+	if j.total > 0 {
+		v.Progress = float64(j.done) / float64(j.total)
+	} else if j.status == JobSucceeded {
+		v.Progress = 1
+	}
+	if !j.startedAt.IsZero() {
+		t := j.startedAt
+		v.StartedAt = &t
+	}
+	if !j.finishedAt.IsZero() {
+		t := j.finishedAt
+		v.FinishedAt = &t
+	}
+	if j.policy != defaultPolicy {
+		v.Policy = j.policy
+	}
+	if j.scored > 0 {
+		e := j.absError / float64(j.scored)
+		v.MeanError = &e
+	}
+	if j.status == JobSucceeded {
+		v.ResultsURL = "/jobs/" + j.id + "/results"
+	}
+	return v
+}
+
+// changed wakes everyone waiting on the job's view. j.mu must be held.
+func (j *Job) changed() {
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+func (j *Job) finish(status JobStatus, errMsg string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.err = errMsg
+	j.finishedAt = time.Now()
+	j.cases = nil
+	j.expected = nil
+	j.changed()
+}
+
+// jobManager queues jobs for a fixed pool of workers and forgets finished
+// jobs after ttl.
+type jobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+	ttl   time.Duration
+	run   func(j *Job) error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newJobManager(workers, queueSize int, ttl time.Duration, run func(j *Job) error) *jobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &jobManager{
+		jobs:   map[string]*Job{},
+		queue:  make(chan *Job, queueSize),
+		ttl:    ttl,
+		run:    run,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	return m
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit queues a job predicting cases under policy, failing when the queue
+// is full. expected is nil or holds the known output of each case.
+func (m *jobManager) submit(owner, policy string, cases []Query, expected []float64) (*Job, error) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &Job{
+		id:        newJobID(),
+		owner:     owner,
+		policy:    policy,
+		status:    JobQueued,
+		total:     len(cases),
+		createdAt: time.Now(),
+		cases:     cases,
+		expected:  expected,
+		ctx:       ctx,
+		cancel:    cancel,
+		updated:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.expire(time.Now())
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- j:
+		return j, nil
+	default:
+		m.mu.Lock()
+		delete(m.jobs, j.id)
+		m.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("job queue is full")
+	}
+}
+
+func (m *jobManager) get(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	return m.jobs[id]
+}
+
+// expire drops finished jobs older than the ttl. m.mu must be held.
+func (m *jobManager) expire(now time.Time) {
+	for id, j := range m.jobs {
+		j.mu.Lock()
+		old := j.status.finished() && now.Sub(j.finishedAt) > m.ttl
+		j.mu.Unlock()
+		if old {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (m *jobManager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case j := <-m.queue:
+			m.process(j)
+		}
+	}
+}
+
+func (m *jobManager) process(j *Job) {
+	j.mu.Lock()
+	if j.status != JobQueued {
+		j.mu.Unlock()
+		return // canceled while queued
+	}
+	j.status = JobRunning
+	j.startedAt = time.Now()
+	j.changed()
+	j.mu.Unlock()
+
+	err := m.run(j)
+	switch {
+	case j.ctx.Err() != nil:
+		j.finish(JobCanceled, "")
+	case err != nil:
+		log.Printf("job %s failed: %v", j.id, err)
+		j.finish(JobFailed, err.Error())
+	default:
+		j.finish(JobSucceeded, "")
+	}
+}
+
+// close cancels all jobs and waits for the workers to exit.
+func (m *jobManager) close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// runJob predicts every case of j, checking for cancellation as it goes.
+func (s *Server) runJob(j *Job) error {
+	m := s.tenants[j.policy].model.Load()
+	if m == nil {
+		return fmt.Errorf("model is not loaded")
+	}
+	j.mu.Lock()
+	cases, expected := j.cases, j.expected
+	j.provenance = m.provenance(time.Now())
+	prov := j.provenance
+	j.mu.Unlock()
+
+	results := make([]PredictionResponse, len(cases))
+	absError, scored := 0.0, 0
+	for i, q := range cases {
+		r, err := s.predictOne(j.ctx, m, q, prov)
+		if j.ctx.Err() != nil {
+			return j.ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("case %d: %v", i, err)
+		}
+		results[i] = r
+		if expected != nil && !r.Abstained {
+			absError += math.Abs(r.Reimbursement - expected[i])
+			scored++
+		}
+		if (i+1)%100 == 0 || i+1 == len(cases) {
+			j.mu.Lock()
+			j.done = i + 1
+			j.absError, j.scored = absError, scored
+			j.changed()
+			j.mu.Unlock()
+		}
+	}
+
+	j.mu.Lock()
+	j.results = results
+	j.mu.Unlock()
+	return nil
+}
+
+// ownerOf names the principal that owns jobs created by r.
+func ownerOf(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// lookupJob finds the job named in the path, hiding other principals' jobs
+// from everyone but admins.
+func (s *Server) lookupJob(w http.ResponseWriter, r *http.Request) *Job {
+	j := s.jobs.get(r.PathValue("id"))
+	if j != nil {
+		p := principalFrom(r.Context())
+		if p != nil && j.owner != p.Name && !p.Scopes[scopeAdmin] {
+			j = nil
+		}
+	}
+	if j == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+	}
+	return j
+}
+
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	t := s.tenantFor(w, r, req.Policy)
+	if t == nil {
+		return
+	}
+	if req.ExpectedOutputs != nil && len(req.ExpectedOutputs) != len(req.Cases) {
+		writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("%d expected outputs for %d cases", len(req.ExpectedOutputs), len(req.Cases)))
+		return
+	}
+	if s.cfg.maxJobCases > 0 && len(req.Cases) > s.cfg.maxJobCases {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("job of %d cases exceeds the limit of %d", len(req.Cases), s.cfg.maxJobCases))
+		return
+	}
+
+	j, err := s.jobs.submit(ownerOf(r), t.name, req.Cases, req.ExpectedOutputs)
+	if err != nil {
+		w.Header().Set("Retry-After", "30")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSONResponse(w, http.StatusAccepted, j.view())
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if j := s.lookupJob(w, r); j != nil {
+		writeJSONResponse(w, http.StatusOK, j.view())
+	}
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	j := s.lookupJob(w, r)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	if j.status == JobQueued {
+		j.status = JobCanceled
+		j.finishedAt = time.Now()
+		j.cases = nil
+		j.expected = nil
+		j.changed()
+	}
+	j.mu.Unlock()
+	j.cancel()
+	writeJSONResponse(w, http.StatusOK, j.view())
+}
+
+// jobEventInterval is the least time between progress events on a job's
+// event stream, however fast the job advances; jobEventKeepalive is the most,
+// so proxies don't close a quiet stream.
+const (
+	jobEventInterval  = 250 * time.Millisecond
+	jobEventKeepalive = 15 * time.Second
+)
+
+// handleJobEvents streams a job's progress as server-sent events: a progress
+// event carrying the job's view when it starts and as it advances, then a
+// done event once it finishes, after which the stream ends.
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.lookupJob(w, r)
+	if j == nil {
+		return
+	}
+	rc := http.NewResponseController(w)
+	// The stream lasts as long as the job, not the server's write timeout.
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepalive := time.NewTicker(jobEventKeepalive)
+	defer keepalive.Stop()
+	for {
+		j.mu.Lock()
+		updated := j.updated
+		j.mu.Unlock()
+		v := j.view()
+		event := "progress"
+		if v.Status.finished() {
+			event = "done"
+		}
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		if err := rc.Flush(); err != nil || event == "done" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(jobEventInterval):
+		}
+		for waiting := true; waiting; {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updated:
+				waiting = false
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+				if rc.Flush() != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleJobResults downloads a finished job's predictions as JSON, or as
+// CSV with ?format=csv.
+func (s *Server) handleJobResults(w http.ResponseWriter, r *http.Request) {
+	j := s.lookupJob(w, r)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	status, results, prov := j.status, j.results, j.provenance
+	j.mu.Unlock()
+	if status != JobSucceeded {
+		writeError(w, http.StatusConflict, fmt.Sprintf("job is %s", status))
+		return
+	}
+
+	setProvenanceHeader(w, prov)
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSONResponse(w, http.StatusOK, BatchResponse{Predictions: results, Provenance: prov})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="job-%s.csv"`, j.id))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"trip_duration_days", "miles_traveled", "total_receipts_amount", "reimbursement", "warning"})
+	for _, p := range results {
+		warning := ""
+		if p.Warning != nil {
+			warning = p.Warning.Message
+		}
+		if p.Abstained {
+			warning = "cannot estimate"
+		}
+		cw.Write([]string{
+			strconv.Itoa(p.Input.TripDurationDays),
+			strconv.FormatFloat(p.Input.MilesTraveled, 'f', -1, 64),
+			strconv.FormatFloat(p.Input.TotalReceiptsAmount, 'f', -1, 64),
+			strconv.FormatFloat(p.Reimbursement, 'f', 2, 64),
+			warning,
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// kdTree is an exact neighbor index that splits the scaled feature space on
+// one axis at each node. The gap between a query and a node's splitting
+// plane along that axis bounds the distance to every case beyond it under
+// the Euclidean and Manhattan metrics, but not under Mahalanobis, whose axes
+// are rotated.
+type kdTree struct {
+	points  []featureVector
+	outputs []float64
+	metric  distanceMetric
+	order   []int32
+	nodes   []kdNode
+}
+
+type kdNode struct {
+	axis        int
+	split       float64 // raw feature value of the splitting plane
+	start, end  int
+	left, right int32 // -1 at leaves
+}
+
+// kdScales converts raw feature differences to the scaled units the metrics
+// use.
+var kdScales = featureVector{dayScale, mileScale, receiptScale}
+
+const kdLeafSize = 16
+
+func newKDTree(data TrainingData, metric distanceMetric) *kdTree {
+	t := &kdTree{
+		points:  make([]featureVector, len(data)),
+		outputs: make([]float64, len(data)),
+		metric:  metric,
+		order:   make([]int32, len(data)),
+	}
+	for i, c := range data {
+		t.points[i] = caseFeatures(c)
+		t.outputs[i] = c.ExpectedOutput
+		t.order[i] = int32(i)
+	}
+	if len(data) > 0 {
+		t.build(0, len(data))
+	}
+	return t
+}
+
+func (t *kdTree) build(start, end int) int32 {
+	idx := int32(len(t.nodes))
+	t.nodes = append(t.nodes, kdNode{start: start, end: end, left: -1, right: -1})
+	ids := t.order[start:end]
+	if len(ids) <= kdLeafSize {
+		return idx
+	}
+
+	// Split the axis with the widest scaled spread at its median.
+	axis, widest := 0, -1.0
+	for a := range kdScales {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, id := range ids {
+			lo = math.Min(lo, t.points[id][a])
+			hi = math.Max(hi, t.points[id][a])
+		}
+		if spread := (hi - lo) / kdScales[a]; spread > widest {
+			axis, widest = a, spread
+		}
+	}
+	if widest == 0 {
+		return idx // all cases coincide
+	}
+	slices.SortFunc(ids, func(a, b int32) int { return compareDist(t.points[a][axis], t.points[b][axis]) })
+	mid := len(ids) / 2
+
+	t.nodes[idx].axis = axis
+	t.nodes[idx].split = t.points[ids[mid]][axis]
+	left := t.build(start, start+mid)
+	right := t.build(start+mid, end)
+	t.nodes[idx].left, t.nodes[idx].right = left, right
+	return idx
+}
+
+func (t *kdTree) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	k = min(k, len(t.points))
+	if k == 0 {
+		return dst
+	}
+	return append(dst, t.visit(dst[len(dst):], 0, q, k)...)
+}
+
+func (t *kdTree) visit(nearest []Neighbor, node int32, q featureVector, k int) []Neighbor {
+	n := &t.nodes[node]
+	if n.left < 0 {
+		for _, id := range t.order[n.start:n.end] {
+			nearest = insertNeighbor(nearest, k, Neighbor{Distance: t.metric.distance(q, t.points[id]), Output: t.outputs[id], Case: int(id)})
+		}
+		return nearest
+	}
+	near, far := n.left, n.right
+	if q[n.axis] >= n.split {
+		near, far = far, near
+	}
+	nearest = t.visit(nearest, near, q, k)
+	if gap := math.Abs(q[n.axis]-n.split) / kdScales[n.axis]; len(nearest) < k || gap < nearest[len(nearest)-1].Distance {
+		nearest = t.visit(nearest, far, q, k)
+	}
+	return nearest
+}
@@ -0,0 +1,199 @@
+//go:build lambda
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// lambdaFlagsEnv holds the model flags of a Lambda function, which is
+// started without arguments, e.g. "-model-tag v3 -registry /var/task/models".
+const lambdaFlagsEnv = "REIMBURSEMENT_FLAGS"
+
+// lambdaRuntimeVersion prefixes the paths of the Lambda runtime API.
+const lambdaRuntimeVersion = "/2018-06-01/runtime"
+
+// runLambda serves invocations from the Lambda runtime API when running as
+// a Lambda function's bootstrap, and reports whether it did. Outside Lambda
+// the binary is the usual CLI. The model is built once, in the init phase,
+// so invocations only predict. Build the function's bootstrap for a
+// provided.al2023 runtime with:
+//
+//	GOOS=linux go build -tags lambda -o bootstrap
+func runLambda() bool {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		return false
+	}
+	rt := &lambdaRuntime{base: "http://" + api + lambdaRuntimeVersion}
+
+	fs := flag.NewFlagSet("lambda", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	err := parseFlags(fs, strings.Fields(os.Getenv(lambdaFlagsEnv)))
+	var p *Predictor
+	if err == nil {
+		p, err = model.build()
+	}
+	if err != nil {
+		rt.fail("/init/error", err)
+		log.Fatalf("init: %v", err)
+	}
+	log.Printf("loaded model %s (%d cases)", p.Version, len(p.Training))
+
+	for {
+		id, event, err := rt.next()
+		if err != nil {
+			log.Fatalf("fetching invocation: %v", err)
+		}
+		resp, err := lambdaInvoke(p, event)
+		if err != nil {
+			rt.fail("/invocation/"+id+"/error", err)
+			continue
+		}
+		if err := rt.post("/invocation/"+id+"/response", resp); err != nil {
+			log.Printf("invocation %s: %v", id, err)
+		}
+	}
+}
+
+// lambdaRuntime is a client of the Lambda runtime API.
+type lambdaRuntime struct {
+	base string
+}
+
+// next blocks until the next invocation and returns its request ID and event.
+func (rt *lambdaRuntime) next() (string, []byte, error) {
+	resp, err := http.Get(rt.base + "/invocation/next")
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	event, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Header.Get("Lambda-Runtime-Aws-Request-Id"), event, nil
+}
+
+func (rt *lambdaRuntime) post(path string, body []byte) error {
+	resp, err := http.Post(rt.base+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("posting %s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+// fail reports err as an init or invocation error.
+func (rt *lambdaRuntime) fail(path string, err error) {
+	body, _ := json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "Error"})
+	if err := rt.post(path, body); err != nil {
+		log.Printf("reporting error: %v", err)
+	}
+}
+
+// lambdaProxyEvent is the part of an API Gateway or function URL event
+// carrying the HTTP request body.
+type lambdaProxyEvent struct {
+	Body            *string `json:"body"`
+	IsBase64Encoded bool    `json:"isBase64Encoded"`
+}
+
+// lambdaProxyResponse is the HTTP response to a proxy event.
+type lambdaProxyResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// lambdaInvoke answers an event that is a Query or BatchRequest, either
+// invoked directly or as the body of an HTTP request through API Gateway or
+// a function URL. Bad direct requests fail the invocation; bad HTTP requests
+// are answered with status 400.
+func lambdaInvoke(p *Predictor, event []byte) ([]byte, error) {
+	var proxy lambdaProxyEvent
+	if err := json.Unmarshal(event, &proxy); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	if proxy.Body == nil {
+		return lambdaPredict(p, event)
+	}
+
+	payload := []byte(*proxy.Body)
+	if proxy.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(*proxy.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 body: %v", err)
+		}
+		payload = decoded
+	}
+	status := http.StatusOK
+	body, err := lambdaPredict(p, payload)
+	if err != nil {
+		status = http.StatusBadRequest
+		body, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return json.Marshal(lambdaProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	})
+}
+
+// lambdaPredict answers a JSON Query with a PredictionResponse, or a
+// BatchRequest with a BatchResponse.
+func lambdaPredict(p *Predictor, payload []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	prov := p.Provenance(time.Now())
+	predict := func(q Query) (PredictionResponse, error) {
+		y, err := predictChecked(context.Background(), p, q)
+		return PredictionResponse{Input: q, Reimbursement: y}, err
+	}
+
+	if _, ok := fields["cases"]; ok {
+		var req BatchRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid batch request: %v", err)
+		}
+		resp := BatchResponse{Predictions: make([]PredictionResponse, len(req.Cases)), Provenance: prov}
+		for i, q := range req.Cases {
+			var err error
+			if resp.Predictions[i], err = predict(q); err != nil {
+				return nil, fmt.Errorf("case %d: %v", i, err)
+			}
+		}
+		return json.Marshal(resp)
+	}
+
+	var q Query
+	if err := json.Unmarshal(payload, &q); err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	resp, err := predict(q)
+	if err != nil {
+		return nil, err
+	}
+	resp.Provenance = &prov
+	return json.Marshal(resp)
+}
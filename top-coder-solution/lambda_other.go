@@ -0,0 +1,7 @@
+//go:build !lambda
+
+package main
+
+// runLambda serves Lambda invocations in binaries built with the lambda tag.
+// Other builds are never Lambda functions.
+func runLambda() bool { return false }
@@ -0,0 +1,119 @@
+package main
+
+import "math"
+
+// linearModel is an ordinary least squares fit of the output to an
+// intercept and the features of a feature set, by default the three inputs.
+// It extrapolates more sensibly than KNN far from the training data, where
+// every neighbor is distant.
+type linearModel struct {
+	Intercept float64
+	Coef      []float64 // one per feature
+	features  featureSet
+}
+
+// regressors returns the features of in the model is fitted on, unscaled.
+func regressors(features featureSet, in featureVector) featurePoint {
+	if features == nil {
+		return featurePoint{in[0], in[1], in[2]}
+	}
+	var x featurePoint
+	for i, f := range features {
+		x[i] = f.value(in)
+	}
+	return x
+}
+
+// fitLinear fits a linear model over features (nil for the default set) to
+// training by solving the normal equations, weighting each case for recency
+// by r.
+func fitLinear(training TrainingData, features featureSet, r *recencyDecay) *linearModel {
+	dims := len(featureNames)
+	if features != nil {
+		dims = len(features)
+	}
+	eq := newNormalEquations(dims + 1)
+	x := make([]float64, dims+1)
+	x[0] = 1
+	for _, c := range training {
+		v := regressors(features, caseFeatures(c))
+		copy(x[1:], v[:dims])
+		eq.add(x, c.ExpectedOutput, r.weight(c))
+	}
+	beta := eq.solve()
+	return &linearModel{Intercept: beta[0], Coef: beta[1:len(beta):len(beta)], features: features}
+}
+
+// normalEquations accumulates the augmented normal equations of a weighted
+// least squares fit whose first regressor is the intercept.
+type normalEquations struct {
+	a [][]float64 // n rows of n coefficients and the right-hand side
+}
+
+func newNormalEquations(n int) *normalEquations {
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	return &normalEquations{a}
+}
+
+// add adds an observation y of the regressors x with weight w.
+func (e *normalEquations) add(x []float64, y, w float64) {
+	n := len(e.a)
+	for i := range n {
+		for j := range n {
+			e.a[i][j] += w * x[i] * x[j]
+		}
+		e.a[i][n] += w * x[i] * y
+	}
+}
+
+// solve returns the fitted coefficients, consuming e. A tiny ridge on all
+// but the intercept keeps the equations solvable when a regressor is
+// constant; coefficients that remain undetermined are zero.
+func (e *normalEquations) solve() []float64 {
+	a, n := e.a, len(e.a)
+	for i := 1; i < n; i++ {
+		a[i][i] += 1e-9 * (a[i][i] + 1)
+	}
+
+	// Gaussian elimination with partial pivoting.
+	for col := range n {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		if a[col][col] == 0 {
+			continue
+		}
+		for r := range n {
+			if r != col {
+				f := a[r][col] / a[col][col]
+				for c := col; c <= n; c++ {
+					a[r][c] -= f * a[col][c]
+				}
+			}
+		}
+	}
+
+	beta := make([]float64, n)
+	for i := range n {
+		if a[i][i] != 0 {
+			beta[i] = a[i][n] / a[i][i]
+		}
+	}
+	return beta
+}
+
+func (m *linearModel) predict(v featureVector) float64 {
+	x := regressors(m.features, v)
+	y := m.Intercept
+	for i, c := range m.Coef {
+		y += c * x[i]
+	}
+	return y
+}
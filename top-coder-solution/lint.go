@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// Lint severities, most severe first.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// severityRank orders severities; higher is more severe.
+var severityRank = map[string]int{severityInfo: 0, severityWarning: 1, severityError: 2}
+
+// LintFinding is one problem found in a case file.
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Case     int    `json:"case"`
+	Message  string `json:"message"`
+}
+
+// LintReport is the result of linting a case file.
+type LintReport struct {
+	Path     string         `json:"path"`
+	Cases    int            `json:"cases"`
+	Counts   map[string]int `json:"counts"` // findings by severity
+	Findings []LintFinding  `json:"findings"`
+}
+
+// outlierMAD is how many median absolute deviations from the median
+// residual a case's residual from the linear trend may lie before its output
+// is reported as suspicious.
+const outlierMAD = 6
+
+// lintCases checks cases for values that cannot be right (non-finite or
+// negative inputs and outputs, outputs for zero-day trips), duplicated
+// inputs, and outputs far off the linear trend of the other cases.
+func lintCases(cases TrainingData) []LintFinding {
+	var findings []LintFinding
+	report := func(severity, check string, i int, format string, args ...any) {
+		findings = append(findings, LintFinding{severity, check, i, fmt.Sprintf(format, args...)})
+	}
+
+	var plausible []int // cases the linear trend is fitted to
+	for i, c := range cases {
+		in := c.Input
+		f := caseFeatures(c)
+		finite := true
+		for j, v := range [...]float64{f[0], f[1], f[2], c.ExpectedOutput} {
+			name := "output"
+			if j < len(featureNames) {
+				name = featureNames[j]
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				report(severityError, "non-finite", i, "%s is %g", name, v)
+				finite = false
+			} else if v < 0 {
+				report(severityError, "negative", i, "%s is negative (%g)", name, v)
+			}
+		}
+		if !finite {
+			continue
+		}
+		switch {
+		case in.TripDurationDays == 0 && c.ExpectedOutput != 0:
+			report(severityError, "impossible", i, "0-day trip has output %.2f", c.ExpectedOutput)
+		case in.TripDurationDays > 0 && c.ExpectedOutput == 0:
+			report(severityWarning, "suspicious-output", i, "%d-day trip has zero output", in.TripDurationDays)
+		case in.TripDurationDays > 0:
+			plausible = append(plausible, i)
+		}
+	}
+
+	for i, matches := range exactMatches(cases) {
+		for _, j := range matches {
+			if j >= i {
+				break
+			}
+			if math.Abs(cases[i].ExpectedOutput-cases[j].ExpectedOutput) < 0.01 {
+				report(severityInfo, "duplicate", i, "duplicates case %d", j)
+			} else {
+				report(severityWarning, "conflicting-duplicate", i, "same inputs as case %d but output %.2f instead of %.2f",
+					j, cases[i].ExpectedOutput, cases[j].ExpectedOutput)
+			}
+			break // earlier duplicates are reported against case j
+		}
+	}
+
+	if len(plausible) > 0 {
+		fitted := make(TrainingData, len(plausible))
+		for j, i := range plausible {
+			fitted[j] = cases[i]
+		}
+		model := fitLinear(fitted, nil, nil)
+		residuals := make([]float64, len(fitted))
+		for j, c := range fitted {
+			residuals[j] = c.ExpectedOutput - model.predict(caseFeatures(c))
+		}
+		median, mad := medianMAD(slices.Clone(residuals))
+		for j, i := range plausible {
+			if r := residuals[j] - median; mad > 0 && math.Abs(r) > outlierMAD*mad {
+				report(severityWarning, "suspicious-output", i, "output %.2f is $%.2f off the linear trend (typical deviation $%.2f)",
+					cases[i].ExpectedOutput, r, mad)
+			}
+		}
+	}
+
+	slices.SortStableFunc(findings, func(a, b LintFinding) int {
+		if r := severityRank[b.Severity] - severityRank[a.Severity]; r != 0 {
+			return r
+		}
+		return a.Case - b.Case
+	})
+	return findings
+}
+
+// medianMAD returns the median of values and their median absolute
+// deviation from it. values is reordered.
+func medianMAD(values []float64) (float64, float64) {
+	slices.Sort(values)
+	median := values[len(values)/2]
+	dev := make([]float64, len(values))
+	for i, v := range values {
+		dev[i] = math.Abs(v - median)
+	}
+	slices.Sort(dev)
+	return median, dev[len(dev)/2]
+}
+
+func runLintData(args []string) error {
+	fs := flag.NewFlagSet("lint-data", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	failOn := fs.String("fail-on", severityError, "exit nonzero on findings of this severity or worse: error, warning, info or never")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	threshold, ok := severityRank[*failOn]
+	if !ok && *failOn != "never" {
+		return fmt.Errorf("unknown -fail-on severity %q (want error, warning, info or never)", *failOn)
+	}
+	if !ok {
+		threshold = math.MaxInt
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{defaultDataPath}
+	}
+
+	var reports []LintReport
+	failed := 0
+	for _, path := range paths {
+		cases, err := loadTrainingData(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", path, err)
+		}
+		r := LintReport{Path: path, Cases: len(cases), Counts: map[string]int{}, Findings: lintCases(cases)}
+		for _, f := range r.Findings {
+			r.Counts[f.Severity]++
+			if severityRank[f.Severity] >= threshold {
+				failed++
+			}
+		}
+		reports = append(reports, r)
+	}
+
+	if *asJSON {
+		if err := writeJSON(os.Stdout, reports); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range reports {
+			printLintReport(os.Stdout, r)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d findings at or above %s severity", failed, *failOn)
+	}
+	return nil
+}
+
+func printLintReport(w io.Writer, r LintReport) {
+	fmt.Fprintf(w, "%s: %d cases, %d errors, %d warnings, %d info\n", r.Path, r.Cases,
+		r.Counts[severityError], r.Counts[severityWarning], r.Counts[severityInfo])
+	if len(r.Findings) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tCASE\tCHECK\tMESSAGE")
+	for _, f := range r.Findings {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", f.Severity, f.Case, f.Check, f.Message)
+	}
+	tw.Flush()
+}
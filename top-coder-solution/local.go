@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Losses the local regression minimizes over a query's neighbors.
+const (
+	localLossSquared = "squared" // weighted least squares
+	localLossHuber   = "huber"   // squared for small residuals, absolute beyond huberTuning scales
+)
+
+const (
+	// localMinNeighbors is the fewest neighbors a local fit uses, whatever
+	// k is, so that its four coefficients are well determined.
+	localMinNeighbors = 20
+	// huberTuning is the Huber threshold in robust standard deviations of
+	// the residuals, the usual choice for 95% efficiency on normal noise.
+	huberTuning = 1.345
+	// huberIterations bounds the reweighting rounds of a Huber fit.
+	huberIterations = 20
+)
+
+func validateLocalLoss(loss string) error {
+	switch loss {
+	case "", localLossSquared, localLossHuber:
+		return nil
+	}
+	return fmt.Errorf("unknown local regression loss %q (want %s or %s)", loss, localLossSquared, localLossHuber)
+}
+
+// localModel answers each query with a linear fit of the outputs of its
+// nearest neighbors on their inputs, weighted by inverse distance, evaluated
+// at the query. With the Huber loss the fit is refitted with neighbors whose
+// residuals are large downweighted, so a mislabeled neighbor bends it less.
+type localModel struct {
+	hp Hyperparameters
+	p  *Predictor // searches the neighbors
+}
+
+// localFit is a local regression at one query.
+type localFit struct {
+	Neighbors  int
+	Beta       []float64 // intercept, then the slope per day, mile and receipt dollar
+	Downweight int       // neighbors the Huber loss downweighted
+}
+
+func (m *localModel) Fit(training TrainingData) {
+	hp := m.hp
+	hp.Model, hp.Overrides = "", nil
+	m.p = NewPredictor(training, hp)
+}
+
+func (m *localModel) fit(q Query) localFit {
+	v := q.features()
+	pool := m.p.pool(v)
+	neighbors := m.p.searcher(v).search(nil, v, max(m.hp.K, localMinNeighbors))
+	xs := make([][]float64, len(neighbors))
+	ys := make([]float64, len(neighbors))
+	base := make([]float64, len(neighbors))
+	for i, n := range neighbors {
+		c := caseFeatures(pool[n.Case])
+		// Centered on the query, so the intercept is the prediction.
+		xs[i] = []float64{1, c[0] - v[0], c[1] - v[1], c[2] - v[2]}
+		ys[i], base[i] = n.Output, inverseDistance(n)
+	}
+	beta, downweighted := fitLocal(xs, ys, base, m.hp.LocalLoss)
+	return localFit{Neighbors: len(neighbors), Beta: beta, Downweight: downweighted}
+}
+
+// fitLocal fits ys on xs by weighted least squares with weights base, or
+// with the Huber loss by iteratively reweighted least squares, rescaling
+// the threshold each round to the median absolute deviation of the
+// residuals. It returns the coefficients and the number of observations
+// downweighted in the final round.
+func fitLocal(xs [][]float64, ys, base []float64, loss string) ([]float64, int) {
+	const regressors = 4
+	if len(ys) == 0 {
+		return make([]float64, regressors), 0
+	}
+	w := append([]float64(nil), base...)
+	residuals := make([]float64, len(ys))
+	for round := 0; ; round++ {
+		eq := newNormalEquations(regressors)
+		for i, x := range xs {
+			eq.add(x, ys[i], w[i])
+		}
+		beta := eq.solve()
+		if loss != localLossHuber || round == huberIterations {
+			return beta, countDownweighted(w, base)
+		}
+		for i, x := range xs {
+			residuals[i] = ys[i] - dot(beta, x)
+		}
+		_, mad := medianMAD(append([]float64(nil), residuals...))
+		threshold := huberTuning * 1.4826 * mad
+		if !(threshold > 0) {
+			return beta, countDownweighted(w, base)
+		}
+		changed := false
+		for i, r := range residuals {
+			next := base[i] * min(1, threshold/math.Abs(r))
+			changed = changed || math.Abs(next-w[i]) > 1e-9*base[i]
+			w[i] = next
+		}
+		if !changed {
+			return beta, countDownweighted(w, base)
+		}
+	}
+}
+
+func countDownweighted(w, base []float64) int {
+	n := 0
+	for i := range w {
+		if w[i] < base[i] {
+			n++
+		}
+	}
+	return n
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func (m *localModel) Predict(q Query) float64 {
+	return m.fit(q).Beta[0]
+}
+
+func (m *localModel) Explain(q Query) ModelExplanation {
+	f := m.fit(q)
+	loss := m.hp.LocalLoss
+	if loss == "" {
+		loss = localLossSquared
+	}
+	steps := []string{
+		fmt.Sprintf("linear fit over %d nearest neighbors, %s loss", f.Neighbors, loss),
+		fmt.Sprintf("per day: %.2f, per mile: %.4f, per receipt dollar: %.4f", f.Beta[1], f.Beta[2], f.Beta[3]),
+	}
+	if loss == localLossHuber {
+		steps = append(steps, fmt.Sprintf("downweighted %d neighbors as outliers", f.Downweight))
+	}
+	return ModelExplanation{Model: modelLocal, Prediction: f.Beta[0], Steps: steps}
+}
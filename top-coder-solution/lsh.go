@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+)
+
+// lshIndex generates neighbor candidates with locality-sensitive hashing and
+// re-ranks them exactly. Each of its tables hashes the scaled features with
+// several random projections quantized to buckets of a fixed width (the
+// p-stable scheme of Datar et al., 2004), so nearby cases tend to share a
+// bucket in at least one table. It stores only bucket membership and reads
+// features from the training data itself, keeping it far smaller than a
+// graph or tree index, and usable over memory-mapped data.
+type lshIndex struct {
+	data   TrainingData
+	tables []lshTable
+	width  float64
+
+	scratch sync.Pool // *[]uint32 visit generations, with the generation last
+}
+
+type lshTable struct {
+	projections [][3]float64
+	offsets     []float64
+	buckets     map[uint64][]int32
+}
+
+// lshSeed fixes the projections so the same data always builds the same index.
+const lshSeed = 0x15b
+
+func newLSH(data TrainingData, tables, hashes int, width float64) *lshIndex {
+	x := &lshIndex{data: data, tables: make([]lshTable, tables), width: width}
+	x.scratch.New = func() any {
+		s := make([]uint32, len(data)+1)
+		return &s
+	}
+	rng := rand.New(rand.NewPCG(lshSeed, uint64(len(data))))
+	for t := range x.tables {
+		tab := &x.tables[t]
+		tab.buckets = map[uint64][]int32{}
+		for range hashes {
+			tab.projections = append(tab.projections, [3]float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()})
+			tab.offsets = append(tab.offsets, rng.Float64()*width)
+		}
+		for i, c := range data {
+			key := tab.key(caseFeatures(c), width)
+			tab.buckets[key] = append(tab.buckets[key], int32(i))
+		}
+	}
+	return x
+}
+
+// key hashes v, in raw feature units, to its bucket in the table.
+func (t *lshTable) key(v featureVector, width float64) uint64 {
+	scaled := [3]float64{v[0] / dayScale, v[1] / mileScale, v[2] / receiptScale}
+	h := uint64(14695981039346656037) // FNV-1a over the quantized projections
+	for i, a := range t.projections {
+		dot := a[0]*scaled[0] + a[1]*scaled[1] + a[2]*scaled[2]
+		q := int64(math.Floor((dot + t.offsets[i]) / width))
+		for b := 0; b < 64; b += 8 {
+			h ^= uint64(q>>b) & 0xff
+			h *= 1099511628211
+		}
+	}
+	return h
+}
+
+func (x *lshIndex) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	k = min(k, len(x.data))
+	sp := x.scratch.Get().(*[]uint32)
+	defer x.scratch.Put(sp)
+	seen := *sp
+	gen := seen[len(seen)-1] + 1
+	if gen == 0 {
+		clear(seen)
+		gen = 1
+	}
+	seen[len(seen)-1] = gen
+
+	nearest := dst[len(dst):]
+	candidates := 0
+	for t := range x.tables {
+		for _, i := range x.tables[t].buckets[x.tables[t].key(q, x.width)] {
+			if seen[i] == gen {
+				continue
+			}
+			seen[i] = gen
+			candidates++
+			nearest = x.rank(nearest, q, int(i), k)
+		}
+	}
+	if candidates < k {
+		// Too few collisions to fill the neighbor list: fall back to a full
+		// scan rather than predicting from fewer than k neighbors.
+		nearest = nearest[:0]
+		for i := range x.data {
+			nearest = x.rank(nearest, q, i, k)
+		}
+	}
+	return append(dst, nearest...)
+}
+
+// rank inserts case i into the sorted neighbors nearest.
+func (x *lshIndex) rank(nearest []Neighbor, q featureVector, i, k int) []Neighbor {
+	c := x.data[i]
+	d := calculateDistance(int(q[0]), q[1], q[2], c.Input.TripDurationDays, c.Input.MilesTraveled, c.Input.TotalReceiptsAmount)
+	return insertNeighbor(nearest, k, Neighbor{Distance: d, Output: c.ExpectedOutput, Case: i})
+}
@@ -1,85 +1,339 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"math"
 	"os"
-	"sort"
-	"strconv"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
 )
 
 type TestCase struct {
-	Input struct {
-		TripDurationDays    int     `json:"trip_duration_days"`
-		MilesTraveled       float64 `json:"miles_traveled"`
-		TotalReceiptsAmount float64 `json:"total_receipts_amount"`
-	} `json:"input"`
-	ExpectedOutput float64 `json:"expected_output"`
+	Input          Query    `json:"input"`
+	ExpectedOutput float64  `json:"expected_output"`
+	Timestamp      caseTime `json:"timestamp,omitempty"` // when the case was recorded, if known
 }
 
 type Neighbor struct {
 	Distance float64
 	Output   float64
+	Case     int // index of the training case, where the search tracks it
 }
 
 type TrainingData []TestCase
 
+// command is a subcommand: its handler, which receives the arguments
+// following the subcommand name, the arguments it takes after its flags, and
+// a one-line summary for help.
+type command struct {
+	run     func(args []string) error
+	args    string
+	summary string
+}
+
+// commands maps subcommand names to their commands. It is filled in by init
+// because the commands' flag parsing looks up their help here.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"predict":           {runPredict, "<trip_duration_days> <miles_traveled> <total_receipts_amount>", "predict the reimbursement of one trip"},
+		"batch":             {runBatch, "", "predict the reimbursement of every case in a file"},
+		"generate-results":  {runGenerateResults, "", "predict the private cases in order as private_results.txt for submission"},
+		"eval":              {runEval, "", "measure prediction error on labelled cases or by cross-validation"},
+		"stats":             {runStats, "[cases.json ...]", "summarize the inputs and outputs of case files"},
+		"rescore":           {runRescore, "", "re-predict historical cases, each under the policy version in force when it was recorded, and total the change from the amounts paid"},
+		"train":             {runTrain, "", "register the current model and its data under a version tag"},
+		"models":            {runModels, "", "list the registered model versions"},
+		"bake":              {runBake, "", "write a registered model version for -tags baked builds to embed"},
+		"serve":             {runServe, "", "serve predictions over HTTP"},
+		"worker":            {runWorker, "", "predict batch jobs taken from a NATS queue"},
+		"openapi":           {runOpenAPI, "", "print the OpenAPI spec of the HTTP API"},
+		"tune":              {runTune, "", "search neighbor counts and metrics by cross-validation"},
+		"sweep":             {runSweep, "", "predict a grid of inputs as CSV"},
+		"compare-models":    {runCompareModels, "<trip_duration_days> <miles_traveled> <total_receipts_amount>", "compare models' errors and their predictions for one trip"},
+		"importance":        {runImportance, "", "measure how much each input matters to each model"},
+		"pdp":               {runPDP, "", "compute partial dependence and ICE curves of one input"},
+		"efficiency":        {runEfficiency, "", "trace predictions across miles per day"},
+		"boundaries":        {runBoundaries, "", "probe predictions either side of segment thresholds"},
+		"check-properties":  {runCheckProperties, "", "check predictions are monotone across a grid of inputs"},
+		"gate":              {runGate, "", "fail when the model's error exceeds limits, for CI"},
+		"canary":            {runCanary, "", "compare a candidate model version with the baseline on holdout cases"},
+		"model-diff":        {runModelDiff, "", "report how a model version's predictions moved from the baseline's, by segment"},
+		"doctor":            {runDoctor, "", "diagnose the training data, config, registry and writable directories, suggesting fixes"},
+		"selftest":          {runSelfTest, "", "check the model reproduces embedded known cases, to confirm a deployment is healthy"},
+		"gen-golden":        {runGenGolden, "", "record predictions of random inputs as a golden file"},
+		"verify-golden":     {runVerifyGolden, "", "check predictions still match a golden file"},
+		"drift":             {runDrift, "<old.json> <new.json>", "test whether two case files differ in distribution"},
+		"lint-data":         {runLintData, "[cases.json ...]", "find suspicious cases in case files"},
+		"cluster":           {runCluster, "", "cluster the training data with k-means"},
+		"discover-segments": {runDiscoverSegments, "", "derive a segmentation config from a regression tree"},
+		"extract-rules":     {runExtractRules, "", "describe the data as readable rules"},
+		"curves":            {runCurves, "", "fit reimbursement curves per trip-length segment"},
+		"day-bonuses":       {runDayBonuses, "", "estimate bonuses for particular trip lengths"},
+		"estimate-tiers":    {runEstimateTiers, "", "estimate mileage rate tiers as a rule config"},
+		"per-diem":          {runPerDiem, "", "estimate per diem rates by trip length as a rule config"},
+		"synth":             {runSynth, "", "generate inputs distributed like the training data"},
+		"convert":           {runConvert, "", "convert case files between JSON, CSV and xlsx"},
+		"stratify":          {runStratify, "", "split a case file into train and test sets preserving its duration and receipt bands"},
+		"pack":              {runPack, "", "pack training data into a memory-mappable file"},
+		"bench-index":       {runBenchIndex, "", "benchmark the neighbor search indexes"},
+		"export-plots":      {runExportPlots, "", "write plots of predictions and residuals"},
+		"verify-audit":      {runVerifyAudit, "<oldest.jsonl> [... <newest.jsonl>]", "verify the hash chain of audit logs"},
+		"help":              {runHelp, "[command]", "list the commands, or describe one command's flags"},
+	}
+}
+
+// progName is the name the program was run as.
+func progName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// printUsage lists the ways to run the program and its commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage:\n  %s <trip_duration_days> <miles_traveled> <total_receipts_amount>\n", progName())
+	fmt.Fprintf(w, "  %s=<days> %s=<miles> %s=<receipts> %s\n", envTripDays, envMiles, envReceipts, progName())
+	fmt.Fprintf(w, "  %s <command> [flags] [arguments]\n\nCommands:\n", progName())
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun '%s help <command>' for a command's flags.\n", progName())
+}
+
+// commandUsage returns the usage function of a command's flags.
+func commandUsage(fs *flag.FlagSet, cmd command) func() {
+	return func() {
+		usage := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", progName(), fs.Name(), cmd.args))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s%s.\n\nFlags:\n", usage, strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+		fs.PrintDefaults()
+	}
+}
+
+func runHelp(args []string) error {
+	switch len(args) {
+	case 0:
+		printUsage(os.Stdout)
+		return nil
+	case 1:
+		cmd, ok := commands[args[0]]
+		if !ok || args[0] == "help" {
+			break
+		}
+		return cmd.run([]string{"-help"})
+	}
+	printUsage(os.Stderr)
+	return &exitError{code: 2}
+}
+
+// exitAbstained is the exit status of a prediction withheld for low
+// confidence.
+const exitAbstained = 3
+
+// exitError ends a command with a specific exit status. The command has
+// already reported why, so main prints nothing further.
+type exitError struct{ code int }
+
+func (e *exitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Fprintf(os.Stderr, "Usage: %s <trip_duration_days> <miles_traveled> <total_receipts_amount>\n", os.Args[0])
-		os.Exit(1)
+	if runLambda() {
+		return
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			err := cmd.run(os.Args[2:])
+			reportMemory(os.Stderr)
+			reportTimings(os.Stderr)
+			if err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					return
+				}
+				var exit *exitError
+				if errors.As(err, &exit) {
+					os.Exit(exit.code)
+				}
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
 
-	tripDays, err := strconv.Atoi(os.Args[1])
+	if len(os.Args) == 2 && (os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help") {
+		printUsage(os.Stdout)
+		return
+	}
+	level, dryRun, timings, args := cutPositionalFlags(os.Args[1:])
+	args, err := queryArgs(args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing trip_duration_days: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	miles, err := strconv.ParseFloat(os.Args[2], 64)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing miles_traveled: %v\n", err)
+	if len(args) != 3 && !(dryRun && len(args) == 0) {
+		printUsage(os.Stderr)
 		os.Exit(1)
 	}
 
-	receipts, err := strconv.ParseFloat(os.Args[3], 64)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing total_receipts_amount: %v\n", err)
+	var q Query
+	if len(args) > 0 {
+		if q, err = parseQuery(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if timings {
+		startTimings()
+		timer.end(phaseParse)
+	}
+
+	diag := newDiagnostics(os.Stderr, level)
+	if dataRequired() {
+		fmt.Fprintf(os.Stderr, "Error: this binary reads no default training data; predict with '%s predict -data PATH' or '-model-tag TAG'\n", progName())
 		os.Exit(1)
 	}
+	if isBaked() {
+		if err := predictBaked(q, args, dryRun, diag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		reportTimings(os.Stderr)
+		return
+	}
 
 	// Load training data
-	trainingData, err := loadTrainingData()
+	trainingData, err := loadTrainingData(defaultDataPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading training data: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error loading training data: %v\nRun '%s doctor' to diagnose.\n", err, progName())
 		os.Exit(1)
 	}
+	timer.end(phaseLoad)
+	diag.printf(verbosityInfo, "loaded %d cases from %s in %v\n", len(trainingData), defaultDataPath, diag.elapsed())
+	if dryRun {
+		diag.printf(verbosityNormal, "dry run: %s\n", dryRunSummary(args, fmt.Sprintf("%d cases load from %s", len(trainingData), defaultDataPath)))
+		reportTimings(os.Stderr)
+		return
+	}
 
 	// Find nearest neighbors and predict using weighted average
-	reimbursement := predictWeightedKNN(tripDays, miles, receipts, trainingData, 5)
-	fmt.Printf("%.2f\n", reimbursement)
+	reimbursement := predictWeightedKNN(q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, trainingData, defaultK, aggregateMean)
+	if diag.enabled(verbosityDebug) {
+		printNeighbors(os.Stderr, q, trainingData, defaultK)
+	}
+	diag.printf(verbosityInfo, "predicted with k=%d in %v total\n", defaultK, diag.elapsed())
+	fmt.Println(centsOf(reimbursement))
+	reportTimings(os.Stderr)
+}
+
+// defaultDataPath is the training data location relative to this directory.
+const defaultDataPath = "../public_cases.json"
+
+// defaultK is the number of neighbors used for prediction.
+const defaultK = 5
+
+func loadTrainingData(path string) (TrainingData, error) {
+	return loadTrainingFile(path, true)
 }
 
-func loadTrainingData() (TrainingData, error) {
-	// Load from public_cases.json in parent directory
-	file, err := os.Open("../public_cases.json")
+// loadTrainingFile loads JSON, packed or workbook training data. mapPacked
+// selects whether packed data is memory-mapped where possible or decoded
+// into the heap. Workbooks are read from their first sheet, with columns
+// named like the JSON fields. path may be an s3:// or gs:// URI. Data with
+// no cases is an error.
+func loadTrainingFile(path string, mapPacked bool) (TrainingData, error) {
+	data, err := readTrainingFile(path, mapPacked)
+	if err == nil {
+		err = checkNotEmpty(path, data)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return data, nil
+}
 
-	var data TrainingData
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&data)
+func readTrainingFile(path string, mapPacked bool) (TrainingData, error) {
+	path, err := localPath(path)
 	if err != nil {
 		return nil, err
 	}
+	if packed, err := isPacked(path); err != nil {
+		return nil, err
+	} else if packed {
+		return loadPacked(path, mapPacked)
+	}
+	if workbook, err := isWorkbook(path); err != nil {
+		return nil, err
+	} else if workbook {
+		var table tableFlags
+		return table.loadWorkbookCases(path)
+	}
 
+	var data TrainingData
+	if info, err := os.Stat(path); err == nil {
+		data = make(TrainingData, 0, info.Size()/jsonBytesPerCase)
+	}
+	if err := streamJSONCases(path, func(c TestCase) { data = append(data, c) }); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 
-func predictWeightedKNN(tripDays int, miles, receipts float64, training TrainingData, k int) float64 {
+// streamJSONCases calls add with each case of a JSON training data file in
+// order. It decodes one case at a time: decoding the whole array at once
+// would make the decoder buffer the entire file alongside the cases built
+// from it. Each case is checked against caseSchema first, so a malformed
+// case is reported by its index and field.
+func streamJSONCases(path string, add func(TestCase)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReaderSize(file, 1<<16))
+	if tok, err := decoder.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array of cases")
+	}
+	var raw json.RawMessage
+	for n := 0; decoder.More(); n++ {
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("case %d: %v", n, err)
+		}
+		if err := caseSchema.validateJSON(raw); err != nil {
+			return fmt.Errorf("case %d: %v", n, err)
+		}
+		var c TestCase
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return fmt.Errorf("case %d: %v", n, err)
+		}
+		if err := checkCase(c); err != nil {
+			return fmt.Errorf("case %d (%s): %v", n, describeCase(c), err)
+		}
+		add(c)
+	}
+	_, err = decoder.Token()
+	return err
+}
+
+// jsonBytesPerCase is a low estimate of the size of one case in a training
+// data file, used to preallocate for the cases a file holds.
+const jsonBytesPerCase = 100
+
+// predictWeightedKNN answers with the output of an exact match, or else
+// combines the outputs of the k nearest training cases as how says.
+func predictWeightedKNN(tripDays int, miles, receipts float64, training TrainingData, k int, how string) float64 {
 	// Check for exact matches first - return immediately if found
 	for _, case_ := range training {
 		if case_.Input.TripDurationDays == tripDays &&
@@ -89,63 +343,81 @@ func predictWeightedKNN(tripDays int, miles, receipts float64, training Training
 		}
 	}
 
-	// Calculate distances to all training points
-	neighbors := make([]Neighbor, 0, len(training))
+	// Keep only the k nearest training points
+	k = min(max(k, 1), len(training))
+	buf := neighborPool.Get().(*[]Neighbor)
+	defer neighborPool.Put(buf)
+	neighbors := nearestNeighbors((*buf)[:0], tripDays, miles, receipts, training, k)
+	*buf = neighbors
 
-	for _, case_ := range training {
+	return aggregate(neighbors, how, inverseDistance)
+}
+
+// weightedAverage is the inverse-distance weighted mean output of neighbors,
+// which must be non-empty and sorted by distance.
+func weightedAverage(neighbors []Neighbor) float64 {
+	return aggregate(neighbors, aggregateMean, inverseDistance)
+}
+
+// neighborPool recycles the neighbor buffers of predictWeightedKNN so that
+// batch prediction does not allocate per query.
+var neighborPool = sync.Pool{New: func() any { return new([]Neighbor) }}
+
+// nearestNeighbors appends the k training points nearest the query to dst in
+// ascending order of distance. It maintains a bounded insertion-sorted buffer
+// rather than sorting the distance to every training point.
+func nearestNeighbors(dst []Neighbor, tripDays int, miles, receipts float64, training TrainingData, k int) []Neighbor {
+	for i, case_ := range training {
 		distance := calculateDistance(
 			tripDays, miles, receipts,
 			case_.Input.TripDurationDays, case_.Input.MilesTraveled, case_.Input.TotalReceiptsAmount,
 		)
-
-		neighbors = append(neighbors, Neighbor{
-			Distance: distance,
-			Output:   case_.ExpectedOutput,
-		})
+		dst = insertNeighbor(dst, k, Neighbor{Distance: distance, Output: case_.ExpectedOutput, Case: i})
 	}
+	return dst
+}
 
-	// Sort by distance
-	sort.Slice(neighbors, func(i, j int) bool {
-		return neighbors[i].Distance < neighbors[j].Distance
-	})
-
-	// Use weighted average of k nearest neighbors
-	if k > len(neighbors) {
-		k = len(neighbors)
+// printNeighbors lists the training cases predictWeightedKNN combines for q,
+// nearest first.
+func printNeighbors(w io.Writer, q Query, training TrainingData, k int) {
+	k = min(max(k, 1), len(training))
+	for _, n := range nearestNeighbors(nil, q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, training, k) {
+		in := training[n.Case].Input
+		fmt.Fprintf(w, "neighbor %d days, %g miles, $%.2f receipts: output %.2f at distance %.4f\n",
+			in.TripDurationDays, in.MilesTraveled, in.TotalReceiptsAmount, n.Output, n.Distance)
 	}
+}
 
-	weightedSum := 0.0
-	totalWeight := 0.0
-
-	for i := 0; i < k; i++ {
-		// Inverse distance weighting with small epsilon to avoid division by zero
-		epsilon := 1e-8
-		weight := 1.0 / (neighbors[i].Distance + epsilon)
-
-		weightedSum += weight * neighbors[i].Output
-		totalWeight += weight
+// insertNeighbor adds n to the sorted buffer dst of at most k neighbors,
+// dropping the farthest once the buffer is full.
+func insertNeighbor(dst []Neighbor, k int, n Neighbor) []Neighbor {
+	if len(dst) == k && n.Distance >= dst[k-1].Distance {
+		return dst
 	}
-
-	if totalWeight == 0 {
-		// Fallback to nearest neighbor
-		return neighbors[0].Output
+	if len(dst) < k {
+		dst = append(dst, Neighbor{})
 	}
-
-	return weightedSum / totalWeight
+	i := len(dst) - 1
+	for ; i > 0 && dst[i-1].Distance > n.Distance; i-- {
+		dst[i] = dst[i-1]
+	}
+	dst[i] = n
+	return dst
 }
 
+// Scale factors based on typical ranges observed in data
+const (
+	dayScale     = 20.0   // Trip days typically 1-20
+	mileScale    = 2000.0 // Miles typically 0-2000
+	receiptScale = 3000.0 // Receipts typically 0-3000
+)
+
 func calculateDistance(days1 int, miles1, receipts1 float64, days2 int, miles2, receipts2 float64) float64 {
 	// Improved scaled Euclidean distance with better normalization
 
-	// Scale factors based on typical ranges observed in data
-	dayScale := 20.0       // Trip days typically 1-20
-	mileScale := 2000.0    // Miles typically 0-2000
-	receiptScale := 3000.0 // Receipts typically 0-3000
-
 	daysDiff := float64(days1-days2) / dayScale
 	milesDiff := (miles1 - miles2) / mileScale
 	receiptsDiff := (receipts1 - receipts2) / receiptScale
 
 	return math.Sqrt(daysDiff*daysDiff + milesDiff*milesDiff + receiptsDiff*receiptsDiff)
 }
-
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// peakMemory returns the memory the Go runtime obtained from the system,
+// for platforms without a peak resident set size, and what it measured.
+func peakMemory() (int64, string) {
+	return peakRuntimeMemory()
+}
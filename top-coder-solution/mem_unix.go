@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakMemory returns the process's peak resident set size, which includes
+// the pages of memory-mapped files it touched, and what it measured.
+func peakMemory() (int64, string) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return peakRuntimeMemory()
+	}
+	peak := int64(usage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		peak *= 1024 // kilobytes elsewhere
+	}
+	return peak, "resident"
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// memBudget, set by -mem-budget, is the memory in bytes the process aims
+// to stay under, 0 for no budget. It is the Go runtime's soft memory limit,
+// and main reports the peak usage against it on exit.
+var memBudget int64
+
+// Memory estimates for planning the training data's load under a budget.
+const (
+	// memBaseline is the memory the program needs before any training
+	// data: the runtime, code and working buffers.
+	memBaseline = 24 << 20
+	// heapBytesPerCase is the heap one decoded training case costs once a
+	// predictor is built on it: the case, its scaled feature vector and its
+	// share of the neighbor index.
+	heapBytesPerCase = 240
+)
+
+// byteUnits are the size suffixes parseByteSize accepts, in powers of 1024
+// as container memory limits count them.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}}
+
+// parseByteSize parses a size such as 512MB, 1.5G, 800MiB or 1048576.
+func parseByteSize(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "I")
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, unit = strings.TrimSpace(n), u.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 || v*float64(unit) >= 1<<62 {
+		return 0, fmt.Errorf("invalid size %q, want a number of bytes with an optional K, M, G or T suffix such as 512MB", s)
+	}
+	return int64(v * float64(unit)), nil
+}
+
+// formatByteSize formats n bytes in the largest unit of at least one.
+func formatByteSize(n int64) string {
+	for _, u := range byteUnits {
+		if n >= u.size {
+			return strconv.FormatFloat(float64(n)/float64(u.size), 'f', 1, 64) + u.suffix + "B"
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// loadPlan is how training data is loaded to fit a memory budget.
+type loadPlan struct {
+	mmap   bool          // memory-map packed data
+	pack   bool          // stream JSON data into a temporary packed file to map
+	sample *SampleConfig // sample the data down, nil for all of it
+	notes  []string      // the strategies chosen, for the user
+}
+
+// planTrainingLoad chooses how to load the training data at path within
+// budget bytes, starting from the -mmap and sampling flags given. Decoded
+// data that fits is loaded as asked. Otherwise the data is memory-mapped,
+// costing page cache rather than heap, packing a JSON file first, where
+// the platform can map it; and if it still does not fit, it is sampled
+// down to as many cases as do, with the -sample method and seed.
+func planTrainingLoad(path string, budget int64, mmap bool, sample *SampleConfig, samples sampleFlags) (loadPlan, error) {
+	plan := loadPlan{mmap: mmap, sample: sample}
+	avail := budget - memBaseline
+	if avail <= 0 {
+		return plan, fmt.Errorf("-mem-budget must be more than %s", formatByteSize(memBaseline))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return plan, err
+	}
+	packed, err := isPacked(path)
+	if err != nil {
+		return plan, err
+	}
+	workbook, err := isWorkbook(path)
+	if err != nil {
+		return plan, err
+	}
+	// The JSON size gives an overestimate of the case count; a workbook,
+	// being compressed, may hold more.
+	n := info.Size() / jsonBytesPerCase
+	if packed {
+		n = max(info.Size()-packedHeaderSize, 0) / packedRecordSize
+	}
+	if sample != nil {
+		n = min(n, int64(sample.MaxCases))
+	}
+	if n*heapBytesPerCase <= avail {
+		return plan, nil
+	}
+
+	perCase := int64(heapBytesPerCase)
+	if canMapFiles && canMapInPlace() && !workbook && sample == nil {
+		perCase -= packedRecordSize
+		if n*perCase <= avail {
+			plan.mmap = true
+			if !packed {
+				plan.pack = true
+				plan.notes = append(plan.notes, "packing the JSON training data into a temporary file to memory-map it")
+			} else if !mmap {
+				plan.notes = append(plan.notes, "memory-mapping the packed training data despite -mmap=false")
+			}
+			return plan, nil
+		}
+		perCase = heapBytesPerCase // the sampled cases are copied into the heap
+	}
+	maxCases := int(avail / perCase)
+	if maxCases < 1 {
+		return plan, fmt.Errorf("-mem-budget %s leaves no room for training data", formatByteSize(budget))
+	}
+	plan.sample = &SampleConfig{MaxCases: maxCases, Method: samples.method, Seed: samples.seed}
+	if err := plan.sample.validate(); err != nil {
+		return plan, err
+	}
+	plan.notes = append(plan.notes, fmt.Sprintf("training on a %s sample of at most %d of about %d cases",
+		samples.method, maxCases, n))
+	return plan, nil
+}
+
+// packJSONFile streams the JSON training data at path into a temporary
+// packed file, holding one case at a time, and returns the packed file's
+// path. The caller removes it.
+func packJSONFile(path string) (string, error) {
+	file, err := os.CreateTemp("", "training-*.pack")
+	if err != nil {
+		return "", err
+	}
+	fail := func(err error) (string, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if _, err := file.Seek(packedHeaderSize, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	w := bufio.NewWriter(file)
+	rec := make([]byte, packedRecordSize)
+	var n uint64
+	var unpackable error // the first case packed data cannot hold
+	err = streamJSONCases(path, func(c TestCase) {
+		if unpackable != nil {
+			return
+		}
+		if unpackable = checkPackable(c); unpackable != nil {
+			unpackable = fmt.Errorf("%s: case %d: %v", path, n, unpackable)
+			return
+		}
+		encodeRecord(rec, c)
+		w.Write(rec)
+		n++
+	})
+	if err == nil {
+		err = unpackable
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fail(err)
+	}
+	header := make([]byte, packedHeaderSize)
+	copy(header, packedMagic)
+	binary.LittleEndian.PutUint32(header[4:], packedVersion)
+	binary.LittleEndian.PutUint64(header[8:], n)
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		return fail(err)
+	}
+	return file.Name(), nil
+}
+
+// setMemBudget parses and applies -mem-budget.
+func setMemBudget(s string) error {
+	budget, err := parseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("-mem-budget: %v", err)
+	}
+	memBudget = budget
+	debug.SetMemoryLimit(budget)
+	return nil
+}
+
+// reportMemory writes the process's peak memory usage against memBudget,
+// when one is set.
+func reportMemory(w io.Writer) {
+	if memBudget == 0 {
+		return
+	}
+	peak, what := peakMemory()
+	fmt.Fprintf(w, "Peak memory: %s %s of the %s budget", formatByteSize(peak), what, formatByteSize(memBudget))
+	if peak > memBudget {
+		fmt.Fprintf(w, " (over by %s)", formatByteSize(peak-memBudget))
+	}
+	fmt.Fprintln(w)
+}
+
+// peakRuntimeMemory returns the memory the Go runtime has obtained from the
+// system, which it rarely returns, so it is close to the peak.
+func peakRuntimeMemory() (int64, string) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.Sys), "obtained by the Go runtime"
+}
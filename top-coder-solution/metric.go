@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Distance metrics between feature vectors. All of them first divide each
+// feature by its typical range (its featureDef Scale, by default dayScale,
+// mileScale and receiptScale) except Mahalanobis, which whitens with the
+// training covariance instead.
+const (
+	metricEuclidean   = "euclidean"
+	metricManhattan   = "manhattan"
+	metricMahalanobis = "mahalanobis"
+)
+
+func validateMetric(name string) error {
+	switch name {
+	case "", metricEuclidean, metricManhattan, metricMahalanobis:
+		return nil
+	}
+	return fmt.Errorf("unknown metric %q (want %s, %s or %s)", name, metricEuclidean, metricManhattan, metricMahalanobis)
+}
+
+// isEuclidean reports whether the metric name selects the default metric.
+func isEuclidean(name string) bool {
+	return name == "" || name == metricEuclidean
+}
+
+// distanceMetric measures the distance between two feature vectors. Every
+// implementation satisfies the triangle inequality, which tree indexes rely
+// on to prune their search.
+type distanceMetric interface {
+	distance(a, b featureVector) float64
+}
+
+// newMetric returns the named metric over features, fitting it to training
+// where needed. A nil feature set selects the default features.
+func newMetric(name string, training TrainingData, features featureSet) distanceMetric {
+	switch {
+	case name == metricMahalanobis:
+		return newMahalanobis(training, features)
+	case features != nil:
+		return featureMetric{features: features, manhattan: name == metricManhattan}
+	case name == metricManhattan:
+		return manhattanMetric{}
+	}
+	return euclideanMetric{}
+}
+
+// euclideanMetric is calculateDistance, the metric of the original model.
+type euclideanMetric struct{}
+
+func (euclideanMetric) distance(a, b featureVector) float64 {
+	return calculateDistance(int(a[0]), a[1], a[2], int(b[0]), b[1], b[2])
+}
+
+// manhattanMetric sums the absolute scaled feature differences.
+type manhattanMetric struct{}
+
+func (manhattanMetric) distance(a, b featureVector) float64 {
+	return math.Abs(a[0]-b[0])/dayScale + math.Abs(a[1]-b[1])/mileScale + math.Abs(a[2]-b[2])/receiptScale
+}
+
+// featureMetric is the Euclidean or Manhattan metric over the scaled
+// features of a non-default feature set.
+type featureMetric struct {
+	features  featureSet
+	manhattan bool
+}
+
+func (m featureMetric) distance(a, b featureVector) float64 {
+	pa, pb := m.features.project(a), m.features.project(b)
+	sum := 0.0
+	for i := range m.features {
+		d := pa[i] - pb[i]
+		if m.manhattan {
+			sum += math.Abs(d)
+		} else {
+			sum += d * d
+		}
+	}
+	if m.manhattan {
+		return sum
+	}
+	return math.Sqrt(sum)
+}
+
+// mahalanobisMetric is the distance sqrt((a-b)ᵀ Σ⁻¹ (a-b)) for the training
+// covariance Σ, computed as the Euclidean length of W(a-b) where W is the
+// inverse of Σ's Cholesky factor. It accounts for correlated features, such
+// as receipts growing with trip length. It is measured over the raw inputs,
+// or over the features of a non-default feature set.
+type mahalanobisMetric struct {
+	features featureSet                        // nil for the raw inputs
+	n        int                               // dimension
+	w        [maxFeatures][maxFeatures]float64 // lower triangular
+}
+
+// coords returns the coordinates of v the metric whitens.
+func (m *mahalanobisMetric) coords(v featureVector) featurePoint {
+	if m.features == nil {
+		return featurePoint{v[0], v[1], v[2]}
+	}
+	return m.features.project(v)
+}
+
+func newMahalanobis(training TrainingData, features featureSet) *mahalanobisMetric {
+	m := &mahalanobisMetric{features: features, n: len(featureNames)}
+	if features != nil {
+		m.n = len(features)
+	}
+	n := m.n
+	var mean featurePoint
+	for _, c := range training {
+		v := m.coords(caseFeatures(c))
+		for j := range n {
+			mean[j] += v[j] / float64(len(training))
+		}
+	}
+	var cov [maxFeatures][maxFeatures]float64
+	for _, c := range training {
+		v := m.coords(caseFeatures(c))
+		for i := range n {
+			for j := range n {
+				cov[i][j] += (v[i] - mean[i]) * (v[j] - mean[j]) / float64(max(len(training)-1, 1))
+			}
+		}
+	}
+	// Regularize so constant or collinear features keep Σ positive definite.
+	for i := range n {
+		cov[i][i] += 1e-9 * max(cov[i][i], 1)
+	}
+
+	// Cholesky: Σ = L Lᵀ.
+	var l [maxFeatures][maxFeatures]float64
+	for i := range n {
+		for j := 0; j <= i; j++ {
+			sum := cov[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				l[i][i] = math.Sqrt(math.Max(sum, 1e-12))
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	// W = L⁻¹ by forward substitution.
+	for col := range n {
+		for i := col; i < n; i++ {
+			sum := 0.0
+			if i == col {
+				sum = 1
+			}
+			for k := col; k < i; k++ {
+				sum -= l[i][k] * m.w[k][col]
+			}
+			m.w[i][col] = sum / l[i][i]
+		}
+	}
+	return m
+}
+
+func (m *mahalanobisMetric) distance(a, b featureVector) float64 {
+	pa, pb := m.coords(a), m.coords(b)
+	var d featurePoint
+	for i := range m.n {
+		d[i] = pa[i] - pb[i]
+	}
+	sum := 0.0
+	for i := range m.n {
+		z := 0.0
+		for j := 0; j <= i; j++ {
+			z += m.w[i][j] * d[j]
+		}
+		sum += z * z
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// canMapFiles reports whether mapFile maps files rather than reading them.
+const canMapFiles = false
+
+// mapFile reads the file at path into memory on platforms without mmap.
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func unmapFile(data []byte) {}
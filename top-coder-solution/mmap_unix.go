@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// canMapFiles reports whether mapFile maps files rather than reading them.
+const canMapFiles = true
+
+// mapFile maps the file at path read-only into memory. The mapping is
+// shared with the page cache, so the file must not be truncated or
+// rewritten while mapped, which would fault the reader: a mapped file is
+// only ever replaced by renaming a new file over it, as writePacked does.
+func mapFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(data []byte) {
+	if data != nil {
+		syscall.Munmap(data)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Model is a reimbursement model that learns from training cases. Weighted
+// KNN is the default; the others answer in place of KNN when a predictor's
+// Hyperparameters.Model names them. Models see a case's inputs as a Query,
+// named input fields included.
+//
+// Models that can fail, such as external programs, also implement
+// Err() error to report their first failure, and predict NaN once it has
+// happened. Models holding resources implement io.Closer.
+type Model interface {
+	// Fit trains the model on training, replacing anything learned before.
+	Fit(training TrainingData)
+	// Predict returns the estimated reimbursement for q.
+	Predict(q Query) float64
+	// Explain describes how the model arrives at Predict(q).
+	Explain(q Query) ModelExplanation
+}
+
+// ModelExplanation is a readable account of a single prediction.
+type ModelExplanation struct {
+	Model      string   `json:"model"`
+	Prediction float64  `json:"prediction"`
+	Steps      []string `json:"steps"`
+}
+
+// ModelFactory returns an untrained model configured by hp.
+type ModelFactory func(hp Hyperparameters) Model
+
+// Names of the built-in models.
+const (
+	modelKNN    = "knn"
+	modelLinear = "linear"
+	modelTree   = "tree"
+	modelForest = "forest"
+	modelRule   = "rule"
+	modelCurve  = "curve"
+	modelLocal  = "local"
+)
+
+var (
+	modelsMu sync.RWMutex
+	models   = map[string]ModelFactory{
+		modelKNN:    func(hp Hyperparameters) Model { return &knnModel{hp: hp} },
+		modelLinear: func(hp Hyperparameters) Model { return &linearRegression{hp: hp} },
+		modelTree:   func(Hyperparameters) Model { return &treeModel{params: defaultTreeParams} },
+		modelForest: func(Hyperparameters) Model { return &forestModel{trees: defaultForestTrees, params: defaultTreeParams} },
+		modelRule:   newRuleModel,
+		modelCurve:  func(Hyperparameters) Model { return &curveModel{} },
+		modelLocal:  func(hp Hyperparameters) Model { return &localModel{hp: hp} },
+	}
+)
+
+// RegisterModel makes a model available under name, so that -model name and
+// Hyperparameters.Model select it. It panics if name is empty or already
+// registered, like database/sql.Register. Being in package main, it serves
+// models added to this program, such as in a file of their own whose init
+// function registers them; it is not an API for other modules.
+func RegisterModel(name string, factory ModelFactory) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	if name == "" || factory == nil {
+		panic("RegisterModel: empty name or nil factory")
+	}
+	if _, dup := models[name]; dup {
+		panic("RegisterModel: model " + name + " registered twice")
+	}
+	models[name] = factory
+}
+
+// modelNames returns the registered model names, sorted.
+func modelNames() []string {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func validateModel(name string) error {
+	modelsMu.RLock()
+	_, ok := models[name]
+	modelsMu.RUnlock()
+	if name == "" || ok {
+		return nil
+	}
+	if command, ok := strings.CutPrefix(name, execPrefix); ok {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("model %q names no program", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown model %q (want one of %s, or %sPROGRAM)", name, strings.Join(modelNames(), ", "), execPrefix)
+}
+
+// isKNN reports whether name selects the built-in KNN, which the predictor
+// runs itself.
+func isKNN(name string) bool {
+	return name == "" || name == modelKNN
+}
+
+// newModel returns the untrained model hp.Model names, which must be
+// registered or name an external program.
+func newModel(hp Hyperparameters) Model {
+	if command, ok := strings.CutPrefix(hp.Model, execPrefix); ok {
+		return &execModel{command: command}
+	}
+	modelsMu.RLock()
+	factory := models[hp.Model]
+	modelsMu.RUnlock()
+	return factory(hp)
+}
+
+// knnModel adapts the predictor's weighted KNN to the Model interface, for
+// callers that treat every model alike.
+type knnModel struct {
+	hp Hyperparameters
+	p  *Predictor
+}
+
+func (m *knnModel) Fit(training TrainingData) {
+	hp := m.hp
+	hp.Model, hp.Overrides = "", nil // overrides belong to the predictor wrapping the model
+	m.p = NewPredictor(training, hp)
+}
+
+func (m *knnModel) Predict(q Query) float64 {
+	return m.p.PredictQuery(q)
+}
+
+func (m *knnModel) Explain(q Query) ModelExplanation {
+	return m.p.Explain(q)
+}
+
+// linearRegression is the linear model over the predictor's features.
+type linearRegression struct {
+	hp Hyperparameters
+	m  *linearModel
+}
+
+func (l *linearRegression) Fit(training TrainingData) {
+	features, _ := newFeatureSet(l.hp.Features)
+	l.m = fitLinear(training, features, newRecencyDecay(training, l.hp.RecencyHalfLife))
+}
+
+func (l *linearRegression) Predict(q Query) float64 {
+	return l.m.predict(q.features())
+}
+
+func (l *linearRegression) Explain(q Query) ModelExplanation {
+	return ModelExplanation{Model: modelLinear, Prediction: l.Predict(q), Steps: l.m.explain(q.features())}
+}
+
+// explain lists the contribution of the intercept and each feature to the
+// prediction for v.
+func (m *linearModel) explain(v featureVector) []string {
+	steps := []string{fmt.Sprintf("intercept: %+.2f", m.Intercept)}
+	x := regressors(m.features, v)
+	for i, c := range m.Coef {
+		var name string
+		if m.features != nil {
+			name = m.features[i].Name
+		} else {
+			name = featureNames[i]
+		}
+		steps = append(steps, fmt.Sprintf("%s: %g × %.4f = %+.2f", name, x[i], c, x[i]*c))
+	}
+	return steps
+}
+
+// defaultTreeParams bounds the tree model: deep enough to follow the
+// policy's thresholds, with leaves large enough to average out noise.
+var defaultTreeParams = treeParams{MaxDepth: 8, MinLeaf: 5}
+
+// treeModel is a single regression tree.
+type treeModel struct {
+	params treeParams
+	root   *treeNode
+}
+
+func (t *treeModel) Fit(training TrainingData) {
+	t.root = fitRegressionTree(training, t.params)
+}
+
+func (t *treeModel) Predict(q Query) float64 {
+	return t.root.predict(q.features())
+}
+
+func (t *treeModel) Explain(q Query) ModelExplanation {
+	steps, leaf := t.root.decisionPath(q.features())
+	steps = append(steps, fmt.Sprintf("leaf of %d cases: mean %.2f", leaf.Count, leaf.Value))
+	return ModelExplanation{Model: modelTree, Prediction: leaf.Value, Steps: steps}
+}
+
+// decisionPath returns the splits v passes on its way down the tree, each as
+// the feature, its value, the branch taken and the threshold, and the leaf
+// it reaches.
+func (n *treeNode) decisionPath(v featureVector) ([]string, *treeNode) {
+	var steps []string
+	for !n.isLeaf() {
+		branch, next := "<=", n.Left
+		if v[n.Feature] > n.Threshold {
+			branch, next = ">", n.Right
+		}
+		steps = append(steps, fmt.Sprintf("%s %g %s %.6g", featureNames[n.Feature], v[n.Feature], branch, n.Threshold))
+		n = next
+	}
+	return steps, n
+}
+
+// defaultForestTrees is the number of trees in the forest model.
+const defaultForestTrees = 50
+
+// forestModel averages regression trees grown on bootstrap samples of the
+// training data. The samples are drawn from a fixed seed, so fitting is
+// deterministic.
+type forestModel struct {
+	trees  int
+	params treeParams
+	roots  []*treeNode
+}
+
+func (f *forestModel) Fit(training TrainingData) {
+	rng := rand.New(rand.NewPCG(1, 1))
+	f.roots = make([]*treeNode, f.trees)
+	for t := range f.roots {
+		sample := make(TrainingData, len(training))
+		for i := range sample {
+			sample[i] = training[rng.IntN(len(training))]
+		}
+		f.roots[t] = fitRegressionTree(sample, f.params)
+	}
+}
+
+func (f *forestModel) Predict(q Query) float64 {
+	v := q.features()
+	sum := 0.0
+	for _, root := range f.roots {
+		sum += root.predict(v)
+	}
+	return sum / float64(len(f.roots))
+}
+
+func (f *forestModel) Explain(q Query) ModelExplanation {
+	v := q.features()
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, root := range f.roots {
+		y := root.predict(v)
+		lo, hi = math.Min(lo, y), math.Max(hi, y)
+	}
+	steps := []string{fmt.Sprintf("mean of %d trees on bootstrap samples, ranging %.2f to %.2f", len(f.roots), lo, hi)}
+	for i, root := range f.roots {
+		path, leaf := root.decisionPath(v)
+		steps = append(steps, fmt.Sprintf("tree %d: %s -> %.2f (%d cases)", i+1, strings.Join(path, ", "), leaf.Value, leaf.Count))
+	}
+	return ModelExplanation{Model: modelForest, Prediction: f.Predict(q), Steps: steps}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+)
+
+// deltaBucketEdges bound the buckets of the change distribution, in dollars.
+var deltaBucketEdges = []float64{-100, -10, -1, 1, 10, 100}
+
+// DeltaBucket counts the cases whose prediction changed by an amount in a
+// range, which includes its lower bound; the outermost are open-ended.
+type DeltaBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// DeltaStats describes how the predictions of a set of cases changed.
+type DeltaStats struct {
+	Count        int           `json:"count"`
+	Increases    int           `json:"increases"`
+	Decreases    int           `json:"decreases"`
+	Unchanged    int           `json:"unchanged"` // to the cent
+	MeanDelta    float64       `json:"mean_delta"`
+	MeanAbsDelta float64       `json:"mean_abs_delta"`
+	Min          float64       `json:"min"`
+	P10          float64       `json:"p10"`
+	Median       float64       `json:"median"`
+	P90          float64       `json:"p90"`
+	Max          float64       `json:"max"`
+	Distribution []DeltaBucket `json:"distribution"`
+}
+
+// DeltaMover is a case whose prediction changed.
+type DeltaMover struct {
+	Case      int     `json:"case"`
+	Input     Query   `json:"input"`
+	Baseline  float64 `json:"baseline"`
+	Candidate float64 `json:"candidate"`
+	Delta     float64 `json:"delta"` // candidate less baseline
+}
+
+// DeltaSegment is the change within one band of an input, with the cases
+// that moved most.
+type DeltaSegment struct {
+	Dimension string `json:"dimension"`
+	Band      string `json:"band"`
+	DeltaStats
+	TopMovers []DeltaMover `json:"top_movers"`
+}
+
+// ModelDelta is how a candidate model version's predictions differ from the
+// baseline's on a reference case set.
+type ModelDelta struct {
+	Baseline  string         `json:"baseline"`
+	Candidate string         `json:"candidate"`
+	Cases     string         `json:"cases"`
+	Overall   DeltaStats     `json:"overall"`
+	TopMovers []DeltaMover   `json:"top_movers"`
+	Segments  []DeltaSegment `json:"segments"`
+}
+
+// predictionDeltas predicts every case with both models, rounded to the
+// cent.
+func predictionDeltas(cases TrainingData, baseline, candidate *Predictor) ([]DeltaMover, error) {
+	deltas := make([]DeltaMover, len(cases))
+	for i, c := range cases {
+		in := c.Input
+		before := centsOf(baseline.PredictQuery(in))
+		after := centsOf(candidate.PredictQuery(in))
+		deltas[i] = DeltaMover{Case: i, Input: in, Baseline: before.Dollars(), Candidate: after.Dollars(), Delta: (after - before).Dollars()}
+	}
+	if err := baseline.Err(); err != nil {
+		return nil, err
+	}
+	return deltas, candidate.Err()
+}
+
+func deltaStats(deltas []DeltaMover) DeltaStats {
+	s := DeltaStats{Count: len(deltas)}
+	for i := 0; i <= len(deltaBucketEdges); i++ {
+		var name string
+		switch {
+		case i == 0:
+			name = fmt.Sprintf("< %g", deltaBucketEdges[0])
+		case i == len(deltaBucketEdges):
+			name = fmt.Sprintf(">= %g", deltaBucketEdges[i-1])
+		default:
+			name = fmt.Sprintf("%g to %g", deltaBucketEdges[i-1], deltaBucketEdges[i])
+		}
+		s.Distribution = append(s.Distribution, DeltaBucket{Range: name})
+	}
+	if len(deltas) == 0 {
+		return s
+	}
+	values := make([]float64, len(deltas))
+	var sum, abs float64
+	for i, d := range deltas {
+		values[i] = d.Delta
+		sum += d.Delta
+		abs += math.Abs(d.Delta)
+		switch {
+		case d.Delta > 0:
+			s.Increases++
+		case d.Delta < 0:
+			s.Decreases++
+		default:
+			s.Unchanged++
+		}
+		s.Distribution[sort.SearchFloat64s(deltaBucketEdges, math.Nextafter(d.Delta, math.Inf(1)))].Count++
+	}
+	sort.Float64s(values)
+	s.MeanDelta = sum / float64(len(values))
+	s.MeanAbsDelta = abs / float64(len(values))
+	s.Min, s.Max = values[0], values[len(values)-1]
+	s.P10 = sortedQuantile(values, 0.1)
+	s.Median = sortedQuantile(values, 0.5)
+	s.P90 = sortedQuantile(values, 0.9)
+	return s
+}
+
+// topMovers returns the n cases whose predictions changed most, largest
+// change first, leaving out those unchanged.
+func topMovers(deltas []DeltaMover, n int) []DeltaMover {
+	var moved []DeltaMover
+	for _, d := range deltas {
+		if d.Delta != 0 {
+			moved = append(moved, d)
+		}
+	}
+	slices.SortStableFunc(moved, func(a, b DeltaMover) int {
+		return cmp.Compare(math.Abs(b.Delta), math.Abs(a.Delta))
+	})
+	return moved[:min(n, len(moved))]
+}
+
+// modelDelta describes the changes overall and in every band of the eval
+// segmenters.
+func modelDelta(deltas []DeltaMover, top int) ModelDelta {
+	d := ModelDelta{Overall: deltaStats(deltas), TopMovers: topMovers(deltas, top)}
+	for _, s := range segmenters {
+		type band struct {
+			order  int
+			name   string
+			deltas []DeltaMover
+		}
+		var bands []*band
+		byName := map[string]*band{}
+		for _, delta := range deltas {
+			order, name := s.Band(TestCase{Input: delta.Input})
+			b, ok := byName[name]
+			if !ok {
+				b = &band{order: order, name: name}
+				byName[name] = b
+				bands = append(bands, b)
+			}
+			b.deltas = append(b.deltas, delta)
+		}
+		sort.Slice(bands, func(i, j int) bool { return bands[i].order < bands[j].order })
+		for _, b := range bands {
+			d.Segments = append(d.Segments, DeltaSegment{s.Title, b.name, deltaStats(b.deltas), topMovers(b.deltas, top)})
+		}
+	}
+	return d
+}
+
+func printModelDelta(w io.Writer, d ModelDelta) {
+	o := d.Overall
+	fmt.Fprintf(w, "Candidate %s vs baseline %s on %s (%d cases)\n", d.Candidate, d.Baseline, d.Cases, o.Count)
+	fmt.Fprintf(w, "%d increased, %d decreased, %d unchanged; mean change %+.2f, mean absolute change %.2f\n",
+		o.Increases, o.Decreases, o.Unchanged, o.MeanDelta, o.MeanAbsDelta)
+	fmt.Fprintf(w, "Change: min %+.2f, p10 %+.2f, median %+.2f, p90 %+.2f, max %+.2f\n\n", o.Min, o.P10, o.Median, o.P90, o.Max)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE ($)\tCASES")
+	for _, b := range o.Distribution {
+		fmt.Fprintf(tw, "%s\t%d\n", b.Range, b.Count)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIMENSION\tBAND\tCASES\tUP\tDOWN\tMEAN\tMEAN ABS\tTOP MOVER")
+	for _, s := range d.Segments {
+		mover := "-"
+		if len(s.TopMovers) > 0 {
+			m := s.TopMovers[0]
+			mover = fmt.Sprintf("case %d: %.2f -> %.2f", m.Case, m.Baseline, m.Candidate)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%+.2f\t%.2f\t%s\n",
+			s.Dimension, s.Band, s.Count, s.Increases, s.Decreases, s.MeanDelta, s.MeanAbsDelta, mover)
+	}
+	tw.Flush()
+
+	if len(d.TopMovers) > 0 {
+		fmt.Fprintln(w, "\nTop movers:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CASE\tDAYS\tMILES\tRECEIPTS\tBASELINE\tCANDIDATE\tCHANGE")
+		for _, m := range d.TopMovers {
+			fmt.Fprintf(tw, "%d\t%d\t%g\t%.2f\t%.2f\t%.2f\t%+.2f\n", m.Case, m.Input.TripDurationDays, m.Input.MilesTraveled,
+				m.Input.TotalReceiptsAmount, m.Baseline, m.Candidate, m.Delta)
+		}
+		tw.Flush()
+	}
+}
+
+func runModelDiff(args []string) error {
+	fs := flag.NewFlagSet("model-diff", flag.ContinueOnError)
+	baselineTag := fs.String("baseline", "", "registered model version released before")
+	candidateTag := fs.String("candidate", "", "registered model version being released")
+	registry := fs.String("registry", defaultRegistry, "model registry directory")
+	casesPath := fs.String("cases", "", "reference cases to predict with both versions, labelled or not")
+	top := fs.Int("top", 3, "list this many of the cases that moved most, overall and in each band")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *baselineTag == "" || *candidateTag == "" || *casesPath == "" {
+		return fmt.Errorf("-baseline, -candidate and -cases are required")
+	}
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative")
+	}
+
+	baseline, _, err := loadRegisteredModel(*registry, *baselineTag)
+	if err != nil {
+		return err
+	}
+	defer baseline.Close()
+	candidate, _, err := loadRegisteredModel(*registry, *candidateTag)
+	if err != nil {
+		return err
+	}
+	defer candidate.Close()
+	format, err := fileFormat("", *casesPath)
+	if err != nil {
+		return err
+	}
+	path, err := localPath(*casesPath)
+	if err != nil {
+		return err
+	}
+	cases, _, err := readCases(path, format, defaultColumns(), tableFlags{})
+	if err != nil {
+		return fmt.Errorf("loading cases: %v", err)
+	}
+
+	deltas, err := predictionDeltas(cases, baseline, candidate)
+	if err != nil {
+		return err
+	}
+	report := modelDelta(deltas, *top)
+	report.Baseline, report.Candidate, report.Cases = *baselineTag, *candidateTag, *casesPath
+	if *asJSON {
+		return writeJSON(os.Stdout, report)
+	}
+	printModelDelta(os.Stdout, report)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Cents is a monetary amount in whole cents. Amounts are rounded into Cents
+// by their decimal value, so the rounding never flips on binary
+// representation error as math.Round(v*100) and %.2f can: 1.005 is stored
+// just below itself, yet it is 101 cents, as it would be on paper.
+type Cents int64
+
+// centsOf rounds the dollar amount v to the nearest cent, halves away from
+// zero. v is taken as the shortest decimal that identifies it. Non-finite
+// amounts are 0 cents, and amounts beyond the range of Cents saturate at
+// its ends; checkedCents reports both.
+func centsOf(v float64) Cents {
+	c, _ := checkedCents(v)
+	return c
+}
+
+// checkedCents is centsOf, failing when v is not finite or its cents do not
+// fit in Cents.
+func checkedCents(v float64) (Cents, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("amount is %v", v)
+	}
+	c, err := strconv.ParseInt(roundDecimalString(v, 2), 10, 64)
+	if err != nil {
+		return Cents(c), fmt.Errorf("amount %g is too large to count in cents", v)
+	}
+	return Cents(c), nil
+}
+
+// roundDecimalString returns v rounded to places decimal places, halves
+// away from zero, as an integer count of units of the last place: 1.005 at
+// two places is "101". v must be finite.
+func roundDecimalString(v float64, places int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	frac += strings.Repeat("0", places+1)
+	digits := []byte(whole + frac[:places])
+	if frac[places] >= '5' {
+		i := len(digits) - 1
+		for ; i >= 0 && digits[i] == '9'; i-- {
+			digits[i] = '0'
+		}
+		if i < 0 {
+			digits = append([]byte{'1'}, digits...)
+		} else {
+			digits[i]++
+		}
+	}
+	out := strings.TrimLeft(string(digits), "0")
+	if out == "" {
+		return "0"
+	}
+	if v < 0 {
+		out = "-" + out
+	}
+	return out
+}
+
+// roundDecimal rounds v to places decimal places by its decimal value, like
+// centsOf, returning the float nearest the rounded decimal.
+func roundDecimal(v float64, places int) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	units, err := strconv.ParseFloat(roundDecimalString(v, places), 64)
+	if err != nil {
+		return v
+	}
+	return units / math.Pow10(places)
+}
+
+// Dollars returns the float nearest the amount, which %.2f prints exactly.
+func (c Cents) Dollars() float64 {
+	return float64(c) / 100
+}
+
+// String formats the amount as dollars with two decimal places, as 1234.56.
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return sign + strconv.FormatInt(int64(c/100), 10) + "." + strconv.FormatInt(int64(c%100/10), 10) + strconv.FormatInt(int64(c%10), 10)
+}
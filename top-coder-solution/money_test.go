@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCentsOf(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want Cents
+	}{
+		{0, 0},
+		{1234.56, 123456},
+		{1.005, 101}, // just below 1.005 in binary, but 1.005 as written
+		{1.004999, 100},
+		{2.675, 268},
+		{0.125, 13},
+		{-0.125, -13}, // halves away from zero
+		{-1.005, -101},
+		{9.995, 1000},
+		{99.999, 10000},
+		{0.001, 0},
+		{1e-9, 0},
+		{123456789.125, 12345678913},
+		{math.NaN(), 0},
+		{math.Inf(1), 0},
+		{1e30, math.MaxInt64},
+		{-1e30, math.MinInt64},
+	}
+	for _, tt := range tests {
+		if got := centsOf(tt.v); got != tt.want {
+			t.Errorf("centsOf(%v) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestCheckedCents(t *testing.T) {
+	tests := []struct {
+		v       float64
+		want    Cents
+		wantErr bool
+	}{
+		{1.005, 101, false},
+		{-3.5, -350, false},
+		{math.NaN(), 0, true},
+		{math.Inf(-1), 0, true},
+		{1e17, 0, true},
+		{-1e17, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := checkedCents(tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkedCents(%v) error %v, want error %t", tt.v, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("checkedCents(%v) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRoundDecimal(t *testing.T) {
+	tests := []struct {
+		v      float64
+		places int
+		want   float64
+	}{
+		{1.005, 2, 1.01},
+		{1.0049, 2, 1},
+		{0.5, 0, 1},
+		{-0.5, 0, -1},
+		{2.5, 0, 3},
+		{1.23456, 4, 1.2346},
+		{0.1 + 0.2, 2, 0.3},
+	}
+	for _, tt := range tests {
+		if got := roundDecimal(tt.v, tt.places); got != tt.want {
+			t.Errorf("roundDecimal(%v, %d) = %v, want %v", tt.v, tt.places, got, tt.want)
+		}
+	}
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if got := roundDecimal(v, 2); !(got == v || math.IsNaN(got) && math.IsNaN(v)) {
+			t.Errorf("roundDecimal(%v, 2) = %v, want it unchanged", v, got)
+		}
+	}
+}
+
+func TestCentsString(t *testing.T) {
+	tests := []struct {
+		c    Cents
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{50, "0.50"},
+		{123456, "1234.56"},
+		{-101, "-1.01"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("Cents(%d).String() = %q, want %q", tt.c, got, tt.want)
+		}
+		// %.2f prints Dollars exactly as String does.
+		if got := fmt.Sprintf("%.2f", tt.c.Dollars()); got != tt.want {
+			t.Errorf("Cents(%d).Dollars() prints as %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
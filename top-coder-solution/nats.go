@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// natsConn is a minimal client of the NATS text protocol: enough to join a
+// queue group on one subject and publish replies.
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // guards w
+	w    *bufio.Writer
+}
+
+// natsMsg is a message delivered on a subscription.
+type natsMsg struct {
+	Subject string
+	Reply   string
+	Data    []byte
+}
+
+// natsDialTimeout bounds connecting and the protocol handshake.
+const natsDialTimeout = 10 * time.Second
+
+// dialNATS connects to a nats:// or tls:// URL, authenticating with the
+// URL's user and password, or its user as a token when there is no password.
+func dialNATS(rawURL string) (*natsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q, want nats://host[:port]", rawURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	conn, err := net.DialTimeout("tcp", host, natsDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	line, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if op, arg, _ := strings.Cut(line, " "); op != "INFO" || json.Unmarshal([]byte(arg), &info) != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting from NATS server: %q", line)
+	}
+	if info.TLSRequired || u.Scheme == "tls" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.conn, c.r, c.w = tc, bufio.NewReader(tc), bufio.NewWriter(tc)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "lang": "go", "version": "1", "name": "reimbursement-worker"}
+	if user := u.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			opts["user"], opts["pass"] = user.Username(), pass
+		} else {
+			opts["auth_token"] = user.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	if err := c.send("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			c.Close()
+			return nil, fmt.Errorf("NATS server: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	c.conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *natsConn) send(s string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.w.WriteString(s); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// subscribe joins queue on subject, so each message goes to one member.
+func (c *natsConn) subscribe(subject, queue string, sid int) error {
+	return c.send(fmt.Sprintf("SUB %s %s %d\r\n", subject, queue, sid))
+}
+
+func (c *natsConn) publish(subject string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(data))
+	c.w.Write(data)
+	c.w.WriteString("\r\n")
+	return c.w.Flush()
+}
+
+// next returns the next message, answering the server's pings meanwhile.
+func (c *natsConn) next() (natsMsg, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return natsMsg{}, err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(args)
+			if len(f) != 3 && len(f) != 4 {
+				return natsMsg{}, fmt.Errorf("malformed NATS message header %q", line)
+			}
+			n, err := strconv.Atoi(f[len(f)-1])
+			if err != nil {
+				return natsMsg{}, fmt.Errorf("malformed NATS message header %q", line)
+			}
+			msg := natsMsg{Subject: f[0], Data: make([]byte, n+2)}
+			if len(f) == 4 {
+				msg.Reply = f[2]
+			}
+			if _, err := io.ReadFull(c.r, msg.Data); err != nil {
+				return natsMsg{}, err
+			}
+			msg.Data = msg.Data[:n]
+			return msg, nil
+		case "PING":
+			if err := c.send("PONG\r\n"); err != nil {
+				return natsMsg{}, err
+			}
+		case "-ERR":
+			return natsMsg{}, fmt.Errorf("NATS server: %s", strings.TrimSpace(args))
+		}
+	}
+}
+
+func (c *natsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+)
+
+// maxMagnitude bounds the inputs and outputs accepted from training data and
+// queries. Well beyond any trip, it keeps squared distances, weighted sums
+// and integer cents far from overflow.
+const maxMagnitude = 1e12
+
+// debugNumerics, set by -debug-numerics, makes predictors check every
+// intermediate value of a prediction, not only its result, and report the
+// computation that first went non-finite.
+var debugNumerics bool
+
+// checkNumber reports a value that is not finite or is too large to compute
+// with safely.
+func checkNumber(name string, v float64) error {
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		return fmt.Errorf("%s is %v", name, v)
+	case math.Abs(v) > maxMagnitude:
+		return fmt.Errorf("%s %g exceeds %g", name, v, maxMagnitude)
+	}
+	return nil
+}
+
+// checkQuery checks the inputs of q.
+func checkQuery(q Query) error {
+	if err := checkNumber("days", float64(q.TripDurationDays)); err != nil {
+		return err
+	}
+	if err := checkNumber("miles", q.MilesTraveled); err != nil {
+		return err
+	}
+	if err := checkNumber("receipts", q.TotalReceiptsAmount); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(q.Fields)) {
+		if err := checkNumber(name, q.Fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCases returns an error naming the first case with a non-finite or
+// oversized input or output. A single NaN would otherwise make its distance
+// to every query NaN and silently corrupt every neighbor search.
+func checkCases(data TrainingData) error {
+	for i, c := range data {
+		if err := checkCase(c); err != nil {
+			return fmt.Errorf("training case %d (%s): %v", i, describeCase(c), err)
+		}
+	}
+	return nil
+}
+
+// checkNotEmpty rejects training data at path with no cases, which no model
+// can predict from.
+func checkNotEmpty(path string, data TrainingData) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%s has no cases; training data needs at least one", path)
+	}
+	return nil
+}
+
+// checkCase checks the inputs and output of c.
+func checkCase(c TestCase) error {
+	if err := checkQuery(c.Input); err != nil {
+		return err
+	}
+	return checkNumber("expected_output", c.ExpectedOutput)
+}
+
+func describeCase(c TestCase) string {
+	return fmt.Sprintf("%d days, %g miles, $%g receipts, output %g",
+		c.Input.TripDurationDays, c.Input.MilesTraveled, c.Input.TotalReceiptsAmount, c.ExpectedOutput)
+}
+
+// checkPrediction records an error on p when the prediction y for q is not
+// finite, or with debugNumerics when any step computing it was not, and
+// returns y.
+func (p *Predictor) checkPrediction(q Query, y float64) float64 {
+	finite := !math.IsNaN(y) && !math.IsInf(y, 0)
+	if finite && !debugNumerics {
+		return y
+	}
+	err := p.diagnoseNumerics(q)
+	if err == nil && !finite {
+		err = fmt.Errorf("prediction is %v", y)
+		if m := p.predictModel(q); p.overrides != nil && !math.IsNaN(m) && !math.IsInf(m, 0) {
+			err = fmt.Errorf("override rules turned %.2f into %v", m, y)
+		}
+	}
+	if err != nil {
+		p.numericsMu.Lock()
+		if p.numericsErr == nil {
+			p.numericsErr = fmt.Errorf("numerics: %d days, %g miles, $%g receipts: %v",
+				q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, err)
+		}
+		p.numericsMu.Unlock()
+	}
+	return y
+}
+
+// diagnoseNumerics retraces the prediction for q and returns an error naming
+// the first computation that is not finite: the query, a neighbor's
+// distance, output or weight, their sums, or the answering model.
+func (p *Predictor) diagnoseNumerics(q Query) error {
+	if err := checkQuery(q); err != nil {
+		return fmt.Errorf("query %v", err)
+	}
+	if p.model != nil {
+		if y := p.model.Predict(q); math.IsNaN(y) || math.IsInf(y, 0) {
+			return fmt.Errorf("model %s returned %v", p.model.Explain(q).Model, y)
+		}
+		return nil
+	}
+	q = p.Bins.apply(q)
+	v := q.features()
+	if p.linear != nil && p.fallsBack(v) {
+		if y := p.linear.predict(v); math.IsNaN(y) || math.IsInf(y, 0) {
+			return fmt.Errorf("linear fallback returned %v", y)
+		}
+		return nil
+	}
+	pool := p.pool(v)
+	var weightedSum, totalWeight float64
+	for _, n := range p.searcher(v).search(nil, v, max(p.K, 1)) {
+		c := pool[n.Case]
+		w := p.recency.weight(c) / (n.Distance + 1e-8)
+		for _, x := range []struct {
+			name string
+			v    float64
+		}{{"distance", n.Distance}, {"output", n.Output}, {"weight", w}} {
+			if math.IsNaN(x.v) || math.IsInf(x.v, 0) {
+				return fmt.Errorf("neighbor %s to training case %d (%s) is %v", x.name, n.Case, describeCase(c), x.v)
+			}
+		}
+		weightedSum += w * n.Output
+		totalWeight += w
+	}
+	if math.IsInf(weightedSum, 0) || math.IsInf(totalWeight, 0) {
+		return fmt.Errorf("weighted sum of neighbor outputs overflowed")
+	}
+	return nil
+}
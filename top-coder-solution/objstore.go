@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object storage URI schemes accepted wherever a data file is read.
+const (
+	schemeS3  = "s3://"
+	schemeGCS = "gs://"
+)
+
+// isRemote reports whether path names an object in S3 or Cloud Storage.
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, schemeS3) || strings.HasPrefix(path, schemeGCS)
+}
+
+// fetchMu serializes fetches, so two loads of one object never download it
+// into the cache at once.
+var fetchMu sync.Mutex
+
+// localPath returns path itself for local files, and for s3:// and gs://
+// URIs a local copy of the object. Copies are cached by URI and revalidated
+// with the object's ETag on every call, so an unchanged object is not
+// downloaded again and a reload sees an updated one.
+func localPath(path string) (string, error) {
+	if !isRemote(path) {
+		return path, nil
+	}
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+	local, err := fetchObject(path)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %v", path, err)
+	}
+	return local, nil
+}
+
+// objectCacheDir is where fetched objects are kept.
+func objectCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "top-coder-solution", "objects"), nil
+}
+
+// objectTimeout bounds a single object download.
+const objectTimeout = 5 * time.Minute
+
+func fetchObject(uri string) (string, error) {
+	dir, err := objectCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(uri))
+	local := filepath.Join(dir, hex.EncodeToString(sum[:16])+path.Ext(uri))
+	etagPath := local + ".etag"
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+	req, err := objectRequest(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	if etag, err := os.ReadFile(etagPath); err == nil {
+		if _, err := os.Stat(local); err == nil {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+	if err := authorizeObjectRequest(ctx, req, uri); err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return local, nil
+	case http.StatusOK:
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+		if req.Header.Get("Authorization") == "" && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized) {
+			err = fmt.Errorf("%v (no credentials found, so the request was anonymous)", err)
+		}
+		return "", err
+	}
+
+	// Download beside the cached copy and rename over it, so a failed
+	// download never leaves a truncated file behind.
+	tmp, err := os.CreateTemp(dir, "fetch-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return "", err
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		os.WriteFile(etagPath, []byte(etag), 0o644)
+	} else {
+		os.Remove(etagPath)
+	}
+	return local, nil
+}
+
+// splitObjectURI splits scheme://bucket/key.
+func splitObjectURI(uri string) (bucket, key string, err error) {
+	rest := uri[strings.Index(uri, "://")+3:]
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid object URI %q, want scheme://bucket/key", uri)
+	}
+	return bucket, key, nil
+}
+
+// objectRequest returns the unauthenticated GET request for an object.
+func objectRequest(ctx context.Context, uri string) (*http.Request, error) {
+	bucket, key, err := splitObjectURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	var endpoint string
+	if strings.HasPrefix(uri, schemeGCS) {
+		endpoint = "https://storage.googleapis.com/storage/v1/b/" + url.PathEscape(bucket) + "/o/" +
+			url.PathEscape(key) + "?alt=media"
+	} else if custom := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); custom != "" {
+		// S3-compatible stores such as MinIO address buckets by path.
+		endpoint = strings.TrimSuffix(custom, "/") + "/" + awsEscape(bucket) + "/" + awsEscape(key)
+	} else {
+		endpoint = "https://" + bucket + ".s3." + awsRegion() + ".amazonaws.com/" + awsEscape(key)
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+}
+
+// authorizeObjectRequest signs req with credentials discovered from the
+// environment. Without credentials the request is sent anonymously, which
+// works for public objects.
+func authorizeObjectRequest(ctx context.Context, req *http.Request, uri string) error {
+	if strings.HasPrefix(uri, schemeGCS) {
+		token, err := gcsToken(ctx)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return nil
+	}
+	creds, err := awsCredentials(ctx)
+	if err != nil {
+		return fmt.Errorf("AWS credentials: %v", err)
+	}
+	if creds.accessKey != "" {
+		signAWSv4(req, creds, awsRegion(), "s3", time.Now())
+	}
+	return nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func awsRegion() string {
+	if region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+type awsCreds struct {
+	accessKey, secretKey, sessionToken string
+}
+
+// awsCredentials discovers credentials like the AWS SDKs: from the
+// environment; from a web identity token exchanged for a role's credentials,
+// as on EKS with IAM roles for service accounts; from the shared credentials
+// file for AWS_PROFILE; from the ECS container credentials endpoint; and
+// from the EC2 instance metadata service. It returns no credentials when
+// none of them has any.
+func awsCredentials(ctx context.Context) (awsCreds, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCreds{id, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return awsWebIdentityCredentials(ctx, tokenFile)
+	}
+	creds, err := awsSharedCredentials()
+	if err != nil || creds.accessKey != "" {
+		return creds, err
+	}
+	if relative, full := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); relative != "" || full != "" {
+		return awsContainerCredentials(ctx, relative, full)
+	}
+	return awsInstanceCredentials(ctx), nil
+}
+
+// awsSharedCredentials reads the shared credentials file's profile for
+// AWS_PROFILE.
+func awsSharedCredentials() (awsCreds, error) {
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCreds{}, nil
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return awsCreds{}, nil
+	} else if err != nil {
+		return awsCreds{}, err
+	}
+	defer f.Close()
+
+	profile := firstEnv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	var creds awsCreds
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			creds.accessKey = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			creds.secretKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			creds.sessionToken = strings.TrimSpace(v)
+		}
+	}
+	return creds, scanner.Err()
+}
+
+// awsRoleCredentials is the JSON form of temporary role credentials, as the
+// container and instance metadata endpoints serve them.
+type awsRoleCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// awsMetadataTimeout bounds each request to a credentials endpoint on the
+// local network, which is unreachable rather than slow off AWS.
+const awsMetadataTimeout = 2 * time.Second
+
+// awsWebIdentityCredentials exchanges the web identity token in tokenFile
+// for temporary credentials of the role AWS_ROLE_ARN, through STS.
+func awsWebIdentityCredentials(ctx context.Context, tokenFile string) (awsCreds, error) {
+	role := os.Getenv("AWS_ROLE_ARN")
+	if role == "" {
+		return awsCreds{}, fmt.Errorf("AWS_WEB_IDENTITY_TOKEN_FILE is set but AWS_ROLE_ARN is not")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCreds{}, fmt.Errorf("reading web identity token: %v", err)
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("top-coder-solution-%d", time.Now().Unix())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	endpoint := firstEnv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = "https://sts." + awsRegion() + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCreds{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return awsCreds{}, fmt.Errorf("assuming role %s: %v", role, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		Message string `xml:"Error>Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		return awsCreds{}, fmt.Errorf("assuming role %s: %s %s", role, resp.Status, body.Message)
+	}
+	c := body.Credentials
+	return awsCreds{c.AccessKeyID, c.SecretAccessKey, c.SessionToken}, nil
+}
+
+// awsContainerCredentials fetches the task role's credentials from the ECS
+// (or EKS Pod Identity) container credentials endpoint: the relative URI on
+// the ECS agent's address, or else the full URI, with the authorization
+// token its environment gives.
+func awsContainerCredentials(ctx context.Context, relative, full string) (awsCreds, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsMetadataTimeout)
+	defer cancel()
+	endpoint := full
+	if relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCreds{}, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return awsCreds{}, fmt.Errorf("reading container authorization token: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	creds, err := getRoleCredentials(req)
+	if err != nil {
+		return awsCreds{}, fmt.Errorf("container credentials: %v", err)
+	}
+	return creds, nil
+}
+
+// awsInstanceCredentials fetches the instance role's credentials from the
+// EC2 instance metadata service with an IMDSv2 session token, returning no
+// credentials off EC2, without a role, or with AWS_EC2_METADATA_DISABLED.
+func awsInstanceCredentials(ctx context.Context) awsCreds {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return awsCreds{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, awsMetadataTimeout)
+	defer cancel()
+	base := firstEnv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base+"/latest/api/token", nil)
+	if err != nil {
+		return awsCreds{}
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	token, err := getMetadata(req)
+	if err != nil {
+		return awsCreds{}
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return awsCreds{}
+	}
+	roles, err := getMetadata(req)
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if err != nil || role == "" {
+		return awsCreds{}
+	}
+	if req, err = get(role); err != nil {
+		return awsCreds{}
+	}
+	creds, _ := getRoleCredentials(req)
+	return creds
+}
+
+// getMetadata returns the body of a successful metadata request.
+func getMetadata(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// getRoleCredentials returns the role credentials a metadata request
+// answers with.
+func getRoleCredentials(req *http.Request) (awsCreds, error) {
+	body, err := getMetadata(req)
+	if err != nil {
+		return awsCreds{}, err
+	}
+	var c awsRoleCredentials
+	if err := json.Unmarshal(body, &c); err != nil {
+		return awsCreds{}, err
+	}
+	if c.AccessKeyID == "" {
+		return awsCreds{}, fmt.Errorf("response has no AccessKeyId")
+	}
+	return awsCreds{c.AccessKeyID, c.SecretAccessKey, c.Token}, nil
+}
+
+// awsEscape percent-encodes an S3 key as Signature Version 4 requires:
+// everything but unreserved characters and the slashes between segments.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// emptySHA256 is the hex SHA-256 of an empty request body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signAWSv4 signs a bodiless request with AWS Signature Version 4, covering
+// the host and every header already set on it.
+func signAWSv4(req *http.Request, creds awsCreds, region, service string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsEscape(k)+"="+strings.ReplaceAll(awsEscape(v), "/", "%2F"))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), strings.Join(params, "&"),
+		canonicalHeaders.String(), signedHeaders, emptySHA256,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+// gcsScope is the OAuth scope for reading Cloud Storage objects.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsToken discovers an OAuth access token like Google's client libraries:
+// GOOGLE_OAUTH_ACCESS_TOKEN, then the application default credentials file
+// (a service account key or a gcloud user login), then the metadata server
+// of a Google Cloud VM. It returns "" when none is available.
+func gcsToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if file == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			file = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(file); err != nil {
+				file = ""
+			}
+		}
+	}
+	if file != "" {
+		return gcsTokenFromFile(ctx, file)
+	}
+	return gcsMetadataToken(ctx), nil
+}
+
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func gcsTokenFromFile(ctx context.Context, file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var c googleCredentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", fmt.Errorf("parsing credentials %s: %v", file, err)
+	}
+	if c.TokenURI == "" {
+		c.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	form := url.Values{}
+	switch c.Type {
+	case "service_account":
+		assertion, err := c.jwtAssertion(time.Now())
+		if err != nil {
+			return "", fmt.Errorf("credentials %s: %v", file, err)
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	default:
+		return "", fmt.Errorf("credentials %s: unsupported type %q", file, c.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(req)
+}
+
+// jwtAssertion is the signed JWT a service account exchanges for a token.
+func (c googleCredentials) jwtAssertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key is not RSA")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   c.ClientEmail,
+		"scope": gcsScope,
+		"aud":   c.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// gcsMetadataToken asks the metadata server of a Google Cloud VM for its
+// service account's token, returning "" off Google Cloud.
+func gcsMetadataToken(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, err := requestToken(req)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+func requestToken(req *http.Request) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token request: %s", resp.Status)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("token request: %s: %s %s", resp.Status, body.Error, body.Description)
+	}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiOperation describes one route of the HTTP API for the OpenAPI spec.
+// Request and response bodies are given as values of their Go types, whose
+// schemas are derived from the types themselves, so the spec cannot drift
+// from what the handlers encode.
+type apiOperation struct {
+	method, path, summary string
+	scope                 string // required scope; empty for routes open to all
+	request               any    // JSON body; nil for none
+	status                int
+	response              any    // JSON body of a successful response
+	mediaType             string // of a non-JSON successful response
+	query                 map[string]string
+	errors                []int
+	perPolicy             bool // also served under policyPrefix
+}
+
+var apiOperations = []apiOperation{
+	{method: "POST", path: "/predict", summary: "Predict the reimbursement of a trip",
+		scope: scopePredict, request: PredictRequest{}, status: http.StatusOK, response: PredictionResponse{},
+		errors: []int{400, 404, 413, 422, 503}, perPolicy: true},
+	{method: "POST", path: "/batch", summary: "Predict the reimbursements of several trips",
+		scope: scopePredict, request: BatchRequest{}, status: http.StatusOK, response: BatchResponse{},
+		errors: []int{400, 404, 413, 422, 503}, perPolicy: true},
+	{method: "POST", path: "/jobs", summary: "Submit an async batch job, optionally scored against expected outputs",
+		scope: scopePredict, request: JobRequest{}, status: http.StatusAccepted, response: JobView{},
+		errors: []int{400, 404, 413, 503}, perPolicy: true},
+	{method: "GET", path: "/jobs/{id}", summary: "Report a job's progress",
+		scope: scopePredict, status: http.StatusOK, response: JobView{}, errors: []int{404}},
+	{method: "DELETE", path: "/jobs/{id}", summary: "Cancel a job",
+		scope: scopePredict, status: http.StatusOK, response: JobView{}, errors: []int{404}},
+	{method: "GET", path: "/jobs/{id}/results", summary: "Download a finished job's predictions",
+		scope: scopePredict, status: http.StatusOK, response: BatchResponse{},
+		query:  map[string]string{"format": "csv to download CSV instead of JSON"},
+		errors: []int{404, 409}},
+	{method: "GET", path: "/jobs/{id}/events",
+		summary: "Stream a job's progress as server-sent events: progress events carrying the job, then a done event",
+		scope:   scopePredict, status: http.StatusOK, mediaType: "text/event-stream", errors: []int{404}},
+	{method: "GET", path: "/modelinfo", summary: "Describe the model being served",
+		scope: scopePredict, status: http.StatusOK, response: ModelInfo{}, errors: []int{404, 503}, perPolicy: true},
+	{method: "POST", path: "/reload", summary: "Reload the model from its data files",
+		scope: scopeAdmin, status: http.StatusOK, response: ModelInfo{}, errors: []int{404, 422}, perPolicy: true},
+	{method: "GET", path: "/metrics", summary: "Report each policy's prediction and reload counters in the Prometheus text format",
+		scope: scopeAdmin, status: http.StatusOK, mediaType: "text/plain"},
+	{method: "GET", path: "/healthz", summary: "Report that the process is up",
+		status: http.StatusOK, response: map[string]string{}},
+	{method: "GET", path: "/readyz", summary: "Report whether the model has loaded",
+		status: http.StatusOK, response: map[string]string{}, errors: []int{503}},
+}
+
+// openAPISpec returns the OpenAPI 3 description of the HTTP API.
+func openAPISpec() map[string]any {
+	b := schemaBuilder{components: map[string]any{}}
+	errorSchema := map[string]any{
+		"type":       "object",
+		"required":   []string{"error"},
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}
+	paths := map[string]any{}
+	for _, op := range apiOperations {
+		success := map[string]any{"description": http.StatusText(op.status)}
+		switch {
+		case op.mediaType != "":
+			success["content"] = map[string]any{op.mediaType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		case op.response != nil:
+			success["content"] = jsonContent(b.schema(reflect.TypeOf(op.response)))
+		}
+		responses := map[string]any{strconv.Itoa(op.status): success}
+		codes := op.errors
+		if op.scope != "" {
+			codes = slices.Concat(codes, []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests})
+		}
+		for _, code := range codes {
+			responses[strconv.Itoa(code)] = map[string]any{
+				"description": http.StatusText(code),
+				"content":     jsonContent(map[string]any{"$ref": "#/components/schemas/Error"}),
+			}
+		}
+
+		operation := map[string]any{"summary": op.summary, "responses": responses}
+		if op.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(b.schema(reflect.TypeOf(op.request))),
+			}
+		}
+		if op.scope != "" {
+			operation["description"] = "Requires the " + op.scope + " scope when the server has -auth-config."
+			operation["security"] = []any{map[string]any{"bearer": []string{}}}
+		} else {
+			operation["security"] = []any{}
+		}
+		opPaths := []string{op.path}
+		if op.perPolicy {
+			opPaths = append(opPaths, policyPrefix+op.path)
+		}
+		for _, path := range opPaths {
+			var params []any
+			for _, name := range pathParams(path) {
+				params = append(params, map[string]any{
+					"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+				})
+			}
+			for _, name := range slices.Sorted(maps.Keys(op.query)) {
+				params = append(params, map[string]any{
+					"name": name, "in": "query", "description": op.query[name], "schema": map[string]any{"type": "string"},
+				})
+			}
+			pathOp := maps.Clone(operation)
+			if params != nil {
+				pathOp["parameters"] = params
+			}
+			item, _ := paths[path].(map[string]any)
+			if item == nil {
+				item = map[string]any{}
+				paths[path] = item
+			}
+			item[strings.ToLower(op.method)] = pathOp
+		}
+	}
+	b.components["Error"] = errorSchema
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Travel reimbursement API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// pathParams returns the names of the {wildcards} in a route pattern.
+func pathParams(path string) []string {
+	var names []string
+	for _, seg := range strings.Split(path, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			names = append(names, strings.TrimSuffix(name, "}"))
+		}
+	}
+	return names
+}
+
+// schemaBuilder derives JSON schemas from Go types as encoding/json encodes
+// them, collecting named struct types as reusable components.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	caseTimeType = reflect.TypeFor[caseTime]()
+	queryType    = reflect.TypeFor[Query]()
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType || t == caseTimeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = map[string]any{} // placeholder for recursive types
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	b.fields(t, props, &required)
+	s := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		s["required"] = required
+	}
+	// A query's named input fields are further members of its object.
+	if f, ok := t.FieldByName("Query"); t == queryType || ok && f.Anonymous && f.Type == queryType {
+		s["additionalProperties"] = map[string]any{"type": "number"}
+	}
+	return s
+}
+
+// fields adds the JSON properties of struct type t to props, promoting the
+// fields of untagged embedded structs as encoding/json does. Properties
+// always encoded are required.
+func (b *schemaBuilder) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.fields(ft, props, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, openAPISpec())
+}
+
+// docsPage is the interactive API reference served at /docs: it renders the
+// spec at /openapi.json in the manner of Swagger UI and sends requests to the
+// server, and is self-contained so it works without network access.
+//
+//go:embed docs.html
+var docsPage []byte
+
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// runOpenAPI prints the OpenAPI spec the server publishes at /openapi.json,
+// for generating clients without a running server.
+func runOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return writeJSON(os.Stdout, openAPISpec())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// writeJSON writes v as indented JSON without HTML escaping, so that
+// conditions like "receipts <= 800" stay readable.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// writeJSONFile writes v as JSON to path, or to stdout when path is empty.
+func writeJSONFile(path string, v any) error {
+	if path == "" {
+		return writeJSON(os.Stdout, v)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(file, v); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// OverrideRule adjusts predictions after the model, encoding a quirk of the
+// policy without retraining. Rule has the form
+//
+//	if <condition> then output <op> <expression>
+//
+// where op is =, +=, -=, *= or /=, and the condition and expression are
+// expressions (see expr) over days, miles, receipts and output, as in
+// "if days == 5 then output *= 1.08". Rules apply in order, each to the
+// output of the ones before.
+type OverrideRule struct {
+	Name string `json:"name,omitempty"` // defaults to Rule in explanations
+	Rule string `json:"rule"`
+}
+
+// overrideVars are the variables of override rules.
+var overrideVars = []string{"days", "miles", "receipts", "output"}
+
+var overrideSyntax = regexp.MustCompile(`^\s*if\s+(.+?)\s+then\s+output\s*([-+*/]?=)\s*(.+?)\s*$`)
+
+// override is a compiled OverrideRule.
+type override struct {
+	name  string
+	cond  *expr
+	op    string
+	value *expr
+}
+
+func compileOverrides(rules []OverrideRule) ([]override, error) {
+	out := make([]override, len(rules))
+	for i, r := range rules {
+		m := overrideSyntax.FindStringSubmatch(r.Rule)
+		if m == nil {
+			return nil, fmt.Errorf("override %d: %q is not of the form \"if <condition> then output <op> <expression>\"", i+1, r.Rule)
+		}
+		cond, err := parseExpr(m[1], overrideVars)
+		if err != nil {
+			return nil, fmt.Errorf("override %d: %v", i+1, err)
+		}
+		value, err := parseExpr(m[3], overrideVars)
+		if err != nil {
+			return nil, fmt.Errorf("override %d: %v", i+1, err)
+		}
+		out[i] = override{name: r.Name, cond: cond, op: m[2], value: value}
+		if out[i].name == "" {
+			out[i].name = r.Rule
+		}
+	}
+	return out, nil
+}
+
+// apply reports whether the rule fires for v, whose output is the
+// prediction so far, and returns the overridden output.
+func (o override) apply(v featureVector, output float64) (float64, bool) {
+	vars := [...]float64{v[0], v[1], v[2], output}
+	if o.cond.eval(vars[:]) == 0 {
+		return output, false
+	}
+	x := o.value.eval(vars[:])
+	switch o.op {
+	case "+=":
+		return output + x, true
+	case "-=":
+		return output - x, true
+	case "*=":
+		return output * x, true
+	case "/=":
+		if x == 0 {
+			return 0, true
+		}
+		return output / x, true
+	}
+	return x, true
+}
+
+// applyOverrides runs rules over output, the model's prediction for v, and
+// returns the final output and the names of the rules that fired.
+func applyOverrides(rules []override, v featureVector, output float64) (float64, []string) {
+	var fired []string
+	for _, r := range rules {
+		var ok bool
+		if output, ok = r.apply(v, output); ok {
+			fired = append(fired, r.name)
+		}
+	}
+	return output, fired
+}
+
+// loadOverrides reads a JSON array of override rules from path.
+func loadOverrides(path string) ([]OverrideRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []OverrideRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing overrides %s: %v", path, err)
+	}
+	if _, err := compileOverrides(rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
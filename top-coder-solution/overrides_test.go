@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	rules, err := compileOverrides([]OverrideRule{
+		{Name: "five-day bonus", Rule: "if days == 5 then output *= 1.08"},
+		{Rule: "if receipts > 2000 then output -= 50"},
+		{Name: "floor", Rule: "if output < 100 then output = 100"},
+		{Name: "cap", Rule: "if output > 2000 then output = 2000"},
+		{Name: "zero divisor", Rule: "if miles == 999 then output /= days - days"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		v     featureVector
+		in    float64
+		want  float64
+		fired []string
+	}{
+		{"none fire", featureVector{3, 100, 500}, 500, 500, nil},
+		{"one fires", featureVector{5, 100, 500}, 1000, 1080, []string{"five-day bonus"}},
+		{"unnamed rules are named by their text", featureVector{3, 100, 2500}, 500, 450,
+			[]string{"if receipts > 2000 then output -= 50"}},
+		{"each applies to the output so far", featureVector{5, 100, 2500}, 50, 100,
+			[]string{"five-day bonus", "if receipts > 2000 then output -= 50", "floor"}},
+		{"cap", featureVector{5, 100, 500}, 1900, 2000, []string{"five-day bonus", "cap"}},
+		{"division by zero gives 0, after the floor", featureVector{3, 999, 500}, 500, 0,
+			[]string{"zero divisor"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fired := applyOverrides(rules, tt.v, tt.in)
+			if got != tt.want {
+				t.Errorf("output %g, want %g", got, tt.want)
+			}
+			if !slices.Equal(fired, tt.fired) {
+				t.Errorf("fired %q, want %q", fired, tt.fired)
+			}
+		})
+	}
+}
+
+func TestCompileOverridesErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"days == 5 then output *= 2", "is not of the form"},
+		{"if days == 5 then output ^= 2", "is not of the form"},
+		{"if days == 5 then result = 2", "is not of the form"},
+		{"if nights == 5 then output = 2", `unknown variable "nights"`},
+		{"if days == 5 then output = 2 +", "unexpected end"},
+	}
+	for _, tt := range tests {
+		_, err := compileOverrides([]OverrideRule{{Rule: "if days > 0 then output = output"}, {Rule: tt.rule}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "override 2: ") {
+			t.Errorf("compileOverrides(%q) = %v, want override 2 failing with %q", tt.rule, err, tt.want)
+		}
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	rules, err := loadOverrides(write("ok.json", `[{"name": "bonus", "rule": "if days == 5 then output += 10"}]`))
+	if err != nil || len(rules) != 1 || rules[0].Name != "bonus" {
+		t.Errorf("loadOverrides = %v, %v; want the one rule", rules, err)
+	}
+	if _, err := loadOverrides(write("bad.json", `{"rule": "x"}`)); err == nil || !strings.Contains(err.Error(), "parsing overrides") {
+		t.Errorf("loading an object instead of a list: got %v", err)
+	}
+	if _, err := loadOverrides(write("rule.json", `[{"rule": "if x then output = 1"}]`)); err == nil || !strings.Contains(err.Error(), "override 1") {
+		t.Errorf("loading an invalid rule: got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"unsafe"
+)
+
+// The packed training data format is a 16-byte header (magic, format version
+// and case count) followed by fixed-size little-endian records of trip days
+// (int64), miles and receipts (float64), 8 zero bytes, expected output
+// (float64) and timestamp (int64 Unix seconds, 0 when unknown). Records
+// match the in-memory layout of TestCase on 64-bit little-endian machines,
+// the zero bytes standing for a nil map of named input fields, which packed
+// data cannot hold. So a packed file can be memory-mapped and used in place:
+// worker processes mapping the same file share one copy in the page cache
+// instead of each decoding the data into its own heap. Version 1 files,
+// whose records lack the timestamp, and version 2 files, whose records lack
+// the zero bytes, are still read but decoded into the heap.
+const (
+	packedMagic      = "TCPK"
+	packedVersion    = 3
+	packedHeaderSize = 16
+	packedRecordSize = 48
+)
+
+// packedRecordSizes maps each readable format version to its record size.
+var packedRecordSizes = map[uint32]int{1: 32, 2: 40, packedVersion: packedRecordSize}
+
+// checkPackable rejects a case with named input fields, which packed
+// records cannot hold.
+func checkPackable(c TestCase) error {
+	if len(c.Input.Fields) > 0 {
+		return fmt.Errorf("packed data cannot hold named input fields such as %q", slices.Min(slices.Collect(maps.Keys(c.Input.Fields))))
+	}
+	return nil
+}
+
+// isPacked reports whether the file at path is in the packed format.
+func isPacked(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	magic := make([]byte, len(packedMagic))
+	n, _ := file.Read(magic)
+	return n == len(magic) && string(magic) == packedMagic, nil
+}
+
+// writePacked writes data in the packed format. It writes a temporary file
+// beside path, syncs it and renames it over path, so a process that has the
+// old file memory-mapped keeps reading the old data: truncating a mapped
+// file in place would crash that process on its next read of it. Replace a
+// packed file that may be mapped only this way, never by rewriting it.
+func writePacked(path string, data TrainingData) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	w := bufio.NewWriter(file)
+	header := make([]byte, packedHeaderSize)
+	copy(header, packedMagic)
+	binary.LittleEndian.PutUint32(header[4:], packedVersion)
+	binary.LittleEndian.PutUint64(header[8:], uint64(len(data)))
+	w.Write(header)
+
+	rec := make([]byte, packedRecordSize)
+	for i, c := range data {
+		if err := checkPackable(c); err != nil {
+			file.Close()
+			return fmt.Errorf("case %d: %v", i, err)
+		}
+		encodeRecord(rec, c)
+		w.Write(rec)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = file.Chmod(0o644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// encodeRecord encodes c into the packed record rec.
+func encodeRecord(rec []byte, c TestCase) {
+	binary.LittleEndian.PutUint64(rec[0:], uint64(int64(c.Input.TripDurationDays)))
+	binary.LittleEndian.PutUint64(rec[8:], math.Float64bits(c.Input.MilesTraveled))
+	binary.LittleEndian.PutUint64(rec[16:], math.Float64bits(c.Input.TotalReceiptsAmount))
+	binary.LittleEndian.PutUint64(rec[24:], 0)
+	binary.LittleEndian.PutUint64(rec[32:], math.Float64bits(c.ExpectedOutput))
+	binary.LittleEndian.PutUint64(rec[40:], uint64(int64(c.Timestamp)))
+}
+
+// packedCount validates the header of a packed file of size bytes and
+// returns its case count and record size.
+func packedCount(header []byte, size int64) (n, recordSize int, err error) {
+	if len(header) < packedHeaderSize || string(header[:4]) != packedMagic {
+		return 0, 0, fmt.Errorf("not a packed training data file")
+	}
+	v := binary.LittleEndian.Uint32(header[4:])
+	recordSize, ok := packedRecordSizes[v]
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported packed format version %d", v)
+	}
+	count := binary.LittleEndian.Uint64(header[8:])
+	if count > uint64(size-packedHeaderSize)/uint64(recordSize) || size != packedHeaderSize+int64(count)*int64(recordSize) {
+		return 0, 0, fmt.Errorf("packed file is %d bytes, inconsistent with its %d cases", size, count)
+	}
+	return int(count), recordSize, nil
+}
+
+// decodeRecords decodes packed records of recordSize bytes into out.
+func decodeRecords(out TrainingData, records []byte, recordSize int) {
+	output := 24 // the offset of the expected output, before version 3
+	if recordSize == packedRecordSize {
+		output = 32
+	}
+	for i := range out {
+		rec := records[i*recordSize:]
+		out[i].Input.TripDurationDays = int(int64(binary.LittleEndian.Uint64(rec[0:])))
+		out[i].Input.MilesTraveled = math.Float64frombits(binary.LittleEndian.Uint64(rec[8:]))
+		out[i].Input.TotalReceiptsAmount = math.Float64frombits(binary.LittleEndian.Uint64(rec[16:]))
+		out[i].ExpectedOutput = math.Float64frombits(binary.LittleEndian.Uint64(rec[output:]))
+		if recordSize > 32 {
+			out[i].Timestamp = caseTime(int64(binary.LittleEndian.Uint64(rec[output+8:])))
+		}
+	}
+}
+
+// packedShardCases is the fewest cases worth decoding on their own goroutine.
+const packedShardCases = 1 << 15
+
+// decodePackedFile decodes the packed file at path into the heap. The
+// records are split into shards that are read and decoded concurrently,
+// each straight into its place in the result.
+func decodePackedFile(path string) (TrainingData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, packedHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%s: reading header: %v", path, err)
+	}
+	n, recordSize, err := packedCount(header, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	out := make(TrainingData, n)
+	var mu sync.Mutex
+	var firstErr error
+	forShards(n, packedShardCases, func(lo, hi int) {
+		buf := make([]byte, (hi-lo)*recordSize)
+		if _, err := file.ReadAt(buf, packedHeaderSize+int64(lo)*int64(recordSize)); err != nil {
+			mu.Lock()
+			firstErr = cmp.Or(firstErr, err)
+			mu.Unlock()
+			return
+		}
+		decodeRecords(out[lo:hi], buf, recordSize)
+	})
+	if firstErr != nil {
+		return nil, fmt.Errorf("%s: %v", path, firstErr)
+	}
+	return out, nil
+}
+
+// canMapInPlace reports whether packed records share TestCase's memory
+// layout on this machine.
+func canMapInPlace() bool {
+	var probe [2]byte
+	binary.NativeEndian.PutUint16(probe[:], 1)
+	var c TestCase
+	return probe[0] == 1 &&
+		unsafe.Sizeof(c) == packedRecordSize &&
+		unsafe.Sizeof(c.Input.TripDurationDays) == 8 &&
+		unsafe.Offsetof(c.Input.Fields) == 24 &&
+		unsafe.Sizeof(c.Input.Fields) == 8 &&
+		unsafe.Offsetof(c.ExpectedOutput) == 32 &&
+		unsafe.Offsetof(c.Timestamp) == 40
+}
+
+// mapped records the address ranges of memory-mapped training data, which
+// is read-only and so may be shared by predictors without copying.
+var mapped struct {
+	sync.Mutex
+	ranges [][2]uintptr
+}
+
+func isMapped(data TrainingData) bool {
+	if len(data) == 0 {
+		return false
+	}
+	addr := uintptr(unsafe.Pointer(&data[0]))
+	mapped.Lock()
+	defer mapped.Unlock()
+	for _, r := range mapped.ranges {
+		if addr >= r[0] && addr < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPacked loads a packed training data file. With mapInPlace set, and
+// where the platform and the file's format version allow it, the file is
+// memory-mapped read-only and used in place; otherwise it is decoded into the
+// heap. Mappings stay in place for the life of the process. Non-finite
+// values are rejected, as packing does not check them.
+func loadPacked(path string, mapInPlace bool) (TrainingData, error) {
+	data, err := readPacked(path, mapInPlace)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCases(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+func readPacked(path string, mapInPlace bool) (TrainingData, error) {
+	if !mapInPlace || !canMapInPlace() {
+		return decodePackedFile(path)
+	}
+
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n, recordSize, err := packedCount(data, int64(len(data)))
+	if err != nil || n == 0 || recordSize != packedRecordSize {
+		unmapFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if n > 0 {
+			return decodePackedFile(path) // an older format version
+		}
+		return TrainingData{}, nil
+	}
+	base := unsafe.Pointer(&data[packedHeaderSize])
+	mapped.Lock()
+	mapped.ranges = append(mapped.ranges, [2]uintptr{uintptr(base), uintptr(base) + uintptr(n*packedRecordSize)})
+	mapped.Unlock()
+	return unsafe.Slice((*TestCase)(base), n), nil
+}
+
+func runPack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data to pack")
+	out := fs.String("out", "", "packed output file (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	data, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if err := writePacked(*out, data); err != nil {
+		return fmt.Errorf("writing %s: %v", *out, err)
+	}
+	fmt.Printf("Packed %d cases into %s\n", len(data), *out)
+	return nil
+}
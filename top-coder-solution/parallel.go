@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// forShards splits [0, n) into contiguous shards of at least minShard items,
+// one per CPU at most, and calls fn on each concurrently. It returns once
+// every shard is done.
+func forShards(n, minShard int, fn func(lo, hi int)) {
+	shards := min(runtime.GOMAXPROCS(0), max(n/max(minShard, 1), 1))
+	if shards <= 1 {
+		fn(0, n)
+		return
+	}
+	var wg sync.WaitGroup
+	for s := range shards {
+		lo, hi := n*s/shards, n*(s+1)/shards
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// forEach calls fn for every i in [0, n) on a pool of at most workers
+// goroutines (GOMAXPROCS when workers is 0 or less), handing out indexes in
+// increasing order. It returns once every call is done.
+func forEach(n, workers int, fn func(i int)) {
+	forEachContext(context.Background(), n, workers, fn)
+}
+
+// forEachContext is forEach that stops handing out indexes once ctx is
+// done. It returns once every call started is done, with ctx's error if
+// some index was never handed out.
+func forEachContext(ctx context.Context, n, workers int, fn func(i int)) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, n)
+	if workers <= 1 {
+		for i := range n {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			fn(i)
+		}
+		return nil
+	}
+	var next atomic.Int64
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				if ctx.Err() != nil {
+					stopped.Store(true)
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+	if stopped.Load() {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// progress reports a count of finished items to w every interval until
+// stopped. A nil *progress discards updates, so callers need not check
+// whether reporting is on.
+type progress struct {
+	w     io.Writer
+	label string
+	total int64
+	start time.Time
+	done  atomic.Int64
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func startProgress(w io.Writer, label string, total int, interval time.Duration) *progress {
+	p := &progress{w: w, label: label, total: int64(total), start: time.Now(), quit: make(chan struct{})}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.quit:
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) add(n int) {
+	if p != nil {
+		p.done.Add(int64(n))
+	}
+}
+
+func (p *progress) report() {
+	done, elapsed := p.done.Load(), time.Since(p.start)
+	line := fmt.Sprintf("%s: %d/%d (%.0f%%) in %s", p.label, done, p.total, pct(int(done), int(p.total)),
+		elapsed.Round(time.Second))
+	if done > 0 && done < p.total {
+		eta := time.Duration(float64(elapsed) * float64(p.total-done) / float64(done))
+		line += fmt.Sprintf(", about %s left", eta.Round(time.Second))
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// stop ends reporting with a final report.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+	close(p.quit)
+	p.wg.Wait()
+	p.report()
+}
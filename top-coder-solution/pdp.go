@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// defaultPDPPoints is the number of grid points of a feature's curve when
+// no grid is given.
+const defaultPDPPoints = 50
+
+// dependence computes the individual conditional expectation curves of the
+// cases, their predictions with the feature set to each grid value and the
+// other inputs as recorded, and the partial dependence curve, the mean of
+// all training cases' curves.
+func dependence(p *Predictor, feature int, grid []float64, ice TrainingData, jobs int) (pdp []float64, curves [][]float64) {
+	predict := func(c TestCase, x float64) float64 {
+		v := caseFeatures(c)
+		v[feature] = x
+		return p.Predict(int(math.Round(v[0])), v[1], v[2])
+	}
+	pdp = make([]float64, len(grid))
+	forEach(len(grid), jobs, func(g int) {
+		sum := 0.0
+		for _, c := range p.Training {
+			sum += predict(c, grid[g])
+		}
+		pdp[g] = sum / float64(len(p.Training))
+	})
+	curves = make([][]float64, len(ice))
+	forEach(len(ice), jobs, func(i int) {
+		curves[i] = make([]float64, len(grid))
+		for g, x := range grid {
+			curves[i][g] = predict(ice[i], x)
+		}
+	})
+	return pdp, curves
+}
+
+// defaultPDPGrid spans the feature's training range: every day count, or
+// defaultPDPPoints evenly spaced values to the cent.
+func defaultPDPGrid(training TrainingData, feature int) []float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, c := range training {
+		x := caseFeatures(c)[feature]
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	if featureNames[feature] == "days" {
+		return gridRange{Start: lo, End: hi, Step: 1}.Values()
+	}
+	if hi == lo {
+		return []float64{lo}
+	}
+	grid := gridRange{Start: lo, End: hi, Step: (hi - lo) / (defaultPDPPoints - 1)}.Values()
+	for i := range grid {
+		grid[i] = roundCents(grid[i])
+	}
+	return grid
+}
+
+// iceSample returns n training cases evenly spaced through the data.
+func iceSample(training TrainingData, n int) TrainingData {
+	n = min(n, len(training))
+	out := make(TrainingData, n)
+	for i := range out {
+		out[i] = training[i*len(training)/n]
+	}
+	return out
+}
+
+// writeDependence writes one CSV row per grid value: the value, the partial
+// dependence, and each ICE curve in a column named for its case's inputs.
+func writeDependence(w io.Writer, feature string, grid, pdp []float64, ice TrainingData, curves [][]float64) error {
+	cw := csv.NewWriter(w)
+	header := []string{feature, "pdp"}
+	for _, c := range ice {
+		header = append(header, fmt.Sprintf("ice %dd %gmi $%.2f", c.Input.TripDurationDays, c.Input.MilesTraveled, c.Input.TotalReceiptsAmount))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for g, x := range grid {
+		row := []string{strconv.FormatFloat(x, 'f', -1, 64), strconv.FormatFloat(pdp[g], 'f', 2, 64)}
+		for _, curve := range curves {
+			row = append(row, strconv.FormatFloat(curve[g], 'f', 2, 64))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func runPDP(args []string) error {
+	fs := flag.NewFlagSet("pdp", flag.ContinueOnError)
+	feature := fs.String("feature", "", "input to vary: days, miles or receipts")
+	gridFlag := fs.String("grid", "", "values of the feature as start:end[:step] (default its training range)")
+	iceCases := fs.Int("ice", 20, "training cases to draw ICE curves for (0 for the partial dependence only)")
+	out := fs.String("out", "", "output CSV path (default stdout)")
+	jobs := fs.Int("jobs", 0, "prediction workers (0 uses every CPU)")
+	var model modelFlags
+	model.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	j, ok := featureIndex(*feature)
+	if !ok {
+		return fmt.Errorf("-feature must be days, miles or receipts")
+	}
+	if *iceCases < 0 {
+		return fmt.Errorf("-ice must not be negative")
+	}
+
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if len(p.Training) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+	grid := defaultPDPGrid(p.Training, j)
+	if *gridFlag != "" {
+		r, err := parseGridRange(*gridFlag)
+		if err != nil {
+			return fmt.Errorf("-grid: %v", err)
+		}
+		grid = r.Values()
+	}
+
+	ice := iceSample(p.Training, *iceCases)
+	pdp, curves := dependence(p, j, grid, ice, *jobs)
+	if err := p.Err(); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return writeDependence(os.Stdout, featureNames[j], grid, pdp, ice, curves)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+	err = writeDependence(buf, featureNames[j], grid, pdp, ice, curves)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// DayComponent is the part of the reimbursement of trips of one length that
+// is due to their days, with miles and receipts controlled for.
+type DayComponent struct {
+	Days      int     `json:"days"`
+	Cases     int     `json:"cases"`
+	Component float64 `json:"component"` // including the base amount
+	PerDay    float64 `json:"per_day"`
+}
+
+// estimateDayComponents regresses outputs on an indicator for each trip
+// length and the rule model's mileage and receipt tiers, so each length's
+// coefficient is its reimbursement at zero miles and receipts.
+func estimateDayComponents(training TrainingData) []DayComponent {
+	counts := map[int]int{}
+	maxDays := 0
+	for _, c := range training {
+		counts[c.Input.TripDurationDays]++
+		maxDays = max(maxDays, c.Input.TripDurationDays)
+	}
+	column := map[int]int{}
+	var lengths []int
+	for d := 0; d <= maxDays; d++ {
+		if counts[d] > 0 {
+			column[d] = len(lengths)
+			lengths = append(lengths, d)
+		}
+	}
+
+	n := len(lengths)
+	eq := newNormalEquations(n + len(defaultMileageTiers) + len(defaultReceiptTiers))
+	for _, c := range training {
+		x := make([]float64, n)
+		x[column[c.Input.TripDurationDays]] = 1
+		x = append(x, tierAmounts(c.Input.MilesTraveled, defaultMileageTiers)...)
+		x = append(x, tierAmounts(c.Input.TotalReceiptsAmount, defaultReceiptTiers)...)
+		eq.add(x, c.ExpectedOutput, 1)
+	}
+	beta := eq.solve()
+	out := make([]DayComponent, n)
+	for i, d := range lengths {
+		out[i] = DayComponent{Days: d, Cases: counts[d], Component: beta[i]}
+		if d > 0 {
+			out[i].PerDay = beta[i] / float64(d)
+		}
+	}
+	return out
+}
+
+func printPerDiem(w io.Writer, components []DayComponent, cfg RuleConfig) {
+	fmt.Fprintln(w, "Reimbursement due to trip length, controlling for miles and receipts")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Days\tCases\tComponent\tPer day")
+	for _, c := range components {
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%.2f\n", c.Days, c.Cases, c.Component, c.PerDay)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRule model per diem by band, with a base of %.2f\n\n", cfg.Base)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Days\tPer diem")
+	for i, b := range cfg.PerDiemBands {
+		to := "and longer"
+		if i+1 < len(cfg.PerDiemBands) {
+			to = fmt.Sprintf("to %d", cfg.PerDiemBands[i+1].FromDays-1)
+		}
+		fmt.Fprintf(tw, "%d %s\t%.2f\n", b.FromDays, to, b.PerDiem)
+	}
+	tw.Flush()
+}
+
+func runPerDiem(args []string) error {
+	fs := flag.NewFlagSet("per-diem", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	bands := fs.String("bands", "1,4,7", "first days of the duration bands, ascending")
+	out := fs.String("out", "", "write the rule config to this path, for -rule-config (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	starts, err := parseCurveBands(*bands)
+	if err != nil {
+		return err
+	}
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if len(trainingData) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+
+	m := &ruleModel{}
+	for _, d := range starts {
+		m.Config.PerDiemBands = append(m.Config.PerDiemBands, PerDiemBand{FromDays: d})
+	}
+	m.Fit(trainingData)
+	printPerDiem(os.Stderr, estimateDayComponents(trainingData), m.Config)
+	return writeJSONFile(*out, m.Config)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// plotSeries is a named set of points. Series sharing a plot are drawn with
+// distinct colors (vega-lite) or as separate lines (gnuplot).
+type plotSeries struct {
+	Name   string
+	Points []point
+}
+
+// plotSpec describes one diagnostic plot independently of the output format.
+type plotSpec struct {
+	Name   string // file name stem
+	Title  string
+	XLabel string
+	YLabel string
+	Mark   string // "point" or "line"
+	Series []plotSeries
+}
+
+// vegaLite returns the plot as a Vega-Lite v5 specification with inline data.
+func (p plotSpec) vegaLite() map[string]any {
+	values := []map[string]any{}
+	for _, s := range p.Series {
+		for _, pt := range s.Points {
+			values = append(values, map[string]any{"x": pt.X, "y": pt.Y, "series": s.Name})
+		}
+	}
+
+	encoding := map[string]any{
+		"x": map[string]any{"field": "x", "type": "quantitative", "title": p.XLabel},
+		"y": map[string]any{"field": "y", "type": "quantitative", "title": p.YLabel},
+	}
+	if len(p.Series) > 1 {
+		encoding["color"] = map[string]any{"field": "series", "type": "nominal", "title": ""}
+	}
+
+	return map[string]any{
+		"$schema":  "https://vega.github.io/schema/vega-lite/v5.json",
+		"title":    p.Title,
+		"width":    600,
+		"height":   360,
+		"data":     map[string]any{"values": values},
+		"mark":     map[string]any{"type": p.Mark, "tooltip": true},
+		"encoding": encoding,
+	}
+}
+
+// gnuplot returns a gnuplot script and its data file contents. Each series is
+// written as its own index block in the data file.
+func (p plotSpec) gnuplot(dataFile string) (script, data string) {
+	var d strings.Builder
+	for i, s := range p.Series {
+		if i > 0 {
+			d.WriteString("\n\n")
+		}
+		fmt.Fprintf(&d, "# %s\n", s.Name)
+		for _, pt := range s.Points {
+			d.WriteString(strconv.FormatFloat(pt.X, 'f', -1, 64))
+			d.WriteByte(' ')
+			d.WriteString(strconv.FormatFloat(pt.Y, 'f', 2, 64))
+			d.WriteByte('\n')
+		}
+	}
+
+	style := "points pt 7 ps 0.4"
+	if p.Mark == "line" {
+		style = "lines"
+	}
+
+	var sc strings.Builder
+	fmt.Fprintf(&sc, "set title %q\n", p.Title)
+	fmt.Fprintf(&sc, "set xlabel %q\n", p.XLabel)
+	fmt.Fprintf(&sc, "set ylabel %q\n", p.YLabel)
+	sc.WriteString("set key outside right\n")
+	sc.WriteString("plot ")
+	for i, s := range p.Series {
+		if i > 0 {
+			sc.WriteString(", \\\n     ")
+		}
+		fmt.Fprintf(&sc, "%q index %d using 1:2 with %s title %q", dataFile, i, style, s.Name)
+	}
+	sc.WriteString("\n")
+
+	return sc.String(), d.String()
+}
+
+// diagnosticPlots builds the predicted-vs-actual and residual plots from eval
+// results, plus prediction surface slices across receipts and miles for
+// several trip durations.
+func diagnosticPlots(results []EvalResult, p *Predictor, sliceDays []int, sliceMiles, sliceReceipts float64) []plotSpec {
+	predictedActual := make([]point, len(results))
+	residualReceipts := make([]point, len(results))
+	residualMiles := make([]point, len(results))
+	for i, r := range results {
+		predictedActual[i] = point{r.Case.ExpectedOutput, r.Predicted}
+		residualReceipts[i] = point{r.Case.Input.TotalReceiptsAmount, r.Residual()}
+		residualMiles[i] = point{r.Case.Input.MilesTraveled, r.Residual()}
+	}
+
+	receiptSlices := make([]plotSeries, len(sliceDays))
+	mileSlices := make([]plotSeries, len(sliceDays))
+	for i, d := range sliceDays {
+		name := fmt.Sprintf("%d days", d)
+		receiptSlices[i].Name = name
+		for r := 0.0; r <= 2500; r += 25 {
+			receiptSlices[i].Points = append(receiptSlices[i].Points,
+				point{r, p.Predict(d, sliceMiles, r)})
+		}
+		mileSlices[i].Name = name
+		for m := 0.0; m <= 1500; m += 15 {
+			mileSlices[i].Points = append(mileSlices[i].Points,
+				point{m, p.Predict(d, m, sliceReceipts)})
+		}
+	}
+
+	return []plotSpec{
+		{
+			Name: "predicted_vs_actual", Title: "Predicted vs actual reimbursement",
+			XLabel: "expected ($)", YLabel: "predicted ($)", Mark: "point",
+			Series: []plotSeries{{Name: "cases", Points: predictedActual}},
+		},
+		{
+			Name: "residual_vs_receipts", Title: "Residual vs receipts",
+			XLabel: "receipts ($)", YLabel: "residual ($)", Mark: "point",
+			Series: []plotSeries{{Name: "cases", Points: residualReceipts}},
+		},
+		{
+			Name: "residual_vs_miles", Title: "Residual vs miles",
+			XLabel: "miles traveled", YLabel: "residual ($)", Mark: "point",
+			Series: []plotSeries{{Name: "cases", Points: residualMiles}},
+		},
+		{
+			Name: "surface_receipts", Title: fmt.Sprintf("Prediction vs receipts at %g miles", sliceMiles),
+			XLabel: "receipts ($)", YLabel: "predicted ($)", Mark: "line",
+			Series: receiptSlices,
+		},
+		{
+			Name: "surface_miles", Title: fmt.Sprintf("Prediction vs miles at $%.2f receipts", sliceReceipts),
+			XLabel: "miles traveled", YLabel: "predicted ($)", Mark: "line",
+			Series: mileSlices,
+		},
+	}
+}
+
+// parseIntList parses a comma-separated list of integers.
+func parseIntList(s string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer list %q: %v", s, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func runExportPlots(args []string) error {
+	fs := flag.NewFlagSet("export-plots", flag.ContinueOnError)
+	format := fs.String("format", "vega-lite", "plot format: vega-lite or gnuplot")
+	outDir := fs.String("out", "plots", "output directory")
+	var model modelFlags
+	model.register(fs)
+	loo := fs.Bool("loo", true, "use leave-one-out predictions for residual plots")
+	days := fs.String("slice-days", "1,3,5,8,12", "trip durations for surface slices")
+	sliceMiles := fs.Float64("slice-miles", 500, "miles held fixed for the receipts slice")
+	sliceReceipts := fs.Float64("slice-receipts", 800, "receipts held fixed for the miles slice")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "vega-lite" && *format != "gnuplot" {
+		return fmt.Errorf("unknown plot format %q", *format)
+	}
+
+	sliceDays, err := parseIntList(*days)
+	if err != nil {
+		return err
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return err
+	}
+
+	results := evaluate(predictor.Training, predictor, *loo)
+	for _, p := range diagnosticPlots(results, predictor, sliceDays, *sliceMiles, *sliceReceipts) {
+		if err := writePlot(*outDir, *format, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePlot(dir, format string, p plotSpec) error {
+	switch format {
+	case "gnuplot":
+		dataFile := p.Name + ".dat"
+		script, data := p.gnuplot(dataFile)
+		if err := os.WriteFile(filepath.Join(dir, dataFile), []byte(data), 0o644); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, p.Name+".gp"), []byte(script), 0o644)
+	default:
+		spec, err := json.MarshalIndent(p.vegaLite(), "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, p.Name+".vl.json"), spec, 0o644)
+	}
+}
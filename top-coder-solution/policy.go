@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Input policies for queries with a zero or negative input.
+const (
+	policyPassthrough = "passthrough" // predict from the inputs as given
+	policyReject      = "reject"      // refuse to predict
+	policyClamp       = "clamp"       // raise the input to the smallest positive training value
+)
+
+func validateInputPolicy(policy string) error {
+	switch policy {
+	case policyPassthrough, policyReject, policyClamp:
+		return nil
+	}
+	return fmt.Errorf("unknown input policy %q (want %s, %s or %s)", policy, policyPassthrough, policyReject, policyClamp)
+}
+
+// inputError reports a query refused by the input policy, as opposed to a
+// failure to predict it.
+type inputError struct{ msg string }
+
+func (e *inputError) Error() string { return e.msg }
+
+// inputFloor returns the smallest positive value of each input in training,
+// the values zero and negative inputs are clamped to.
+func inputFloor(training TrainingData) featureVector {
+	var floor featureVector
+	for _, c := range training {
+		v := caseFeatures(c)
+		for j := range v {
+			if v[j] > 0 && (floor[j] == 0 || v[j] < floor[j]) {
+				floor[j] = v[j]
+			}
+		}
+	}
+	return floor
+}
+
+// applyInputPolicy returns the query to predict in place of q. A query with
+// no zero or negative input is returned unchanged under every policy.
+// Clamped queries are reported through changed.
+func applyInputPolicy(policy string, q Query, floor featureVector) (adjusted Query, changed bool, err error) {
+	v := q.features()
+	var edges []string
+	for j, name := range featureNames {
+		if v[j] <= 0 {
+			edges = append(edges, fmt.Sprintf("%s %g", name, v[j]))
+			v[j] = floor[j]
+		}
+	}
+	if len(edges) == 0 || policy == policyPassthrough {
+		return q, false, nil
+	}
+	if policy == policyReject {
+		return q, false, &inputError{fmt.Sprintf("input must be positive: %s", strings.Join(edges, ", "))}
+	}
+	return Query{TripDurationDays: int(v[0]), MilesTraveled: v[1], TotalReceiptsAmount: v[2]}, true, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PolicyVersion is one era of the reimbursement policy in the config file:
+// the flags, such as the training data or rule config, that reproduce the
+// rules in force from its effective date until the next version's. For
+// example,
+//
+//	{"policy_versions": [
+//		{"name": "2019", "effective": "2019-01-01", "flags": {"data": "cases-2019.json"}},
+//		{"name": "2023", "effective": "2023-07-01", "flags": {"data": "cases-2023.json", "rule-config": "rules-2023.json"}}]}
+type PolicyVersion struct {
+	Name      string                     `json:"name"`
+	Effective string                     `json:"effective"` // YYYY-MM-DD
+	Flags     map[string]json.RawMessage `json:"flags"`
+}
+
+// policyVersion returns the policy version named name or, when name is
+// empty, the one in force on date, a YYYY-MM-DD date.
+func (c *Config) policyVersion(name, date string) (PolicyVersion, error) {
+	if len(c.PolicyVersions) == 0 {
+		return PolicyVersion{}, fmt.Errorf("the config file defines no policy_versions")
+	}
+	if name != "" {
+		for _, v := range c.PolicyVersions {
+			if v.Name == name {
+				return v, nil
+			}
+		}
+		names := make([]string, len(c.PolicyVersions))
+		for i, v := range c.PolicyVersions {
+			names[i] = v.Name
+		}
+		return PolicyVersion{}, fmt.Errorf("no policy version %q (versions are %s)", name, strings.Join(names, ", "))
+	}
+
+	on, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return PolicyVersion{}, fmt.Errorf("effective date %q is not YYYY-MM-DD", date)
+	}
+	var best PolicyVersion
+	var bestFrom time.Time
+	for _, v := range c.PolicyVersions {
+		from, err := time.Parse(time.DateOnly, v.Effective)
+		if err != nil {
+			return PolicyVersion{}, fmt.Errorf("policy version %s: effective date %q is not YYYY-MM-DD", v.Name, v.Effective)
+		}
+		if !from.After(on) && (best.Name == "" || from.After(bestFrom)) {
+			best, bestFrom = v, from
+		}
+	}
+	if best.Name == "" {
+		return PolicyVersion{}, fmt.Errorf("no policy version was in force on %s", date)
+	}
+	return best, nil
+}
+
+// applyPolicyVersion applies the flags of the policy version fs's
+// -policy-version or -effective-date selects from the config file, over any
+// profile but under the flags given explicitly, and records the version's
+// name in -policy-version. It does nothing for a flag set without those
+// flags, or when neither is set.
+func applyPolicyVersion(fs *flag.FlagSet, configPath string) error {
+	nameFlag, dateFlag := fs.Lookup("policy-version"), fs.Lookup("effective-date")
+	if nameFlag == nil || dateFlag == nil {
+		return nil
+	}
+	name, date := nameFlag.Value.String(), dateFlag.Value.String()
+	if name == "" && date == "" {
+		return nil
+	}
+	if name != "" && date != "" {
+		return fmt.Errorf("-policy-version and -effective-date are mutually exclusive")
+	}
+	c, err := loadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %v", err)
+	}
+	v, err := c.policyVersion(name, date)
+	if err != nil {
+		return err
+	}
+	flags := map[string]json.RawMessage{}
+	for flagName, value := range v.Flags {
+		if flagName != "policy-version" && flagName != "effective-date" {
+			flags[flagName] = value
+		}
+	}
+	if err := (Profile{Flags: flags}).apply(fs); err != nil {
+		return fmt.Errorf("policy version %s: %v", v.Name, err)
+	}
+	return nameFlag.Value.Set(v.Name)
+}
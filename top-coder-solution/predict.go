@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query is the input of a single reimbursement estimate.
+type Query struct {
+	TripDurationDays    int     `json:"trip_duration_days"`
+	MilesTraveled       float64 `json:"miles_traveled"`
+	TotalReceiptsAmount float64 `json:"total_receipts_amount"`
+	// Fields are named input fields beyond the three, such as a department
+	// or destination tier coded as a number. In JSON they sit beside the
+	// three as "destination_tier": 2. Models ignore them unless a feature
+	// config reads them (see FeatureConfig).
+	Fields map[string]float64 `json:"-"`
+}
+
+// queryJSONNames are the JSON names of Query's own inputs.
+var queryJSONNames = []string{"trip_duration_days", "miles_traveled", "total_receipts_amount"}
+
+func (q Query) features() featureVector {
+	v := featureVector{float64(q.TripDurationDays), q.MilesTraveled, q.TotalReceiptsAmount}
+	if len(q.Fields) > 0 {
+		for i, name := range inputFieldNames() {
+			v[len(featureNames)+i] = q.Fields[name]
+		}
+	}
+	return v
+}
+
+func (q Query) MarshalJSON() ([]byte, error) {
+	type plain Query
+	b, err := json.Marshal(plain(q))
+	if err != nil || len(q.Fields) == 0 {
+		return b, err
+	}
+	fields, err := json.Marshal(q.Fields)
+	if err != nil {
+		return nil, err
+	}
+	// Splice the fields, sorted by name, into the object after the inputs.
+	return append(append(b[:len(b)-1], ','), fields[1:]...), nil
+}
+
+func (q *Query) UnmarshalJSON(data []byte) error {
+	return q.decodeJSON(data)
+}
+
+// decodeJSON decodes the JSON object data into q, taking each member that
+// is not one of the inputs or named in skip as a named input field.
+func (q *Query) decodeJSON(data []byte, skip ...string) error {
+	type plain Query
+	if err := json.Unmarshal(data, (*plain)(q)); err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	q.Fields = nil
+	for name, raw := range members {
+		// encoding/json matches the inputs' names ignoring case.
+		if slices.ContainsFunc(queryJSONNames, func(s string) bool { return strings.EqualFold(s, name) }) ||
+			slices.Contains(skip, name) {
+			continue
+		}
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return fmt.Errorf("input field %q must be a number", name)
+		}
+		if q.Fields == nil {
+			q.Fields = map[string]float64{}
+		}
+		q.Fields[name] = v
+	}
+	return nil
+}
+
+// Environment variables holding the inputs when none are given as
+// arguments, for wrappers that cannot safely build a command line.
+const (
+	envTripDays = "TRIP_DAYS"
+	envMiles    = "MILES"
+	envReceipts = "RECEIPTS"
+)
+
+// queryArgs returns the input arguments args, or when there are none the
+// inputs set in the environment, which must then all be set. It returns no
+// arguments when neither gives any.
+func queryArgs(args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	var values, missing []string
+	for _, name := range []string{envTripDays, envMiles, envReceipts} {
+		if v, ok := os.LookupEnv(name); ok {
+			values = append(values, strings.TrimSpace(v))
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return values, nil
+	case 3:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("inputs are read from the environment when no arguments are given, but %s is not set",
+		strings.Join(missing, " and "))
+}
+
+// parseQuery parses the three positional inputs.
+func parseQuery(args []string) (Query, error) {
+	var q Query
+	if len(args) != 3 {
+		return q, fmt.Errorf("expected <trip_duration_days> <miles_traveled> <total_receipts_amount>, got %d arguments", len(args))
+	}
+
+	var err error
+	q.TripDurationDays, err = strconv.Atoi(args[0])
+	if err != nil {
+		return q, fmt.Errorf("parsing trip_duration_days: %v", err)
+	}
+	q.MilesTraveled, err = strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return q, fmt.Errorf("parsing miles_traveled: %v", err)
+	}
+	q.TotalReceiptsAmount, err = strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return q, fmt.Errorf("parsing total_receipts_amount: %v", err)
+	}
+	return q, checkQuery(q)
+}
+
+// roundCents rounds a dollar amount to two decimal places by its decimal
+// value; see Cents.
+func roundCents(v float64) float64 {
+	return centsOf(v).Dollars()
+}
+
+// predictChecked predicts q through p, rounded to the cent, for the paths
+// answering queries from outside: it rejects an invalid query, and fails,
+// for q alone, when the prediction is not finite or too large to count in
+// cents. Unlike Err, its error never carries over to other queries.
+func predictChecked(ctx context.Context, p *Predictor, q Query) (float64, error) {
+	if err := checkQuery(q); err != nil {
+		return 0, err
+	}
+	y, err := p.PredictContext(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	c, err := checkedCents(y)
+	if err != nil {
+		return 0, fmt.Errorf("prediction for %d days, %g miles, $%g receipts: %v",
+			q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, err)
+	}
+	return c.Dollars(), nil
+}
+
+// PredictionResponse is the JSON form of a single prediction.
+type PredictionResponse struct {
+	Input         Query             `json:"input"`
+	Reimbursement float64           `json:"reimbursement"`
+	Formatted     string            `json:"formatted,omitempty"`      // Reimbursement as printed, for non-default -format
+	Adjusted      *Query            `json:"adjusted_input,omitempty"` // the input predicted from, when the input policy clamped it
+	Confidence    *Confidence       `json:"confidence,omitempty"`
+	Abstained     bool              `json:"abstained,omitempty"` // confidence was too low to estimate; Reimbursement is 0
+	Warning       *AnomalyWarning   `json:"warning,omitempty"`
+	Explanation   *ModelExplanation `json:"explanation,omitempty"`
+	Provenance    *Provenance       `json:"provenance,omitempty"`
+}
+
+// predictDryRun builds the model predict would use and reports what was
+// checked, without predicting. The inputs in args, if any, are already
+// valid.
+func predictDryRun(model *modelFlags, remoteURL string, args []string, diag *diagnostics) error {
+	if remoteURL != "" {
+		diag.printf(verbosityNormal, "dry run: %s\n", dryRunSummary(args, "predictions would come from "+remoteURL))
+		return nil
+	}
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if err := validatePredictor(p); err != nil {
+		return err
+	}
+	if err := p.Err(); err != nil {
+		return err
+	}
+	diag.printf(verbosityNormal, "dry run: %s\n", dryRunSummary(args,
+		fmt.Sprintf("model %s builds from %d cases of %s", p.Version, len(p.Training), model.dataPath)))
+	return nil
+}
+
+// dryRunSummary describes a successful dry run: the inputs, if given, and
+// the model checked.
+func dryRunSummary(args []string, model string) string {
+	if len(args) == 0 {
+		return model
+	}
+	return fmt.Sprintf("inputs %s are valid; %s", strings.Join(args, " "), model)
+}
+
+func runPredict(args []string) error {
+	fs := flag.NewFlagSet("predict", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	asJSON := fs.Bool("json", false, "print the prediction as JSON")
+	var format amountFormat
+	format.register(fs)
+	minConfidence := fs.Float64("min-confidence", 0,
+		"report \"cannot estimate\" (exit status 3) when the confidence score is below this (0 disables)")
+	policy := fs.String("input-policy", policyPassthrough,
+		"handling of zero or negative inputs: passthrough, reject or clamp (to the smallest positive training value)")
+	anomalyQuantile := fs.Float64("anomaly-quantile", 0.01,
+		"flag queries less dense than this fraction of training cases (0 disables)")
+	explain := fs.Bool("explain", false, "describe how the model arrived at the prediction (on stderr unless -json)")
+	var audit auditFlags
+	audit.register(fs)
+	var remote remoteFlags
+	remote.register(fs)
+	var verbosity verbosityFlags
+	verbosity.register(fs)
+	dryRun := fs.Bool("dry-run", false,
+		"check the inputs, if given, and that the model builds, then exit without predicting")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	diag := newDiagnostics(os.Stderr, verbosity.level())
+	if err := format.validate(); err != nil {
+		return err
+	}
+	if err := validateInputPolicy(*policy); err != nil {
+		return err
+	}
+	if err := validateAnomalyQuantile(*anomalyQuantile); err != nil {
+		return err
+	}
+	if err := remote.validate(fs); err != nil {
+		return err
+	}
+
+	inputs, err := queryArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+	var q Query
+	if !*dryRun || len(inputs) > 0 {
+		if q, err = parseQuery(inputs); err != nil {
+			return err
+		}
+	}
+	if *dryRun {
+		return predictDryRun(&model, remote.url, inputs, diag)
+	}
+
+	var resp PredictionResponse
+	if remote.url != "" {
+		if resp, err = remote.predict(q); err != nil {
+			return err
+		}
+		diag.printf(verbosityInfo, "predicted by %s in %v\n", remote.url, diag.elapsed())
+		if !resp.Abstained {
+			resp.Reimbursement = format.round(resp.Reimbursement)
+		}
+	} else {
+		predictor, err := model.build()
+		if err != nil {
+			return err
+		}
+		diag.printf(verbosityInfo, "built model %s from %d cases of %s in %v\n",
+			predictor.Version, len(predictor.Training), model.dataPath, diag.elapsed())
+		auditLog, err := audit.open()
+		if err != nil {
+			return err
+		}
+
+		in, clamped, err := applyInputPolicy(*policy, q, inputFloor(predictor.Training))
+		if err != nil {
+			return err
+		}
+		resp = PredictionResponse{
+			Input:         q,
+			Reimbursement: format.round(predictor.PredictQuery(in)),
+		}
+		if err := predictor.Err(); err != nil {
+			return err
+		}
+		if clamped {
+			resp.Adjusted = &in
+		}
+		if *minConfidence > 0 {
+			c := predictor.Confidence(in)
+			resp.Confidence = &c
+			if c.Score < *minConfidence {
+				resp.Reimbursement, resp.Abstained = 0, true
+			}
+		}
+		prov := predictor.Provenance(time.Now())
+		resp.Provenance = &prov
+		if *anomalyQuantile > 0 {
+			resp.Warning = newAnomalyDetector(predictor.Training, *anomalyQuantile).Check(in)
+		}
+		diag.printf(verbosityInfo, "predicted in %v total\n", diag.elapsed())
+		if *explain {
+			e := predictor.Explain(in)
+			resp.Explanation = &e
+		} else if diag.enabled(verbosityDebug) {
+			for _, step := range predictor.Explain(in).Steps {
+				fmt.Fprintln(os.Stderr, step)
+			}
+		}
+		if auditLog != nil {
+			err := auditLog.Record(newAuditRecord(resp, prov, predictor.Summarize(in)))
+			if closeErr := auditLog.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("writing audit log: %v", err)
+			}
+		}
+	}
+	if format.style != formatPlain && !resp.Abstained {
+		resp.Formatted = format.format(resp.Reimbursement)
+	}
+
+	if *asJSON {
+		if err := writeJSON(os.Stdout, resp); err != nil || !resp.Abstained {
+			return err
+		}
+		return &exitError{code: exitAbstained}
+	}
+	if resp.Abstained {
+		if remote.url != "" {
+			fmt.Fprintf(os.Stderr, "cannot estimate: confidence %.2f is below the server's minimum\n", resp.Confidence.Score)
+		} else {
+			fmt.Fprintln(os.Stderr, abstainMessage(*resp.Confidence, *minConfidence))
+		}
+		return &exitError{code: exitAbstained}
+	}
+	if in := resp.Adjusted; in != nil && diag.enabled(verbosityNormal) {
+		fmt.Fprintf(os.Stderr, "Warning: input clamped to %d days, %g miles, $%.2f receipts\n",
+			in.TripDurationDays, in.MilesTraveled, in.TotalReceiptsAmount)
+	}
+	if resp.Warning != nil && diag.enabled(verbosityNormal) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", resp.Warning.Message)
+	}
+	if e := resp.Explanation; e != nil {
+		fmt.Fprintf(os.Stderr, "Model %s:\n", e.Model)
+		for _, step := range e.Steps {
+			fmt.Fprintf(os.Stderr, "  %s\n", step)
+		}
+	}
+	fmt.Println(format.format(resp.Reimbursement))
+	return nil
+}
@@ -0,0 +1,798 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Predictor estimates reimbursements from training cases using weighted KNN,
+// or the registered model Model names. When a segmentation is configured,
+// neighbors are drawn only from training cases in the same segment as the
+// query.
+//
+// A Predictor is an immutable snapshot: it owns a private copy of its
+// training data and index, so once Version and DataSHA256 are set it is safe
+// for concurrent use without locking. To change the model, build a new
+// Predictor and swap it in.
+type Predictor struct {
+	Training     TrainingData
+	Model        string // the registered model answering instead of KNN; empty for KNN
+	K            int
+	Segmentation *Segmentation
+	Index        *IndexConfig
+	Metric       string
+	Features     []FeatureConfig // the features distances are measured over; nil for the defaults
+	Sample       *SampleConfig   // how Training was sampled from the data file
+	Duplicates   string          // how cases with identical inputs were merged
+	// RecencyHalfLife, when positive, is the age in days at which a
+	// timestamped case has half the influence of the newest one.
+	RecencyHalfLife float64
+	// FallbackDistance, when positive, is the nearest-neighbor distance
+	// beyond which queries are answered by a linear fit instead of KNN.
+	FallbackDistance float64
+	Overrides        []OverrideRule // rules adjusting every prediction, in order
+	Routing          *RoutingConfig // the models answering by input, in place of KNN
+	Chain            []ChainStage   // the fallback chain answering in place of KNN
+	Rule             *RuleConfig    // the rule model's tiers, nil for the defaults
+	Aggregate        string         // how neighbors' outputs are combined; empty for the weighted mean
+	LocalLoss        string         // the local model's regression loss; empty for squared
+	Bins             *BinConfig     // the buckets inputs are rounded to before matching; nil matches raw inputs
+	Reproducible     bool           // predict in platform-independent arithmetic, rounded to the cent
+
+	// Version is the registry tag of the model, or unregisteredVersion.
+	Version string
+	// DataSHA256 is the hash of the training data file.
+	DataSHA256 string
+	// PolicyVersion is the policy version the predictor was built for, or
+	// empty when none was selected.
+	PolicyVersion string
+
+	// segments holds the training cases of each segment, indexed like
+	// Segmentation.Segments.
+	segments []TrainingData
+
+	// columns and segmentColumns are struct-of-arrays copies of Training and
+	// segments used by Predict. They are nil for memory-mapped data, which is
+	// predicted from in place rather than duplicated into the heap.
+	columns        *featureColumns
+	segmentColumns []*featureColumns
+
+	// index and segmentIndexes are the neighbor indexes over Training and
+	// segments, built unless the predictor uses the columns.
+	index          neighborIndex
+	segmentIndexes []neighborIndex
+
+	features  featureSet // resolved Features
+	metric    distanceMetric
+	linear    *linearModel  // the fallback model, when FallbackDistance is set
+	recency   *recencyDecay // nil unless RecencyHalfLife is set and cases are timestamped
+	model     Model         // the fitted Model, routes or chain, nil for KNN
+	overrides []override    // compiled Overrides
+
+	// typical caches typicalDistance.
+	typicalOnce sync.Once
+	typical     float64
+
+	// numericsErr is the first non-finite prediction checkPrediction found.
+	numericsMu  sync.Mutex
+	numericsErr error
+}
+
+// NewPredictor builds a predictor with the hyperparameters hp, which must be
+// valid. training is copied, so the caller may reuse it afterwards. Inputs
+// are rounded to hp.Bins, then cases with identical inputs are merged as
+// hp.Duplicates says.
+func NewPredictor(training TrainingData, hp Hyperparameters) *Predictor {
+	seg := hp.Segmentation
+	// Resolve the features first: merging duplicates compares the named
+	// input fields they read, which have vector slots only once resolved.
+	features, _ := newFeatureSet(hp.Features)
+	p := &Predictor{Training: frozen(mergeDuplicates(binCases(training, hp.Bins), hp.Duplicates)), Model: hp.Model, K: hp.K, Segmentation: seg,
+		Index: hp.Index, Metric: hp.Metric, Features: hp.Features, Sample: hp.Sample, Duplicates: hp.Duplicates,
+		RecencyHalfLife: hp.RecencyHalfLife, FallbackDistance: hp.FallbackDistance, Overrides: hp.Overrides, Routing: hp.Routing, Chain: hp.Chain, Rule: hp.Rule,
+		Aggregate: hp.Aggregate, LocalLoss: hp.LocalLoss, Bins: hp.Bins,
+		Reproducible: hp.Reproducible}
+	if len(hp.Overrides) > 0 {
+		p.overrides, _ = compileOverrides(hp.Overrides)
+	}
+	p.features = features.withScales(p.Training)
+	p.metric = newMetric(hp.Metric, p.Training, p.features)
+	p.recency = newRecencyDecay(p.Training, p.RecencyHalfLife)
+	if p.FallbackDistance > 0 {
+		p.linear = fitLinear(p.Training, p.features, p.recency)
+	}
+	if !isKNN(p.Model) {
+		p.model = newModel(hp)
+		p.model.Fit(p.Training)
+	} else if p.Routing != nil {
+		p.model = newRoutedModel(hp)
+		p.model.Fit(p.Training)
+	} else if p.Chain != nil {
+		p.model = &chainModel{hp: hp}
+		p.model.Fit(p.Training)
+	}
+	if seg != nil {
+		p.segments = make([]TrainingData, len(seg.Segments))
+		for _, c := range p.Training {
+			if i := seg.segmentOf(caseFeatures(c)); i >= 0 {
+				p.segments[i] = append(p.segments[i], c)
+			}
+		}
+		for i := range p.segments {
+			p.segments[i] = slices.Clip(p.segments[i])
+		}
+	}
+	// The columns hold the default features and weight neighbors by distance
+	// alone, so other feature sets and recency weighting need an index.
+	if p.Index.kind() != indexExact || !isEuclidean(p.Metric) || p.features != nil || p.recency != nil {
+		p.index = buildIndex(p.Index, p.Training, p.metric)
+		for _, s := range p.segments {
+			p.segmentIndexes = append(p.segmentIndexes, buildIndex(p.Index, s, p.metric))
+		}
+	} else if !isMapped(p.Training) {
+		p.columns = newFeatureColumns(p.Training)
+		for _, s := range p.segments {
+			p.segmentColumns = append(p.segmentColumns, newFeatureColumns(s))
+		}
+	}
+	return p
+}
+
+// Hyperparameters returns the settings the predictor was built with.
+func (p *Predictor) Hyperparameters() Hyperparameters {
+	return Hyperparameters{Model: p.Model, K: p.K, Segmentation: p.Segmentation, Index: p.Index, Metric: p.Metric, Features: p.Features, Sample: p.Sample,
+		Duplicates: p.Duplicates, RecencyHalfLife: p.RecencyHalfLife, FallbackDistance: p.FallbackDistance, Overrides: p.Overrides, Routing: p.Routing, Chain: p.Chain, Rule: p.Rule,
+		Aggregate: p.Aggregate, LocalLoss: p.LocalLoss, Bins: p.Bins,
+		Reproducible: p.Reproducible}
+}
+
+// validate checks that hyperparameters describe a model NewPredictor can
+// build.
+func (h Hyperparameters) validate() error {
+	if h.K < 1 {
+		return fmt.Errorf("k must be at least 1")
+	}
+	if err := validateModel(h.Model); err != nil {
+		return err
+	}
+	if err := validateMetric(h.Metric); err != nil {
+		return err
+	}
+	if err := h.Index.validate(); err != nil {
+		return err
+	}
+	if err := h.Sample.validate(); err != nil {
+		return err
+	}
+	if err := validateDuplicates(h.Duplicates); err != nil {
+		return err
+	}
+	if err := validateAggregate(h.Aggregate); err != nil {
+		return err
+	}
+	if err := validateLocalLoss(h.LocalLoss); err != nil {
+		return err
+	}
+	if err := h.Bins.validate(); err != nil {
+		return err
+	}
+	if h.FallbackDistance < 0 {
+		return fmt.Errorf("fallback distance must not be negative")
+	}
+	if h.RecencyHalfLife < 0 {
+		return fmt.Errorf("recency half-life must not be negative")
+	}
+	if !isKNN(h.Model) && (h.Segmentation != nil || h.FallbackDistance > 0) {
+		return fmt.Errorf("segmentation and the fallback distance apply only to the knn model")
+	}
+	if !isKNN(h.Model) && h.Aggregate != "" {
+		return fmt.Errorf("neighbor aggregation applies only to the knn model")
+	}
+	if !isKNN(h.Model) && h.Bins != nil {
+		return fmt.Errorf("input binning applies only to the knn model")
+	}
+	if h.Reproducible && (!isKNN(h.Model) || h.Routing != nil || h.Chain != nil || h.Index != nil || !isEuclidean(h.Metric) ||
+		len(h.Features) > 0 || h.RecencyHalfLife > 0 || h.FallbackDistance > 0) {
+		return fmt.Errorf("reproducible mode requires the knn model with exact search, the euclidean metric, the default features, " +
+			"and no recency weighting or fallback")
+	}
+	if err := h.Routing.validate(); err != nil {
+		return err
+	}
+	if !isKNN(h.Model) && h.Routing != nil {
+		return fmt.Errorf("routing chooses the model; set its default route instead of the model")
+	}
+	if err := validateChain(h.Chain); err != nil {
+		return err
+	}
+	if h.Chain != nil && (!isKNN(h.Model) || h.Routing != nil) {
+		return fmt.Errorf("the fallback chain chooses the model; it excludes the model and routing")
+	}
+	if h.Rule != nil && h.Model != modelRule && h.Routing == nil && h.Chain == nil {
+		return fmt.Errorf("the rule config applies only to the rule model")
+	}
+	if h.LocalLoss != "" && h.Model != modelLocal && h.Routing == nil && h.Chain == nil {
+		return fmt.Errorf("the local regression loss applies only to the local model")
+	}
+	features, err := newFeatureSet(h.Features)
+	if err != nil {
+		return err
+	}
+	if _, err := compileOverrides(h.Overrides); err != nil {
+		return err
+	}
+	if h.Index.kind() == indexLSH && (!isEuclidean(h.Metric) || features != nil) {
+		return fmt.Errorf("the lsh index requires the euclidean metric and the default features")
+	}
+	if h.Index.kind() == indexKDTree && features != nil {
+		return fmt.Errorf("the kd-tree index requires the default features")
+	}
+	if h.Index.kind() == indexKDTree && h.Metric == metricMahalanobis {
+		return fmt.Errorf("the kd-tree index does not support the mahalanobis metric")
+	}
+	return nil
+}
+
+// searcher returns an index over the neighbor pool of v.
+func (p *Predictor) searcher(v featureVector) neighborIndex {
+	if idx, _ := p.poolIndex(v); idx != nil {
+		return idx
+	}
+	if cols := p.poolColumns(v); cols != nil {
+		return exactIndex{cols}
+	}
+	return bruteIndex{p.pool(v), p.metric}
+}
+
+// frozen returns a copy of data with no spare capacity, so appending to a
+// slice handed out by a predictor can never write into its snapshot.
+// Memory-mapped data is read-only already and is shared rather than copied.
+func frozen(data TrainingData) TrainingData {
+	if isMapped(data) {
+		return slices.Clip(data)
+	}
+	out := make(TrainingData, len(data))
+	copy(out, data)
+	return out
+}
+
+// pool returns the training cases neighbors are drawn from for a query. A
+// query outside every segment, or in an empty one, uses all training data.
+func (p *Predictor) pool(v featureVector) TrainingData {
+	if p.Segmentation == nil {
+		return p.Training
+	}
+	if i := p.Segmentation.segmentOf(v); i >= 0 && len(p.segments[i]) > 0 {
+		return p.segments[i]
+	}
+	return p.Training
+}
+
+// poolIndex returns the index over pool(v) and that pool, or a nil index
+// when the predictor searches exhaustively.
+func (p *Predictor) poolIndex(v featureVector) (neighborIndex, TrainingData) {
+	if p.index == nil || p.Segmentation == nil {
+		return p.index, p.Training
+	}
+	if i := p.Segmentation.segmentOf(v); i >= 0 && len(p.segments[i]) > 0 {
+		return p.segmentIndexes[i], p.segments[i]
+	}
+	return p.index, p.Training
+}
+
+// poolColumns is pool in struct-of-arrays form, or nil without columns.
+func (p *Predictor) poolColumns(v featureVector) *featureColumns {
+	if p.Segmentation == nil || p.columns == nil {
+		return p.columns
+	}
+	if i := p.Segmentation.segmentOf(v); i >= 0 && len(p.segments[i]) > 0 {
+		return p.segmentColumns[i]
+	}
+	return p.columns
+}
+
+// Predict returns the estimated reimbursement for a trip.
+func (p *Predictor) Predict(tripDays int, miles, receipts float64) float64 {
+	return p.PredictQuery(Query{TripDurationDays: tripDays, MilesTraveled: miles, TotalReceiptsAmount: receipts})
+}
+
+// PredictQuery is Predict for the inputs of q, named input fields included.
+func (p *Predictor) PredictQuery(q Query) float64 {
+	y := p.predictModel(q)
+	if p.overrides != nil {
+		y, _ = applyOverrides(p.overrides, q.features(), y)
+	}
+	return p.checkPrediction(q, y)
+}
+
+// PredictContext is PredictQuery for callers with a deadline: it fails with
+// ctx's error if ctx is done before the prediction starts or by the time it
+// finishes, and with an error when the prediction is not a finite number.
+// A prediction already running is not interrupted.
+func (p *Predictor) PredictContext(ctx context.Context, q Query) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	y := p.PredictQuery(q)
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if math.IsNaN(y) || math.IsInf(y, 0) {
+		return 0, fmt.Errorf("prediction for %d days, %g miles, $%g receipts is %v", q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount, y)
+	}
+	return y, nil
+}
+
+// predictModel is PredictQuery before the override rules.
+func (p *Predictor) predictModel(q Query) float64 {
+	if p.model != nil {
+		return p.model.Predict(q)
+	}
+	q = p.Bins.apply(q)
+	tripDays, miles, receipts := q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount
+	v := q.features()
+	if p.Reproducible {
+		return predictReproducible(tripDays, miles, receipts, p.pool(v), p.K, p.Aggregate)
+	}
+	if p.linear != nil && p.fallsBack(v) {
+		return p.linear.predict(v)
+	}
+	if idx, pool := p.poolIndex(v); idx != nil {
+		return predictIndexed(idx, pool, v, p.K, p.recency, p.Aggregate)
+	}
+	if cols := p.poolColumns(v); cols != nil {
+		return cols.predict(tripDays, miles, receipts, p.K, p.Aggregate)
+	}
+	return predictWeightedKNN(tripDays, miles, receipts, p.pool(v), p.K, p.Aggregate)
+}
+
+// Err returns the first failure of the predictor's model, for models that
+// can fail, or else the first prediction that was not a finite number.
+func (p *Predictor) Err() error {
+	if m, ok := p.model.(interface{ Err() error }); ok {
+		if err := m.Err(); err != nil {
+			return err
+		}
+	}
+	p.numericsMu.Lock()
+	defer p.numericsMu.Unlock()
+	return p.numericsErr
+}
+
+// Close releases what the predictor's model holds, such as an external
+// program. The predictor must not be used afterwards.
+func (p *Predictor) Close() error {
+	if c, ok := p.model.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// fallsBack reports whether v is farther than FallbackDistance from every
+// case of its neighbor pool.
+func (p *Predictor) fallsBack(v featureVector) bool {
+	nearest := p.searcher(v).search(nil, v, 1)
+	return len(nearest) == 0 || nearest[0].Distance > p.FallbackDistance
+}
+
+// fallbackLinear names the linear fallback model in explanations.
+const fallbackLinear = "linear"
+
+// ExplanationSummary is a compact account of how a prediction was made.
+type ExplanationSummary struct {
+	Neighbors       int      `json:"neighbors"`
+	NearestDistance float64  `json:"nearest_distance"`
+	ExactMatch      bool     `json:"exact_match"`
+	Segment         string   `json:"segment,omitempty"`
+	Fallback        string   `json:"fallback,omitempty"`  // the model used instead of KNN, if any
+	Model           string   `json:"model,omitempty"`     // the model answering, when not KNN
+	Route           string   `json:"route,omitempty"`     // the condition of the route taken, with routing
+	Stage           string   `json:"stage,omitempty"`     // the fallback chain stage that answered
+	Overrides       []string `json:"overrides,omitempty"` // the override rules that fired
+}
+
+// Summarize describes the neighbor pool a prediction for q draws on.
+func (p *Predictor) Summarize(q Query) ExplanationSummary {
+	raw := q
+	if p.model == nil {
+		q = p.Bins.apply(q)
+	}
+	v := q.features()
+	pool := p.pool(v)
+	s := ExplanationSummary{Neighbors: min(p.K, len(pool)), NearestDistance: math.Inf(1)}
+	if p.Segmentation != nil {
+		if i := p.Segmentation.segmentOf(v); i >= 0 {
+			s.Segment = p.Segmentation.Segments[i].Name
+		}
+	}
+	for _, c := range pool {
+		cv := caseFeatures(c)
+		d := p.metric.distance(v, cv)
+		s.NearestDistance = math.Min(s.NearestDistance, d)
+		if c.Input.TripDurationDays == q.TripDurationDays &&
+			math.Abs(c.Input.MilesTraveled-q.MilesTraveled) < 0.001 &&
+			math.Abs(c.Input.TotalReceiptsAmount-q.TotalReceiptsAmount) < 0.001 && sameInputFields(cv, v) {
+			s.ExactMatch = true
+		}
+	}
+	if s.ExactMatch {
+		s.Neighbors = 1
+	}
+	if p.linear != nil && s.NearestDistance > p.FallbackDistance {
+		s.Neighbors, s.Fallback = 0, fallbackLinear
+	}
+	if p.model != nil {
+		s.Neighbors, s.Model = 0, p.Model
+	}
+	if r, ok := p.model.(*routedModel); ok {
+		s.Model, s.Route = r.name(r.route(q))
+	}
+	if c, ok := p.model.(*chainModel); ok {
+		s.Model, s.Stage = "", c.stage(q)
+	}
+	if p.overrides != nil {
+		_, s.Overrides = applyOverrides(p.overrides, raw.features(), p.predictModel(raw))
+	}
+	return s
+}
+
+// Explain describes how the prediction for q is made: by the model, by the
+// linear fallback, or from the nearest neighbors and their weights, followed
+// by the override rules that fired.
+func (p *Predictor) Explain(q Query) ModelExplanation {
+	e := p.explainModel(q)
+	for _, r := range p.overrides {
+		if y, ok := r.apply(q.features(), e.Prediction); ok {
+			e.Steps = append(e.Steps, fmt.Sprintf("override %s: %.2f -> %.2f", r.name, e.Prediction, y))
+			e.Prediction = y
+		}
+	}
+	return e
+}
+
+func (p *Predictor) explainModel(q Query) ModelExplanation {
+	if p.model != nil {
+		return p.model.Explain(q)
+	}
+	q = p.Bins.apply(q)
+	v := q.features()
+	predicted := p.predictModel(q)
+	if p.linear != nil && p.fallsBack(v) {
+		steps := append([]string{fmt.Sprintf("no case within fallback distance %g", p.FallbackDistance)}, p.linear.explain(v)...)
+		return ModelExplanation{Model: fallbackLinear, Prediction: predicted, Steps: steps}
+	}
+	var steps []string
+	if s := p.Summarize(q); s.Segment != "" {
+		steps = append(steps, "segment "+s.Segment)
+	}
+	if p.Bins != nil {
+		steps = append(steps, fmt.Sprintf("binned to %d days, %g miles, $%.2f receipts",
+			q.TripDurationDays, q.MilesTraveled, q.TotalReceiptsAmount))
+	}
+	if p.Aggregate != "" {
+		steps = append(steps, "aggregate neighbors by weighted "+p.Aggregate)
+	}
+	pool := p.pool(v)
+	for _, n := range p.searcher(v).search(nil, v, p.K) {
+		in := pool[n.Case].Input
+		steps = append(steps, fmt.Sprintf("neighbor %d days, %g miles, $%.2f receipts: output %.2f at distance %.4f",
+			in.TripDurationDays, in.MilesTraveled, in.TotalReceiptsAmount, n.Output, n.Distance))
+	}
+	return ModelExplanation{Model: modelKNN, Prediction: predicted, Steps: steps}
+}
+
+// modelFlags are the flags shared by every command that builds a predictor.
+type modelFlags struct {
+	model        string
+	dataPath     string
+	k            int
+	segmentsPath string
+	modelTag     string
+	registry     string
+	index        indexFlags
+	metric       string
+	featuresPath string
+	mmap         bool
+	sample       sampleFlags
+	memBudget    string
+	duplicates   string
+	halfLife     float64
+	fallback     float64
+	overrides    string
+	routing      string
+	chain        string
+	rule         string
+	aggregate    string
+	localLoss    string
+	bins         string
+	reproducible bool
+	debug        bool
+	table        tableFlags
+
+	// packedData is a temporary packed copy of the -data file that
+	// fitMemBudget made to memory-map, loaded in its place.
+	packedData string
+
+	// policyVersion names the policy version whose flags were applied, and
+	// effectiveDate the date that selected it; see applyPolicyVersion.
+	policyVersion string
+	effectiveDate string
+}
+
+func (m *modelFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&m.model, "model", modelKNN,
+		"model to predict with: "+strings.Join(modelNames(), ", ")+", or "+execPrefix+"PROGRAM for an external program")
+	if isBaked() {
+		fs.StringVar(&m.dataPath, "data", bakedData, "training data path or s3:// or gs:// URI, or "+bakedData+" for the model baked into this binary")
+	} else {
+		fs.StringVar(&m.dataPath, "data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	}
+	fs.IntVar(&m.k, "k", defaultK, "number of neighbors")
+	fs.StringVar(&m.segmentsPath, "segments", "", "segmentation config restricting neighbors to the query's segment")
+	fs.StringVar(&m.modelTag, "model-tag", "", "use a registered model version instead of -data/-k/-segments")
+	fs.StringVar(&m.registry, "registry", defaultRegistry, "model registry directory")
+	m.index.register(fs)
+	fs.StringVar(&m.metric, "metric", metricEuclidean, "distance metric: euclidean, manhattan or mahalanobis")
+	fs.StringVar(&m.featuresPath, "features", "",
+		"JSON list of the features distances are measured over, as {\"name\", \"scale\"} or derived {\"name\", \"expr\"} (default days, miles and receipts)")
+	fs.BoolVar(&m.mmap, "mmap", true, "memory-map packed training data instead of decoding it into the heap; replace a mapped file only by renaming a new one over it, as pack does")
+	m.sample.register(fs)
+	fs.StringVar(&m.memBudget, "mem-budget", "",
+		"keep memory under this size, such as 512MB, memory-mapping or sampling the training data to fit, and report the peak on exit")
+	fs.StringVar(&m.duplicates, "duplicates", duplicatesKeepAll,
+		"merge cases with identical inputs: keep-all, mean, median or most-recent")
+	fs.StringVar(&m.aggregate, "aggregate", aggregateMean,
+		"combine the neighbors' inverse-distance weighted outputs by their mean, median or trimmed-mean")
+	fs.Float64Var(&m.halfLife, "recency-half-life", 0,
+		"halve the influence of timestamped cases every this many days before the newest case (0 disables)")
+	fs.Float64Var(&m.fallback, "fallback-distance", 0,
+		"answer with a linear fit when the nearest neighbor is farther than this (scaled units; 0 disables)")
+	fs.StringVar(&m.overrides, "overrides", "",
+		"JSON list of rules applied after the model, as {\"name\", \"rule\": \"if days == 5 then output *= 1.08\"}")
+	fs.StringVar(&m.routing, "routing", "",
+		"JSON routing config sending queries to models by input, as {\"routes\": [{\"when\": \"days >= 10\", \"model\": \"tree\"}], \"default\": \"knn\"}")
+	fs.StringVar(&m.chain, "chain", "",
+		"JSON fallback chain answering with the first stage that does not decline, as [{\"stage\": \"exact\"}, {\"stage\": \"knn\", \"min_confidence\": 0.5}, {\"stage\": \"linear\"}]")
+	fs.StringVar(&m.rule, "rule-config", "", "JSON rule model config whose tiers and bands the rule model refits, as written by estimate-tiers")
+	fs.StringVar(&m.localLoss, "local-loss", localLossSquared,
+		"loss of the local model's regressions on neighbors: squared, or huber to resist mislabeled cases")
+	fs.StringVar(&m.bins, "bins", "",
+		"round inputs to buckets before matching neighbors, as input=width pairs such as receipts=10,miles=5")
+	fs.BoolVar(&m.reproducible, "reproducible", false,
+		"predict in exact integer and ordered floating-point arithmetic, so every platform gives the same cents")
+	fs.BoolVar(&m.debug, "debug-numerics", false,
+		"check every step of each prediction for NaN or infinity and report the first computation that produced one")
+	m.table.register(fs)
+	fs.StringVar(&m.policyVersion, "policy-version", "",
+		"predict under this policy version of the config file, applying its flags over the profile's")
+	fs.StringVar(&m.effectiveDate, "effective-date", "",
+		"predict under the policy version of the config file in force on this YYYY-MM-DD date")
+}
+
+// build loads the training data and segmentation and returns the predictor.
+// With -model-tag set, the registered model is loaded instead, and with
+// -data naming the baked model, that model.
+func (m *modelFlags) build() (*Predictor, error) {
+	if m.debug {
+		debugNumerics = true
+	}
+	if m.memBudget != "" {
+		if err := setMemBudget(m.memBudget); err != nil {
+			return nil, err
+		}
+	}
+	if m.modelTag != "" {
+		p, _, err := loadRegisteredModel(m.registry, m.modelTag)
+		if p != nil {
+			p.PolicyVersion = m.policyVersion
+		}
+		return p, err
+	}
+	if m.dataPath == bakedData {
+		p, err := loadBakedModel()
+		if p != nil {
+			p.PolicyVersion = m.policyVersion
+		}
+		return p, err
+	}
+	timer.beginLoad()
+	sample, err := m.sample.config()
+	if err != nil {
+		return nil, err
+	}
+	if memBudget > 0 {
+		if sample, err = m.fitMemBudget(sample); err != nil {
+			return nil, err
+		}
+	}
+	trainingData, err := m.loadTrainingData(sample)
+	if m.packedData != "" {
+		os.Remove(m.packedData) // a mapping outlives its file's name
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading training data: %v", err)
+	}
+	sum, err := hashFile(m.dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("hashing training data: %v", err)
+	}
+	timer.end(phaseLoad)
+
+	var seg *Segmentation
+	if m.segmentsPath != "" {
+		seg, err = loadSegmentation(m.segmentsPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading segmentation: %v", err)
+		}
+	}
+	hp := Hyperparameters{K: m.k, Segmentation: seg, Sample: sample, RecencyHalfLife: m.halfLife, FallbackDistance: m.fallback,
+		Reproducible: m.reproducible}
+	if !isKNN(m.model) {
+		hp.Model = m.model
+	}
+	if !isEuclidean(m.metric) {
+		hp.Metric = m.metric
+	}
+	if m.duplicates != duplicatesKeepAll {
+		hp.Duplicates = m.duplicates
+	}
+	if m.aggregate != aggregateMean {
+		hp.Aggregate = m.aggregate
+	}
+	if m.localLoss != localLossSquared {
+		hp.LocalLoss = m.localLoss
+	}
+	if m.bins != "" {
+		if hp.Bins, err = parseBins(m.bins); err != nil {
+			return nil, err
+		}
+	}
+	if m.featuresPath != "" {
+		if hp.Features, err = loadFeatureConfig(m.featuresPath); err != nil {
+			return nil, err
+		}
+	}
+	if m.overrides != "" {
+		if hp.Overrides, err = loadOverrides(m.overrides); err != nil {
+			return nil, err
+		}
+	}
+	if m.routing != "" {
+		if hp.Routing, err = loadRouting(m.routing); err != nil {
+			return nil, err
+		}
+	}
+	if m.chain != "" {
+		if hp.Chain, err = loadChain(m.chain); err != nil {
+			return nil, err
+		}
+	}
+	if m.rule != "" {
+		if hp.Rule, err = loadRuleConfig(m.rule); err != nil {
+			return nil, err
+		}
+	}
+	if hp.Index, err = m.index.config(); err != nil {
+		return nil, err
+	}
+	if err := hp.validate(); err != nil {
+		return nil, err
+	}
+	p := NewPredictor(trainingData, hp)
+	timer.end(phaseIndex)
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	p.Version = unregisteredVersion
+	p.DataSHA256 = sum
+	p.PolicyVersion = m.policyVersion
+	return p, nil
+}
+
+// fitMemBudget plans the training data's load within memBudget (see
+// planTrainingLoad), applying the plan to the flags, and returns the
+// sampling to load with.
+func (m *modelFlags) fitMemBudget(sample *SampleConfig) (*SampleConfig, error) {
+	path, err := localPath(m.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planTrainingLoad(path, memBudget, m.mmap, sample, m.sample)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range plan.notes {
+		fmt.Fprintf(os.Stderr, "-mem-budget %s: %s\n", formatByteSize(memBudget), note)
+	}
+	m.mmap = plan.mmap
+	if plan.pack {
+		if m.packedData, err = packJSONFile(path); err != nil {
+			return nil, fmt.Errorf("packing training data: %v", err)
+		}
+	}
+	return plan.sample, nil
+}
+
+// loadTrainingData loads the -data file, reading a workbook with the
+// -sheet and -columns flags.
+func (m *modelFlags) loadTrainingData(sample *SampleConfig) (TrainingData, error) {
+	path, err := localPath(m.dataPath)
+	if err != nil {
+		return nil, err
+	}
+	if m.packedData != "" {
+		path = m.packedData
+	}
+	if workbook, err := isWorkbook(path); err != nil {
+		return nil, err
+	} else if !workbook {
+		return loadSampledTrainingFile(path, m.mmap, sample)
+	}
+	data, err := m.table.loadWorkbookCases(path)
+	if err == nil {
+		err = checkNotEmpty(m.dataPath, data)
+	}
+	if err != nil || sample == nil {
+		return data, err
+	}
+	s := newCaseSampler(*sample)
+	for _, c := range data {
+		s.add(c)
+	}
+	return s.sample(), nil
+}
+
+// unregisteredVersion is the model version of predictors built directly
+// from flags rather than loaded from the registry.
+const unregisteredVersion = "unregistered"
+
+// Provenance identifies exactly which model produced a prediction.
+type Provenance struct {
+	Policy          string          `json:"policy,omitempty"` // the server policy answering, unless the default
+	PolicyVersion   string          `json:"policy_version,omitempty"`
+	ModelVersion    string          `json:"model_version"`
+	DataSHA256      string          `json:"data_sha256"`
+	Hyperparameters Hyperparameters `json:"hyperparameters"`
+	Timestamp       time.Time       `json:"timestamp"`
+}
+
+// Provenance returns the predictor's provenance stamped with time t.
+func (p *Predictor) Provenance(t time.Time) Provenance {
+	return Provenance{
+		PolicyVersion:   p.PolicyVersion,
+		ModelVersion:    p.Version,
+		DataSHA256:      p.DataSHA256,
+		Hyperparameters: p.Hyperparameters(),
+		Timestamp:       t.UTC(),
+	}
+}
+
+// sources returns the files the predictor is built from.
+func (m *modelFlags) sources() []string {
+	if m.modelTag != "" {
+		dir := filepath.Join(m.registry, m.modelTag)
+		return []string{filepath.Join(dir, manifestFile), filepath.Join(dir, casesFile)}
+	}
+	paths := []string{m.dataPath}
+	if m.segmentsPath != "" {
+		paths = append(paths, m.segmentsPath)
+	}
+	if m.featuresPath != "" {
+		paths = append(paths, m.featuresPath)
+	}
+	for _, path := range []string{m.overrides, m.routing, m.chain, m.rule} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
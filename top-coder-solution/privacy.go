@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// privacyFlags configure Laplace noise added to exported reimbursements, so
+// that aggregate analyses can be shared without exact per-case figures.
+type privacyFlags struct {
+	epsilon     float64
+	sensitivity float64
+	seed        uint64
+}
+
+func (f *privacyFlags) register(fs *flag.FlagSet) {
+	fs.Float64Var(&f.epsilon, "dp-epsilon", 0,
+		"add Laplace noise giving each reimbursement this differential privacy budget; smaller is more private (0 disables)")
+	fs.Float64Var(&f.sensitivity, "dp-sensitivity", 0,
+		"largest change in a reimbursement one case can cause, in dollars (default the range of training outputs)")
+	fs.Uint64Var(&f.seed, "dp-seed", 0, "seed of the noise, for reproducible exports (0 draws a random seed)")
+}
+
+func (f *privacyFlags) validate() error {
+	if f.epsilon < 0 {
+		return fmt.Errorf("-dp-epsilon must not be negative")
+	}
+	if f.sensitivity < 0 {
+		return fmt.Errorf("-dp-sensitivity must not be negative")
+	}
+	if f.epsilon == 0 && (f.sensitivity != 0 || f.seed != 0) {
+		return fmt.Errorf("-dp-sensitivity and -dp-seed require -dp-epsilon")
+	}
+	return nil
+}
+
+// laplaceNoise is calibrated Laplace noise with scale Sensitivity/Epsilon.
+type laplaceNoise struct {
+	Epsilon     float64
+	Sensitivity float64
+	Seed        uint64
+}
+
+// noise returns the configured noise, or nil when it is disabled. The
+// sensitivity defaults to the range of training outputs, the most any one
+// reimbursement can plausibly move.
+func (f *privacyFlags) noise(training TrainingData) *laplaceNoise {
+	if f.epsilon == 0 {
+		return nil
+	}
+	n := &laplaceNoise{Epsilon: f.epsilon, Sensitivity: f.sensitivity, Seed: f.seed}
+	if n.Sensitivity == 0 {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, c := range training {
+			lo, hi = math.Min(lo, c.ExpectedOutput), math.Max(hi, c.ExpectedOutput)
+		}
+		if len(training) > 0 {
+			n.Sensitivity = hi - lo
+		}
+	}
+	if n.Seed == 0 {
+		n.Seed = rand.Uint64()
+	}
+	return n
+}
+
+// Scale is the noise's scale parameter b; its standard deviation is b√2.
+func (n *laplaceNoise) Scale() float64 {
+	return n.Sensitivity / n.Epsilon
+}
+
+// rng returns the noise source for one stream of values, such as a chunk of
+// a sweep, so that parallel exports with a fixed seed are reproducible.
+func (n *laplaceNoise) rng(stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(n.Seed, stream))
+}
+
+// add perturbs a reimbursement with noise drawn from rng. Reimbursements
+// are never negative, so noisy ones are clamped at zero, which does not
+// weaken the privacy guarantee.
+func (n *laplaceNoise) add(rng *rand.Rand, v float64) float64 {
+	// u is uniform on the open interval (-1/2, 1/2): at -1/2 the noise
+	// would be infinite.
+	f := rng.Float64()
+	for f == 0 {
+		f = rng.Float64()
+	}
+	u := f - 0.5
+	noise := -n.Scale() * math.Copysign(math.Log(1-2*math.Abs(u)), u)
+	return math.Max(0, v+noise)
+}
+
+func (n *laplaceNoise) String() string {
+	return fmt.Sprintf("Laplace noise: epsilon %g, sensitivity $%.2f, scale $%.2f", n.Epsilon, n.Sensitivity, n.Scale())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultConfigPath is the config file of named profiles, relative to the
+// working directory.
+const defaultConfigPath = "reimbursement.json"
+
+// Config is the config file: named profiles bundling the settings of an
+// environment, such as
+//
+//	{"profiles": {"staging": {
+//		"flags": {"data": "s3://reimburse-staging/cases.json", "model": "knn", "k": 7, "audit-log": "audit.jsonl"},
+//		"log_file": "/var/log/reimburse.log"}}}
+type Config struct {
+	Profiles       map[string]Profile `json:"profiles"`
+	PolicyVersions []PolicyVersion    `json:"policy_versions,omitempty"`
+}
+
+// Profile is one environment's settings. Flags holds flag values by name,
+// as JSON strings, numbers or booleans; each command takes the ones it
+// defines, so one profile serves every command. LogFile, when set, receives
+// the log instead of stderr.
+type Profile struct {
+	Flags   map[string]json.RawMessage `json:"flags"`
+	LogFile string                     `json:"log_file,omitempty"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// profile returns the named profile.
+func (c *Config) profile(name string) (Profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("no profile %q (profiles are %s)", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// apply sets the flags of fs the profile has values for, except those set on
+// the command line, which take precedence, and redirects the log. The values
+// act as defaults: fs.Visit still reports only the flags set explicitly.
+func (p Profile) apply(fs *flag.FlagSet) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	names := make([]string, 0, len(p.Flags))
+	for name := range p.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || explicit[name] {
+			continue
+		}
+		raw := p.Flags[name]
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("profile flag %s: %v", name, err)
+		}
+	}
+
+	if p.LogFile != "" {
+		file, err := os.OpenFile(p.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening log file: %v", err)
+		}
+		log.SetOutput(file)
+	}
+	return nil
+}
+
+// profileFlags select a profile of the config file.
+type profileFlags struct {
+	config  string
+	profile string
+}
+
+func (f *profileFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.config, "config", defaultConfigPath, "config file of named profiles")
+	fs.StringVar(&f.profile, "profile", "", "apply this profile of the config file; flags given explicitly override it")
+}
+
+// parseFlags parses args like fs.Parse, adding the -config, -profile and
+// -timings flags and applying the selected profile, then any policy version
+// selected. A flag set named after a command describes the command in its
+// usage.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var f profileFlags
+	f.register(fs)
+	timings := fs.Bool("timings", false,
+		"on exit, write the wall time of argument parsing, data load, index build and prediction to stderr as JSON")
+	if cmd, ok := commands[fs.Name()]; ok {
+		fs.Usage = commandUsage(fs, cmd)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if f.profile != "" {
+		c, err := loadConfig(f.config)
+		if err != nil {
+			return fmt.Errorf("loading config: %v", err)
+		}
+		p, err := c.profile(f.profile)
+		if err != nil {
+			return err
+		}
+		if err := p.apply(fs); err != nil {
+			return err
+		}
+	}
+	if err := applyPolicyVersion(fs, f.config); err != nil {
+		return err
+	}
+	if err := checkExplicitData(fs); err != nil {
+		return err
+	}
+	if *timings {
+		startTimings()
+		timer.end(phaseParse)
+	}
+	return nil
+}
+
+// requireDataEnv, set to any value, makes any binary require training data
+// to be named explicitly, as slim builds always do.
+const requireDataEnv = "REIMBURSEMENT_REQUIRE_DATA"
+
+// dataRequired reports whether training data must be named explicitly
+// rather than read from a default path.
+func dataRequired() bool {
+	return slimBuild || os.Getenv(requireDataEnv) != ""
+}
+
+// checkExplicitData fails, where dataRequired, when fs has a -data flag
+// left at its default and no -model-tag naming a registered model instead.
+// Flags a profile set count as given.
+func checkExplicitData(fs *flag.FlagSet) error {
+	if !dataRequired() {
+		return nil
+	}
+	given := func(name string) bool {
+		f := fs.Lookup(name)
+		if f == nil {
+			return false
+		}
+		set := f.Value.String() != f.DefValue
+		fs.Visit(func(v *flag.Flag) { set = set || v.Name == name })
+		return set
+	}
+	if fs.Lookup("data") == nil || given("data") || given("model-tag") {
+		return nil
+	}
+	what := "this slim build"
+	if !slimBuild {
+		what = requireDataEnv
+	}
+	if fs.Lookup("model-tag") != nil {
+		return fmt.Errorf("%s reads no default training data; give -data or -model-tag", what)
+	}
+	return fmt.Errorf("%s reads no default training data; give -data", what)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// Properties checked by check-properties.
+const (
+	propertyFinite      = "finite"
+	propertyNonNegative = "non-negative"
+	propertyDays        = "monotone-in-days"
+	propertyMiles       = "monotone-in-miles"
+	propertyPerDay      = "bounded-per-day"
+)
+
+// PropertyViolation is an input where the prediction surface breaks an
+// invariant. For monotonicity, Previous is the prediction one grid step
+// lower in days or miles.
+type PropertyViolation struct {
+	Property   string  `json:"property"`
+	Input      Query   `json:"input"`
+	Prediction float64 `json:"prediction"`
+	Previous   float64 `json:"previous,omitempty"`
+}
+
+// PropertyReport counts the violations of each property over a grid and
+// keeps examples of them.
+type PropertyReport struct {
+	Points     int                 `json:"points"`
+	Tolerance  float64             `json:"tolerance"`
+	MaxPerDay  float64             `json:"max_per_day"`
+	Violations map[string]int      `json:"violations"`
+	Examples   []PropertyViolation `json:"examples"`
+}
+
+// checkProperties predicts every point of the grid with p and tests its
+// invariants: predictions are finite and non-negative, fall by no more than
+// tolerance when days or miles increase by one step, and stay within
+// maxPerDay per day. At most examples violations of each property are kept.
+func checkProperties(p *Predictor, days, miles, receipts []float64, tolerance, maxPerDay float64, examples, workers int) PropertyReport {
+	nm, nr := len(miles), len(receipts)
+	grid := make([]float64, len(days)*nm*nr)
+	forEach(len(days), workers, func(i int) {
+		for j, m := range miles {
+			for k, r := range receipts {
+				grid[(i*nm+j)*nr+k] = p.Predict(int(days[i]), m, r)
+			}
+		}
+	})
+
+	report := PropertyReport{Points: len(grid), Tolerance: tolerance, MaxPerDay: maxPerDay, Violations: map[string]int{}}
+	violate := func(property string, i, j, k int, previous float64) {
+		report.Violations[property]++
+		if report.Violations[property] <= examples {
+			report.Examples = append(report.Examples, PropertyViolation{
+				Property:   property,
+				Input:      Query{TripDurationDays: int(days[i]), MilesTraveled: miles[j], TotalReceiptsAmount: receipts[k]},
+				Prediction: grid[(i*nm+j)*nr+k],
+				Previous:   previous,
+			})
+		}
+	}
+	for i, d := range days {
+		for j := range miles {
+			for k := range receipts {
+				y := grid[(i*nm+j)*nr+k]
+				if math.IsNaN(y) || math.IsInf(y, 0) {
+					violate(propertyFinite, i, j, k, 0)
+					continue
+				}
+				if y < 0 {
+					violate(propertyNonNegative, i, j, k, 0)
+				}
+				if i > 0 {
+					if prev := grid[((i-1)*nm+j)*nr+k]; y < prev-tolerance {
+						violate(propertyDays, i, j, k, prev)
+					}
+				}
+				if j > 0 {
+					if prev := grid[(i*nm+j-1)*nr+k]; y < prev-tolerance {
+						violate(propertyMiles, i, j, k, prev)
+					}
+				}
+				if d > 0 && y/d > maxPerDay {
+					violate(propertyPerDay, i, j, k, 0)
+				}
+			}
+		}
+	}
+	return report
+}
+
+// maxTrainingPerDay returns the largest output per trip day in training.
+func maxTrainingPerDay(training TrainingData) float64 {
+	rate := 0.0
+	for _, c := range training {
+		if c.Input.TripDurationDays > 0 {
+			rate = math.Max(rate, c.ExpectedOutput/float64(c.Input.TripDurationDays))
+		}
+	}
+	return rate
+}
+
+func runCheckProperties(args []string) error {
+	fs := flag.NewFlagSet("check-properties", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	days := fs.String("days", "1:14", "trip duration range start:end[:step]")
+	miles := fs.String("miles", "0:2000:50", "miles traveled range start:end[:step]")
+	receipts := fs.String("receipts", "0:2500:50", "receipts amount range start:end[:step]")
+	tolerance := fs.Float64("tolerance", 5, "largest drop in dollars still counted as monotone")
+	maxPerDay := fs.Float64("max-per-day", 0, "largest plausible reimbursement per trip day (default the largest in the training data)")
+	examples := fs.Int("examples", 5, "example inputs to report per violated property")
+	jobs := fs.Int("jobs", 0, "prediction workers (0 uses every CPU)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var ranges [3]gridRange
+	for i, s := range []string{*days, *miles, *receipts} {
+		r, err := parseGridRange(s)
+		if err != nil {
+			return err
+		}
+		ranges[i] = r
+	}
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if *maxPerDay <= 0 {
+		*maxPerDay = maxTrainingPerDay(p.Training)
+	}
+
+	report := checkProperties(p, ranges[0].Values(), ranges[1].Values(), ranges[2].Values(), *tolerance, *maxPerDay, *examples, *jobs)
+	if *asJSON {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			return err
+		}
+	} else {
+		printProperties(os.Stdout, report)
+	}
+	if len(report.Violations) > 0 {
+		return &exitError{code: exitGateFailed}
+	}
+	return nil
+}
+
+func printProperties(w io.Writer, r PropertyReport) {
+	fmt.Fprintf(w, "Checked %d grid points (tolerance $%g, max $%.2f per day)\n\n", r.Points, r.Tolerance, r.MaxPerDay)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROPERTY\tVIOLATIONS")
+	for _, prop := range []string{propertyFinite, propertyNonNegative, propertyDays, propertyMiles, propertyPerDay} {
+		fmt.Fprintf(tw, "%s\t%d\n", prop, r.Violations[prop])
+	}
+	tw.Flush()
+	if len(r.Examples) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nExamples:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROPERTY\tDAYS\tMILES\tRECEIPTS\tPREDICTION\tPREVIOUS STEP")
+	for _, v := range r.Examples {
+		prev := ""
+		if v.Property == propertyDays || v.Property == propertyMiles {
+			prev = fmt.Sprintf("%.2f", v.Previous)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%g\t%.2f\t%.2f\t%s\n", v.Property, v.Input.TripDurationDays, v.Input.MilesTraveled,
+			v.Input.TotalReceiptsAmount, v.Prediction, prev)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// caseTime is when a training case was recorded, in Unix seconds, or 0 when
+// unknown. It is an integer rather than a time.Time so that TestCase holds
+// no pointers and packed data can be used in place. In JSON it is an RFC 3339
+// timestamp or a YYYY-MM-DD date.
+type caseTime int64
+
+func (t caseTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Unix(int64(t), 0).UTC().Format(time.RFC3339))
+}
+
+func (t *caseTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("timestamp must be a string: %v", err)
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*t = caseTime(parsed.Unix())
+			return nil
+		}
+	}
+	return fmt.Errorf("timestamp %q is neither RFC 3339 nor YYYY-MM-DD", s)
+}
+
+// recencyDecay weights training cases by age, halving a case's influence
+// every halfLife days before the newest timestamp in the training data.
+// Cases without a timestamp keep full weight. A nil *recencyDecay weights
+// every case equally.
+type recencyDecay struct {
+	halfLife float64 // days
+	newest   caseTime
+}
+
+// newRecencyDecay returns the decay for training, or nil when halfLifeDays is
+// zero or no case has a timestamp.
+func newRecencyDecay(training TrainingData, halfLifeDays float64) *recencyDecay {
+	if halfLifeDays <= 0 {
+		return nil
+	}
+	r := &recencyDecay{halfLife: halfLifeDays}
+	for _, c := range training {
+		r.newest = max(r.newest, c.Timestamp)
+	}
+	if r.newest == 0 {
+		return nil
+	}
+	return r
+}
+
+// weight returns the influence of c, in (0, 1].
+func (r *recencyDecay) weight(c TestCase) float64 {
+	if r == nil || c.Timestamp == 0 {
+		return 1
+	}
+	ageDays := float64(r.newest-c.Timestamp) / (24 * 60 * 60)
+	return math.Exp2(-ageDays / r.halfLife)
+}
+
+// aggregate is the package-level aggregate with each neighbor's
+// inverse-distance weight scaled by the recency weight of its case in pool.
+func (r *recencyDecay) aggregate(neighbors []Neighbor, pool TrainingData, how string) float64 {
+	if r == nil {
+		return aggregate(neighbors, how, inverseDistance)
+	}
+	return aggregate(neighbors, how, func(n Neighbor) float64 {
+		return r.weight(pool[n.Case]) / (n.Distance + 1e-8)
+	})
+}
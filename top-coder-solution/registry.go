@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultRegistry is the model registry directory relative to this directory.
+const defaultRegistry = "models"
+
+// Registry layout:
+//
+//	models/<tag>/manifest.json  hyperparameters, data hash and eval metrics
+//	models/<tag>/cases.json     snapshot of the training data
+const (
+	manifestFile = "manifest.json"
+	casesFile    = "cases.json"
+)
+
+var validTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Hyperparameters are the settings that, with the training data, fully
+// determine a predictor.
+type Hyperparameters struct {
+	Model        string          `json:"model,omitempty"` // registered model answering instead of KNN; empty for KNN
+	K            int             `json:"k"`
+	Segmentation *Segmentation   `json:"segmentation,omitempty"`
+	Index        *IndexConfig    `json:"index,omitempty"`
+	Metric       string          `json:"metric,omitempty"`
+	Features     []FeatureConfig `json:"features,omitempty"`
+	Sample       *SampleConfig   `json:"sample,omitempty"`
+	Duplicates   string          `json:"duplicates,omitempty"` // merge strategy for identical inputs; empty keeps all
+	// RecencyHalfLife is the age in days at which a timestamped case has
+	// half the influence of the newest; 0 weights cases equally.
+	RecencyHalfLife float64 `json:"recency_half_life_days,omitempty"`
+	// FallbackDistance is the nearest-neighbor distance beyond which a
+	// linear fit answers instead of KNN; 0 disables the fallback.
+	FallbackDistance float64 `json:"fallback_distance,omitempty"`
+	// Overrides are rules applied to every prediction after the model.
+	Overrides []OverrideRule `json:"overrides,omitempty"`
+	// Routing sends queries to different models by their inputs, in place
+	// of KNN.
+	Routing *RoutingConfig `json:"routing,omitempty"`
+	// Chain answers each query with the first of its stages that does not
+	// decline, in place of KNN.
+	Chain []ChainStage `json:"chain,omitempty"`
+	// Rule sets the tiers and bands of the rule model, whose amounts and
+	// rates it refits; nil uses the defaults.
+	Rule *RuleConfig `json:"rule,omitempty"`
+	// Aggregate is how the outputs of the nearest neighbors are combined:
+	// mean, median or trimmed-mean; empty is the mean.
+	Aggregate string `json:"aggregate,omitempty"`
+	// LocalLoss is the loss the local model's regressions minimize:
+	// squared or huber; empty is squared.
+	LocalLoss string `json:"local_loss,omitempty"`
+	// Bins rounds inputs to buckets before neighbors are matched; nil
+	// matches the raw inputs.
+	Bins *BinConfig `json:"bins,omitempty"`
+	// Reproducible predicts in arithmetic that gives the same cents on
+	// every platform.
+	Reproducible bool `json:"reproducible,omitempty"`
+}
+
+// ModelMetrics records how a model scored when it was trained.
+type ModelMetrics struct {
+	Method string `json:"method"`
+	EvalSummary
+	Score float64 `json:"score"`
+}
+
+// ModelManifest describes one registered model version.
+type ModelManifest struct {
+	Tag             string          `json:"tag"`
+	CreatedAt       time.Time       `json:"created_at"`
+	SourceData      string          `json:"source_data"`
+	DataSHA256      string          `json:"data_sha256"`
+	CaseCount       int             `json:"case_count"`
+	Hyperparameters Hyperparameters `json:"hyperparameters"`
+	Metrics         ModelMetrics    `json:"metrics"`
+}
+
+// hashFile returns the hex SHA-256 of a file's contents. path may be an
+// s3:// or gs:// URI.
+func hashFile(path string) (string, error) {
+	path, err := localPath(path)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func loadManifest(registry, tag string) (*ModelManifest, error) {
+	if !validTag.MatchString(tag) {
+		return nil, fmt.Errorf("invalid model tag %q", tag)
+	}
+	data, err := os.ReadFile(filepath.Join(registry, tag, manifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("model %q not found in registry %s", tag, registry)
+		}
+		return nil, err
+	}
+	var m ModelManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing manifest for %q: %v", tag, err)
+	}
+	return &m, nil
+}
+
+// loadRegisteredModel rebuilds the predictor stored under tag, verifying that
+// the training data snapshot still matches the recorded hash.
+func loadRegisteredModel(registry, tag string) (*Predictor, *ModelManifest, error) {
+	timer.beginLoad()
+	m, err := loadManifest(registry, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := filepath.Join(registry, tag, casesFile)
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sum != m.DataSHA256 {
+		return nil, nil, fmt.Errorf("model %q: training data hash %s does not match manifest %s", tag, sum, m.DataSHA256)
+	}
+
+	if err := m.Hyperparameters.validate(); err != nil {
+		return nil, nil, fmt.Errorf("model %q: %v", tag, err)
+	}
+	trainingData, err := loadSampledTrainingFile(path, true, m.Hyperparameters.Sample)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading training data for %q: %v", tag, err)
+	}
+	timer.end(phaseLoad)
+	p := NewPredictor(trainingData, m.Hyperparameters)
+	timer.end(phaseIndex)
+	if err := p.Err(); err != nil {
+		return nil, nil, fmt.Errorf("model %q: %v", tag, err)
+	}
+	p.Version = m.Tag
+	p.DataSHA256 = m.DataSHA256
+	return p, m, nil
+}
+
+func runTrain(args []string) error {
+	fs := flag.NewFlagSet("train", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	tag := fs.String("tag", "", "version tag for the trained model (required)")
+	force := fs.Bool("force", false, "overwrite an existing model with the same tag")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tag == "" {
+		return fmt.Errorf("-tag is required")
+	}
+	if !validTag.MatchString(*tag) {
+		return fmt.Errorf("invalid model tag %q", *tag)
+	}
+	if model.modelTag != "" {
+		return fmt.Errorf("-model-tag cannot be used with train")
+	}
+	if model.dataPath == bakedData {
+		return fmt.Errorf("train needs -data; the baked model is already trained")
+	}
+
+	dir := filepath.Join(model.registry, *tag)
+	if _, err := os.Stat(dir); err == nil && !*force {
+		return fmt.Errorf("model %q already exists; use -force to overwrite", *tag)
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	source, err := localPath(model.dataPath)
+	if err != nil {
+		return err
+	}
+	workbook, err := isWorkbook(source)
+	if err != nil {
+		return err
+	}
+
+	summary := summarize(evaluate(predictor.Training, predictor, true))
+	manifest := ModelManifest{
+		Tag:             *tag,
+		CreatedAt:       time.Now().UTC(),
+		SourceData:      model.dataPath,
+		CaseCount:       len(predictor.Training),
+		Hyperparameters: predictor.Hyperparameters(),
+		Metrics:         ModelMetrics{Method: "leave-one-out", EvalSummary: summary, Score: summary.Score()},
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// The registry snapshots workbooks as JSON, so loading a model does not
+	// depend on the sheet and column flags it was trained with.
+	cases := filepath.Join(dir, casesFile)
+	if workbook {
+		data, err := model.table.loadWorkbookCases(source)
+		if err != nil {
+			return err
+		}
+		err = writeJSONFile(cases, data)
+	} else {
+		err = copyFile(cases, source)
+	}
+	if err != nil {
+		return err
+	}
+	if manifest.DataSHA256, err = hashFile(cases); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return err
+	}
+
+	fmt.Printf("Registered model %s (%d cases, LOO average error $%.2f, score %.2f)\n",
+		*tag, manifest.CaseCount, summary.MeanError, manifest.Metrics.Score)
+	return nil
+}
+
+func runModels(args []string) error {
+	fs := flag.NewFlagSet("models", flag.ContinueOnError)
+	registry := fs.String("registry", defaultRegistry, "model registry directory")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(*registry)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No models registered")
+			return nil
+		}
+		return err
+	}
+
+	var manifests []*ModelManifest
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := loadManifest(*registry, e.Name())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", e.Name(), err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].CreatedAt.Before(manifests[j].CreatedAt)
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tCREATED\tCASES\tK\tSEGMENTS\tLOO MAE\tSCORE\tDATA SHA256")
+	for _, m := range manifests {
+		segments := 0
+		if m.Hyperparameters.Segmentation != nil {
+			segments = len(m.Hyperparameters.Segmentation.Segments)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.2f\t%.2f\t%.12s\n", m.Tag, m.CreatedAt.Format(time.RFC3339),
+			m.CaseCount, m.Hyperparameters.K, segments, m.Metrics.MeanError, m.Metrics.Score, m.DataSHA256)
+	}
+	return tw.Flush()
+}
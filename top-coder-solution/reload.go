@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// reload rebuilds t's predictor from its source and swaps it in. On failure
+// the current model keeps serving. Concurrent reloads of a tenant are
+// serialized so an older load can never replace a newer one.
+func (s *Server) reload(t *tenant) (*serving, error) {
+	t.reloads.Lock()
+	defer t.reloads.Unlock()
+
+	p, err := t.load()
+	if err == nil {
+		err = validatePredictor(p)
+	}
+	if err != nil {
+		t.metrics.reloadFailures.Add(1)
+		log.Printf("%sreload failed, keeping current model: %v", t.logPrefix(), err)
+		return nil, err
+	}
+	var shadow *Predictor
+	if t.loadShadow != nil {
+		if shadow, err = t.loadShadow(p); err != nil {
+			log.Printf("%sshadow model failed to load, serving without it: %v", t.logPrefix(), err)
+			shadow = nil
+		}
+	}
+	m := s.setPredictor(t, p, shadow)
+	t.metrics.reloads.Add(1)
+	logServing(t, m)
+	return m, nil
+}
+
+// handleReload rebuilds the model of the policy named in the path, or the
+// default model, from its updated data file and reports the newly loaded
+// model.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	t := s.tenantFor(w, r, "")
+	if t == nil {
+		return
+	}
+	m, err := s.reload(t)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "reload failed: "+err.Error())
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, m.info())
+}
+
+// validatePredictor rejects models that should never replace a working one,
+// such as those built from a truncated data file.
+func validatePredictor(p *Predictor) error {
+	if len(p.Training) < p.K {
+		return fmt.Errorf("training data has %d cases, fewer than k=%d", len(p.Training), p.K)
+	}
+	for i, c := range p.Training {
+		in := c.Input
+		if in.TripDurationDays < 1 || in.MilesTraveled < 0 || in.TotalReceiptsAmount < 0 {
+			return fmt.Errorf("training case %d has invalid input %+v", i, in)
+		}
+	}
+	return nil
+}
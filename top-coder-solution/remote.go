@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// remoteFlags forward a prediction to a running server instead of loading
+// the model locally, so laptops and CI share the server's model.
+type remoteFlags struct {
+	url     string
+	token   string
+	timeout time.Duration
+}
+
+func (f *remoteFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.url, "remote", "", "predict with the server at this URL, e.g. https://reimburse.internal, instead of locally")
+	fs.StringVar(&f.token, "remote-token", "", "bearer token for -remote")
+	fs.DurationVar(&f.timeout, "remote-timeout", 10*time.Second, "time limit of a -remote request")
+}
+
+// remoteClientFlags are the predict flags that still apply with -remote.
+// The rest configure the local model, which the server's configuration
+// replaces.
+var remoteClientFlags = map[string]bool{
+	"json": true, "precision": true, "format": true,
+	"remote": true, "remote-token": true, "remote-timeout": true,
+	"config": true, "profile": true,
+}
+
+func (f *remoteFlags) validate(fs *flag.FlagSet) error {
+	if f.url == "" {
+		if f.token != "" {
+			return fmt.Errorf("-remote-token requires -remote")
+		}
+		return nil
+	}
+	u, err := url.Parse(f.url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -remote URL %q, want http(s)://host", f.url)
+	}
+	var local []string
+	fs.Visit(func(fl *flag.Flag) {
+		if !remoteClientFlags[fl.Name] {
+			local = append(local, "-"+fl.Name)
+		}
+	})
+	if len(local) > 0 {
+		sort.Strings(local)
+		return fmt.Errorf("%s cannot be used with -remote; the server's configuration applies", strings.Join(local, ", "))
+	}
+	return nil
+}
+
+// predict asks the server for q's prediction.
+func (f *remoteFlags) predict(q Query) (PredictionResponse, error) {
+	body, err := json.Marshal(q)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(f.url, "/")+"/predict", bytes.NewReader(body))
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return PredictionResponse{}, fmt.Errorf("remote: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return PredictionResponse{}, fmt.Errorf("remote: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return PredictionResponse{}, fmt.Errorf("remote: %s: %s", resp.Status, e.Error)
+		}
+		return PredictionResponse{}, fmt.Errorf("remote: %s", resp.Status)
+	}
+	var pr PredictionResponse
+	if err := json.Unmarshal(data, &pr); err != nil {
+		return PredictionResponse{}, fmt.Errorf("remote: parsing response: %v", err)
+	}
+	return pr, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	plotWidth   = 560
+	plotHeight  = 320
+	plotMargin  = 48
+	histogramNB = 30
+)
+
+// point is a single (x, y) sample in a scatter plot.
+type point struct {
+	X, Y float64
+}
+
+// axisBounds returns the min and max of values, padded so that a constant
+// series still produces a drawable axis.
+func axisBounds(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 1
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values[1:] {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+// svgFrame writes the plot border, axis labels and tick values.
+func svgFrame(b *strings.Builder, xLabel, yLabel string, xMin, xMax, yMin, yMax float64) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`, plotWidth, plotHeight)
+	fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#999"/>`,
+		plotMargin, plotMargin/2, plotWidth-plotMargin*3/2, plotHeight-plotMargin*3/2)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, plotWidth/2, plotHeight-6, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(b, `<text x="12" y="%d" text-anchor="middle" transform="rotate(-90 12 %d)">%s</text>`,
+		plotHeight/2, plotHeight/2, template.HTMLEscapeString(yLabel))
+	fmt.Fprintf(b, `<text x="%d" y="%d">%.0f</text>`, plotMargin, plotHeight-plotMargin+14, xMin)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%.0f</text>`, plotWidth-plotMargin/2, plotHeight-plotMargin+14, xMax)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%.0f</text>`, plotMargin-4, plotHeight-plotMargin, yMin)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="end">%.0f</text>`, plotMargin-4, plotMargin/2+10, yMax)
+}
+
+// plotScale maps data coordinates into the plot area.
+type plotScale struct {
+	xMin, xMax, yMin, yMax float64
+}
+
+func (s plotScale) x(v float64) float64 {
+	inner := float64(plotWidth - plotMargin*3/2)
+	return float64(plotMargin) + (v-s.xMin)/(s.xMax-s.xMin)*inner
+}
+
+func (s plotScale) y(v float64) float64 {
+	inner := float64(plotHeight - plotMargin*3/2)
+	return float64(plotMargin/2) + inner - (v-s.yMin)/(s.yMax-s.yMin)*inner
+}
+
+// scatterSVG renders points as an inline SVG scatter plot. When diagonal is
+// set a y = x reference line is drawn; when zeroLine is set a y = 0 line is.
+func scatterSVG(points []point, xLabel, yLabel string, diagonal, zeroLine bool) template.HTML {
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	for i, p := range points {
+		xs[i], ys[i] = p.X, p.Y
+	}
+	xMin, xMax := axisBounds(xs)
+	yMin, yMax := axisBounds(ys)
+	if diagonal {
+		xMin, yMin = math.Min(xMin, yMin), math.Min(xMin, yMin)
+		xMax, yMax = math.Max(xMax, yMax), math.Max(xMax, yMax)
+	}
+	s := plotScale{xMin, xMax, yMin, yMax}
+
+	var b strings.Builder
+	svgFrame(&b, xLabel, yLabel, xMin, xMax, yMin, yMax)
+	if diagonal {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#c33" stroke-dasharray="4"/>`,
+			s.x(xMin), s.y(xMin), s.x(xMax), s.y(xMax))
+	}
+	if zeroLine && yMin < 0 && yMax > 0 {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#c33" stroke-dasharray="4"/>`,
+			s.x(xMin), s.y(0), s.x(xMax), s.y(0))
+	}
+	for _, p := range points {
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="2" fill="#3366aa" fill-opacity="0.5"/>`, s.x(p.X), s.y(p.Y))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// histogramSVG renders values as a histogram of bins equal-width bars.
+func histogramSVG(values []float64, bins int, xLabel string) template.HTML {
+	xMin, xMax := axisBounds(values)
+	counts := make([]float64, bins)
+	for _, v := range values {
+		i := int((v - xMin) / (xMax - xMin) * float64(bins))
+		if i >= bins {
+			i = bins - 1
+		}
+		counts[i]++
+	}
+	yMax := 1.0
+	for _, c := range counts {
+		yMax = math.Max(yMax, c)
+	}
+	s := plotScale{xMin, xMax, 0, yMax}
+
+	var b strings.Builder
+	svgFrame(&b, xLabel, "cases", xMin, xMax, 0, yMax)
+	width := (s.x(xMax) - s.x(xMin)) / float64(bins)
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		x := s.x(xMin) + float64(i)*width
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#3366aa"/>`,
+			x, s.y(c), width-1, s.y(0)-s.y(c))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+type reportSegmentTable struct {
+	Title    string
+	Segments []Segment
+}
+
+type reportData struct {
+	Summary          EvalSummary
+	ExactPct         float64
+	ClosePct         float64
+	ErrorHistogram   template.HTML
+	ResidualReceipts template.HTML
+	ResidualDays     template.HTML
+	PredictedActual  template.HTML
+	SegmentTables    []reportSegmentTable
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reimbursement model evaluation</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.plots { display: flex; flex-wrap: wrap; gap: 1em; }
+</style>
+</head>
+<body>
+<h1>Reimbursement model evaluation</h1>
+
+<h2>Summary</h2>
+<table>
+<tr><td>Total cases</td><td>{{.Summary.Count}}</td></tr>
+<tr><td>Exact matches (to the cent)</td><td>{{.Summary.ExactMatches}} ({{printf "%.1f" .ExactPct}}%)</td></tr>
+<tr><td>Close matches (±$1.00)</td><td>{{.Summary.CloseMatches}} ({{printf "%.1f" .ClosePct}}%)</td></tr>
+<tr><td>Average error</td><td>${{printf "%.2f" .Summary.Challenge.AverageError}}</td></tr>
+<tr><td>RMSE</td><td>${{printf "%.2f" .Summary.RMSE}}</td></tr>
+<tr><td>Maximum error</td><td>${{printf "%.2f" .Summary.MaxError}}</td></tr>
+<tr><td>Score</td><td>{{printf "%.2f" .Summary.Score}}</td></tr>
+</table>
+
+<h2>Error distribution</h2>
+<div class="plots">{{.ErrorHistogram}}</div>
+
+<h2>Residuals</h2>
+<div class="plots">
+{{.PredictedActual}}
+{{.ResidualReceipts}}
+{{.ResidualDays}}
+</div>
+
+<h2>Segments</h2>
+{{range .SegmentTables}}
+<h3>{{.Title}}</h3>
+<table>
+<tr><th>Segment</th><th>Cases</th><th>Exact</th><th>Close</th><th>Avg error</th><th>RMSE</th><th>Max error</th></tr>
+{{range .Segments}}<tr><td>{{.Name}}</td><td>{{.Summary.Count}}</td><td>{{.Summary.ExactMatches}}</td><td>{{.Summary.CloseMatches}}</td><td>${{printf "%.2f" .Summary.Challenge.AverageError}}</td><td>${{printf "%.2f" .Summary.RMSE}}</td><td>${{printf "%.2f" .Summary.MaxError}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport renders a standalone HTML page with summary metrics, an
+// error histogram, residual plots and per-segment tables.
+func writeHTMLReport(w io.Writer, results []EvalResult, summary EvalSummary) error {
+	errors := make([]float64, len(results))
+	predictedActual := make([]point, len(results))
+	residualReceipts := make([]point, len(results))
+	residualDays := make([]point, len(results))
+	for i, r := range results {
+		errors[i] = r.AbsError()
+		predictedActual[i] = point{r.Case.ExpectedOutput, r.Predicted}
+		residualReceipts[i] = point{r.Case.Input.TotalReceiptsAmount, r.Residual()}
+		residualDays[i] = point{float64(r.Case.Input.TripDurationDays), r.Residual()}
+	}
+
+	data := reportData{
+		Summary:          summary,
+		ExactPct:         pct(summary.ExactMatches, summary.Count),
+		ClosePct:         pct(summary.CloseMatches, summary.Count),
+		ErrorHistogram:   histogramSVG(errors, histogramNB, "absolute error ($)"),
+		PredictedActual:  scatterSVG(predictedActual, "expected ($)", "predicted ($)", true, false),
+		ResidualReceipts: scatterSVG(residualReceipts, "receipts ($)", "residual ($)", false, true),
+		ResidualDays:     scatterSVG(residualDays, "trip duration (days)", "residual ($)", false, true),
+	}
+	for _, s := range segmenters {
+		data.SegmentTables = append(data.SegmentTables, reportSegmentTable{Title: s.Title, Segments: s.segment(results)})
+	}
+
+	return reportTemplate.Execute(w, data)
+}
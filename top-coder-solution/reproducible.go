@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Reproducible mode predicts with arithmetic whose every step is exactly
+// specified by IEEE 754, so identical inputs give identical cents on amd64,
+// arm64 and wasm. Inputs are converted to integer thousandths, squared
+// distances are computed exactly in integers, and the only floating-point
+// steps left are correctly rounded ones (conversion, square root, division
+// and single additions and multiplications) performed in a fixed order, with
+// explicit conversions so that no platform fuses them into a multiply-add.
+
+// fixedScale is the common denominator of the distance scales over inputs in
+// thousandths: dayScale, and mileScale and receiptScale times 1000.
+const fixedScale = 6e6
+
+// fixedInput is a case's inputs in exact integer units: days, and miles and
+// receipts in thousandths.
+type fixedInput struct {
+	days, miles, receipts int64
+}
+
+func toFixed(days int, miles, receipts float64) fixedInput {
+	return fixedInput{int64(days), int64(math.Round(miles * 1000)), int64(math.Round(receipts * 1000))}
+}
+
+// squaredDistance is calculateDistance squared, times fixedScale squared,
+// exactly. Each scaled difference fits in an int64 for inputs within
+// maxMagnitude, but its square may not, so the sum is taken in 128 bits.
+func (a fixedInput) squaredDistance(b fixedInput) uint128 {
+	var sum uint128
+	for _, d := range [...]int64{
+		(a.days - b.days) * (fixedScale / dayScale),
+		(a.miles - b.miles) * (fixedScale / (mileScale * 1000)),
+		(a.receipts - b.receipts) * (fixedScale / (receiptScale * 1000)),
+	} {
+		if d < 0 {
+			d = -d
+		}
+		hi, lo := bits.Mul64(uint64(d), uint64(d))
+		var carry uint64
+		sum.lo, carry = bits.Add64(sum.lo, lo, 0)
+		sum.hi, _ = bits.Add64(sum.hi, hi, carry)
+	}
+	return sum
+}
+
+// uint128 is an unsigned 128-bit integer.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (u uint128) isZero() bool {
+	return u.hi == 0 && u.lo == 0
+}
+
+// float64 converts u to the nearest float64 for values below 2^64, and for
+// larger ones to within a rounding of it, the same on every platform.
+func (u uint128) float64() float64 {
+	return float64(float64(u.hi)*0x1p64) + float64(u.lo)
+}
+
+// predictReproducible is predictWeightedKNN in reproducible arithmetic,
+// rounded to the cent. Ties in distance go to the case earlier in training.
+func predictReproducible(tripDays int, miles, receipts float64, training TrainingData, k int, how string) float64 {
+	q := toFixed(tripDays, miles, receipts)
+	k = min(max(k, 1), len(training))
+	buf := neighborPool.Get().(*[]Neighbor)
+	defer neighborPool.Put(buf)
+	neighbors := (*buf)[:0]
+	for i, c := range training {
+		sq := q.squaredDistance(toFixed(c.Input.TripDurationDays, c.Input.MilesTraveled, c.Input.TotalReceiptsAmount))
+		if sq.isZero() {
+			*buf = neighbors
+			return roundCents(c.ExpectedOutput)
+		}
+		neighbors = insertNeighbor(neighbors, k, Neighbor{Distance: sq.float64(), Output: c.ExpectedOutput, Case: i})
+	}
+	for i := range neighbors {
+		neighbors[i].Distance = float64(math.Sqrt(neighbors[i].Distance) / fixedScale)
+	}
+	*buf = neighbors
+	if len(neighbors) == 0 {
+		return 0
+	}
+	return roundCents(aggregate(neighbors, how, inverseDistance))
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RescoredCase is a historical case predicted again, with the change from
+// the amount paid.
+type RescoredCase struct {
+	Case          int     `json:"case"`
+	Input         Query   `json:"input"`
+	PolicyVersion string  `json:"policy_version,omitempty"` // the era's, when rescored by era
+	Paid          float64 `json:"paid"`
+	Rescored      float64 `json:"rescored"`
+	Delta         float64 `json:"delta"` // rescored less paid
+}
+
+// RescoreSummary is the financial impact of paying historical cases at the
+// amounts a model predicts instead of those paid.
+type RescoreSummary struct {
+	ModelVersion    string        `json:"model_version"`
+	PolicyVersion   string        `json:"policy_version,omitempty"`
+	Cases           int           `json:"cases"`
+	PaidTotal       float64       `json:"paid_total"`
+	RescoredTotal   float64       `json:"rescored_total"`
+	NetImpact       float64       `json:"net_impact"` // rescored total less paid total
+	Increases       int           `json:"increases"`
+	Decreases       int           `json:"decreases"`
+	Unchanged       int           `json:"unchanged"`
+	MeanAbsDelta    float64       `json:"mean_abs_delta"`
+	LargestIncrease *RescoredCase `json:"largest_increase,omitempty"`
+	LargestDecrease *RescoredCase `json:"largest_decrease,omitempty"`
+}
+
+// rescore predicts each historical case with p.
+func rescore(p *Predictor, history TrainingData) ([]RescoredCase, RescoreSummary, error) {
+	predicted := make([]float64, len(history))
+	for i, c := range history {
+		predicted[i] = p.PredictQuery(c.Input)
+	}
+	if err := p.Err(); err != nil {
+		return nil, RescoreSummary{}, err
+	}
+	rows, s := summarizeRescore(history, predicted, nil)
+	s.ModelVersion, s.PolicyVersion = p.Version, p.PolicyVersion
+	return rows, s, nil
+}
+
+// rescoreEra is one policy era's cases, by index in the history, and the
+// model flags reproducing its rules.
+type rescoreEra struct {
+	version PolicyVersion
+	model   modelFlags
+	cases   []int
+}
+
+// splitEras assigns each historical case to the policy version of the
+// config file in force on the day it was recorded, and returns the eras
+// with any cases in effective date order, each with model's flags under
+// the version's, except those set explicitly on fs.
+func splitEras(c *Config, fs *flag.FlagSet, model modelFlags, history TrainingData) ([]*rescoreEra, error) {
+	byName := map[string]*rescoreEra{}
+	for i, hc := range history {
+		if hc.Timestamp == 0 {
+			return nil, fmt.Errorf("case %d has no timestamp to choose its policy version by; "+
+				"give every case one, or rescore under one version with -policy-version", i)
+		}
+		v, err := c.policyVersion("", time.Unix(int64(hc.Timestamp), 0).UTC().Format(time.DateOnly))
+		if err != nil {
+			return nil, fmt.Errorf("case %d: %v", i, err)
+		}
+		era := byName[v.Name]
+		if era == nil {
+			if era, err = eraOf(v, fs, model); err != nil {
+				return nil, err
+			}
+			byName[v.Name] = era
+		}
+		era.cases = append(era.cases, i)
+	}
+	eras := slices.Collect(maps.Values(byName))
+	slices.SortFunc(eras, func(a, b *rescoreEra) int { return strings.Compare(a.version.Effective, b.version.Effective) })
+	return eras, nil
+}
+
+// eraOf returns the era of policy version v, its model flags being model's
+// with v's applied over them, except those set explicitly on fs.
+func eraOf(v PolicyVersion, fs *flag.FlagSet, model modelFlags) (*rescoreEra, error) {
+	eraFlags := flag.NewFlagSet("era", flag.ContinueOnError)
+	era := &rescoreEra{version: v}
+	era.model.register(eraFlags)
+	// The flags write to era.model's fields, so start them from model's
+	// values, and mark those given explicitly so the version leaves them.
+	era.model = model
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if eraFlags.Lookup(f.Name) != nil && err == nil {
+			err = eraFlags.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]json.RawMessage{}
+	for name, value := range v.Flags {
+		if name != "policy-version" && name != "effective-date" {
+			flags[name] = value
+		}
+	}
+	if err := (Profile{Flags: flags}).apply(eraFlags); err != nil {
+		return nil, fmt.Errorf("policy version %s: %v", v.Name, err)
+	}
+	era.model.policyVersion = v.Name
+	return era, nil
+}
+
+// rescoreByEra predicts each historical case with the model of its era. It
+// also returns the eras' training data together.
+func rescoreByEra(eras []*rescoreEra, history TrainingData) ([]RescoredCase, RescoreSummary, TrainingData, error) {
+	var training TrainingData
+	predicted := make([]float64, len(history))
+	versions := make([]string, len(history))
+	var names, models []string
+	for _, era := range eras {
+		p, err := era.model.build()
+		if err != nil {
+			return nil, RescoreSummary{}, nil, fmt.Errorf("policy version %s: %v", era.version.Name, err)
+		}
+		training = append(training, p.Training...)
+		for _, i := range era.cases {
+			in := history[i].Input
+			predicted[i] = p.PredictQuery(in)
+			versions[i] = era.version.Name
+		}
+		err = p.Err()
+		p.Close()
+		if err != nil {
+			return nil, RescoreSummary{}, nil, fmt.Errorf("policy version %s: %v", era.version.Name, err)
+		}
+		names = append(names, era.version.Name)
+		if !slices.Contains(models, p.Version) {
+			models = append(models, p.Version)
+		}
+	}
+	rows, s := summarizeRescore(history, predicted, versions)
+	s.ModelVersion, s.PolicyVersion = strings.Join(models, ", "), strings.Join(names, ", ")
+	return rows, s, training, nil
+}
+
+// summarizeRescore compares each historical case's amount paid with its
+// prediction, rescored under versions[i] when versions is not nil. Amounts
+// are rounded to the cent before they are compared or totalled, so the
+// totals are those a ledger would show.
+func summarizeRescore(history TrainingData, predicted []float64, versions []string) ([]RescoredCase, RescoreSummary) {
+	s := RescoreSummary{Cases: len(history)}
+	rows := make([]RescoredCase, len(history))
+	var paidTotal, rescoredTotal, absTotal Cents
+	for i, c := range history {
+		paid := centsOf(c.ExpectedOutput)
+		rescored := centsOf(predicted[i])
+		delta := rescored - paid
+		rows[i] = RescoredCase{Case: i, Input: c.Input, Paid: paid.Dollars(), Rescored: rescored.Dollars(), Delta: delta.Dollars()}
+		if versions != nil {
+			rows[i].PolicyVersion = versions[i]
+		}
+		paidTotal += paid
+		rescoredTotal += rescored
+		switch {
+		case delta > 0:
+			s.Increases++
+			absTotal += delta
+			if s.LargestIncrease == nil || rows[i].Delta > s.LargestIncrease.Delta {
+				s.LargestIncrease = &rows[i]
+			}
+		case delta < 0:
+			s.Decreases++
+			absTotal -= delta
+			if s.LargestDecrease == nil || rows[i].Delta < s.LargestDecrease.Delta {
+				s.LargestDecrease = &rows[i]
+			}
+		default:
+			s.Unchanged++
+		}
+	}
+	s.PaidTotal, s.RescoredTotal = paidTotal.Dollars(), rescoredTotal.Dollars()
+	s.NetImpact = (rescoredTotal - paidTotal).Dollars()
+	if len(history) > 0 {
+		s.MeanAbsDelta = absTotal.Dollars() / float64(len(history))
+	}
+	return rows, s
+}
+
+// addRescoreNoise returns a copy of rows with noise added to each rescored
+// amount, and the delta changed to match, for export. The summary keeps the
+// exact amounts.
+func addRescoreNoise(rows []RescoredCase, noise *laplaceNoise) []RescoredCase {
+	rng := noise.rng(0)
+	noisy := make([]RescoredCase, len(rows))
+	for i, r := range rows {
+		rescored := centsOf(noise.add(rng, r.Rescored))
+		r.Rescored, r.Delta = rescored.Dollars(), (rescored - centsOf(r.Paid)).Dollars()
+		noisy[i] = r
+	}
+	return noisy
+}
+
+// writeRescoredCSV writes rows as CSV. The policy_version column is written
+// only when the cases were rescored by era.
+func writeRescoredCSV(w io.Writer, rows []RescoredCase) error {
+	byEra := len(rows) > 0 && rows[0].PolicyVersion != ""
+	cw := csv.NewWriter(w)
+	header := []string{"case", "trip_duration_days", "miles_traveled", "total_receipts_amount", "paid", "rescored", "delta"}
+	if byEra {
+		header = append(header, "policy_version")
+	}
+	cw.Write(header)
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(r.Case),
+			strconv.Itoa(r.Input.TripDurationDays),
+			strconv.FormatFloat(r.Input.MilesTraveled, 'f', -1, 64),
+			strconv.FormatFloat(r.Input.TotalReceiptsAmount, 'f', 2, 64),
+			centsOf(r.Paid).String(),
+			centsOf(r.Rescored).String(),
+			centsOf(r.Delta).String(),
+		}
+		if byEra {
+			record = append(record, r.PolicyVersion)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func printRescoreSummary(w io.Writer, s RescoreSummary) {
+	model := s.ModelVersion
+	if strings.Contains(s.PolicyVersion, ",") {
+		model += " under policy versions " + s.PolicyVersion + " by case date"
+	} else if s.PolicyVersion != "" {
+		model += " under policy version " + s.PolicyVersion
+	}
+	fmt.Fprintf(w, "Rescored %d cases with model %s\n", s.Cases, model)
+	fmt.Fprintf(w, "Paid:       %s\n", centsOf(s.PaidTotal))
+	fmt.Fprintf(w, "Rescored:   %s\n", centsOf(s.RescoredTotal))
+	fmt.Fprintf(w, "Net impact: %+.2f\n", s.NetImpact)
+	fmt.Fprintf(w, "%d increases, %d decreases, %d unchanged; mean absolute change %.2f\n",
+		s.Increases, s.Decreases, s.Unchanged, s.MeanAbsDelta)
+	for _, c := range []struct {
+		label string
+		r     *RescoredCase
+	}{{"Largest increase", s.LargestIncrease}, {"Largest decrease", s.LargestDecrease}} {
+		if c.r != nil {
+			fmt.Fprintf(w, "%s: case %d (%d days, %g miles, $%.2f receipts) paid %.2f, rescored %.2f (%+.2f)\n",
+				c.label, c.r.Case, c.r.Input.TripDurationDays, c.r.Input.MilesTraveled, c.r.Input.TotalReceiptsAmount,
+				c.r.Paid, c.r.Rescored, c.r.Delta)
+		}
+	}
+}
+
+func runRescore(args []string) error {
+	fs := flag.NewFlagSet("rescore", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	casesPath := fs.String("cases", "", "historical cases with the amounts paid as their expected output, a path or s3:// or gs:// URI (required)")
+	from := fs.String("from", "", "format of -cases: json, csv or xlsx (default from its extension)")
+	out := fs.String("out", "", "write each case's paid and rescored amounts as CSV to this path (default stdout)")
+	asJSON := fs.Bool("json", false, "print the summary as JSON")
+	var privacy privacyFlags
+	privacy.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := privacy.validate(); err != nil {
+		return err
+	}
+	if *casesPath == "" {
+		return fmt.Errorf("-cases is required")
+	}
+	inFormat, err := fileFormat(*from, *casesPath)
+	if err != nil {
+		return err
+	}
+	inPath, err := localPath(*casesPath)
+	if err != nil {
+		return err
+	}
+	m, err := parseColumnMapping(model.table.columns)
+	if err != nil {
+		return err
+	}
+	history, labelled, err := readCases(inPath, inFormat, m, model.table)
+	if err != nil {
+		return fmt.Errorf("loading cases: %v", err)
+	}
+	if !labelled {
+		return fmt.Errorf("%s has no amounts paid; rescoring needs each case's expected output", *casesPath)
+	}
+
+	// Unless one policy version was chosen, each case is rescored under the
+	// version of the config file in force when it was recorded.
+	var eras []*rescoreEra
+	if model.policyVersion == "" {
+		c, err := loadConfig(fs.Lookup("config").Value.String())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("loading config: %v", err)
+		}
+		if c != nil && len(c.PolicyVersions) > 0 {
+			if eras, err = splitEras(c, fs, model, history); err != nil {
+				return err
+			}
+		}
+	}
+	var rows []RescoredCase
+	var summary RescoreSummary
+	var training TrainingData
+	if eras != nil {
+		rows, summary, training, err = rescoreByEra(eras, history)
+	} else {
+		var predictor *Predictor
+		if predictor, err = model.build(); err != nil {
+			return err
+		}
+		defer predictor.Close()
+		rows, summary, err = rescore(predictor, history)
+		training = predictor.Training
+	}
+	if err != nil {
+		return err
+	}
+	if noise := privacy.noise(training); noise != nil {
+		fmt.Fprintln(os.Stderr, noise)
+		rows = addRescoreNoise(rows, noise)
+	}
+
+	// The summary goes to stderr when the CSV takes stdout.
+	report := io.Writer(os.Stdout)
+	if *out == "" {
+		report = os.Stderr
+		if err := writeRescoredCSV(os.Stdout, rows); err != nil {
+			return err
+		}
+	} else {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := writeRescoredCSV(file, rows); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		return writeJSON(report, summary)
+	}
+	printRescoreSummary(report, summary)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// resultsError is the line generate_results.sh writes for a case whose
+// prediction failed; eval counts it as a failed run.
+const resultsError = "ERROR"
+
+// generateResults predicts every case on up to jobs goroutines (every CPU
+// when jobs is 0) and returns one line per case in input order: the
+// prediction to the cent as run.sh prints it, or resultsError when it is
+// not a finite number. Each prediction is stored by its case's index, so
+// the order holds however the workers interleave. Predictions are perturbed
+// by noise unless it is nil, each case drawing from its own stream so a
+// fixed seed gives the same results with any number of workers.
+func generateResults(p *Predictor, cases TrainingData, jobs int, noise *laplaceNoise, prog *progress) []string {
+	lines := make([]string, len(cases))
+	forEach(len(cases), jobs, func(i int) {
+		in := cases[i].Input
+		y := p.PredictQuery(in)
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			lines[i] = resultsError
+		} else {
+			if noise != nil {
+				y = noise.add(noise.rng(uint64(i)), y)
+			}
+			lines[i] = strconv.FormatFloat(roundCents(y), 'f', 2, 64)
+		}
+		prog.add(1)
+	})
+	return lines
+}
+
+func runGenerateResults(args []string) error {
+	fs := flag.NewFlagSet("generate-results", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	in := fs.String("cases", "../private_cases.json", "cases to predict, a path or s3:// or gs:// URI")
+	from := fs.String("from", "", "format of -cases: json, csv or xlsx (default from its extension)")
+	out := fs.String("out", "../private_results.txt", "write the results to this path (- for stdout)")
+	jobs := fs.Int("jobs", 0, "prediction workers (0 uses every CPU)")
+	showProgress := fs.Bool("progress", true, "report progress on stderr")
+	var privacy privacyFlags
+	privacy.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := privacy.validate(); err != nil {
+		return err
+	}
+	inFormat, err := fileFormat(*from, *in)
+	if err != nil {
+		return err
+	}
+	inPath, err := localPath(*in)
+	if err != nil {
+		return err
+	}
+	m, err := parseColumnMapping(model.table.columns)
+	if err != nil {
+		return err
+	}
+	cases, _, err := readCases(inPath, inFormat, m, model.table)
+	if err != nil {
+		return fmt.Errorf("loading cases: %v", err)
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer predictor.Close()
+	noise := privacy.noise(predictor.Training)
+	if noise != nil {
+		fmt.Fprintln(os.Stderr, noise)
+	}
+	var prog *progress
+	if *showProgress {
+		prog = startProgress(os.Stderr, "generate-results", len(cases), 5*time.Second)
+	}
+	start := time.Now()
+	lines := generateResults(predictor, cases, *jobs, noise, prog)
+	prog.stop()
+	if err := predictor.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, line := range lines {
+		if line == resultsError {
+			fmt.Fprintf(os.Stderr, "Error on case %d: prediction is not a finite number\n", i+1)
+			failed++
+		}
+	}
+	file := os.Stdout
+	if *out != "-" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	w := bufio.NewWriter(file)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if *out != "-" {
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %d results (%d failed) to %s in %s\n",
+			len(lines), failed, *out, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RoutingConfig sends each query to the model of the first route whose
+// condition holds, or to Default when none does. Conditions are expressions
+// (see expr) over days, miles and receipts, as in
+//
+//	{"routes": [{"when": "days >= 10", "model": "tree"},
+//	            {"when": "receipts > 1800", "model": "linear"}],
+//	 "default": "knn"}
+//
+// Each route's model is fitted on the training cases routed to it, so it
+// learns only its regime of the policy.
+type RoutingConfig struct {
+	Routes  []Route `json:"routes"`
+	Default string  `json:"default,omitempty"` // model of unmatched queries; knn when empty
+}
+
+// Route is one condition and the model answering the queries it matches.
+type Route struct {
+	When  string `json:"when"`
+	Model string `json:"model"`
+}
+
+// routeVars are the variables of route conditions.
+var routeVars = []string{"days", "miles", "receipts"}
+
+// defaultRoute names the default route in explanations.
+const defaultRoute = "default"
+
+func (c *RoutingConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if _, err := compileRoutes(c.Routes); err != nil {
+		return err
+	}
+	for i, r := range c.Routes {
+		if err := validateModel(r.Model); err != nil {
+			return fmt.Errorf("route %d: %v", i+1, err)
+		}
+	}
+	return validateModel(c.Default)
+}
+
+func compileRoutes(routes []Route) ([]*expr, error) {
+	conds := make([]*expr, len(routes))
+	for i, r := range routes {
+		cond, err := parseExpr(r.When, routeVars)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		conds[i] = cond
+	}
+	return conds, nil
+}
+
+// loadRouting reads a routing config from path.
+func loadRouting(path string) (*RoutingConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c RoutingConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing routing %s: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// routedModel answers each query with the model of its route.
+type routedModel struct {
+	hp     Hyperparameters
+	conds  []*expr
+	models []Model // indexed like the routes, then the default
+}
+
+func newRoutedModel(hp Hyperparameters) *routedModel {
+	conds, _ := compileRoutes(hp.Routing.Routes)
+	return &routedModel{hp: hp, conds: conds}
+}
+
+// route returns the index of the route of q, len(conds) for the default.
+func (r *routedModel) route(q Query) int {
+	v := q.features()
+	for i, cond := range r.conds {
+		if cond.eval(v[:]) != 0 {
+			return i
+		}
+	}
+	return len(r.conds)
+}
+
+// name returns the model and condition of route i.
+func (r *routedModel) name(i int) (model, when string) {
+	if i == len(r.conds) {
+		model, when = r.hp.Routing.Default, defaultRoute
+	} else {
+		model, when = r.hp.Routing.Routes[i].Model, r.hp.Routing.Routes[i].When
+	}
+	if model == "" {
+		model = modelKNN
+	}
+	return model, when
+}
+
+// Fit fits each route's model on the cases routed to it, or on all of
+// training when no case is.
+func (r *routedModel) Fit(training TrainingData) {
+	routed := make([]TrainingData, len(r.conds)+1)
+	for _, c := range training {
+		i := r.route(c.Input)
+		routed[i] = append(routed[i], c)
+	}
+	r.models = make([]Model, len(routed))
+	for i, cases := range routed {
+		hp := r.hp
+		hp.Routing, hp.Overrides = nil, nil // overrides belong to the predictor wrapping the model
+		hp.Model, _ = r.name(i)
+		if len(cases) == 0 {
+			cases = training
+		}
+		r.models[i] = newModel(hp)
+		r.models[i].Fit(cases)
+	}
+}
+
+func (r *routedModel) Predict(q Query) float64 {
+	return r.models[r.route(q)].Predict(q)
+}
+
+func (r *routedModel) Explain(q Query) ModelExplanation {
+	i := r.route(q)
+	e := r.models[i].Explain(q)
+	model, when := r.name(i)
+	e.Model = model
+	e.Steps = append([]string{"route " + when}, e.Steps...)
+	return e
+}
+
+// Err returns the first failure of a route's model.
+func (r *routedModel) Err() error {
+	for _, m := range r.models {
+		if m, ok := m.(interface{ Err() error }); ok {
+			if err := m.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *routedModel) Close() error {
+	var first error
+	for _, m := range r.models {
+		if c, ok := m.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+)
+
+// RateTier reimburses the part of an amount above From, up to the next
+// tier's From, at Rate per unit.
+type RateTier struct {
+	From float64 `json:"from"`
+	Rate float64 `json:"rate"`
+}
+
+// BonusBand adds Amount to trips whose miles per day are at least From and
+// below the next band's From.
+type BonusBand struct {
+	From   float64 `json:"from"`
+	Amount float64 `json:"amount"`
+}
+
+// PerDiemBand is the per diem of trips of at least FromDays days, up to the
+// next band.
+type PerDiemBand struct {
+	FromDays int     `json:"from_days"`
+	PerDiem  float64 `json:"per_diem"`
+}
+
+// RuleConfig is a reimbursement formula in the shape of a travel policy: a
+// base amount, a per diem, tiered rates for miles and receipts, a bonus for
+// efficient trips by miles per day, and bonuses for trips of exactly some
+// numbers of days.
+type RuleConfig struct {
+	Base       float64     `json:"base"`
+	PerDiem    float64     `json:"per_diem"`
+	Mileage    []RateTier  `json:"mileage"`
+	Receipts   []RateTier  `json:"receipts"`
+	Efficiency []BonusBand `json:"efficiency,omitempty"`
+	// PerDiemBands, when set, replace PerDiem with a per diem for each band
+	// of trip lengths.
+	PerDiemBands []PerDiemBand `json:"per_diem_bands,omitempty"`
+	// DayBonus is added to trips of exactly its key's days. Fit fits the
+	// days it names, or defaultBonusDays when it is nil; an empty map fits
+	// none.
+	DayBonus map[int]float64 `json:"day_bonus,omitempty"`
+}
+
+// Tier boundaries the rule model fits rates for, after the interviews: full
+// mileage rate for the first 100 miles, and diminishing receipt
+// reimbursement on large totals.
+var (
+	defaultMileageTiers = []float64{0, 100, 500}
+	defaultReceiptTiers = []float64{0, 600, 1200}
+)
+
+// defaultEfficiencyBands are the miles-per-day bands the rule model fits
+// bonuses for, around the 180 to 220 sweet spot the interviews describe.
+var defaultEfficiencyBands = []float64{0, 100, 180, 220, 300}
+
+// defaultBonusDays are the trip lengths the rule model fits a bonus for: the
+// interviews agree 5-day trips get one.
+var defaultBonusDays = []int{5}
+
+// efficiencyBand returns the index of the band holding mpd miles per day.
+func efficiencyBand(mpd float64, bands []float64) int {
+	i := 0
+	for i+1 < len(bands) && mpd >= bands[i+1] {
+		i++
+	}
+	return i
+}
+
+// perDiemBand returns the index of the band of days among bands starting at
+// the ascending day counts bounds; days below the first band fall in it.
+func perDiemBand(days int, bounds []int) int {
+	i := 0
+	for i+1 < len(bounds) && days >= bounds[i+1] {
+		i++
+	}
+	return i
+}
+
+// milesPerDay is the efficiency of a trip, as the miles_per_day feature.
+func milesPerDay(q Query) float64 {
+	return q.MilesTraveled / math.Max(float64(q.TripDurationDays), 1)
+}
+
+// tierAmounts returns the part of x that falls in each tier starting at
+// bounds.
+func tierAmounts(x float64, bounds []float64) []float64 {
+	out := make([]float64, len(bounds))
+	for i, from := range bounds {
+		to := math.Inf(1)
+		if i+1 < len(bounds) {
+			to = bounds[i+1]
+		}
+		out[i] = math.Max(math.Min(x, to)-from, 0)
+	}
+	return out
+}
+
+func tierBounds(tiers []RateTier) []float64 {
+	bounds := make([]float64, len(tiers))
+	for i, t := range tiers {
+		bounds[i] = t.From
+	}
+	return bounds
+}
+
+// loadRuleConfig reads a rule model config from path, checking that its
+// tiers and bands ascend.
+func loadRuleConfig(path string) (*RuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c RuleConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing rule config %s: %v", path, err)
+	}
+	ascending := func(name string, bounds []float64) error {
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] <= bounds[i-1] {
+				return fmt.Errorf("%s: %s tiers must ascend", path, name)
+			}
+		}
+		return nil
+	}
+	efficiency := make([]float64, len(c.Efficiency))
+	for i, b := range c.Efficiency {
+		efficiency[i] = b.From
+	}
+	perDiem := make([]float64, len(c.PerDiemBands))
+	for i, b := range c.PerDiemBands {
+		perDiem[i] = float64(b.FromDays)
+	}
+	for name, bounds := range map[string][]float64{"mileage": tierBounds(c.Mileage), "receipts": tierBounds(c.Receipts),
+		"efficiency": efficiency, "per diem": perDiem} {
+		if err := ascending(name, bounds); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// ruleModel applies a RuleConfig whose amounts are fitted by least squares.
+type ruleModel struct {
+	Config RuleConfig
+}
+
+// newRuleModel returns a rule model with the tiers and bands of hp.Rule, or
+// the defaults.
+func newRuleModel(hp Hyperparameters) Model {
+	m := &ruleModel{}
+	if hp.Rule != nil {
+		m.Config = *hp.Rule
+	}
+	return m
+}
+
+// Fit keeps the tier boundaries and refits every amount and rate.
+func (r *ruleModel) Fit(training TrainingData) {
+	mileage, receipts := defaultMileageTiers, defaultReceiptTiers
+	if len(r.Config.Mileage) > 0 {
+		mileage = tierBounds(r.Config.Mileage)
+	}
+	if len(r.Config.Receipts) > 0 {
+		receipts = tierBounds(r.Config.Receipts)
+	}
+	efficiency := defaultEfficiencyBands
+	if len(r.Config.Efficiency) > 0 {
+		efficiency = make([]float64, len(r.Config.Efficiency))
+		for i, b := range r.Config.Efficiency {
+			efficiency[i] = b.From
+		}
+	}
+	bonusDays := defaultBonusDays
+	if r.Config.DayBonus != nil {
+		bonusDays = slices.Sorted(maps.Keys(r.Config.DayBonus))
+	}
+	perDiemBands := make([]int, len(r.Config.PerDiemBands))
+	for i, b := range r.Config.PerDiemBands {
+		perDiemBands[i] = b.FromDays
+	}
+	// Columns: the base, the per diem or one per band, the mileage and
+	// receipt tiers, the efficiency bands but the first, which is the
+	// baseline the base already covers, and the day bonuses.
+	perDiem := 1 + max(len(perDiemBands), 1)
+	tiers := perDiem + len(mileage) + len(receipts)
+	bonuses := tiers + len(efficiency) - 1
+	eq := newNormalEquations(bonuses + len(bonusDays))
+	for _, c := range training {
+		x := []float64{1}
+		days := float64(c.Input.TripDurationDays)
+		if len(perDiemBands) == 0 {
+			x = append(x, days)
+		} else {
+			band := make([]float64, len(perDiemBands))
+			band[perDiemBand(c.Input.TripDurationDays, perDiemBands)] = days
+			x = append(x, band...)
+		}
+		x = append(x, tierAmounts(c.Input.MilesTraveled, mileage)...)
+		x = append(x, tierAmounts(c.Input.TotalReceiptsAmount, receipts)...)
+		bonus := make([]float64, len(efficiency)-1)
+		if i := efficiencyBand(milesPerDay(c.Input), efficiency); i > 0 {
+			bonus[i-1] = 1
+		}
+		x = append(x, bonus...)
+		for _, d := range bonusDays {
+			x = append(x, exprBool(c.Input.TripDurationDays == d))
+		}
+		eq.add(x, c.ExpectedOutput, 1)
+	}
+	beta := eq.solve()
+	cfg := RuleConfig{Base: beta[0]}
+	if len(perDiemBands) == 0 {
+		cfg.PerDiem = beta[1]
+	}
+	for i, from := range perDiemBands {
+		cfg.PerDiemBands = append(cfg.PerDiemBands, PerDiemBand{FromDays: from, PerDiem: beta[1+i]})
+	}
+	for i, from := range mileage {
+		cfg.Mileage = append(cfg.Mileage, RateTier{from, beta[perDiem+i]})
+	}
+	for i, from := range receipts {
+		cfg.Receipts = append(cfg.Receipts, RateTier{from, beta[perDiem+len(mileage)+i]})
+	}
+	for i, from := range efficiency {
+		band := BonusBand{From: from}
+		if i > 0 {
+			band.Amount = beta[tiers+i-1]
+		}
+		cfg.Efficiency = append(cfg.Efficiency, band)
+	}
+	cfg.DayBonus = make(map[int]float64, len(bonusDays))
+	for i, d := range bonusDays {
+		cfg.DayBonus[d] = beta[bonuses+i]
+	}
+	r.Config = cfg
+}
+
+func (r *ruleModel) Predict(q Query) float64 {
+	return r.Explain(q).Prediction
+}
+
+func (r *ruleModel) Explain(q Query) ModelExplanation {
+	cfg := r.Config
+	perDiem, band := cfg.PerDiem, ""
+	if len(cfg.PerDiemBands) > 0 {
+		bounds := make([]int, len(cfg.PerDiemBands))
+		for i, b := range cfg.PerDiemBands {
+			bounds[i] = b.FromDays
+		}
+		b := cfg.PerDiemBands[perDiemBand(q.TripDurationDays, bounds)]
+		perDiem, band = b.PerDiem, fmt.Sprintf(" (band from %d days)", b.FromDays)
+	}
+	total := cfg.Base + perDiem*float64(q.TripDurationDays)
+	steps := []string{
+		fmt.Sprintf("base: %+.2f", cfg.Base),
+		fmt.Sprintf("per diem%s: %d days × %.2f = %+.2f", band, q.TripDurationDays, perDiem, perDiem*float64(q.TripDurationDays)),
+	}
+	tiered := func(label string, x float64, tiers []RateTier) {
+		for i, amount := range tierAmounts(x, tierBounds(tiers)) {
+			if amount > 0 {
+				v := amount * tiers[i].Rate
+				total += v
+				steps = append(steps, fmt.Sprintf("%s above %g: %g × %.4f = %+.2f", label, tiers[i].From, amount, tiers[i].Rate, v))
+			}
+		}
+	}
+	tiered("miles", q.MilesTraveled, cfg.Mileage)
+	tiered("receipts", q.TotalReceiptsAmount, cfg.Receipts)
+	if len(cfg.Efficiency) > 0 {
+		mpd := milesPerDay(q)
+		bounds := make([]float64, len(cfg.Efficiency))
+		for i, b := range cfg.Efficiency {
+			bounds[i] = b.From
+		}
+		b := cfg.Efficiency[efficiencyBand(mpd, bounds)]
+		total += b.Amount
+		steps = append(steps, fmt.Sprintf("efficiency %.1f miles/day, band from %g: %+.2f", mpd, b.From, b.Amount))
+	}
+	if bonus, ok := cfg.DayBonus[q.TripDurationDays]; ok {
+		total += bonus
+		steps = append(steps, fmt.Sprintf("%d-day trip bonus: %+.2f", q.TripDurationDays, bonus))
+	}
+	return ModelExplanation{Model: modelRule, Prediction: total, Steps: steps}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// leafStats are the training cases reaching a leaf and their mean absolute
+// error against its value.
+type leafStats struct {
+	cases  int
+	absErr float64
+}
+
+// treeLeafStats routes training through the tree and returns each leaf's
+// statistics and the overall mean absolute error.
+func treeLeafStats(root *treeNode, training TrainingData) (map[*treeNode]*leafStats, float64) {
+	stats := map[*treeNode]*leafStats{}
+	total := 0.0
+	for _, c := range training {
+		n := root
+		v := caseFeatures(c)
+		for !n.isLeaf() {
+			if v[n.Feature] <= n.Threshold {
+				n = n.Left
+			} else {
+				n = n.Right
+			}
+		}
+		s := stats[n]
+		if s == nil {
+			s = &leafStats{}
+			stats[n] = s
+		}
+		e := math.Abs(c.ExpectedOutput - n.Value)
+		s.cases++
+		s.absErr += e
+		total += e
+	}
+	if len(training) == 0 {
+		return stats, 0
+	}
+	return stats, total / float64(len(training))
+}
+
+// ruleCondition renders a split readably: days are whole and receipts are
+// in cents, so a threshold between two values reads as a bound on the lower.
+func ruleCondition(feature int, threshold float64) string {
+	switch featureNames[feature] {
+	case "days":
+		return fmt.Sprintf("days <= %d", int(math.Floor(threshold)))
+	case "receipts":
+		return fmt.Sprintf("receipts <= $%.2f", math.Floor(threshold*100)/100)
+	}
+	return fmt.Sprintf("%s <= %.6g", featureNames[feature], threshold)
+}
+
+// writeRules prints the tree as nested if/else rules, each leaf with its
+// expected output, the cases supporting it and their mean error.
+func writeRules(w io.Writer, root *treeNode, stats map[*treeNode]*leafStats) {
+	var walk func(n *treeNode, depth int)
+	walk = func(n *treeNode, depth int) {
+		indent := strings.Repeat("    ", depth)
+		if n.isLeaf() {
+			s := stats[n]
+			if s == nil {
+				s = &leafStats{}
+			}
+			fmt.Fprintf(w, "%sreimburse $%.2f  (%d cases, mean error $%.2f)\n", indent, n.Value, s.cases, s.absErr/math.Max(float64(s.cases), 1))
+			return
+		}
+		fmt.Fprintf(w, "%sif %s:\n", indent, ruleCondition(n.Feature, n.Threshold))
+		walk(n.Left, depth+1)
+		fmt.Fprintf(w, "%selse:\n", indent)
+		walk(n.Right, depth+1)
+	}
+	walk(root, 0)
+}
+
+func runExtractRules(args []string) error {
+	fs := flag.NewFlagSet("extract-rules", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	depth := fs.Int("depth", 3, "maximum depth of the rules; deeper rules fit better but read worse")
+	minLeaf := fs.Int("min-leaf", 30, "minimum cases supporting each rule")
+	asJSON := fs.Bool("json", false, "write the rules as a segmentation config, usable with -segments")
+	out := fs.String("out", "", "write the rules to this path (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *depth < 1 || *minLeaf < 1 {
+		return fmt.Errorf("-depth and -min-leaf must be at least 1")
+	}
+
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if len(trainingData) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+	root := fitRegressionTree(trainingData, treeParams{MaxDepth: *depth, MinLeaf: *minLeaf})
+	if *asJSON {
+		return writeJSONFile(*out, segmentationFromTree(root, fmt.Sprintf("regression-tree depth=%d min-leaf=%d", *depth, *minLeaf)))
+	}
+
+	w := io.Writer(os.Stdout)
+	var file *os.File
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		w = file
+	}
+	bw := bufio.NewWriter(w)
+	stats, mae := treeLeafStats(root, trainingData)
+	fmt.Fprintf(bw, "# Rules of a regression tree of depth %d with at least %d cases per rule, fit on %d cases.\n", *depth, *minLeaf, len(trainingData))
+	fmt.Fprintf(bw, "# Each rule reimburses the mean output of its cases; overall mean error $%.2f.\n", mae)
+	writeRules(bw, root, stats)
+	err = bw.Flush()
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+)
+
+// Sampling methods for bounding the number of training cases.
+const (
+	sampleReservoir  = "reservoir"
+	sampleStratified = "stratified"
+)
+
+// SampleConfig bounds a model's training data to at most MaxCases cases,
+// drawn from the data file with Method and Seed.
+type SampleConfig struct {
+	MaxCases int    `json:"max_cases"`
+	Method   string `json:"method"`
+	Seed     uint64 `json:"seed"`
+}
+
+func (s *SampleConfig) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.MaxCases < 1 {
+		return fmt.Errorf("max cases must be at least 1")
+	}
+	if s.Method != sampleReservoir && s.Method != sampleStratified {
+		return fmt.Errorf("unknown sampling method %q (want %s or %s)", s.Method, sampleReservoir, sampleStratified)
+	}
+	return nil
+}
+
+// indexedCase is a sampled case and its position in the data file, so that
+// samples keep file order.
+type indexedCase struct {
+	index int
+	c     TestCase
+}
+
+// reservoir keeps a uniform random sample of up to size of the cases added
+// to it (Vitter's algorithm R) in O(size) memory.
+type reservoir struct {
+	size  int
+	seen  int
+	cases []indexedCase
+}
+
+func (r *reservoir) add(rng *rand.Rand, ic indexedCase) {
+	r.seen++
+	if len(r.cases) < r.size {
+		r.cases = append(r.cases, ic)
+	} else if j := rng.IntN(r.seen); j < r.size {
+		r.cases[j] = ic
+	}
+}
+
+// caseSampler draws a sample from a stream of cases. Stratified sampling
+// keeps one reservoir per trip length, so every trip length is represented
+// in proportion to its share of the data, at the cost of holding up to
+// MaxCases cases per trip length while streaming.
+type caseSampler struct {
+	cfg    SampleConfig
+	rng    *rand.Rand
+	n      int
+	all    reservoir
+	strata map[int]*reservoir
+}
+
+func newCaseSampler(cfg SampleConfig) *caseSampler {
+	return &caseSampler{
+		cfg:    cfg,
+		rng:    rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		all:    reservoir{size: cfg.MaxCases},
+		strata: map[int]*reservoir{},
+	}
+}
+
+func (s *caseSampler) add(c TestCase) {
+	ic := indexedCase{s.n, c}
+	s.n++
+	if s.cfg.Method != sampleStratified {
+		s.all.add(s.rng, ic)
+		return
+	}
+	r := s.strata[c.Input.TripDurationDays]
+	if r == nil {
+		r = &reservoir{size: s.cfg.MaxCases}
+		s.strata[c.Input.TripDurationDays] = r
+	}
+	r.add(s.rng, ic)
+}
+
+// sample returns the sampled cases in file order.
+func (s *caseSampler) sample() TrainingData {
+	picked := s.all.cases
+	if s.cfg.Method == sampleStratified {
+		picked = s.stratifiedQuotas()
+	}
+	slices.SortFunc(picked, func(a, b indexedCase) int { return a.index - b.index })
+	out := make(TrainingData, len(picked))
+	for i, ic := range picked {
+		out[i] = ic.c
+	}
+	return out
+}
+
+// stratifiedQuotas cuts each stratum's reservoir down to its share of
+// MaxCases, handing leftover slots to the largest remainders.
+func (s *caseSampler) stratifiedQuotas() []indexedCase {
+	if s.n <= s.cfg.MaxCases {
+		var all []indexedCase
+		for _, r := range s.strata {
+			all = append(all, r.cases...)
+		}
+		return all
+	}
+
+	keys := make([]int, 0, len(s.strata))
+	for k := range s.strata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	quota := make(map[int]int, len(keys))
+	remainder := make(map[int]float64, len(keys))
+	left := s.cfg.MaxCases
+	for _, k := range keys {
+		exact := float64(s.cfg.MaxCases) * float64(s.strata[k].seen) / float64(s.n)
+		quota[k] = int(exact)
+		remainder[k] = exact - float64(quota[k])
+		left -= quota[k]
+	}
+	byRemainder := slices.Clone(keys)
+	slices.SortStableFunc(byRemainder, func(a, b int) int { return compareDist(remainder[b], remainder[a]) })
+	for _, k := range byRemainder[:left] {
+		quota[k]++
+	}
+
+	var picked []indexedCase
+	for _, k := range keys {
+		cases := s.strata[k].cases
+		s.rng.Shuffle(len(cases), func(i, j int) { cases[i], cases[j] = cases[j], cases[i] })
+		picked = append(picked, cases[:min(quota[k], len(cases))]...)
+	}
+	return picked
+}
+
+// loadSampledTrainingFile loads training data like loadTrainingFile,
+// sampling it down to cfg when cfg is not nil. JSON files are sampled while
+// streaming, so the full data set is never held in memory.
+func loadSampledTrainingFile(path string, mapPacked bool, cfg *SampleConfig) (TrainingData, error) {
+	if cfg == nil {
+		return loadTrainingFile(path, mapPacked)
+	}
+	path, err := localPath(path)
+	if err != nil {
+		return nil, err
+	}
+	s := newCaseSampler(*cfg)
+	packed, err := isPacked(path)
+	if err != nil {
+		return nil, err
+	}
+	if packed {
+		// Mapped in place, the full data costs page cache rather than heap.
+		data, err := loadPacked(path, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range data {
+			s.add(c)
+		}
+	} else if err := streamJSONCases(path, s.add); err != nil {
+		return nil, err
+	}
+	data := s.sample()
+	if err := checkNotEmpty(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// sampleFlags are the model flags bounding the training data size.
+type sampleFlags struct {
+	maxCases int
+	method   string
+	seed     uint64
+}
+
+func (f *sampleFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.maxCases, "max-cases", 0, "train on a sample of at most this many cases (0 uses all)")
+	fs.StringVar(&f.method, "sample", sampleReservoir, "sampling method for -max-cases: reservoir or stratified (by trip length)")
+	fs.Uint64Var(&f.seed, "sample-seed", 1, "random seed for -max-cases sampling")
+}
+
+// config returns the sampling configuration, nil when sampling is off.
+func (f *sampleFlags) config() (*SampleConfig, error) {
+	if f.maxCases == 0 {
+		return nil, nil
+	}
+	c := &SampleConfig{MaxCases: f.maxCases, Method: f.method, Seed: f.seed}
+	return c, c.validate()
+}
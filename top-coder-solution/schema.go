@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// caseSchemaJSON is the JSON Schema every case of a JSON training data file
+// must satisfy.
+//
+//go:embed cases.schema.json
+var caseSchemaJSON []byte
+
+// jsonSchema is the subset of JSON Schema the case schema uses: a type,
+// required properties and nested property schemas. Properties the schema
+// does not mention are allowed.
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+}
+
+var caseSchema = mustParseSchema(caseSchemaJSON)
+
+func mustParseSchema(data []byte) *jsonSchema {
+	var s jsonSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		panic(fmt.Sprintf("parsing embedded schema: %v", err))
+	}
+	return &s
+}
+
+// schemaError locates a value that does not satisfy a schema.
+type schemaError struct {
+	Path string // dotted property path, empty for the whole value
+	Msg  string
+}
+
+func (e *schemaError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// validateJSON checks raw against s, reporting the first violation found.
+func (s *jsonSchema) validateJSON(raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // keep integers distinguishable from other numbers
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	return s.validate(v, "")
+}
+
+func (s *jsonSchema) validate(v any, path string) error {
+	if s.Type != "" && !hasJSONType(v, s.Type) {
+		return &schemaError{path, fmt.Sprintf("expected %s, got %s", s.Type, describeJSON(v))}
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return &schemaError{joinPath(path, name), "missing required field"}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+		if child, ok := obj[name]; ok {
+			if err := s.Properties[name].validate(child, joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// hasJSONType reports whether a value decoded with UseNumber is of the named
+// JSON Schema type. An integer is a number the decoder can store in an int.
+func hasJSONType(v any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := strconv.ParseInt(string(n), 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// describeJSON names the JSON type of v for error messages, quoting short
+// strings so that values like "12.50" are easy to spot.
+func describeJSON(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case bool:
+		return "boolean"
+	case string:
+		if len(v) <= 20 {
+			return fmt.Sprintf("string %q", v)
+		}
+		return "string"
+	case json.Number:
+		return "number " + string(v)
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Scorer is a custom score eval reports alongside its own metrics, from a
+// config file such as
+//
+//	[{"name": "finance", "expr": "error > 0 ? error * 3 : -error"},
+//	 {"name": "penalty", "expr": "abs_error >= 0.01 ? 0.1 : 0", "aggregate": "sum"},
+//	 {"name": "competition", "exec": "python3 score.py"}]
+//
+// An expression scorer evaluates Expr (see expr) for each case, over days,
+// miles, receipts, expected, predicted, error (predicted less expected) and
+// abs_error, and aggregates the values by their mean, sum, min or max. An
+// exec scorer runs Exec, writes the results to its stdin as one line of
+// JSON,
+//
+//	{"results":[{"input":{…},"expected_output":1234.56,"predicted":1230.00}, …]}
+//
+// and reads the score from its stdout as {"score":12.34}, or
+// {"error":"message"}.
+type Scorer struct {
+	Name      string `json:"name"`
+	Expr      string `json:"expr,omitempty"`
+	Aggregate string `json:"aggregate,omitempty"` // mean when empty
+	Exec      string `json:"exec,omitempty"`
+}
+
+// scorerVars are the variables of scorer expressions.
+var scorerVars = []string{"days", "miles", "receipts", "expected", "predicted", "error", "abs_error"}
+
+// Scorer aggregates.
+const (
+	scoreMean = "mean"
+	scoreSum  = "sum"
+	scoreMin  = "min"
+	scoreMax  = "max"
+)
+
+// ScoreResult is one scorer's score of an eval run.
+type ScoreResult struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// scorer is a compiled Scorer.
+type scorer struct {
+	Scorer
+	expr *expr
+}
+
+func loadScorers(path string) ([]scorer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config []Scorer
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing scorers %s: %v", path, err)
+	}
+	scorers := make([]scorer, len(config))
+	for i, s := range config {
+		if s.Name == "" {
+			return nil, fmt.Errorf("scorer %d has no name", i+1)
+		}
+		if (s.Expr == "") == (s.Exec == "") {
+			return nil, fmt.Errorf("scorer %s: give exactly one of expr and exec", s.Name)
+		}
+		scorers[i].Scorer = s
+		if s.Exec != "" {
+			if s.Aggregate != "" {
+				return nil, fmt.Errorf("scorer %s: an exec scorer aggregates for itself", s.Name)
+			}
+			continue
+		}
+		switch s.Aggregate {
+		case "", scoreMean, scoreSum, scoreMin, scoreMax:
+		default:
+			return nil, fmt.Errorf("scorer %s: unknown aggregate %q (want mean, sum, min or max)", s.Name, s.Aggregate)
+		}
+		if scorers[i].expr, err = parseExpr(s.Expr, scorerVars); err != nil {
+			return nil, fmt.Errorf("scorer %s: %v", s.Name, err)
+		}
+	}
+	return scorers, nil
+}
+
+// score scores the results.
+func (s scorer) score(results []EvalResult) (float64, error) {
+	if s.expr == nil {
+		return s.execScore(results)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	var total float64
+	switch s.Aggregate {
+	case scoreMin:
+		total = math.Inf(1)
+	case scoreMax:
+		total = math.Inf(-1)
+	}
+	for _, r := range results {
+		in := r.Case.Input
+		v := s.expr.eval([]float64{float64(in.TripDurationDays), in.MilesTraveled, in.TotalReceiptsAmount,
+			r.Case.ExpectedOutput, r.Predicted, r.Residual(), r.AbsError()})
+		switch s.Aggregate {
+		case scoreMin:
+			total = min(total, v)
+		case scoreMax:
+			total = max(total, v)
+		default:
+			total += v
+		}
+	}
+	if s.Aggregate == "" || s.Aggregate == scoreMean {
+		total /= float64(len(results))
+	}
+	return total, nil
+}
+
+type scorerResult struct {
+	Input          Query   `json:"input"`
+	ExpectedOutput float64 `json:"expected_output"`
+	Predicted      float64 `json:"predicted"`
+}
+
+type scorerResponse struct {
+	Score *float64 `json:"score"`
+	Error string   `json:"error,omitempty"`
+}
+
+// execScore runs the scorer's program on the results.
+func (s scorer) execScore(results []EvalResult) (float64, error) {
+	proc, err := startExecProcess(s.Exec)
+	if err != nil {
+		return 0, err
+	}
+	defer proc.stop()
+	req := struct {
+		Results []scorerResult `json:"results"`
+	}{make([]scorerResult, len(results))}
+	for i, r := range results {
+		req.Results[i] = scorerResult{r.Case.Input, r.Case.ExpectedOutput, r.Predicted}
+	}
+	if err := proc.enc.Encode(req); err != nil {
+		return 0, fmt.Errorf("sending results: %v", err)
+	}
+	var resp scorerResponse
+	if err := proc.dec.Decode(&resp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, fmt.Errorf("reading score: %v", err)
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	if resp.Score == nil {
+		return 0, fmt.Errorf("response has no score")
+	}
+	return *resp.Score, nil
+}
+
+// runScorers scores the results with each scorer in turn.
+func runScorers(scorers []scorer, results []EvalResult) ([]ScoreResult, error) {
+	out := make([]ScoreResult, len(scorers))
+	for i, s := range scorers {
+		v, err := s.score(results)
+		if err != nil {
+			return nil, fmt.Errorf("scorer %s: %v", s.Name, err)
+		}
+		out[i] = ScoreResult{s.Name, v}
+	}
+	return out, nil
+}
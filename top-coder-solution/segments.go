@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Condition is a comparison of one input feature against a constant.
+type Condition struct {
+	Feature string  `json:"feature"` // days, miles or receipts
+	Op      string  `json:"op"`      // <=, <, >, >=, ==
+	Value   float64 `json:"value"`
+}
+
+// featureIndex returns the featureVector index for a feature name.
+func featureIndex(name string) (int, bool) {
+	for i, n := range featureNames {
+		if n == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (c Condition) validate() error {
+	if _, ok := featureIndex(c.Feature); !ok {
+		return fmt.Errorf("unknown feature %q", c.Feature)
+	}
+	switch c.Op {
+	case "<=", "<", ">", ">=", "==":
+		return nil
+	}
+	return fmt.Errorf("unknown operator %q", c.Op)
+}
+
+// matches reports whether v satisfies the condition. Conditions must have
+// been validated.
+func (c Condition) matches(v featureVector) bool {
+	i, _ := featureIndex(c.Feature)
+	x := v[i]
+	switch c.Op {
+	case "<=":
+		return x <= c.Value
+	case "<":
+		return x < c.Value
+	case ">":
+		return x > c.Value
+	case ">=":
+		return x >= c.Value
+	case "==":
+		return x == c.Value
+	}
+	return false
+}
+
+func (c Condition) String() string {
+	return c.Feature + " " + c.Op + " " + strconv.FormatFloat(c.Value, 'f', -1, 64)
+}
+
+// SegmentRule is a region of input space defined by a conjunction of
+// conditions.
+type SegmentRule struct {
+	Name       string      `json:"name"`
+	Conditions []Condition `json:"conditions"`
+	Count      int         `json:"count,omitempty"`
+	MeanOutput float64     `json:"mean_output,omitempty"`
+}
+
+func (s SegmentRule) matches(v featureVector) bool {
+	for _, c := range s.Conditions {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Segmentation partitions input space into segments. Predictors restrict
+// neighbor search to training cases in the query's segment.
+type Segmentation struct {
+	Source     string               `json:"source"`
+	Thresholds map[string][]float64 `json:"thresholds"`
+	Segments   []SegmentRule        `json:"segments"`
+}
+
+// segmentOf returns the index of the first segment matching v, or -1.
+func (s *Segmentation) segmentOf(v featureVector) int {
+	for i, seg := range s.Segments {
+		if seg.matches(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func loadSegmentation(path string) (*Segmentation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Segmentation
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing segmentation %s: %v", path, err)
+	}
+	for i, seg := range s.Segments {
+		for _, c := range seg.Conditions {
+			if err := c.validate(); err != nil {
+				return nil, fmt.Errorf("segment %d (%s): %v", i, seg.Name, err)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// segmentationFromTree turns each leaf of a tree into a segment whose
+// conditions are the splits along the path to that leaf.
+func segmentationFromTree(root *treeNode, source string) *Segmentation {
+	s := &Segmentation{Source: source, Thresholds: map[string][]float64{}}
+
+	var walk func(n *treeNode, path []Condition)
+	walk = func(n *treeNode, path []Condition) {
+		if n.isLeaf() {
+			conds := simplifyConditions(path)
+			names := make([]string, len(conds))
+			for i, c := range conds {
+				names[i] = c.String()
+			}
+			name := strings.Join(names, " & ")
+			if name == "" {
+				name = "all"
+			}
+			s.Segments = append(s.Segments, SegmentRule{Name: name, Conditions: conds, Count: n.Count, MeanOutput: n.Value})
+			return
+		}
+
+		feature := featureNames[n.Feature]
+		s.Thresholds[feature] = append(s.Thresholds[feature], n.Threshold)
+		walk(n.Left, append(path[:len(path):len(path)], Condition{feature, "<=", n.Threshold}))
+		walk(n.Right, append(path[:len(path):len(path)], Condition{feature, ">", n.Threshold}))
+	}
+	walk(root, nil)
+
+	for f, ts := range s.Thresholds {
+		sort.Float64s(ts)
+		s.Thresholds[f] = dedupeSorted(ts)
+	}
+	return s
+}
+
+// simplifyConditions keeps only the tightest upper and lower bound per
+// feature, preserving first-seen feature order.
+func simplifyConditions(path []Condition) []Condition {
+	type bounds struct {
+		lower, upper *Condition
+	}
+	var order []string
+	byFeature := map[string]*bounds{}
+	for _, c := range path {
+		b, ok := byFeature[c.Feature]
+		if !ok {
+			b = &bounds{}
+			byFeature[c.Feature] = b
+			order = append(order, c.Feature)
+		}
+		if c.Op == ">" {
+			if b.lower == nil || c.Value > b.lower.Value {
+				b.lower = &c
+			}
+		} else if b.upper == nil || c.Value < b.upper.Value {
+			b.upper = &c
+		}
+	}
+
+	var out []Condition
+	for _, f := range order {
+		b := byFeature[f]
+		if b.lower != nil {
+			out = append(out, *b.lower)
+		}
+		if b.upper != nil {
+			out = append(out, *b.upper)
+		}
+	}
+	return out
+}
+
+func dedupeSorted(values []float64) []float64 {
+	out := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func runDiscoverSegments(args []string) error {
+	fs := flag.NewFlagSet("discover-segments", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path")
+	depth := fs.Int("depth", 2, "tree depth (2 or 3 gives 4-8 segments)")
+	minLeaf := fs.Int("min-leaf", 50, "minimum cases per segment")
+	out := fs.String("out", "", "write the segmentation config to this path (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *depth < 1 {
+		return fmt.Errorf("-depth must be at least 1")
+	}
+
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+
+	root := fitRegressionTree(trainingData, treeParams{MaxDepth: *depth, MinLeaf: *minLeaf})
+	seg := segmentationFromTree(root, fmt.Sprintf("regression-tree depth=%d min-leaf=%d", *depth, *minLeaf))
+
+	return writeJSONFile(*out, seg)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// selftestJSON holds known inputs and the reimbursements the legacy system
+// paid for them, from the public cases: a spread of trip lengths, miles and
+// receipts that a healthy deployment reproduces.
+//
+//go:embed selftest.json
+var selftestJSON []byte
+
+// SelfTestCase is the outcome of one selftest case.
+type SelfTestCase struct {
+	Input     Query   `json:"input"`
+	Expected  float64 `json:"expected"`
+	Predicted float64 `json:"predicted"`
+	Passed    bool    `json:"passed"`
+}
+
+// selfTest predicts each case through p as the CLI answers, to the cent,
+// and checks the prediction is within tolerance dollars of the expected
+// output. A prediction that is not a finite number fails.
+func selfTest(p *Predictor, cases TrainingData, tolerance float64) []SelfTestCase {
+	results := make([]SelfTestCase, len(cases))
+	for i, c := range cases {
+		in := c.Input
+		y := p.PredictQuery(in)
+		if !math.IsNaN(y) && !math.IsInf(y, 0) {
+			y = centsOf(y).Dollars()
+		}
+		results[i] = SelfTestCase{Input: Query(in), Expected: c.ExpectedOutput, Predicted: y,
+			Passed: math.Abs(y-c.ExpectedOutput) <= tolerance}
+	}
+	return results
+}
+
+func printSelfTest(w io.Writer, results []SelfTestCase) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAYS\tMILES\tRECEIPTS\tEXPECTED\tPREDICTED\tERROR\tRESULT")
+	for _, r := range results {
+		result := "ok"
+		if !r.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%d\t%g\t%.2f\t%.2f\t%.2f\t%+.2f\t%s\n", r.Input.TripDurationDays, r.Input.MilesTraveled,
+			r.Input.TotalReceiptsAmount, r.Expected, r.Predicted, r.Predicted-r.Expected, result)
+	}
+	tw.Flush()
+}
+
+func runSelfTest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	tolerance := fs.Float64("tolerance", 1, "largest error in dollars a healthy prediction may have")
+	asJSON := fs.Bool("json", false, "write the results as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tolerance < 0 {
+		return fmt.Errorf("-tolerance must not be negative")
+	}
+	var cases TrainingData
+	if err := json.Unmarshal(selftestJSON, &cases); err != nil {
+		return fmt.Errorf("parsing the embedded selftest cases: %v", err)
+	}
+	p, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	results := selfTest(p, cases, *tolerance)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.Passed {
+			failed++
+		}
+	}
+	if *asJSON {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			return err
+		}
+	} else {
+		printSelfTest(os.Stdout, results)
+	}
+	if failed > 0 {
+		return fmt.Errorf("selftest failed: %d of %d predictions are more than $%.2f from the expected output",
+			failed, len(results), *tolerance)
+	}
+	if !*asJSON {
+		fmt.Printf("Selftest passed: model %s predicted all %d cases within $%.2f\n", p.Version, len(results), *tolerance)
+	}
+	return nil
+}
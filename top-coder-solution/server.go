@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// serverConfig holds the HTTP server's limits.
+type serverConfig struct {
+	addr            string
+	rateLimit       float64 // requests per second per client; 0 disables
+	rateBurst       int
+	maxBatch        int
+	maxBodyBytes    int64
+	requestTimeout  time.Duration
+	clientIDHeader  string
+	anomalyQuantile float64
+	inputPolicy     string
+	minConfidence   float64
+	authConfig      string
+	tlsCert         string
+	tlsKey          string
+	clientCA        string
+	maxJobCases     int
+	maxJobBodyBytes int64
+	jobWorkers      int
+	jobQueue        int
+	jobTTL          time.Duration
+	watch           bool
+	watchInterval   time.Duration
+	watchDebounce   time.Duration
+	grpc            bool
+	policies        string
+}
+
+func (c *serverConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "addr", ":8080", "listen address")
+	fs.Float64Var(&c.rateLimit, "rate-limit", 20, "requests per second allowed per client (0 disables)")
+	fs.IntVar(&c.rateBurst, "rate-burst", 40, "requests a client may make in a burst")
+	fs.IntVar(&c.maxBatch, "max-batch", 10000, "maximum cases per batch request")
+	fs.Int64Var(&c.maxBodyBytes, "max-body-bytes", 4<<20, "maximum request body size in bytes")
+	fs.DurationVar(&c.requestTimeout, "request-timeout", 30*time.Second, "maximum time to serve a request")
+	fs.StringVar(&c.clientIDHeader, "client-id-header", "",
+		"header identifying the client for rate limiting (default the remote IP)")
+	fs.Float64Var(&c.anomalyQuantile, "anomaly-quantile", 0.01,
+		"flag queries less dense than this fraction of training cases (0 disables)")
+	fs.StringVar(&c.inputPolicy, "input-policy", policyPassthrough,
+		"handling of zero or negative inputs: passthrough, reject or clamp (to the smallest positive training value)")
+	fs.Float64Var(&c.minConfidence, "min-confidence", 0,
+		"answer \"abstained\" instead of a reimbursement when the confidence score is below this (0 disables)")
+	fs.StringVar(&c.authConfig, "auth-config", "", "JSON file of bearer tokens and client certificates with their scopes")
+	fs.StringVar(&c.tlsCert, "tls-cert", "", "TLS certificate file (enables HTTPS)")
+	fs.StringVar(&c.tlsKey, "tls-key", "", "TLS private key file")
+	fs.StringVar(&c.clientCA, "client-ca", "", "CA bundle for verifying client certificates (mTLS)")
+	fs.IntVar(&c.maxJobCases, "max-job-cases", 1000000, "maximum cases per async job")
+	fs.Int64Var(&c.maxJobBodyBytes, "max-job-body-bytes", 256<<20, "maximum async job request body size in bytes")
+	fs.IntVar(&c.jobWorkers, "job-workers", 2, "number of async jobs processed concurrently")
+	fs.IntVar(&c.jobQueue, "job-queue", 100, "maximum number of queued async jobs")
+	fs.DurationVar(&c.jobTTL, "job-ttl", time.Hour, "how long finished job results are kept")
+	fs.BoolVar(&c.watch, "watch", false, "reload the model automatically when its data files change")
+	fs.DurationVar(&c.watchInterval, "watch-interval", 2*time.Second, "how often -watch checks the data files")
+	fs.DurationVar(&c.watchDebounce, "watch-debounce", 5*time.Second,
+		"how long the data files must stay unchanged before -watch reloads")
+	fs.BoolVar(&c.grpc, "grpc", false,
+		"also serve the streaming gRPC API of reimbursement.proto on -addr (over cleartext HTTP/2 without -tls-cert)")
+	fs.StringVar(&c.policies, "policies", "",
+		"comma-separated profiles of -config to serve as policies alongside the default model, each profile's flags applied over the command line's")
+}
+
+// Server serves predictions over HTTP.
+type Server struct {
+	cfg     serverConfig
+	tenants map[string]*tenant // by policy name; never modified while serving
+	audit   *AuditLog
+	limiter *rateLimiter
+	auth    *authenticator // nil allows unauthenticated access
+	jobs    *jobManager
+
+	// shadowLog records the comparisons of the default model's shadow.
+	shadowLog *shadowLog
+}
+
+// serving is the model state requests are answered from. It is never
+// modified after being stored; a reload swaps in a new one, and each request
+// loads it once so that it is answered by a single consistent model.
+type serving struct {
+	predictor *Predictor
+	shadow    *Predictor // run alongside predictor but never answering; may be nil
+	anomaly   *AnomalyDetector
+	floor     featureVector // clamping floor of the input policy
+	loadedAt  time.Time
+	policy    string // the tenant's name
+	metrics   *tenantMetrics
+}
+
+// provenance returns the predictor's provenance stamped with time t and the
+// policy it serves.
+func (m *serving) provenance(t time.Time) Provenance {
+	prov := m.predictor.Provenance(t)
+	if m.policy != defaultPolicy {
+		prov.Policy = m.policy
+	}
+	return prov
+}
+
+// NewServer builds a server that reports unready until reload has built its
+// default predictor with load. auth may be nil to disable authentication.
+func NewServer(cfg serverConfig, load func() (*Predictor, error), audit *AuditLog, auth *authenticator) *Server {
+	s := &Server{cfg: cfg, tenants: map[string]*tenant{}, audit: audit, auth: auth}
+	s.addPolicy(defaultPolicy, load, nil)
+	if cfg.rateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.rateLimit, cfg.rateBurst)
+	}
+	s.jobs = newJobManager(cfg.jobWorkers, cfg.jobQueue, cfg.jobTTL, s.runJob)
+	return s
+}
+
+// setPredictor builds the serving state of t around p and its shadow, which
+// may be nil, and starts answering t's requests with it. Requests already in
+// flight finish on the state they started with.
+func (s *Server) setPredictor(t *tenant, p, shadow *Predictor) *serving {
+	m := &serving{predictor: p, shadow: shadow, floor: inputFloor(p.Training), loadedAt: time.Now(),
+		policy: t.name, metrics: &t.metrics}
+	if s.cfg.anomalyQuantile > 0 {
+		m.anomaly = newAnomalyDetector(p.Training, s.cfg.anomalyQuantile)
+	}
+	t.model.Store(m)
+	return m
+}
+
+// Close cancels running jobs and stops the job workers.
+func (s *Server) Close() {
+	s.jobs.close()
+}
+
+// Handler returns the server's routes wrapped in its rate, size and time
+// limits.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// Routes answered by a tenant are also served under the policy prefix.
+	perPolicy := func(method, path string, h http.Handler) {
+		mux.Handle(method+" "+path, h)
+		mux.Handle(method+" "+policyPrefix+path, h)
+	}
+	perPolicy("POST", "/predict", s.limitBody(s.cfg.maxBodyBytes, s.require(scopePredict, s.handlePredict)))
+	perPolicy("POST", "/batch", s.limitBody(s.cfg.maxBodyBytes, s.require(scopePredict, s.handleBatch)))
+	perPolicy("POST", "/jobs", s.limitBody(s.cfg.maxJobBodyBytes, s.require(scopePredict, s.handleSubmitJob)))
+	mux.Handle("GET /jobs/{id}", s.require(scopePredict, s.handleGetJob))
+	mux.Handle("DELETE /jobs/{id}", s.require(scopePredict, s.handleCancelJob))
+	mux.Handle("GET /jobs/{id}/results", s.require(scopePredict, s.handleJobResults))
+
+	perPolicy("GET", "/modelinfo", s.require(scopePredict, s.handleModelInfo))
+	perPolicy("POST", "/reload", s.require(scopeAdmin, s.handleReload))
+	mux.Handle("GET /metrics", s.require(scopeAdmin, s.handleMetrics))
+
+	var h http.Handler = mux
+	if s.cfg.requestTimeout > 0 {
+		h = http.TimeoutHandler(h, s.cfg.requestTimeout, `{"error":"request timed out"}`)
+	}
+	// Event streams stay open while their job runs, and gRPC streams while
+	// the client sends cases, so they skip the request timeout, which would
+	// also buffer them.
+	var events http.Handler = s.require(scopePredict, s.handleJobEvents)
+	var stream http.Handler = s.require(scopePredict, s.handlePredictStream)
+	if s.limiter != nil {
+		h = s.rateLimit(h)
+		events = s.rateLimit(events)
+		stream = s.rateLimit(stream)
+	}
+
+	// Probes bypass authentication and limits so the load balancer always
+	// sees the service's real state.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", s.handleHealthz)
+	root.HandleFunc("GET /readyz", s.handleReadyz)
+	root.HandleFunc("GET /openapi.json", handleOpenAPI)
+	root.HandleFunc("GET /docs", handleDocs)
+	root.Handle("GET /jobs/{id}/events", events)
+	if s.cfg.grpc {
+		root.Handle("POST "+grpcPredictStream, stream)
+	}
+	root.Handle("/", h)
+	return root
+}
+
+// clientID identifies the caller for rate limiting.
+func (s *Server) clientID(r *http.Request) string {
+	if s.cfg.clientIDHeader != "" {
+		if id := r.Header.Get(s.cfg.clientIDHeader); id != "" {
+			return id
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.limiter.allow(s.clientID(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the request body at limit bytes; 0 means unlimited.
+func (s *Server) limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSONResponse(w, status, map[string]string{"error": msg})
+}
+
+// decodeBody decodes a JSON request body, rejecting unknown fields, and
+// writes the error response itself when decoding fails.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// setProvenanceHeader records which model answered in X-Model-Provenance.
+func setProvenanceHeader(w http.ResponseWriter, prov Provenance) {
+	segments := 0
+	if prov.Hyperparameters.Segmentation != nil {
+		segments = len(prov.Hyperparameters.Segmentation.Segments)
+	}
+	w.Header().Set("X-Model-Provenance", fmt.Sprintf("version=%s; data-sha256=%s; k=%d; segments=%d; timestamp=%s",
+		prov.ModelVersion, prov.DataSHA256, prov.Hyperparameters.K, segments, prov.Timestamp.Format(time.RFC3339Nano)))
+}
+
+// predictOne answers a single query with m and records it in the audit log.
+// Queries refused by the input policy, or too large to compute with, fail
+// with an *inputError, and queries still unanswered when ctx is done with
+// ctx's error.
+func (s *Server) predictOne(ctx context.Context, m *serving, q Query, prov Provenance) (PredictionResponse, error) {
+	start := time.Now()
+	resp, err := s.predictQuery(ctx, m, q, prov)
+	var refused *inputError
+	switch {
+	case errors.As(err, &refused):
+		m.metrics.refused.Add(1)
+	case err != nil:
+		if ctx.Err() == nil {
+			m.metrics.failures.Add(1)
+		}
+	default:
+		m.metrics.predictions.Add(1)
+		m.metrics.predictNanos.Add(int64(time.Since(start)))
+	}
+	return resp, err
+}
+
+func (s *Server) predictQuery(ctx context.Context, m *serving, q Query, prov Provenance) (PredictionResponse, error) {
+	if err := checkQuery(q); err != nil {
+		return PredictionResponse{}, &inputError{err.Error()}
+	}
+	in, clamped, err := applyInputPolicy(s.cfg.inputPolicy, q, m.floor)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	y, err := predictChecked(ctx, m.predictor, in)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	resp := PredictionResponse{Input: q, Reimbursement: y}
+	if m.shadow != nil {
+		s.compareShadow(m, in, resp.Reimbursement, prov.Timestamp)
+	}
+	if clamped {
+		resp.Adjusted = &in
+	}
+	if s.cfg.minConfidence > 0 {
+		c := m.predictor.Confidence(in)
+		resp.Confidence = &c
+		if c.Score < s.cfg.minConfidence {
+			resp.Reimbursement, resp.Abstained = 0, true
+		}
+	}
+	if m.anomaly != nil {
+		resp.Warning = m.anomaly.Check(in)
+	}
+	if s.audit != nil {
+		if err := s.audit.Record(newAuditRecord(resp, prov, m.predictor.Summarize(in))); err != nil {
+			return resp, fmt.Errorf("writing audit log: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// PredictRequest is the body of POST /predict: a query, and optionally the
+// policy to answer it under.
+type PredictRequest struct {
+	Query
+	Policy string `json:"policy,omitempty"`
+}
+
+// UnmarshalJSON decodes the policy and the query, whose methods would
+// otherwise take over decoding the whole request.
+func (r *PredictRequest) UnmarshalJSON(data []byte) error {
+	var policy struct {
+		Policy string `json:"policy"`
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	r.Policy = policy.Policy
+	return r.Query.decodeJSON(data, "policy")
+}
+
+func (r PredictRequest) MarshalJSON() ([]byte, error) {
+	b, err := r.Query.MarshalJSON()
+	if err != nil || r.Policy == "" {
+		return b, err
+	}
+	policy, err := json.Marshal(r.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(b[:len(b)-1], `,"policy":%s}`, policy), nil
+}
+
+func (s *Server) handlePredict(w http.ResponseWriter, r *http.Request) {
+	var req PredictRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	t := s.tenantFor(w, r, req.Policy)
+	if t == nil {
+		return
+	}
+	m := t.ready(w)
+	if m == nil {
+		return
+	}
+
+	prov := m.provenance(time.Now())
+	resp, err := s.predictOne(r.Context(), m, req.Query, prov)
+	if r.Context().Err() != nil {
+		return // the timeout handler has already responded
+	}
+	var refused *inputError
+	if errors.As(err, &refused) {
+		writeError(w, http.StatusUnprocessableEntity, refused.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("predict: %v", err)
+		writeError(w, http.StatusInternalServerError, "prediction failed")
+		return
+	}
+	resp.Provenance = &prov
+	setProvenanceHeader(w, prov)
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+// BatchRequest is the body of POST /batch.
+type BatchRequest struct {
+	Cases  []Query `json:"cases"`
+	Policy string  `json:"policy,omitempty"`
+}
+
+// BatchResponse is the result of POST /batch.
+type BatchResponse struct {
+	Predictions []PredictionResponse `json:"predictions"`
+	Provenance  Provenance           `json:"provenance"`
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	t := s.tenantFor(w, r, req.Policy)
+	if t == nil {
+		return
+	}
+	m := t.ready(w)
+	if m == nil {
+		return
+	}
+	if s.cfg.maxBatch > 0 && len(req.Cases) > s.cfg.maxBatch {
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("batch of %d cases exceeds the limit of %d", len(req.Cases), s.cfg.maxBatch))
+		return
+	}
+
+	prov := m.provenance(time.Now())
+	resp := BatchResponse{Predictions: make([]PredictionResponse, len(req.Cases)), Provenance: prov}
+	for i, q := range req.Cases {
+		p, err := s.predictOne(r.Context(), m, q, prov)
+		if r.Context().Err() != nil {
+			return // the timeout handler has already responded
+		}
+		var refused *inputError
+		if errors.As(err, &refused) {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("case %d: %v", i, refused))
+			return
+		}
+		if err != nil {
+			log.Printf("batch: %v", err)
+			writeError(w, http.StatusInternalServerError, "prediction failed")
+			return
+		}
+		resp.Predictions[i] = p
+	}
+
+	setProvenanceHeader(w, prov)
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	var cfg serverConfig
+	cfg.register(fs)
+	var audit auditFlags
+	audit.register(fs)
+	var shadow shadowFlags
+	shadow.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := shadow.validate(); err != nil {
+		return err
+	}
+
+	auditLog, err := audit.open()
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	var auth *authenticator
+	if cfg.authConfig != "" {
+		if auth, err = loadAuthenticator(cfg.authConfig); err != nil {
+			return fmt.Errorf("loading auth config: %v", err)
+		}
+	} else {
+		log.Printf("warning: no -auth-config given; the API is unauthenticated")
+	}
+	if err := validateInputPolicy(cfg.inputPolicy); err != nil {
+		return err
+	}
+	if err := validateAnomalyQuantile(cfg.anomalyQuantile); err != nil {
+		return err
+	}
+	if (cfg.tlsCert == "") != (cfg.tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.clientCA != "" && cfg.tlsCert == "" {
+		return fmt.Errorf("-client-ca requires -tls-cert and -tls-key")
+	}
+
+	policies, err := parsePolicies(cfg.policies)
+	if err != nil {
+		return err
+	}
+	var profiles *Config
+	configPath := fs.Lookup("config").Value.String()
+	if len(policies) > 0 {
+		if profiles, err = loadConfig(configPath); err != nil {
+			return fmt.Errorf("loading config: %v", err)
+		}
+	}
+
+	server := NewServer(cfg, model.build, auditLog, auth)
+	defer server.Close()
+	server.defaultTenant().sources = model.sources()
+	for _, name := range policies {
+		profile, err := profiles.profile(name)
+		if err != nil {
+			return err
+		}
+		policy, err := model.withProfile(profile, configPath)
+		if err != nil {
+			return fmt.Errorf("policy %s: %v", name, err)
+		}
+		server.addPolicy(name, policy.build, policy.sources())
+	}
+	def := server.defaultTenant()
+	if def.loadShadow = shadow.loader(model.registry); def.loadShadow != nil {
+		if server.shadowLog, err = openShadowLog(shadow.logPath); err != nil {
+			return fmt.Errorf("opening shadow log: %v", err)
+		}
+		defer server.shadowLog.Close()
+	}
+	srv := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if cfg.requestTimeout > 0 {
+		srv.ReadTimeout = cfg.requestTimeout
+		srv.WriteTimeout = cfg.requestTimeout + 5*time.Second
+	}
+	if cfg.tlsCert != "" {
+		if srv.TLSConfig, err = serverTLSConfig(cfg.clientCA); err != nil {
+			return err
+		}
+	} else if cfg.grpc {
+		// gRPC needs HTTP/2, which TLS negotiates; without it, accept
+		// HTTP/2 with prior knowledge alongside HTTP/1.
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(hup, reloadSignals...)
+		defer signal.Stop(hup)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		log.Printf("listening on %s", cfg.addr)
+		if cfg.tlsCert != "" {
+			errc <- srv.ListenAndServeTLS(cfg.tlsCert, cfg.tlsKey)
+			return
+		}
+		errc <- srv.ListenAndServe()
+	}()
+	// Load the models while listening so that /readyz can report progress
+	// to the load balancer instead of connections being refused.
+	go func() {
+		if err := server.reloadAll(); err != nil {
+			errc <- fmt.Errorf("loading model: %v", err)
+		}
+	}()
+
+	if cfg.watch {
+		for _, t := range server.sortedTenants() {
+			log.Printf("%swatching %s for changes", t.logPrefix(), strings.Join(t.sources, ", "))
+			go watchFiles(ctx, t.sources, cfg.watchInterval, cfg.watchDebounce, func() { server.reload(t) })
+		}
+	}
+
+	for done := false; !done; {
+		select {
+		case err := <-errc:
+			return err
+		case <-hup:
+			go server.reloadAll() // failures are logged and the old models kept
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// shadowFlags select a shadow model: one the server runs on every request
+// alongside the primary, logging both predictions without ever returning the
+// shadow's. This trials a new model against production traffic.
+type shadowFlags struct {
+	model   string
+	tag     string
+	logPath string
+}
+
+func (f *shadowFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.model, "shadow-model", "",
+		"run this model, trained like the primary, in shadow mode: knn, linear, tree, forest, rule or exec:PROGRAM")
+	fs.StringVar(&f.tag, "shadow-model-tag", "", "run this registered model version in shadow mode")
+	fs.StringVar(&f.logPath, "shadow-log", "",
+		"append a JSONL record of each primary and shadow prediction to this file (default the server log)")
+}
+
+func (f *shadowFlags) validate() error {
+	if f.model != "" && f.tag != "" {
+		return fmt.Errorf("-shadow-model and -shadow-model-tag are mutually exclusive")
+	}
+	if f.logPath != "" && f.model == "" && f.tag == "" {
+		return fmt.Errorf("-shadow-log requires -shadow-model or -shadow-model-tag")
+	}
+	if f.model != "" {
+		return validateModel(f.model)
+	}
+	return nil
+}
+
+// loader returns the function building the shadow for a primary predictor,
+// or nil when no shadow is configured.
+func (f *shadowFlags) loader(registry string) func(primary *Predictor) (*Predictor, error) {
+	switch {
+	case f.tag != "":
+		return func(*Predictor) (*Predictor, error) {
+			p, _, err := loadRegisteredModel(registry, f.tag)
+			return p, err
+		}
+	case f.model != "":
+		return func(primary *Predictor) (*Predictor, error) {
+			hp := primary.Hyperparameters()
+			hp.Model = f.model
+			if isKNN(f.model) {
+				hp.Model = ""
+			} else {
+				hp.Segmentation, hp.FallbackDistance = nil, 0 // KNN only
+			}
+			if err := hp.validate(); err != nil {
+				return nil, err
+			}
+			p := NewPredictor(primary.Training, hp)
+			if err := p.Err(); err != nil {
+				return nil, err
+			}
+			p.Version, p.DataSHA256 = primary.Version, primary.DataSHA256
+			return p, nil
+		}
+	}
+	return nil
+}
+
+// ShadowRecord compares the primary and shadow predictions for one query.
+type ShadowRecord struct {
+	Timestamp time.Time        `json:"timestamp"`
+	Query     Query            `json:"query"`
+	Primary   ShadowPrediction `json:"primary"`
+	Shadow    ShadowPrediction `json:"shadow"`
+	Delta     float64          `json:"delta"` // shadow minus primary
+}
+
+// ShadowPrediction is one model's side of a ShadowRecord.
+type ShadowPrediction struct {
+	ModelVersion  string  `json:"model_version"`
+	Model         string  `json:"model,omitempty"` // empty for KNN
+	Reimbursement float64 `json:"reimbursement"`
+}
+
+// shadowLog records shadow comparisons as JSON lines, or to the server log
+// when it has no file.
+type shadowLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func openShadowLog(path string) (*shadowLog, error) {
+	if path == "" {
+		return &shadowLog{}, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &shadowLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// record logs r. A shadow must never affect the primary's answer, so
+// failures are only reported in the server log.
+func (l *shadowLog) record(r ShadowRecord) {
+	if l.file == nil {
+		log.Printf("shadow: %d days, %g miles, $%.2f receipts: primary %.2f, shadow %.2f, delta %+.2f",
+			r.Query.TripDurationDays, r.Query.MilesTraveled, r.Query.TotalReceiptsAmount,
+			r.Primary.Reimbursement, r.Shadow.Reimbursement, r.Delta)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(r); err != nil {
+		log.Printf("writing shadow log: %v", err)
+	}
+}
+
+func (l *shadowLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// compareShadow predicts in with m's shadow and logs it against the
+// primary's prediction.
+func (s *Server) compareShadow(m *serving, in Query, primary float64, t time.Time) {
+	shadow := roundCents(m.shadow.PredictQuery(in))
+	s.shadowLog.record(ShadowRecord{
+		Timestamp: t.UTC(),
+		Query:     in,
+		Primary:   ShadowPrediction{ModelVersion: m.predictor.Version, Model: m.predictor.Model, Reimbursement: primary},
+		Shadow:    ShadowPrediction{ModelVersion: m.shadow.Version, Model: m.shadow.Model, Reimbursement: shadow},
+		Delta:     (centsOf(shadow) - centsOf(primary)).Dollars(),
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reloadSignals is empty where there is no SIGHUP; serve reloads its model
+// only through POST /reload or -watch.
+var reloadSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals that make serve reload its model.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,11 @@
+//go:build slim
+
+package main
+
+// slimBuild is set in binaries built with the slim tag, for distributing
+// where the training data is sensitive: they embed no model, see
+// slim_baked.go, and read training data only from a -data or -model-tag
+// given explicitly, never from a default path.
+//
+//	CGO_ENABLED=0 go build -tags slim
+const slimBuild = true
@@ -0,0 +1,7 @@
+//go:build slim && baked
+
+package main
+
+// A slim binary must not embed a model, so building with both tags fails
+// here, naming the conflict.
+var _ = slim_builds_cannot_embed_a_baked_model
@@ -0,0 +1,6 @@
+//go:build !slim
+
+package main
+
+// slimBuild is false in builds without the slim tag; see slim.go.
+const slimBuild = false
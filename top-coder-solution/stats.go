@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// ColumnStats summarizes the values of one column of a case file.
+type ColumnStats struct {
+	Column string  `json:"column"`
+	Min    float64 `json:"min"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+}
+
+// DataStats summarizes a case file.
+type DataStats struct {
+	Path       string        `json:"path"`
+	Cases      int           `json:"cases"`
+	Duplicates int           `json:"duplicates"` // cases whose input another case shares
+	Columns    []ColumnStats `json:"columns"`
+}
+
+func dataStats(path string, cases TrainingData) DataStats {
+	s := DataStats{Path: path, Cases: len(cases)}
+	// Cases share an input when they agree on every input, named input
+	// fields included.
+	type inputKey struct {
+		days            int
+		miles, receipts float64
+		fields          string // as JSON, whose objects are sorted by name
+	}
+	inputs := make(map[inputKey]int, len(cases))
+	for _, c := range cases {
+		k := inputKey{days: c.Input.TripDurationDays, miles: c.Input.MilesTraveled, receipts: c.Input.TotalReceiptsAmount}
+		if len(c.Input.Fields) > 0 {
+			b, _ := json.Marshal(c.Input.Fields)
+			k.fields = string(b)
+		}
+		inputs[k]++
+	}
+	for _, n := range inputs {
+		if n > 1 {
+			s.Duplicates += n
+		}
+	}
+	if len(cases) == 0 {
+		return s
+	}
+	for _, col := range driftColumns {
+		values := make([]float64, len(cases))
+		for i, c := range cases {
+			values[i] = col.Value(c)
+		}
+		sort.Float64s(values)
+		mean, std := meanStd(values)
+		s.Columns = append(s.Columns, ColumnStats{
+			Column: col.Name,
+			Min:    values[0],
+			P25:    sortedQuantile(values, 0.25),
+			Median: sortedQuantile(values, 0.5),
+			P75:    sortedQuantile(values, 0.75),
+			Max:    values[len(values)-1],
+			Mean:   mean,
+			StdDev: std,
+		})
+	}
+	return s
+}
+
+// sortedQuantile returns the q quantile of sorted, non-empty values,
+// interpolating between the values either side.
+func sortedQuantile(values []float64, q float64) float64 {
+	pos := q * float64(len(values)-1)
+	i := int(pos)
+	if i+1 >= len(values) {
+		return values[len(values)-1]
+	}
+	return values[i] + (pos-float64(i))*(values[i+1]-values[i])
+}
+
+func printDataStats(w io.Writer, s DataStats) {
+	fmt.Fprintf(w, "%s: %d cases, %d sharing their input with another\n", s.Path, s.Cases, s.Duplicates)
+	if len(s.Columns) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Column\tMin\tP25\tMedian\tP75\tMax\tMean\tStd dev")
+	for _, c := range s.Columns {
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n",
+			c.Column, c.Min, c.P25, c.Median, c.P75, c.Max, c.Mean, c.StdDev)
+	}
+	tw.Flush()
+}
+
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the statistics as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{defaultDataPath}
+	}
+
+	var all []DataStats
+	for i, path := range paths {
+		cases, err := loadTrainingData(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", path, err)
+		}
+		s := dataStats(path, cases)
+		all = append(all, s)
+		if !*asJSON {
+			if i > 0 {
+				fmt.Println()
+			}
+			printDataStats(os.Stdout, s)
+		}
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, all)
+	}
+	return nil
+}
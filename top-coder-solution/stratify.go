@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// stratifyBands are the inputs whose joint distribution a stratified split
+// preserves.
+var stratifyBands = []segmenter{durationBands, receiptBands}
+
+// SplitFile is one side of a split.
+type SplitFile struct {
+	Path   string `json:"path"`
+	Cases  int    `json:"cases"`
+	SHA256 string `json:"sha256"`
+}
+
+// SplitStratum counts the cases of one combination of bands on each side.
+type SplitStratum struct {
+	Bands []string `json:"bands"` // one per stratifyBands input
+	Cases int      `json:"cases"`
+	Train int      `json:"train"`
+	Test  int      `json:"test"`
+}
+
+// SplitManifest records how a case file was split, enough to reproduce and
+// verify the split.
+type SplitManifest struct {
+	Source       string         `json:"source"`
+	SourceSHA256 string         `json:"source_sha256"`
+	Seed         uint64         `json:"seed"`
+	TestFraction float64        `json:"test_fraction"`
+	StratifiedBy []string       `json:"stratified_by"`
+	Train        SplitFile      `json:"train"`
+	Test         SplitFile      `json:"test"`
+	Strata       []SplitStratum `json:"strata"`
+}
+
+// stratifiedSplit assigns cases to the test set, fraction of them, so that
+// each stratum of stratifyBands contributes its share. Strata get whole
+// cases by largest remainder, so the test set has exactly its rounded share
+// of all cases and no stratum is more than one case off its own share. The
+// cases chosen within each stratum are drawn at random from seed. It
+// returns whether each case is in the test set, and the strata in band
+// order.
+func stratifiedSplit(cases TrainingData, fraction float64, seed uint64) ([]bool, []SplitStratum) {
+	type stratum struct {
+		order []int
+		SplitStratum
+		members []int
+		quota   float64
+	}
+	var strata []*stratum
+	byKey := map[string]*stratum{}
+	for i, c := range cases {
+		var order []int
+		var bands []string
+		key := ""
+		for _, s := range stratifyBands {
+			o, name := s.Band(c)
+			order, bands = append(order, o), append(bands, name)
+			key += name + "\x00"
+		}
+		st, ok := byKey[key]
+		if !ok {
+			st = &stratum{order: order, SplitStratum: SplitStratum{Bands: bands}}
+			byKey[key] = st
+			strata = append(strata, st)
+		}
+		st.members = append(st.members, i)
+	}
+	slices.SortFunc(strata, func(a, b *stratum) int { return slices.Compare(a.order, b.order) })
+
+	target := int(math.Round(fraction * float64(len(cases))))
+	assigned := 0
+	for _, st := range strata {
+		st.Cases = len(st.members)
+		st.quota = fraction * float64(st.Cases)
+		st.Test = int(st.quota)
+		assigned += st.Test
+	}
+	byRemainder := slices.Clone(strata)
+	slices.SortStableFunc(byRemainder, func(a, b *stratum) int {
+		return cmp.Compare(b.quota-float64(b.Test), a.quota-float64(a.Test))
+	})
+	for _, st := range byRemainder {
+		if assigned >= target {
+			break
+		}
+		if st.Test < st.Cases {
+			st.Test++
+			assigned++
+		}
+	}
+
+	rng := rand.New(rand.NewPCG(seed, seed))
+	test := make([]bool, len(cases))
+	out := make([]SplitStratum, len(strata))
+	for i, st := range strata {
+		rng.Shuffle(len(st.members), func(a, b int) { st.members[a], st.members[b] = st.members[b], st.members[a] })
+		for _, c := range st.members[:st.Test] {
+			test[c] = true
+		}
+		st.Train = st.Cases - st.Test
+		out[i] = st.SplitStratum
+	}
+	return test, out
+}
+
+func printSplit(w io.Writer, m SplitManifest) {
+	fmt.Fprintf(w, "Split %s into %d training cases (%s) and %d test cases (%s)\n",
+		m.Source, m.Train.Cases, m.Train.Path, m.Test.Cases, m.Test.Path)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range m.StratifiedBy {
+		fmt.Fprintf(tw, "%s\t", strings.ToUpper(name))
+	}
+	fmt.Fprintln(tw, "CASES\tTRAIN\tTEST")
+	for _, s := range m.Strata {
+		for _, band := range s.Bands {
+			fmt.Fprintf(tw, "%s\t", band)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\n", s.Cases, s.Train, s.Test)
+	}
+	tw.Flush()
+}
+
+func runStratify(args []string) error {
+	fs := flag.NewFlagSet("stratify", flag.ContinueOnError)
+	in := fs.String("in", "", "case file to split, a path or s3:// or gs:// URI (required)")
+	from := fs.String("from", "", "format of -in: json, csv or xlsx (default from its extension)")
+	trainOut := fs.String("train-out", "", "write the training cases to this path (required)")
+	testOut := fs.String("test-out", "", "write the test cases to this path (required)")
+	manifest := fs.String("manifest", "", "write the split's manifest to this path (default stdout, replacing the summary)")
+	fraction := fs.Float64("test-fraction", 0.2, "fraction of the cases to put in the test set")
+	seed := fs.Uint64("seed", 1, "random seed for choosing the test cases")
+	var table tableFlags
+	table.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *in == "" || *trainOut == "" || *testOut == "" {
+		return fmt.Errorf("-in, -train-out and -test-out are required")
+	}
+	if *fraction <= 0 || *fraction >= 1 {
+		return fmt.Errorf("-test-fraction must be between 0 and 1")
+	}
+	inFormat, err := fileFormat(*from, *in)
+	if err != nil {
+		return err
+	}
+	inPath, err := localPath(*in)
+	if err != nil {
+		return err
+	}
+	m, err := parseColumnMapping(table.columns)
+	if err != nil {
+		return err
+	}
+	cases, labelled, err := readCases(inPath, inFormat, m, table)
+	if err != nil {
+		return err
+	}
+	sum, err := hashFile(inPath)
+	if err != nil {
+		return err
+	}
+
+	test, strata := stratifiedSplit(cases, *fraction, *seed)
+	var trainCases, testCases TrainingData
+	for i, c := range cases {
+		if test[i] {
+			testCases = append(testCases, c)
+		} else {
+			trainCases = append(trainCases, c)
+		}
+	}
+	man := SplitManifest{Source: *in, SourceSHA256: sum, Seed: *seed, TestFraction: *fraction, Strata: strata}
+	for _, s := range stratifyBands {
+		man.StratifiedBy = append(man.StratifiedBy, s.Title)
+	}
+	for _, side := range []struct {
+		file  *SplitFile
+		path  string
+		cases TrainingData
+	}{{&man.Train, *trainOut, trainCases}, {&man.Test, *testOut, testCases}} {
+		format, err := fileFormat("", side.path)
+		if err != nil {
+			return err
+		}
+		if format == fileXLSX {
+			return fmt.Errorf("cannot write %s; split to %s or %s", fileXLSX, fileJSON, fileCSV)
+		}
+		if err := writeCases(side.path, format, side.cases, labelled, m); err != nil {
+			return err
+		}
+		*side.file = SplitFile{Path: side.path, Cases: len(side.cases)}
+		if side.file.SHA256, err = hashFile(side.path); err != nil {
+			return err
+		}
+	}
+
+	if *manifest == "" {
+		return writeJSON(os.Stdout, man)
+	}
+	if err := writeJSONFile(*manifest, man); err != nil {
+		return err
+	}
+	printSplit(os.Stdout, man)
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// gridRange describes an inclusive start:end:step range of input values.
+type gridRange struct {
+	Start, End, Step float64
+}
+
+// parseGridRange parses "start:end" or "start:end:step". The step defaults to 1.
+func parseGridRange(s string) (gridRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return gridRange{}, fmt.Errorf("invalid range %q, want start:end[:step]", s)
+	}
+
+	values := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return gridRange{}, fmt.Errorf("invalid range %q: %v", s, err)
+		}
+		values[i] = v
+	}
+
+	r := gridRange{Start: values[0], End: values[1], Step: 1}
+	if len(values) == 3 {
+		r.Step = values[2]
+	}
+	if r.Step <= 0 {
+		return gridRange{}, fmt.Errorf("invalid range %q: step must be positive", s)
+	}
+	if r.End < r.Start {
+		return gridRange{}, fmt.Errorf("invalid range %q: end is before start", s)
+	}
+	return r, nil
+}
+
+// Values expands the range into its grid points. Points are computed from the
+// start by multiplication so that floating point steps do not drift.
+func (r gridRange) Values() []float64 {
+	n := int((r.End-r.Start)/r.Step+1e-9) + 1
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = r.Start + float64(i)*r.Step
+	}
+	return values
+}
+
+func runSweep(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	days := fs.String("days", "1:14", "trip duration range start:end[:step]")
+	miles := fs.String("miles", "0:2000:100", "miles traveled range start:end[:step]")
+	receipts := fs.String("receipts", "0:2500:100", "receipts amount range start:end[:step]")
+	out := fs.String("out", "", "output CSV path (default stdout)")
+	jobs := fs.Int("jobs", 0, "prediction workers (0 uses every CPU)")
+	var model modelFlags
+	model.register(fs)
+	var privacy privacyFlags
+	privacy.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := privacy.validate(); err != nil {
+		return err
+	}
+
+	dayRange, err := parseGridRange(*days)
+	if err != nil {
+		return err
+	}
+	if dayRange.Start != math.Trunc(dayRange.Start) || dayRange.Step != math.Trunc(dayRange.Step) {
+		return fmt.Errorf("invalid range %q: days start and step must be whole numbers", *days)
+	}
+	mileRange, err := parseGridRange(*miles)
+	if err != nil {
+		return err
+	}
+	receiptRange, err := parseGridRange(*receipts)
+	if err != nil {
+		return err
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer predictor.Close()
+	noise := privacy.noise(predictor.Training)
+	if noise != nil {
+		fmt.Fprintln(os.Stderr, noise)
+	}
+
+	if *out == "" {
+		return writeSweep(os.Stdout, predictor, dayRange, mileRange, receiptRange, noise, *jobs)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+	err = writeSweep(buf, predictor, dayRange, mileRange, receiptRange, noise, *jobs)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sweepChunkSize is the number of grid points a sweep worker predicts and
+// formats at a time.
+const sweepChunkSize = 4096
+
+// sweepChunk is a run of grid points, by index in row order, and its CSV rows
+// once formatted.
+type sweepChunk struct {
+	lo, hi int
+	rows   bytes.Buffer
+	err    error
+	done   chan struct{}
+}
+
+// writeSweep evaluates the model at every point of the grid and writes one
+// CSV row per point, days varying slowest and receipts fastest. Chunks of
+// points are predicted on up to jobs goroutines (every CPU when jobs is 0)
+// and written in grid order, with at most twice jobs chunks held at once.
+// Predictions are perturbed by noise unless it is nil. It fails, leaving the
+// rows so far written, once p reports an error.
+func writeSweep(w io.Writer, p *Predictor, days, miles, receipts gridRange, noise *laplaceNoise, jobs int) error {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	dayValues, mileValues, receiptValues := days.Values(), miles.Values(), receipts.Values()
+	total := len(dayValues) * len(mileValues) * len(receiptValues)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"trip_duration_days", "miles_traveled", "total_receipts_amount", "reimbursement"}); err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	fill := func(c *sweepChunk) {
+		defer close(c.done)
+		cw := csv.NewWriter(&c.rows)
+		var rng *rand.Rand
+		if noise != nil {
+			rng = noise.rng(uint64(c.lo))
+		}
+		for i := c.lo; i < c.hi; i++ {
+			r := receiptValues[i%len(receiptValues)]
+			m := mileValues[i/len(receiptValues)%len(mileValues)]
+			tripDays := int(dayValues[i/(len(receiptValues)*len(mileValues))])
+			prediction := p.Predict(tripDays, m, r)
+			if noise != nil {
+				prediction = noise.add(rng, prediction)
+			}
+			row := []string{
+				strconv.Itoa(tripDays),
+				strconv.FormatFloat(m, 'f', -1, 64),
+				strconv.FormatFloat(r, 'f', 2, 64),
+				strconv.FormatFloat(prediction, 'f', 2, 64),
+			}
+			if c.err = cw.Write(row); c.err != nil {
+				return
+			}
+		}
+		cw.Flush()
+		c.err = cw.Error()
+	}
+
+	pending := make(chan *sweepChunk, 2*jobs)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(pending)
+		workers := make(chan struct{}, jobs)
+		for lo := 0; lo < total; lo += sweepChunkSize {
+			c := &sweepChunk{lo: lo, hi: min(lo+sweepChunkSize, total), done: make(chan struct{})}
+			select {
+			case pending <- c:
+			case <-quit:
+				return
+			}
+			workers <- struct{}{}
+			go func() {
+				defer func() { <-workers }()
+				fill(c)
+			}()
+		}
+	}()
+
+	for c := range pending {
+		<-c.done
+		if c.err != nil {
+			return c.err
+		}
+		// A failed model, such as an exec: program, predicts NaN; stop
+		// before writing its rows.
+		if err := p.Err(); err != nil {
+			return err
+		}
+		if _, err := c.rows.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
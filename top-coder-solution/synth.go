@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+)
+
+// copula is a Gaussian copula over the training inputs: each input keeps its
+// empirical marginal distribution, and their dependence, such as receipts
+// growing with trip length, is the correlation of their normal scores.
+type copula struct {
+	sorted [3][]float64  // each input's training values, ascending
+	l      [3][3]float64 // Cholesky factor of the normal-score correlation
+}
+
+// normalQuantile is the inverse of the standard normal CDF.
+func normalQuantile(u float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*u-1)
+}
+
+// normalCDF is the standard normal CDF.
+func normalCDF(z float64) float64 {
+	return 0.5 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+func fitCopula(training TrainingData) *copula {
+	n := len(training)
+	c := &copula{}
+	var scores [3][]float64
+	for j := range featureNames {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		values := make([]float64, n)
+		for i, tc := range training {
+			values[i] = caseFeatures(tc)[j]
+		}
+		sort.SliceStable(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+		scores[j] = make([]float64, n)
+		c.sorted[j] = make([]float64, n)
+		for rank, i := range order {
+			scores[j][i] = normalQuantile((float64(rank) + 0.5) / float64(n))
+			c.sorted[j][rank] = values[i]
+		}
+	}
+
+	// Normal scores have mean zero, so their correlation is their normalized
+	// inner product.
+	var corr [3][3]float64
+	for a := range 3 {
+		for b := range 3 {
+			for i := range n {
+				corr[a][b] += scores[a][i] * scores[b][i]
+			}
+		}
+	}
+	for a := range 3 {
+		for b := range 3 {
+			if a != b {
+				corr[a][b] /= math.Sqrt(corr[a][a] * corr[b][b])
+			}
+		}
+	}
+	for a := range 3 {
+		corr[a][a] = 1
+	}
+
+	for i := range 3 {
+		for j := 0; j <= i; j++ {
+			sum := corr[i][j]
+			for k := 0; k < j; k++ {
+				sum -= c.l[i][k] * c.l[j][k]
+			}
+			if i == j {
+				c.l[i][i] = math.Sqrt(math.Max(sum, 1e-12))
+			} else {
+				c.l[i][j] = sum / c.l[j][j]
+			}
+		}
+	}
+	return c
+}
+
+// quantile inverts input j's empirical CDF, interpolating between training
+// values.
+func (c *copula) quantile(j int, u float64) float64 {
+	s := c.sorted[j]
+	pos := math.Min(math.Max(u*float64(len(s))-0.5, 0), float64(len(s)-1))
+	i := int(pos)
+	if i == len(s)-1 {
+		return s[i]
+	}
+	return s[i] + (pos-float64(i))*(s[i+1]-s[i])
+}
+
+// sample draws an input, rounded like the training data: whole days and
+// miles and receipts to the cent.
+func (c *copula) sample(rng *rand.Rand) Query {
+	var e, v [3]float64
+	for i := range e {
+		e[i] = rng.NormFloat64()
+	}
+	for i := range v {
+		z := 0.0
+		for k := 0; k <= i; k++ {
+			z += c.l[i][k] * e[k]
+		}
+		v[i] = c.quantile(i, normalCDF(z))
+	}
+	return Query{
+		TripDurationDays:    int(math.Round(v[0])),
+		MilesTraveled:       math.Round(v[1]),
+		TotalReceiptsAmount: roundCents(v[2]),
+	}
+}
+
+func runSynth(args []string) error {
+	fs := flag.NewFlagSet("synth", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data whose distribution to match")
+	n := fs.Int("n", 10000, "number of inputs to generate")
+	seed := fs.Uint64("seed", 1, "random seed")
+	out := fs.String("out", "", "write the inputs to this path (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *n < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	training, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if len(training) == 0 {
+		return fmt.Errorf("no training data to fit")
+	}
+
+	c := fitCopula(training)
+	rng := rand.New(rand.NewPCG(*seed, *seed))
+	queries := make([]Query, *n)
+	for i := range queries {
+		queries[i] = c.sample(rng)
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "Generated %d inputs from %d training cases\n", *n, len(training))
+	}
+	return writeJSONFile(*out, queries)
+}
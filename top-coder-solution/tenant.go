@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPolicy names the model built from the server's own flags, which
+// answers requests that name no policy.
+const defaultPolicy = "default"
+
+// policyPrefix routes a request to a policy by URL path, as in
+// /policies/acme/predict. Requests may instead name the policy in their body.
+const policyPrefix = "/policies/{policy}"
+
+// tenant is one model served by the server: the default model, or a named
+// policy, such as a subsidiary's reimbursement rules, served alongside it.
+// Each tenant loads, reloads and counts its requests independently.
+type tenant struct {
+	name    string
+	model   atomic.Pointer[serving] // nil until the model has loaded
+	load    func() (*Predictor, error)
+	sources []string // files -watch reloads the tenant on
+	reloads sync.Mutex
+	metrics tenantMetrics
+
+	// loadShadow, when set, builds the shadow model for each predictor
+	// load returns.
+	loadShadow func(primary *Predictor) (*Predictor, error)
+}
+
+// tenantMetrics counts a tenant's predictions and reloads.
+type tenantMetrics struct {
+	predictions    atomic.Int64
+	refused        atomic.Int64 // queries refused as invalid input
+	failures       atomic.Int64
+	predictNanos   atomic.Int64
+	reloads        atomic.Int64
+	reloadFailures atomic.Int64
+}
+
+func newTenant(name string, load func() (*Predictor, error), sources []string) *tenant {
+	return &tenant{name: name, load: load, sources: sources}
+}
+
+// logPrefix prefixes the log messages about a tenant other than the default.
+func (t *tenant) logPrefix() string {
+	if t.name == defaultPolicy {
+		return ""
+	}
+	return "policy " + t.name + ": "
+}
+
+// ready returns the tenant's serving state, or responds 503 and returns nil
+// while its model is still loading.
+func (t *tenant) ready(w http.ResponseWriter) *serving {
+	m := t.model.Load()
+	if m == nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, "model is still loading")
+	}
+	return m
+}
+
+// addPolicy serves the model load builds as the named policy. Policies must
+// be added before the server handles requests.
+func (s *Server) addPolicy(name string, load func() (*Predictor, error), sources []string) {
+	s.tenants[name] = newTenant(name, load, sources)
+}
+
+// defaultTenant returns the tenant answering requests that name no policy.
+func (s *Server) defaultTenant() *tenant {
+	return s.tenants[defaultPolicy]
+}
+
+// sortedTenants returns the tenants in name order.
+func (s *Server) sortedTenants() []*tenant {
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	ts := make([]*tenant, len(names))
+	for i, name := range names {
+		ts[i] = s.tenants[name]
+	}
+	return ts
+}
+
+// tenantFor returns the tenant r names by its URL path or, failing that, by
+// the policy of its body, or the default tenant when it names neither. It
+// responds with an error and returns nil for an unknown policy or
+// conflicting names.
+func (s *Server) tenantFor(w http.ResponseWriter, r *http.Request, policy string) *tenant {
+	name := r.PathValue("policy")
+	if name != "" && policy != "" && name != policy {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("policy %q in the body conflicts with %q in the path", policy, name))
+		return nil
+	}
+	if name == "" {
+		name = policy
+	}
+	if name == "" {
+		name = defaultPolicy
+	}
+	t := s.tenants[name]
+	if t == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown policy %q", name))
+	}
+	return t
+}
+
+// parsePolicies parses the -policies list of profile names.
+func parsePolicies(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("empty policy name in -policies")
+		case name == defaultPolicy:
+			return nil, fmt.Errorf("policy name %q is reserved for the model of the server's own flags", defaultPolicy)
+		case slices.Contains(names, name):
+			return nil, fmt.Errorf("policy %q is listed twice", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// withProfile returns a copy of m with the model flags of p applied over
+// it, then those of any policy version p selects from the config file at
+// configPath, for serving a policy described by a profile.
+func (m modelFlags) withProfile(p Profile, configPath string) (modelFlags, error) {
+	fs := flag.NewFlagSet("policy", flag.ContinueOnError)
+	var policy modelFlags
+	policy.register(fs)
+	// The flags write to policy's fields, so start them from m's values,
+	// except the server's own policy version: the profile selects its own.
+	policy = m
+	policy.policyVersion, policy.effectiveDate = "", ""
+	if err := (Profile{Flags: p.Flags}).apply(fs); err != nil {
+		return modelFlags{}, err
+	}
+	if err := applyPolicyVersion(fs, configPath); err != nil {
+		return modelFlags{}, err
+	}
+	return policy, nil
+}
+
+// metricSeries are the per-tenant series GET /metrics reports.
+var metricSeries = []struct {
+	name, kind, help string
+	value            func(t *tenant) float64
+}{
+	{"reimbursement_predictions_total", "counter", "Queries answered.",
+		func(t *tenant) float64 { return float64(t.metrics.predictions.Load()) }},
+	{"reimbursement_refused_total", "counter", "Queries refused as invalid input.",
+		func(t *tenant) float64 { return float64(t.metrics.refused.Load()) }},
+	{"reimbursement_prediction_failures_total", "counter", "Queries that failed with a server error.",
+		func(t *tenant) float64 { return float64(t.metrics.failures.Load()) }},
+	{"reimbursement_prediction_seconds_total", "counter", "Time spent answering queries.",
+		func(t *tenant) float64 { return time.Duration(t.metrics.predictNanos.Load()).Seconds() }},
+	{"reimbursement_reloads_total", "counter", "Models loaded.",
+		func(t *tenant) float64 { return float64(t.metrics.reloads.Load()) }},
+	{"reimbursement_reload_failures_total", "counter", "Model loads that failed, keeping the model before.",
+		func(t *tenant) float64 { return float64(t.metrics.reloadFailures.Load()) }},
+	{"reimbursement_model_cases", "gauge", "Training cases of the model served, 0 while loading.",
+		func(t *tenant) float64 {
+			if m := t.model.Load(); m != nil {
+				return float64(len(m.predictor.Training))
+			}
+			return 0
+		}},
+	{"reimbursement_model_loaded_timestamp_seconds", "gauge", "When the model served was loaded, 0 while loading.",
+		func(t *tenant) float64 {
+			if m := t.model.Load(); m != nil {
+				return float64(m.loadedAt.UnixNano()) / 1e9
+			}
+			return 0
+		}},
+}
+
+// handleMetrics reports each tenant's metrics in the Prometheus text format,
+// labelled by policy.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	tenants := s.sortedTenants()
+	for _, series := range metricSeries {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", series.name, series.help, series.name, series.kind)
+		for _, t := range tenants {
+			fmt.Fprintf(w, "%s{policy=%q} %g\n", series.name, t.name, series.value(t))
+		}
+	}
+}
+
+// reloadAll reloads every tenant, returning the first failure.
+func (s *Server) reloadAll() error {
+	var first error
+	for _, t := range s.sortedTenants() {
+		if _, err := s.reload(t); err != nil && first == nil {
+			first = fmt.Errorf("%s%v", t.logPrefix(), err)
+		}
+	}
+	return first
+}
+
+// logServing logs the model t has started serving.
+func logServing(t *tenant, m *serving) {
+	p := m.predictor
+	log.Printf("%sserving model %s (%d cases, data %.12s)", t.logPrefix(), p.Version, len(p.Training), p.DataSHA256)
+	if m.shadow != nil {
+		log.Printf("%sshadowing with model %s %s", t.logPrefix(), m.shadow.Version, m.shadow.Model)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// ruleSSE fits the rule model with cfg's tiers and bands and returns its
+// summed squared error over training and the fitted config.
+func ruleSSE(training TrainingData, cfg RuleConfig) (float64, RuleConfig) {
+	m := &ruleModel{Config: cfg}
+	m.Fit(training)
+	sse := 0.0
+	for _, c := range training {
+		d := m.Predict(c.Input) - c.ExpectedOutput
+		sse += d * d
+	}
+	return sse, m.Config
+}
+
+// estimateMileageTiers chooses the given number of mileage breakpoints,
+// among quantiles of the training miles, to minimize the rule model's squared
+// error, and returns the fitted config and its RMSE. It searches by
+// coordinate descent: each breakpoint in turn moves to the candidate fitting
+// best with the others held, until none moves.
+func estimateMileageTiers(training TrainingData, breakpoints, candidates int) (RuleConfig, float64) {
+	miles := make([]float64, len(training))
+	for i, c := range training {
+		miles[i] = c.Input.MilesTraveled
+	}
+	sort.Float64s(miles)
+	var grid []float64
+	for i := 1; i < candidates; i++ {
+		v := math.Round(miles[i*(len(miles)-1)/candidates])
+		if v > 0 && (len(grid) == 0 || v > grid[len(grid)-1]) {
+			grid = append(grid, v)
+		}
+	}
+	breakpoints = min(breakpoints, len(grid))
+
+	// Start from evenly spaced candidates.
+	chosen := make([]int, breakpoints)
+	for i := range chosen {
+		chosen[i] = (i + 1) * len(grid) / (breakpoints + 1)
+	}
+	config := func(chosen []int) RuleConfig {
+		cfg := RuleConfig{Mileage: []RateTier{{From: 0}}}
+		for _, g := range chosen {
+			cfg.Mileage = append(cfg.Mileage, RateTier{From: grid[g]})
+		}
+		return cfg
+	}
+	best, fitted := ruleSSE(training, config(chosen))
+	for moved := true; moved; {
+		moved = false
+		for i := range chosen {
+			lo, hi := 0, len(grid)-1
+			if i > 0 {
+				lo = chosen[i-1] + 1
+			}
+			if i+1 < len(chosen) {
+				hi = chosen[i+1] - 1
+			}
+			for g := lo; g <= hi; g++ {
+				if g == chosen[i] {
+					continue
+				}
+				trial := append([]int(nil), chosen...)
+				trial[i] = g
+				if sse, cfg := ruleSSE(training, config(trial)); sse < best {
+					best, fitted, chosen, moved = sse, cfg, trial, true
+				}
+			}
+		}
+	}
+	return fitted, math.Sqrt(best / float64(len(training)))
+}
+
+func printMileageTiers(w io.Writer, cfg RuleConfig, rmse float64) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Miles\tRate per mile")
+	for i, t := range cfg.Mileage {
+		to := "and above"
+		if i+1 < len(cfg.Mileage) {
+			to = fmt.Sprintf("to %g", cfg.Mileage[i+1].From)
+		}
+		fmt.Fprintf(tw, "%g %s\t%.4f\n", t.From, to, t.Rate)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "RMSE of the rule model with these tiers: $%.2f\n", rmse)
+}
+
+func runEstimateTiers(args []string) error {
+	fs := flag.NewFlagSet("estimate-tiers", flag.ContinueOnError)
+	dataPath := fs.String("data", defaultDataPath, "training data path or s3:// or gs:// URI")
+	breakpoints := fs.Int("breakpoints", 2, "number of mileage breakpoints, so tiers is one more")
+	candidates := fs.Int("candidates", 40, "number of mileage quantiles tried as breakpoints")
+	out := fs.String("out", "", "write the rule config to this path, for -rule-config (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *breakpoints < 1 || *candidates < 2 {
+		return fmt.Errorf("-breakpoints must be at least 1 and -candidates at least 2")
+	}
+	trainingData, err := loadTrainingData(*dataPath)
+	if err != nil {
+		return fmt.Errorf("loading training data: %v", err)
+	}
+	if len(trainingData) == 0 {
+		return fmt.Errorf("no training cases")
+	}
+
+	cfg, rmse := estimateMileageTiers(trainingData, *breakpoints, *candidates)
+	printMileageTiers(os.Stderr, cfg, rmse)
+	return writeJSONFile(*out, cfg)
+}
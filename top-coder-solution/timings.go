@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// processStart is when the process started, near enough: the start of
+// argument parsing.
+var processStart = time.Now()
+
+// Phases of a run that -timings reports.
+const (
+	phaseParse   = iota // parsing the arguments and flags, and applying profiles
+	phaseLoad           // reading the training data, and any cases read before it
+	phaseIndex          // building the predictor: preparing features and the neighbor index
+	phasePredict        // the rest of the run: predicting, and the command's other work
+	phaseCount
+)
+
+// Timings are the wall times of a run's phases in seconds, as -timings
+// writes them to stderr on exit, one JSON object on one line:
+//
+//	{"timings":{"parse_seconds":0.0004,"load_seconds":0.0061,"index_seconds":0.0013,"predict_seconds":0.0002,"total_seconds":0.008}}
+//
+// A phase the run did not go through is 0.
+type Timings struct {
+	Parse   float64 `json:"parse_seconds"`
+	Load    float64 `json:"load_seconds"`
+	Index   float64 `json:"index_seconds"`
+	Predict float64 `json:"predict_seconds"`
+	Total   float64 `json:"total_seconds"`
+}
+
+// phaseTimer times the phases of a run. Each phase ends when end is called
+// with it, taking the time since the previous phase ended, so time between
+// phases is not lost but counted in the phase that follows.
+type phaseTimer struct {
+	last    time.Time
+	seconds [phaseCount]float64
+	built   bool // a predictor has been built
+}
+
+// timer, set by -timings, times the run's phases; nil when not timing.
+var timer *phaseTimer
+
+// startTimings makes the run report its timings on exit, timing from the
+// start of the process.
+func startTimings() {
+	if timer == nil {
+		timer = &phaseTimer{last: processStart}
+	}
+}
+
+// end ends phase now. It does nothing on a nil timer.
+func (t *phaseTimer) end(phase int) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.seconds[phase] += now.Sub(t.last).Seconds()
+	t.last = now
+	if phase == phaseIndex {
+		t.built = true
+	}
+}
+
+// beginLoad starts loading a predictor's data. The work since a predictor
+// was last built, if one was, ends as prediction, so a run building
+// several predictors does not count the work between them as loading.
+func (t *phaseTimer) beginLoad() {
+	if t != nil && t.built {
+		t.end(phasePredict)
+	}
+}
+
+// timings ends the prediction phase and returns the timings so far.
+func (t *phaseTimer) timings() Timings {
+	t.end(phasePredict)
+	round := func(s float64) float64 { return roundDecimal(s, 6) }
+	return Timings{
+		Parse:   round(t.seconds[phaseParse]),
+		Load:    round(t.seconds[phaseLoad]),
+		Index:   round(t.seconds[phaseIndex]),
+		Predict: round(t.seconds[phasePredict]),
+		Total:   round(t.last.Sub(processStart).Seconds()),
+	}
+}
+
+// reportTimings writes the run's timings to w when -timings is set.
+func reportTimings(w io.Writer) {
+	if timer == nil {
+		return
+	}
+	line, err := json.Marshal(struct {
+		Timings Timings `json:"timings"`
+	}{timer.timings()})
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", line)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// featureNames names the components of a featureVector.
+var featureNames = [3]string{"days", "miles", "receipts"}
+
+// treeNode is a node of a regression tree. Leaves have Left == nil.
+type treeNode struct {
+	Feature   int     // index into featureVector
+	Threshold float64 // cases with feature <= Threshold go left
+	Left      *treeNode
+	Right     *treeNode
+	Value     float64 // mean output of the cases reaching this node
+	Count     int
+}
+
+func (n *treeNode) isLeaf() bool {
+	return n.Left == nil
+}
+
+// treeParams bounds the growth of a regression tree.
+type treeParams struct {
+	MaxDepth int
+	MinLeaf  int
+}
+
+// fitRegressionTree grows a CART regression tree that greedily minimizes the
+// squared error of the outputs at each split.
+func fitRegressionTree(training TrainingData, params treeParams) *treeNode {
+	idx := make([]int, len(training))
+	for i := range idx {
+		idx[i] = i
+	}
+	features := make([]featureVector, len(training))
+	outputs := make([]float64, len(training))
+	for i, c := range training {
+		features[i] = caseFeatures(c)
+		outputs[i] = c.ExpectedOutput
+	}
+	return growTree(features, outputs, idx, params, 0)
+}
+
+func growTree(features []featureVector, outputs []float64, idx []int, params treeParams, depth int) *treeNode {
+	node := &treeNode{Count: len(idx)}
+	for _, i := range idx {
+		node.Value += outputs[i]
+	}
+	if len(idx) > 0 {
+		node.Value /= float64(len(idx))
+	}
+	if depth >= params.MaxDepth || len(idx) < 2*params.MinLeaf {
+		return node
+	}
+
+	feature, threshold, ok := bestSplit(features, outputs, idx, params.MinLeaf)
+	if !ok {
+		return node
+	}
+
+	var left, right []int
+	for _, i := range idx {
+		if features[i][feature] <= threshold {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+
+	node.Feature = feature
+	node.Threshold = threshold
+	node.Left = growTree(features, outputs, left, params, depth+1)
+	node.Right = growTree(features, outputs, right, params, depth+1)
+	return node
+}
+
+// bestSplit finds the feature and threshold minimizing the summed squared
+// error of both children, considering midpoints between distinct values.
+func bestSplit(features []featureVector, outputs []float64, idx []int, minLeaf int) (int, float64, bool) {
+	bestFeature, bestThreshold := 0, 0.0
+	bestSSE := math.Inf(1)
+	found := false
+
+	sorted := make([]int, len(idx))
+	for f := range featureNames {
+		copy(sorted, idx)
+		sort.Slice(sorted, func(a, b int) bool {
+			return features[sorted[a]][f] < features[sorted[b]][f]
+		})
+
+		totalSum, totalSq := 0.0, 0.0
+		for _, i := range sorted {
+			totalSum += outputs[i]
+			totalSq += outputs[i] * outputs[i]
+		}
+
+		leftSum, leftSq := 0.0, 0.0
+		n := len(sorted)
+		for pos := 0; pos < n-1; pos++ {
+			y := outputs[sorted[pos]]
+			leftSum += y
+			leftSq += y * y
+
+			nLeft := pos + 1
+			nRight := n - nLeft
+			if nLeft < minLeaf || nRight < minLeaf {
+				continue
+			}
+			x, next := features[sorted[pos]][f], features[sorted[pos+1]][f]
+			if x == next {
+				continue
+			}
+
+			rightSum, rightSq := totalSum-leftSum, totalSq-leftSq
+			sse := leftSq - leftSum*leftSum/float64(nLeft) + rightSq - rightSum*rightSum/float64(nRight)
+			if sse < bestSSE {
+				bestSSE = sse
+				bestFeature = f
+				bestThreshold = (x + next) / 2
+				found = true
+			}
+		}
+	}
+
+	return bestFeature, bestThreshold, found
+}
+
+// predict returns the leaf value reached by v.
+func (n *treeNode) predict(v featureVector) float64 {
+	for !n.isLeaf() {
+		if v[n.Feature] <= n.Threshold {
+			n = n.Left
+		} else {
+			n = n.Right
+		}
+	}
+	return n.Value
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// TuneResult is the cross-validated accuracy of one grid configuration.
+type TuneResult struct {
+	Hyperparameters Hyperparameters `json:"hyperparameters"`
+	Summary         EvalSummary     `json:"summary"`
+	Cost            float64         `json:"cost,omitempty"` // mean business cost, when tuned with a cost function
+}
+
+// tune cross-validates every configuration in grid over training on up to
+// workers goroutines, returning results in grid order. Configurations run
+// side by side, and the workers left over split each configuration's folds.
+// Results are priced by cost unless it is nil, and passed to finished, when
+// set, as each configuration finishes. Once ctx is done no configuration
+// starts and those running stop, and tune returns ctx's error with the
+// results incomplete.
+func tune(ctx context.Context, training TrainingData, grid []Hyperparameters, folds []int, workers int, cost *CostFunction,
+	prog *progress, finished func(TuneResult)) ([]TuneResult, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	parallel := max(min(workers, len(grid)), 1)
+	results := make([]TuneResult, len(grid))
+	err := forEachContext(ctx, len(grid), parallel, func(i int) {
+		p := NewPredictor(training, grid[i])
+		cv, err := crossValidateContext(ctx, p, folds, max(workers/parallel, 1), prog)
+		if err != nil {
+			return
+		}
+		results[i] = TuneResult{Hyperparameters: grid[i], Summary: summarize(cv)}
+		if cost != nil {
+			results[i].Cost = cost.meanCost(cv)
+		}
+		if finished != nil {
+			finished(results[i])
+		}
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	return results, err
+}
+
+func runTune(args []string) error {
+	fs := flag.NewFlagSet("tune", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	ks := fs.String("ks", "1,3,5,7,10,15,20", "comma-separated neighbor counts to try (overrides -k)")
+	metrics := fs.String("metrics", metricEuclidean, "comma-separated distance metrics to try (overrides -metric)")
+	folds := fs.Int("folds", 0, "k-fold cross-validation (0 uses leave-one-out)")
+	seed := fs.Uint64("seed", 1, "random seed for assigning -folds")
+	jobs := fs.Int("jobs", 0, "workers shared by configurations and their folds (0 uses every CPU)")
+	showProgress := fs.Bool("progress", true, "report progress on stderr")
+	var costs costFlag
+	costs.register(fs)
+	checkpointPath := fs.String("checkpoint", "", "record each configuration as it finishes in this file")
+	resume := fs.Bool("resume", false, "skip the configurations -checkpoint records as finished by an interrupted run")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	cost, err := costs.function()
+	if err != nil {
+		return err
+	}
+	if *resume && *checkpointPath == "" {
+		return fmt.Errorf("-resume requires -checkpoint")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	kValues, err := parseIntList(*ks)
+	if err != nil {
+		return err
+	}
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	training := predictor.Training
+	if *folds == 1 || *folds > len(training) {
+		return fmt.Errorf("-folds must be between 2 and the number of cases")
+	}
+
+	var grid []Hyperparameters
+	for _, metric := range strings.Split(*metrics, ",") {
+		for _, k := range kValues {
+			hp := predictor.Hyperparameters()
+			hp.K, hp.Metric = k, strings.TrimSpace(metric)
+			if err := hp.validate(); err != nil {
+				return fmt.Errorf("k=%d metric=%s: %v", k, hp.Metric, err)
+			}
+			grid = append(grid, hp)
+		}
+	}
+
+	assigned := leaveOneOutFolds(len(training))
+	if *folds > 0 {
+		assigned = randomFolds(len(training), *folds, *seed)
+	}
+	results := make([]TuneResult, len(grid))
+	pending := grid
+	var finished func(TuneResult)
+	if *checkpointPath != "" {
+		header := TuneCheckpointHeader{DataSHA256: predictor.DataSHA256, Cases: len(training), Folds: *folds, Seed: *seed, Cost: costs.spec}
+		if *folds == 0 {
+			header.Seed = 0
+		}
+		cp, done, err := openTuneCheckpoint(*checkpointPath, header, *resume)
+		if err != nil {
+			return err
+		}
+		defer cp.Close()
+		pending = nil
+		for i, hp := range grid {
+			if r, ok := done[tuneKey(hp)]; ok {
+				results[i] = r
+			} else {
+				pending = append(pending, hp)
+			}
+		}
+		if *resume {
+			fmt.Fprintf(os.Stderr, "resuming: %d of %d configurations finished\n", len(grid)-len(pending), len(grid))
+		}
+		finished = func(r TuneResult) {
+			if err := cp.record(r); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: checkpoint: %v\n", err)
+			}
+		}
+	}
+
+	var prog *progress
+	if *showProgress {
+		prog = startProgress(os.Stderr, fmt.Sprintf("tune (%d configurations)", len(pending)),
+			len(pending)*len(training), 5*time.Second)
+	}
+	ran, err := tune(ctx, training, pending, assigned, *jobs, cost, prog, finished)
+	prog.stop()
+	if err != nil {
+		if *checkpointPath != "" {
+			return fmt.Errorf("tune stopped: %v; rerun with -resume to continue from %s", err, *checkpointPath)
+		}
+		return fmt.Errorf("tune stopped: %v", err)
+	}
+	for i := range results {
+		if len(ran) > 0 && tuneKey(ran[0].Hyperparameters) == tuneKey(grid[i]) {
+			results[i], ran = ran[0], ran[1:]
+		}
+	}
+
+	// With a cost function, the configurations rank by what errors cost
+	// rather than by their size.
+	slices.SortStableFunc(results, func(a, b TuneResult) int {
+		if cost != nil {
+			return compareDist(a.Cost, b.Cost)
+		}
+		return compareDist(a.Summary.MeanError, b.Summary.MeanError)
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "K\tMETRIC\tAVG ERROR\tRMSE\tMAX ERROR\tSCORE"
+	if cost != nil {
+		header += "\tCOST"
+	}
+	fmt.Fprintln(w, header)
+	for _, r := range results {
+		metric := r.Hyperparameters.Metric
+		if metric == "" {
+			metric = metricEuclidean
+		}
+		fmt.Fprintf(w, "%d\t%s\t$%.2f\t$%.2f\t$%.2f\t%.2f", r.Hyperparameters.K, metric,
+			r.Summary.MeanError, r.Summary.RMSE, r.Summary.MaxError, r.Summary.Score())
+		if cost != nil {
+			fmt.Fprintf(w, "\t$%.2f", r.Cost)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Verbosity levels of the diagnostics a prediction writes to stderr. Stdout
+// carries only the prediction at every level, so scripts reading it work
+// the same whatever the level.
+const (
+	verbosityQuiet  = -1 // errors only, no warnings
+	verbosityNormal = 0  // warnings
+	verbosityInfo   = 1  // -v: also the data loaded and the time taken
+	verbosityDebug  = 2  // -vv: also the neighbors behind the prediction
+)
+
+type verbosityFlags struct {
+	v, vv, quiet bool
+}
+
+func (f *verbosityFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.v, "v", false, "describe the data loaded and the time taken on stderr")
+	fs.BoolVar(&f.vv, "vv", false, "like -v, also listing the neighbors behind the prediction")
+	fs.BoolVar(&f.quiet, "quiet", false, "write nothing to stderr but errors")
+}
+
+func (f verbosityFlags) level() int {
+	switch {
+	case f.quiet:
+		return verbosityQuiet
+	case f.vv:
+		return verbosityDebug
+	case f.v:
+		return verbosityInfo
+	}
+	return verbosityNormal
+}
+
+// cutPositionalFlags removes the verbosity, -dry-run and -timings flags
+// leading args, for the positional interface, which cannot parse flags in
+// general: a negative input would look like one.
+func cutPositionalFlags(args []string) (level int, dryRun, timings bool, rest []string) {
+	var f verbosityFlags
+	for ; len(args) > 0; args = args[1:] {
+		switch args[0] {
+		case "-v", "--v":
+			f.v = true
+		case "-vv", "--vv":
+			f.vv = true
+		case "-quiet", "--quiet":
+			f.quiet = true
+		case "-dry-run", "--dry-run":
+			dryRun = true
+		case "-timings", "--timings":
+			timings = true
+		default:
+			return f.level(), dryRun, timings, args
+		}
+	}
+	return f.level(), dryRun, timings, args
+}
+
+// diagnostics writes messages at or below a verbosity level.
+type diagnostics struct {
+	w     io.Writer
+	level int
+	start time.Time
+}
+
+func newDiagnostics(w io.Writer, level int) *diagnostics {
+	return &diagnostics{w: w, level: level, start: time.Now()}
+}
+
+func (d *diagnostics) enabled(level int) bool {
+	return d.level >= level
+}
+
+func (d *diagnostics) printf(level int, format string, args ...any) {
+	if d.enabled(level) {
+		fmt.Fprintf(d.w, format, args...)
+	}
+}
+
+// elapsed returns the time since d was created, rounded for display.
+func (d *diagnostics) elapsed() time.Duration {
+	return time.Since(d.start).Round(time.Microsecond)
+}
@@ -0,0 +1,104 @@
+package main
+
+import "slices"
+
+// vpTree is an exact neighbor index for any metric. Each node picks a
+// vantage point and splits the remaining cases at their median distance mu
+// from it; by the triangle inequality a query at distance d from the vantage
+// point only needs the inner half if d-mu is within the current kth neighbor
+// distance, and the outer half if mu-d is.
+type vpTree struct {
+	points  []featureVector
+	outputs []float64
+	metric  distanceMetric
+	order   []int32
+	nodes   []vpNode
+}
+
+type vpNode struct {
+	vantage      int32   // case index, -1 at leaves
+	mu           float64 // median distance from the vantage point
+	start, end   int     // leaf range of order
+	inner, outer int32
+}
+
+const vpLeafSize = 8
+
+func newVPTree(data TrainingData, metric distanceMetric) *vpTree {
+	t := &vpTree{
+		points:  make([]featureVector, len(data)),
+		outputs: make([]float64, len(data)),
+		metric:  metric,
+		order:   make([]int32, len(data)),
+	}
+	for i, c := range data {
+		t.points[i] = caseFeatures(c)
+		t.outputs[i] = c.ExpectedOutput
+		t.order[i] = int32(i)
+	}
+	if len(data) > 0 {
+		dists := make([]float64, len(data))
+		t.build(0, len(data), dists)
+	}
+	return t
+}
+
+func (t *vpTree) build(start, end int, dists []float64) int32 {
+	idx := int32(len(t.nodes))
+	t.nodes = append(t.nodes, vpNode{vantage: -1, start: start, end: end, inner: -1, outer: -1})
+	ids := t.order[start:end]
+	if len(ids) <= vpLeafSize {
+		return idx
+	}
+
+	// The first case is the vantage point; the rest are split at the median
+	// of their distances to it.
+	vp := ids[0]
+	rest := ids[1:]
+	for _, id := range rest {
+		dists[id] = t.metric.distance(t.points[vp], t.points[id])
+	}
+	slices.SortFunc(rest, func(a, b int32) int { return compareDist(dists[a], dists[b]) })
+	mid := len(rest) / 2
+
+	t.nodes[idx].vantage = vp
+	t.nodes[idx].mu = dists[rest[mid]]
+	inner := t.build(start+1, start+1+mid, dists)
+	outer := t.build(start+1+mid, end, dists)
+	t.nodes[idx].inner, t.nodes[idx].outer = inner, outer
+	return idx
+}
+
+func (t *vpTree) search(dst []Neighbor, q featureVector, k int) []Neighbor {
+	k = min(k, len(t.points))
+	if k == 0 {
+		return dst
+	}
+	return append(dst, t.visit(dst[len(dst):], 0, q, k)...)
+}
+
+func (t *vpTree) visit(nearest []Neighbor, node int32, q featureVector, k int) []Neighbor {
+	n := &t.nodes[node]
+	if n.vantage < 0 {
+		for _, id := range t.order[n.start:n.end] {
+			nearest = insertNeighbor(nearest, k, Neighbor{Distance: t.metric.distance(q, t.points[id]), Output: t.outputs[id], Case: int(id)})
+		}
+		return nearest
+	}
+
+	d := t.metric.distance(q, t.points[n.vantage])
+	nearest = insertNeighbor(nearest, k, Neighbor{Distance: d, Output: t.outputs[n.vantage], Case: int(n.vantage)})
+	within := func(gap float64) bool { return len(nearest) < k || gap < nearest[len(nearest)-1].Distance }
+	if d < n.mu {
+		nearest = t.visit(nearest, n.inner, q, k)
+		if within(n.mu - d) {
+			nearest = t.visit(nearest, n.outer, q, k)
+		}
+	} else {
+		nearest = t.visit(nearest, n.outer, q, k)
+		if within(d - n.mu) {
+			nearest = t.visit(nearest, n.inner, q, k)
+		}
+	}
+	return nearest
+}
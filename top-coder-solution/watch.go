@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// fileStamp is what the watcher compares to detect a changed file.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func stampFiles(paths []string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	for i := range a {
+		if a[i].exists != b[i].exists || a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchFiles polls paths every interval and calls onChange once they have
+// changed and then stayed unchanged for debounce, so a file that is still
+// being written is not picked up half-way. It returns when ctx is done.
+func watchFiles(ctx context.Context, paths []string, interval, debounce time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stampFiles(paths)
+	var changedAt time.Time // zero while no change is pending
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cur := stampFiles(paths)
+			if !sameStamps(cur, last) {
+				last = cur
+				changedAt = now
+				continue
+			}
+			if !changedAt.IsZero() && now.Sub(changedAt) >= debounce {
+				changedAt = time.Time{}
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WorkerJob is a batch of queries consumed from the queue.
+type WorkerJob struct {
+	ID    string  `json:"id"`
+	Cases []Query `json:"cases"`
+}
+
+// WorkerResult answers a WorkerJob. Error is set instead of Predictions when
+// the job could not be processed.
+type WorkerResult struct {
+	ID          string               `json:"id"`
+	Predictions []PredictionResponse `json:"predictions,omitempty"`
+	Provenance  *Provenance          `json:"provenance,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// worker consumes jobs from a NATS queue group and publishes their results
+// to each job's reply subject, or to the results subject when it has none.
+// NATS does not redeliver, so jobs in flight when a worker dies are lost and
+// should be resubmitted by the publisher after a timeout.
+type worker struct {
+	predictor *Predictor
+	url       string
+	subject   string
+	queue     string
+	results   string
+	maxCases  int
+	retries   int
+	backoff   time.Duration
+
+	mu   sync.Mutex
+	conn *natsConn
+}
+
+func (w *worker) current() *natsConn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn
+}
+
+// connect dials and subscribes, retrying with exponential backoff.
+func (w *worker) connect(ctx context.Context) error {
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 && !sleepContext(ctx, w.backoff<<(attempt-1)) {
+			return ctx.Err()
+		}
+		var c *natsConn
+		if c, err = dialNATS(w.url); err == nil {
+			if err = c.subscribe(w.subject, w.queue, 1); err == nil {
+				w.mu.Lock()
+				w.conn = c
+				w.mu.Unlock()
+				return nil
+			}
+			c.Close()
+		}
+		log.Printf("connecting to NATS: %v", err)
+	}
+	return err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// process predicts every case of a job.
+func (w *worker) process(data []byte) WorkerResult {
+	var job WorkerJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return WorkerResult{Error: fmt.Sprintf("invalid job: %v", err)}
+	}
+	if w.maxCases > 0 && len(job.Cases) > w.maxCases {
+		return WorkerResult{ID: job.ID, Error: fmt.Sprintf("job of %d cases exceeds the limit of %d", len(job.Cases), w.maxCases)}
+	}
+	prov := w.predictor.Provenance(time.Now())
+	r := WorkerResult{ID: job.ID, Predictions: make([]PredictionResponse, len(job.Cases)), Provenance: &prov}
+	for i, q := range job.Cases {
+		y, err := predictChecked(context.Background(), w.predictor, q)
+		if err != nil {
+			return WorkerResult{ID: job.ID, Error: fmt.Sprintf("case %d: %v", i, err)}
+		}
+		r.Predictions[i] = PredictionResponse{Input: q, Reimbursement: y}
+	}
+	return r
+}
+
+// handle processes a job and publishes its result, retrying the publish
+// with exponential backoff while the connection is re-established.
+func (w *worker) handle(msg natsMsg) {
+	result := w.process(msg.Data)
+	subject := msg.Reply
+	if subject == "" {
+		subject = w.results
+	}
+	if subject == "" {
+		log.Printf("job %q: no reply subject and no -results subject; result dropped", result.ID)
+		return
+	}
+	if result.Error != "" {
+		log.Printf("job %q: %s", result.ID, result.Error)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("job %q: encoding result: %v", result.ID, err)
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		err := w.current().publish(subject, data)
+		if err == nil {
+			return
+		}
+		if attempt == w.retries {
+			log.Printf("job %q: publishing result: %v", result.ID, err)
+			return
+		}
+		time.Sleep(w.backoff << attempt)
+	}
+}
+
+// run consumes jobs on concurrency goroutines until ctx is done, then
+// finishes the jobs already received before returning.
+func (w *worker) run(ctx context.Context, concurrency int) error {
+	if err := w.connect(ctx); err != nil {
+		return err
+	}
+	log.Printf("consuming %s as queue group %s", w.subject, w.queue)
+
+	jobs := make(chan natsMsg, concurrency)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				w.handle(msg)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+		w.current().Close()
+	}()
+
+	for {
+		msgs, errc := make(chan natsMsg), make(chan error, 1)
+		conn := w.current()
+		go func() {
+			for {
+				msg, err := conn.next()
+				if err != nil {
+					errc <- err
+					return
+				}
+				select {
+				case msgs <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+	receive:
+		for {
+			select {
+			case msg := <-msgs:
+				select {
+				case jobs <- msg:
+				case <-ctx.Done():
+				}
+			case err := <-errc:
+				log.Printf("NATS connection lost: %v; reconnecting", err)
+				conn.Close()
+				break receive
+			case <-ctx.Done():
+				// Stop deliveries but keep the connection for the results
+				// of jobs in flight.
+				conn.send("UNSUB 1\r\n")
+				log.Printf("shutting down")
+				return nil
+			}
+		}
+		if err := w.connect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil // shutting down while reconnecting
+			}
+			return err
+		}
+	}
+}
+
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	var model modelFlags
+	model.register(fs)
+	natsURL := fs.String("nats", "nats://127.0.0.1:4222", "NATS server URL, as nats://[user:pass@]host[:port] or tls://...")
+	subject := fs.String("subject", "reimbursement.jobs", "subject jobs are published on, as {\"id\", \"cases\": [...]}")
+	queue := fs.String("queue", "reimbursement-workers", "queue group shared by workers, so each job is processed once")
+	results := fs.String("results", "reimbursement.results", "subject for results of jobs without a reply subject")
+	concurrency := fs.Int("concurrency", runtime.GOMAXPROCS(0), "jobs processed at once")
+	maxCases := fs.Int("max-job-cases", 10000, "maximum cases per job (0 for no limit)")
+	retries := fs.Int("retries", 5, "attempts after the first to reconnect or publish a result")
+	backoff := fs.Duration("retry-backoff", time.Second, "delay before the first retry, doubling after each")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("-concurrency must be at least 1")
+	}
+	if *retries < 0 || *backoff <= 0 {
+		return fmt.Errorf("-retries must not be negative and -retry-backoff must be positive")
+	}
+
+	predictor, err := model.build()
+	if err != nil {
+		return err
+	}
+	defer predictor.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	w := &worker{
+		predictor: predictor,
+		url:       *natsURL,
+		subject:   *subject,
+		queue:     *queue,
+		results:   *results,
+		maxCases:  *maxCases,
+		retries:   *retries,
+		backoff:   *backoff,
+	}
+	return w.run(ctx, *concurrency)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// zipMagic begins every zip archive, and so every .xlsx workbook.
+const zipMagic = "PK\x03\x04"
+
+// isWorkbook reports whether path is an Excel workbook rather than JSON or
+// packed data.
+func isWorkbook(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	magic := make([]byte, len(zipMagic))
+	n, _ := file.Read(magic)
+	return n == len(magic) && string(magic) == zipMagic, nil
+}
+
+// tableFlags select the worksheet and columns cases are read from in a
+// workbook or CSV file.
+type tableFlags struct {
+	sheet   string
+	columns string
+}
+
+func (f *tableFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.sheet, "sheet", "", "worksheet of an .xlsx workbook to read cases from (default the first)")
+	fs.StringVar(&f.columns, "columns", "",
+		"spreadsheet column of each field as field=column pairs, e.g. \"days=Trip Days,output=Amount\"; fields are "+
+			strings.Join(caseColumns, ", ")+" (default the JSON field names), or any other name for a named input field, e.g. \"department=Dept\"")
+}
+
+// loadWorkbookCases loads labelled cases from the selected worksheet of a
+// workbook.
+func (f *tableFlags) loadWorkbookCases(path string) (TrainingData, error) {
+	cases, labelled, err := f.readWorkbookCases(path)
+	if err != nil {
+		return nil, err
+	}
+	if !labelled {
+		m, _ := parseColumnMapping(f.columns)
+		return nil, fmt.Errorf("%s has no %q column of outputs", path, m[columnOutput])
+	}
+	if err := checkCases(cases); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cases, nil
+}
+
+// readWorkbookCases reads cases from the selected worksheet of a workbook.
+// They are labelled when the sheet has an output column.
+func (f *tableFlags) readWorkbookCases(path string) (TrainingData, bool, error) {
+	m, err := parseColumnMapping(f.columns)
+	if err != nil {
+		return nil, false, err
+	}
+	rows, err := readXLSX(path, f.sheet)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rows) == 0 {
+		return nil, false, fmt.Errorf("%s has no header row", path)
+	}
+	return parseTable(rows[0], rows[1:], m)
+}
+
+// The parts of SpreadsheetML that hold cell text.
+type (
+	xlsxWorkbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	xlsxRelationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	xlsxText struct {
+		T    string `xml:"t"`
+		Runs []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	}
+	xlsxSharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	xlsxWorksheet struct {
+		Rows []struct {
+			Index int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+)
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+// readXLSX returns the text of every cell of a worksheet, by row, with gaps
+// left by empty rows and cells filled with empty strings. The sheet is
+// chosen by name, or is the first when sheet is empty.
+func readXLSX(filename, sheet string) ([][]string, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	parts := map[string]*zip.File{}
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := parts[name]
+		if !ok {
+			return fmt.Errorf("%s: no %s in workbook", filename, name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err := xml.NewDecoder(r).Decode(v); err != nil && err != io.EOF {
+			return fmt.Errorf("%s: parsing %s: %v", filename, name, err)
+		}
+		return nil
+	}
+
+	var wb xlsxWorkbook
+	if err := decode("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationships
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	if len(wb.Sheets) == 0 {
+		return nil, fmt.Errorf("%s has no worksheets", filename)
+	}
+	id := wb.Sheets[0].ID
+	if sheet != "" {
+		id = ""
+		var names []string
+		for _, s := range wb.Sheets {
+			names = append(names, s.Name)
+			if s.Name == sheet {
+				id = s.ID
+			}
+		}
+		if id == "" {
+			return nil, fmt.Errorf("%s has no worksheet %q (sheets are %s)", filename, sheet, strings.Join(names, ", "))
+		}
+	}
+	var target string
+	for _, r := range rels.Relationships {
+		if r.ID == id {
+			target = r.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = path.Join("xl", target)
+	}
+
+	var shared xlsxSharedStrings
+	if _, ok := parts["xl/sharedStrings.xml"]; ok {
+		if err := decode("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+	var ws xlsxWorksheet
+	if err := decode(target, &ws); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range ws.Rows {
+		// Rows and cells without references follow the previous one.
+		if row.Index == 0 {
+			row.Index = len(rows) + 1
+		}
+		if row.Index < 1 || row.Index > xlsxMaxRows {
+			return nil, fmt.Errorf("%s: row %d is outside the sheet's rows 1 to %d", filename, row.Index, xlsxMaxRows)
+		}
+		for len(rows) < row.Index {
+			rows = append(rows, nil)
+		}
+		var cells []string
+		for _, c := range row.Cells {
+			col := len(cells)
+			if c.Ref != "" {
+				var err error
+				if col, err = xlsxColumn(c.Ref); err != nil {
+					return nil, fmt.Errorf("%s: %v", filename, err)
+				}
+			}
+			if col >= xlsxMaxColumns {
+				return nil, fmt.Errorf("%s: row %d has more than %d cells", filename, row.Index, xlsxMaxColumns)
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(shared.Items) {
+					return nil, fmt.Errorf("%s: cell %s: invalid shared string %q", filename, c.Ref, c.Value)
+				}
+				cells[col] = shared.Items[i].String()
+			case "inlineStr":
+				cells[col] = c.Inline.String()
+			default:
+				cells[col] = c.Value
+			}
+		}
+		rows[row.Index-1] = cells
+	}
+	return rows, nil
+}
+
+// The largest worksheet Excel allows: rows 1 to 1048576, columns A to XFD.
+const (
+	xlsxMaxRows    = 1 << 20
+	xlsxMaxColumns = 1 << 14
+)
+
+// xlsxColumn returns the zero-based column of a cell reference like "AB12",
+// in either case.
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		if col > xlsxMaxColumns {
+			return 0, fmt.Errorf("cell %s: column is past the last column XFD", ref)
+		}
+	}
+	if col == 0 {
+		return 0, fmt.Errorf("cell %s: reference does not start with a column letter", ref)
+	}
+	return col - 1, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+		err  string
+	}{
+		{"A1", 0, ""},
+		{"Z9", 25, ""},
+		{"AA1", 26, ""},
+		{"AZ1", 51, ""},
+		{"BA1", 52, ""},
+		{"ab12", 27, ""},
+		{"XFD1", xlsxMaxColumns - 1, ""},
+		{"XFE1", 0, "past the last column XFD"},
+		{"ZZZZZZZZZZZZZZ1", 0, "past the last column XFD"},
+		{"12", 0, "does not start with a column letter"},
+		{"", 0, "does not start with a column letter"},
+	}
+	for _, tt := range tests {
+		got, err := xlsxColumn(tt.ref)
+		switch {
+		case tt.err == "" && (err != nil || got != tt.want):
+			t.Errorf("xlsxColumn(%q) = %d, %v; want %d", tt.ref, got, err, tt.want)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("xlsxColumn(%q) error %v, want %q", tt.ref, err, tt.err)
+		}
+	}
+}
+
+// writeWorkbook writes a workbook of the given parts, adding the workbook
+// and its relationships for sheets named Cases and Other, to a temporary
+// file.
+func writeWorkbook(t *testing.T, parts map[string]string) string {
+	t.Helper()
+	all := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+ xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Cases" sheetId="1" r:id="rId1"/><sheet name="Other" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+	}
+	for name, content := range parts {
+		all[name] = content
+	}
+	path := filepath.Join(t.TempDir(), "cases.xlsx")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for name, content := range all {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sheet wraps rows in a worksheet.
+func sheet(rows string) string {
+	return `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+const sharedStrings = `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Days</t></si><si><t>Miles</t></si><si><r><t>Rec</t></r><r><t>eipts</t></r></si></sst>`
+
+func TestReadXLSX(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[string]string
+		sheet string
+		want  [][]string
+	}{
+		{
+			"shared, rich and inline strings",
+			map[string]string{
+				"xl/sharedStrings.xml": sharedStrings,
+				"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c>` +
+					`<c r="C1" t="s"><v>2</v></c><c r="D1" t="inlineStr"><is><t>Output</t></is></c></row>` +
+					`<row r="2"><c r="A2"><v>3</v></c><c r="B2"><v>93</v></c><c r="C2"><v>1.42</v></c><c r="D2"><v>364.51</v></c></row>`),
+			},
+			"",
+			[][]string{{"Days", "Miles", "Receipts", "Output"}, {"3", "93", "1.42", "364.51"}},
+		},
+		{
+			"empty rows and cells are filled",
+			map[string]string{
+				"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="B1"><v>x</v></c></row><row r="3"><c r="a3"><v>1</v></c><c r="C3"><v>2</v></c></row>`),
+			},
+			"",
+			[][]string{{"", "x"}, nil, {"1", "", "2"}},
+		},
+		{
+			"rows and cells without references follow the previous one",
+			map[string]string{
+				"xl/worksheets/sheet1.xml": sheet(`<row><c><v>1</v></c><c><v>2</v></c></row><row><c r="B2"><v>3</v></c><c><v>4</v></c></row>`),
+			},
+			"",
+			[][]string{{"1", "2"}, {"", "3", "4"}},
+		},
+		{
+			"sheet by name, with an absolute target",
+			map[string]string{
+				"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="A1"><v>first</v></c></row>`),
+				"xl/worksheets/sheet2.xml": sheet(`<row r="1"><c r="A1"><v>second</v></c></row>`),
+			},
+			"Other",
+			[][]string{{"second"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readXLSX(writeWorkbook(t, tt.parts), tt.sheet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadXLSXErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[string]string
+		sheet string
+		want  string
+	}{
+		{"unknown sheet", map[string]string{"xl/worksheets/sheet1.xml": sheet("")}, "Missing",
+			`has no worksheet "Missing" (sheets are Cases, Other)`},
+		{"missing worksheet part", nil, "", "no xl/worksheets/sheet1.xml in workbook"},
+		{"shared string out of range", map[string]string{
+			"xl/sharedStrings.xml":     sharedStrings,
+			"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="A1" t="s"><v>3</v></c></row>`),
+		}, "", `cell A1: invalid shared string "3"`},
+		{"shared string without a table", map[string]string{
+			"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="A1" t="s"><v>0</v></c></row>`),
+		}, "", `cell A1: invalid shared string "0"`},
+		{"bad cell reference", map[string]string{
+			"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="1A"><v>0</v></c></row>`),
+		}, "", "cell 1A: reference does not start with a column letter"},
+		{"column past XFD", map[string]string{
+			"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="XFE1"><v>0</v></c></row>`),
+		}, "", "cell XFE1: column is past the last column XFD"},
+		{"row past the sheet", map[string]string{
+			"xl/worksheets/sheet1.xml": sheet(`<row r="1048577"><c r="A1048577"><v>0</v></c></row>`),
+		}, "", "row 1048577 is outside the sheet's rows 1 to 1048576"},
+		{"negative row", map[string]string{
+			"xl/worksheets/sheet1.xml": sheet(`<row r="-1"><c><v>0</v></c></row>`),
+		}, "", "row -1 is outside"},
+		{"malformed XML", map[string]string{"xl/worksheets/sheet1.xml": "<worksheet><sheetData><row>"}, "",
+			"parsing xl/worksheets/sheet1.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readXLSX(writeWorkbook(t, tt.parts), tt.sheet)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWorkbookCases(t *testing.T) {
+	path := writeWorkbook(t, map[string]string{
+		"xl/sharedStrings.xml": sharedStrings,
+		"xl/worksheets/sheet1.xml": sheet(`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c>` +
+			`<c r="C1" t="s"><v>2</v></c><c r="D1" t="inlineStr"><is><t>Paid</t></is></c></row>` +
+			`<row r="2"><c r="A2"><v>3</v></c><c r="B2"><v>93</v></c><c r="C2"><v>1.42</v></c><c r="D2"><v>364.51</v></c></row>` +
+			`<row r="4"><c r="A4"><v>5</v></c><c r="B4"><v>130</v></c><c r="C4"><v>306.9</v></c><c r="D4"><v>574.1</v></c></row>`),
+	})
+	f := tableFlags{columns: "days=Days,miles=Miles,receipts=Receipts,output=Paid"}
+	cases, err := f.loadWorkbookCases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TrainingData{testCase(3, 93, 1.42, 364.51), testCase(5, 130, 306.9, 574.1)}
+	if !reflect.DeepEqual(cases, want) {
+		t.Errorf("got %+v, want %+v", cases, want)
+	}
+
+	f.columns = "days=Days,miles=Miles,receipts=Receipts,output=Amount"
+	if _, err := f.loadWorkbookCases(path); err == nil || !strings.Contains(err.Error(), `has no "Amount" column of outputs`) {
+		t.Errorf("loading without an output column: got %v", err)
+	}
+	f.columns = "days=Nights"
+	if _, err := f.loadWorkbookCases(path); err == nil || !strings.Contains(err.Error(), `no "Nights" column for days`) {
+		t.Errorf("loading without a days column: got %v", err)
+	}
+}